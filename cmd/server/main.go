@@ -10,10 +10,18 @@ import (
 	"syscall"
 	"time"
 
+	"photosync-backend/internal/albums"
 	"photosync-backend/internal/api"
 	"photosync-backend/internal/auth"
 	"photosync-backend/internal/config"
+	"photosync-backend/internal/index"
+	"photosync-backend/internal/journal"
+	"photosync-backend/internal/models"
+	"photosync-backend/internal/session"
 	"photosync-backend/internal/storage"
+	"photosync-backend/internal/thumbnail"
+	"photosync-backend/internal/trash"
+	"photosync-backend/internal/upload"
 )
 
 func main() {
@@ -32,23 +40,82 @@ func main() {
 		log.Fatalf("Invalid JWT expiry: %v", err)
 	}
 
+	refreshExpiry, err := cfg.GetJWTRefreshExpiry()
+	if err != nil {
+		log.Fatalf("Invalid JWT refresh expiry: %v", err)
+	}
+
 	poolTTL, err := cfg.GetPoolTTL()
 	if err != nil {
 		log.Fatalf("Invalid pool TTL: %v", err)
 	}
 
+	transferTimeout, err := cfg.GetTransferTimeout()
+	if err != nil {
+		log.Fatalf("Invalid transfer timeout: %v", err)
+	}
+
+	uploadExpiry, err := cfg.GetUploadExpiry()
+	if err != nil {
+		log.Fatalf("Invalid upload expiry: %v", err)
+	}
+
+	thumbnailMaxAge, err := cfg.GetThumbnailMaxAge()
+	if err != nil {
+		log.Fatalf("Invalid thumbnail max age: %v", err)
+	}
+
+	rescanInterval, err := cfg.GetSyncRescanInterval()
+	if err != nil {
+		log.Fatalf("Invalid sync rescan interval: %v", err)
+	}
+
+	reconcileInterval, err := cfg.GetIndexReconcileInterval()
+	if err != nil {
+		log.Fatalf("Invalid index reconcile interval: %v", err)
+	}
+
+	trashRetention, err := cfg.GetTrashRetention()
+	if err != nil {
+		log.Fatalf("Invalid trash retention: %v", err)
+	}
+
+	trashPurgeInterval, err := cfg.GetTrashPurgeInterval()
+	if err != nil {
+		log.Fatalf("Invalid trash purge interval: %v", err)
+	}
+
 	authenticator, err := auth.NewAuthenticator(cfg)
 	if err != nil {
 		log.Fatalf("Failed to create authenticator: %v", err)
 	}
 
+	signingKeys, err := auth.LoadKeySet(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load JWT signing keys: %v", err)
+	}
+
 	jwtManager := auth.NewJWTManager(
-		[]byte(cfg.JWT.SecretKey),
-		[]byte(cfg.JWT.EncryptionKey),
+		signingKeys,
 		cfg.JWT.Issuer,
 		jwtExpiry,
 	)
 
+	if err := os.MkdirAll(cfg.GetDataDir(), 0700); err != nil {
+		log.Fatalf("Failed to create data directory: %v", err)
+	}
+
+	var encryptionKeys []session.Key
+	for _, key := range cfg.GetJWTEncryptionKeys() {
+		encryptionKeys = append(encryptionKeys, session.Key{ID: key.ID, Key: []byte(key.Key)})
+	}
+
+	sessions, err := session.Open(cfg.GetSessionsPath(), encryptionKeys, refreshExpiry)
+	if err != nil {
+		log.Fatalf("Failed to open session store: %v", err)
+	}
+	defer sessions.Close()
+
 	storageBackend, err := storage.NewStorageBackend(cfg)
 	if err != nil {
 		log.Fatalf("Failed to create storage backend: %v", err)
@@ -57,10 +124,53 @@ func main() {
 	pool := storage.NewGenericConnectionPool(storageBackend, poolTTL)
 	defer pool.Close()
 
-	authHandler := api.NewAuthHandler(authenticator, jwtManager)
-	photoHandler := api.NewPhotoHandler(pool, storageBackend, jwtManager)
+	names := storage.NewNameResolver(storageBackend, cfg.Storage.CollisionPolicy)
+
+	uploadStore, err := upload.NewStore(cfg.GetUploadStagingDir(), uploadExpiry)
+	if err != nil {
+		log.Fatalf("Failed to create upload store: %v", err)
+	}
+
+	thumbnails, err := thumbnail.NewGenerator(cfg.GetThumbnailCacheDir(), cfg.GetThumbnailQuality(), thumbnailMaxAge)
+	if err != nil {
+		log.Fatalf("Failed to create thumbnail generator: %v", err)
+	}
+
+	changes, err := journal.New(cfg.GetSyncJournalDir(), cfg.GetSyncMaxChanges())
+	if err != nil {
+		log.Fatalf("Failed to create change journal: %v", err)
+	}
+	defer changes.Close()
+
+	files, err := index.Open(cfg.GetIndexPath())
+	if err != nil {
+		log.Fatalf("Failed to open index: %v", err)
+	}
+	defer files.Close()
+	files.OnListing(func(username string, listing []models.FileInfo, started time.Time, err error) {
+		// The index logs failed listings; diffing one would journal every
+		// file as deleted.
+		if err != nil {
+			return
+		}
+		if err := changes.Apply(username, listing, started); err != nil {
+			log.Printf("Journal: Rescan for user %s failed: %v", username, err)
+		}
+	})
+	go files.Watch(pool, storageBackend, reconcileInterval)
+
+	albumStore := albums.NewStore(storageBackend)
+
+	bin := trash.NewBin(storageBackend, trashRetention)
+	go bin.Watch(pool, trashPurgeInterval)
+
+	authHandler := api.NewAuthHandler(authenticator, jwtManager, sessions, cfg.OAuth2.AppRedirectURLs)
+	photoHandler := api.NewPhotoHandler(pool, storageBackend, names, changes, files, albumStore, bin, sessions, transferTimeout, thumbnails, cfg.GetThumbnailSizes(), cfg.GetThumbnailDefaultSize())
+	uploadHandler := api.NewUploadHandler(uploadStore, pool, storageBackend, names, changes, files, sessions, cfg.Uploads.MaxSize, transferTimeout)
+	syncHandler := api.NewSyncHandler(pool, storageBackend, changes, files, sessions, rescanInterval)
+	albumHandler := api.NewAlbumHandler(pool, storageBackend, files, albumStore, sessions)
 
-	router := api.NewRouter(authHandler, photoHandler)
+	router := api.NewRouter(authHandler, photoHandler, uploadHandler, syncHandler, albumHandler)
 
 	tlsConfig := &tls.Config{
 		MinVersion: tls.VersionTLS12,
@@ -11,8 +11,12 @@ require (
 	github.com/golang-jwt/jwt/v5 v5.2.0
 	github.com/hirochachacha/go-smb2 v1.1.0
 	github.com/vmware/go-nfs-client v0.0.0-20190605212624-d43b92724c1b
+	go.etcd.io/bbolt v1.4.3
 	golang.org/x/crypto v0.43.0
+	golang.org/x/image v0.32.0
 	golang.org/x/oauth2 v0.32.0
+	golang.org/x/sync v0.17.0
+	golang.org/x/text v0.30.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
@@ -32,4 +36,5 @@ require (
 	github.com/go-asn1-ber/asn1-ber v1.5.5 // indirect
 	github.com/google/uuid v1.3.1 // indirect
 	github.com/rasky/go-xdr v0.0.0-20170124162913-1a41d1a06c93 // indirect
+	golang.org/x/sys v0.37.0 // indirect
 )
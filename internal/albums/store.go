@@ -0,0 +1,226 @@
+package albums
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"photosync-backend/internal/models"
+	"photosync-backend/internal/storage"
+)
+
+// manifestName is the sidecar file holding a user's albums. It lives in the
+// user's directory on the backend, so albums survive a rebuild of the server
+// and move with the photos they reference.
+const manifestName = ".photosync-albums.json"
+
+var ErrNotFound = errors.New("album not found")
+
+type manifest struct {
+	Albums []*models.Album `json:"albums"`
+}
+
+// Store keeps each user's albums in a manifest on the storage backend.
+// Albums reference photos by name; no photo data is copied.
+type Store struct {
+	backend *storage.SafeBackend
+	mu      sync.Mutex
+	locks   map[string]*sync.Mutex
+}
+
+func NewStore(backend *storage.SafeBackend) *Store {
+	return &Store{
+		backend: backend,
+		locks:   make(map[string]*sync.Mutex),
+	}
+}
+
+func (s *Store) List(conn storage.Connection, username string) ([]*models.Album, error) {
+	defer s.lock(username)()
+
+	m, err := s.load(conn, username)
+	if err != nil {
+		return nil, err
+	}
+	return m.Albums, nil
+}
+
+func (s *Store) Get(conn storage.Connection, username, id string) (*models.Album, error) {
+	defer s.lock(username)()
+
+	m, err := s.load(conn, username)
+	if err != nil {
+		return nil, err
+	}
+
+	i := m.find(id)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	return m.Albums[i], nil
+}
+
+// Create assigns album an ID and timestamps and adds it to the user's albums.
+func (s *Store) Create(conn storage.Connection, username string, album *models.Album) error {
+	defer s.lock(username)()
+
+	m, err := s.load(conn, username)
+	if err != nil {
+		return err
+	}
+
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+
+	album.ID = id
+	album.CreatedAt = time.Now().UTC()
+	album.UpdatedAt = album.CreatedAt
+	if album.Photos == nil {
+		album.Photos = []string{}
+	}
+
+	m.Albums = append(m.Albums, album)
+	return s.save(conn, username, m)
+}
+
+// Update applies fn to an album and saves it, unless fn returns an error.
+func (s *Store) Update(conn storage.Connection, username, id string, fn func(*models.Album) error) (*models.Album, error) {
+	defer s.lock(username)()
+
+	m, err := s.load(conn, username)
+	if err != nil {
+		return nil, err
+	}
+
+	i := m.find(id)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+
+	album := m.Albums[i]
+	if err := fn(album); err != nil {
+		return nil, err
+	}
+	album.UpdatedAt = time.Now().UTC()
+
+	if err := s.save(conn, username, m); err != nil {
+		return nil, err
+	}
+	return album, nil
+}
+
+func (s *Store) Delete(conn storage.Connection, username, id string) error {
+	defer s.lock(username)()
+
+	m, err := s.load(conn, username)
+	if err != nil {
+		return err
+	}
+
+	i := m.find(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+
+	m.Albums = slices.Delete(m.Albums, i, i+1)
+	return s.save(conn, username, m)
+}
+
+// RenamePhoto points every album referencing from at to, after the photo
+// was moved. Albums already holding to keep it in its place.
+func (s *Store) RenamePhoto(conn storage.Connection, username, from, to string) error {
+	defer s.lock(username)()
+
+	m, err := s.load(conn, username)
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	now := time.Now().UTC()
+	for _, album := range m.Albums {
+		i := slices.Index(album.Photos, from)
+		if i < 0 {
+			continue
+		}
+
+		if slices.Contains(album.Photos, to) {
+			album.Photos = slices.Delete(album.Photos, i, i+1)
+		} else {
+			album.Photos[i] = to
+		}
+		if album.Cover == from {
+			album.Cover = to
+		}
+		album.UpdatedAt = now
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+	return s.save(conn, username, m)
+}
+
+// lock serializes manifest access per user and returns the unlock function.
+func (s *Store) lock(username string) func() {
+	s.mu.Lock()
+	l, ok := s.locks[username]
+	if !ok {
+		l = &sync.Mutex{}
+		s.locks[username] = l
+	}
+	s.mu.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+func (s *Store) load(conn storage.Connection, username string) (*manifest, error) {
+	data, err := s.backend.ReadSidecar(conn, username, manifestName)
+	if errors.Is(err, storage.ErrNotFound) {
+		return &manifest{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read album manifest: %w", err)
+	}
+
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse album manifest: %w", err)
+	}
+	return &m, nil
+}
+
+func (s *Store) save(conn storage.Connection, username string, m *manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := s.backend.WriteSidecar(conn, username, manifestName, data); err != nil {
+		return fmt.Errorf("failed to write album manifest: %w", err)
+	}
+	return nil
+}
+
+func (m *manifest) find(id string) int {
+	return slices.IndexFunc(m.Albums, func(album *models.Album) bool {
+		return album.ID == id
+	})
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate album id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
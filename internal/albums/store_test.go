@@ -0,0 +1,151 @@
+package albums
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"photosync-backend/internal/config"
+	"photosync-backend/internal/models"
+	"photosync-backend/internal/storage"
+)
+
+type testStore struct {
+	*Store
+	backend *storage.SafeBackend
+	conn    storage.Connection
+	dir     string
+}
+
+func newTestStore(t *testing.T) *testStore {
+	t.Helper()
+
+	base := t.TempDir()
+	backend := storage.NewSafeBackend(storage.NewLocalBackend(&config.LocalConfig{BasePath: base}))
+	conn, err := backend.Connect("alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testStore{Store: NewStore(backend), backend: backend, conn: conn, dir: filepath.Join(base, "alice")}
+}
+
+func TestStoreCRUD(t *testing.T) {
+	s := newTestStore(t)
+
+	if list, err := s.List(s.conn, "alice"); err != nil || len(list) != 0 {
+		t.Fatalf("List before any album = %v, %v", list, err)
+	}
+
+	album := &models.Album{Name: "Holiday"}
+	if err := s.Create(s.conn, "alice", album); err != nil {
+		t.Fatal(err)
+	}
+	if album.ID == "" || album.CreatedAt.IsZero() || album.Photos == nil {
+		t.Errorf("created album = %+v, want an ID, a creation time and no photos", album)
+	}
+
+	updated, err := s.Update(s.conn, "alice", album.ID, func(a *models.Album) error {
+		a.Photos = []string{"a.jpg", "b.jpg"}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.UpdatedAt.Before(album.CreatedAt) {
+		t.Errorf("UpdatedAt %v before CreatedAt %v", updated.UpdatedAt, album.CreatedAt)
+	}
+
+	errRefused := errors.New("refused")
+	if _, err := s.Update(s.conn, "alice", album.ID, func(a *models.Album) error {
+		a.Name = "Changed"
+		return errRefused
+	}); !errors.Is(err, errRefused) {
+		t.Errorf("refused Update = %v, want %v", err, errRefused)
+	}
+
+	got, err := s.Get(s.conn, "alice", album.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "Holiday" || !slices.Equal(got.Photos, []string{"a.jpg", "b.jpg"}) {
+		t.Errorf("Get = %+v, want the update saved and the refused one not", got)
+	}
+
+	if err := s.Delete(s.conn, "alice", album.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(s.conn, "alice", album.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete = %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(s.conn, "alice", album.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Delete = %v, want ErrNotFound", err)
+	}
+	if _, err := s.Update(s.conn, "alice", "missing", func(*models.Album) error { return nil }); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Update of a missing album = %v, want ErrNotFound", err)
+	}
+}
+
+func TestStorePersists(t *testing.T) {
+	s := newTestStore(t)
+
+	album := &models.Album{Name: "Holiday", Photos: []string{"a.jpg"}, Cover: "a.jpg"}
+	if err := s.Create(s.conn, "alice", album); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(s.dir, manifestName)); err != nil {
+		t.Fatalf("manifest not written: %v", err)
+	}
+
+	// A new store, as after a restart, reads the albums back from the
+	// backend.
+	reopened := NewStore(s.backend)
+	got, err := reopened.Get(s.conn, "alice", album.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != album.Name || got.Cover != album.Cover || !slices.Equal(got.Photos, album.Photos) || !got.CreatedAt.Equal(album.CreatedAt) {
+		t.Errorf("reopened album = %+v, want %+v", got, album)
+	}
+
+	if files, err := s.backend.List(s.conn, "alice"); err != nil || len(files) != 0 {
+		t.Errorf("listing = %v, %v, want the manifest hidden", files, err)
+	}
+}
+
+func TestStoreRenamePhoto(t *testing.T) {
+	tests := []struct {
+		name      string
+		photos    []string
+		cover     string
+		wantPhoto []string
+		wantCover string
+	}{
+		{name: "moved", photos: []string{"x.jpg", "a.jpg", "y.jpg"}, cover: "a.jpg", wantPhoto: []string{"x.jpg", "2024/a.jpg", "y.jpg"}, wantCover: "2024/a.jpg"},
+		{name: "target in album", photos: []string{"a.jpg", "2024/a.jpg"}, cover: "a.jpg", wantPhoto: []string{"2024/a.jpg"}, wantCover: "2024/a.jpg"},
+		{name: "not in album", photos: []string{"x.jpg"}, cover: "x.jpg", wantPhoto: []string{"x.jpg"}, wantCover: "x.jpg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			album := &models.Album{Name: tt.name, Photos: tt.photos, Cover: tt.cover}
+			if err := s.Create(s.conn, "alice", album); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := s.RenamePhoto(s.conn, "alice", "a.jpg", "2024/a.jpg"); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := NewStore(s.backend).Get(s.conn, "alice", album.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got.Photos, tt.wantPhoto) || got.Cover != tt.wantCover {
+				t.Errorf("album = %v cover %q, want %v cover %q", got.Photos, got.Cover, tt.wantPhoto, tt.wantCover)
+			}
+		})
+	}
+}
@@ -0,0 +1,416 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"photosync-backend/internal/albums"
+	"photosync-backend/internal/index"
+	"photosync-backend/internal/models"
+	"photosync-backend/internal/session"
+	"photosync-backend/internal/storage"
+)
+
+const maxAlbumNameLength = 200
+
+var (
+	errCoverNotInAlbum = errors.New("cover must be a photo in the album")
+	errPhotoNotInAlbum = errors.New("photo not in album")
+	errInvalidPosition = errors.New("invalid position")
+)
+
+type AlbumHandler struct {
+	pool     *storage.GenericConnectionPool
+	backend  storage.StorageBackend
+	index    *index.Index
+	albums   *albums.Store
+	sessions *session.Store
+}
+
+func NewAlbumHandler(pool *storage.GenericConnectionPool, backend storage.StorageBackend, files *index.Index, store *albums.Store, sessions *session.Store) *AlbumHandler {
+	return &AlbumHandler{
+		pool:     pool,
+		backend:  backend,
+		index:    files,
+		albums:   store,
+		sessions: sessions,
+	}
+}
+
+func (h *AlbumHandler) ListAlbums(w http.ResponseWriter, r *http.Request) {
+	claims, conn, done, ok := userConnection(w, r, h.sessions, h.pool)
+	if !ok {
+		return
+	}
+	defer done()
+
+	list, err := h.albums.List(conn, claims.Username)
+	if err != nil {
+		h.albumError(w, claims.Username, err)
+		return
+	}
+
+	files, ok := h.files(w, conn, claims.Username)
+	if !ok {
+		return
+	}
+
+	resp := make([]models.Album, 0, len(list))
+	for _, album := range list {
+		resp = append(resp, present(album, files))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *AlbumHandler) GetAlbum(w http.ResponseWriter, r *http.Request) {
+	claims, conn, done, ok := userConnection(w, r, h.sessions, h.pool)
+	if !ok {
+		return
+	}
+	defer done()
+
+	album, err := h.albums.Get(conn, claims.Username, chi.URLParam(r, "albumID"))
+	if err != nil {
+		h.albumError(w, claims.Username, err)
+		return
+	}
+
+	files, ok := h.files(w, conn, claims.Username)
+	if !ok {
+		return
+	}
+
+	h.writeAlbum(w, http.StatusOK, album, files)
+}
+
+func (h *AlbumHandler) CreateAlbum(w http.ResponseWriter, r *http.Request) {
+	claims, conn, done, ok := userConnection(w, r, h.sessions, h.pool)
+	if !ok {
+		return
+	}
+	defer done()
+
+	req, ok := decodeAlbumRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.Name == nil {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	files, ok := h.files(w, conn, claims.Username)
+	if !ok {
+		return
+	}
+
+	album := &models.Album{Name: *req.Name}
+	if req.Description != nil {
+		album.Description = *req.Description
+	}
+	if req.Photos != nil {
+		if album.Photos, ok = checkPhotos(w, req.Photos, files); !ok {
+			return
+		}
+	}
+	if req.Cover != nil && *req.Cover != "" {
+		if !slices.Contains(album.Photos, *req.Cover) {
+			http.Error(w, errCoverNotInAlbum.Error(), http.StatusBadRequest)
+			return
+		}
+		album.Cover = *req.Cover
+	}
+
+	if err := h.albums.Create(conn, claims.Username, album); err != nil {
+		h.albumError(w, claims.Username, err)
+		return
+	}
+
+	h.writeAlbum(w, http.StatusCreated, album, files)
+}
+
+func (h *AlbumHandler) UpdateAlbum(w http.ResponseWriter, r *http.Request) {
+	claims, conn, done, ok := userConnection(w, r, h.sessions, h.pool)
+	if !ok {
+		return
+	}
+	defer done()
+
+	req, ok := decodeAlbumRequest(w, r)
+	if !ok {
+		return
+	}
+
+	files, ok := h.files(w, conn, claims.Username)
+	if !ok {
+		return
+	}
+
+	var photos []string
+	if req.Photos != nil {
+		if photos, ok = checkPhotos(w, req.Photos, files); !ok {
+			return
+		}
+	}
+
+	album, err := h.albums.Update(conn, claims.Username, chi.URLParam(r, "albumID"), func(album *models.Album) error {
+		if req.Name != nil {
+			album.Name = *req.Name
+		}
+		if req.Description != nil {
+			album.Description = *req.Description
+		}
+		if photos != nil {
+			album.Photos = photos
+			if !slices.Contains(photos, album.Cover) {
+				album.Cover = ""
+			}
+		}
+		if req.Cover != nil {
+			if *req.Cover != "" && !slices.Contains(album.Photos, *req.Cover) {
+				return errCoverNotInAlbum
+			}
+			album.Cover = *req.Cover
+		}
+		return nil
+	})
+	if err != nil {
+		h.albumError(w, claims.Username, err)
+		return
+	}
+
+	h.writeAlbum(w, http.StatusOK, album, files)
+}
+
+func (h *AlbumHandler) DeleteAlbum(w http.ResponseWriter, r *http.Request) {
+	claims, conn, done, ok := userConnection(w, r, h.sessions, h.pool)
+	if !ok {
+		return
+	}
+	defer done()
+
+	if err := h.albums.Delete(conn, claims.Username, chi.URLParam(r, "albumID")); err != nil {
+		h.albumError(w, claims.Username, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AddPhotos inserts photos into an album at position, or appends them. The
+// position counts the photos as presented, without missing ones. Photos
+// already in the album keep their place.
+func (h *AlbumHandler) AddPhotos(w http.ResponseWriter, r *http.Request) {
+	claims, conn, done, ok := userConnection(w, r, h.sessions, h.pool)
+	if !ok {
+		return
+	}
+	defer done()
+
+	var req models.AlbumPhotosRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Photos) == 0 {
+		http.Error(w, "photos are required", http.StatusBadRequest)
+		return
+	}
+
+	files, ok := h.files(w, conn, claims.Username)
+	if !ok {
+		return
+	}
+
+	photos, ok := checkPhotos(w, req.Photos, files)
+	if !ok {
+		return
+	}
+
+	album, err := h.albums.Update(conn, claims.Username, chi.URLParam(r, "albumID"), func(album *models.Album) error {
+		position := len(album.Photos)
+		if req.Position != nil {
+			var ok bool
+			if position, ok = storedPosition(album, files, *req.Position); !ok {
+				return errInvalidPosition
+			}
+		}
+
+		added := slices.DeleteFunc(photos, func(name string) bool {
+			return slices.Contains(album.Photos, name)
+		})
+		album.Photos = slices.Insert(album.Photos, position, added...)
+		return nil
+	})
+	if err != nil {
+		h.albumError(w, claims.Username, err)
+		return
+	}
+
+	h.writeAlbum(w, http.StatusOK, album, files)
+}
+
+// RemovePhoto takes a photo out of an album; the photo itself is kept.
+func (h *AlbumHandler) RemovePhoto(w http.ResponseWriter, r *http.Request) {
+	photoID, ok := photoName(w, r)
+	if !ok {
+		return
+	}
+
+	claims, conn, done, ok := userConnection(w, r, h.sessions, h.pool)
+	if !ok {
+		return
+	}
+	defer done()
+
+	_, err := h.albums.Update(conn, claims.Username, chi.URLParam(r, "albumID"), func(album *models.Album) error {
+		i := slices.Index(album.Photos, photoID)
+		if i < 0 {
+			return errPhotoNotInAlbum
+		}
+		album.Photos = slices.Delete(album.Photos, i, i+1)
+		if album.Cover == photoID {
+			album.Cover = ""
+		}
+		return nil
+	})
+	if err != nil {
+		h.albumError(w, claims.Username, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AlbumHandler) files(w http.ResponseWriter, conn storage.Connection, username string) (map[string]models.FileInfo, bool) {
+	list, err := listFiles(h.index, h.backend, conn, username)
+	if err != nil {
+		log.Printf("List for user %s failed: %v", username, err)
+		http.Error(w, "failed to list photos", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	files := make(map[string]models.FileInfo, len(list))
+	for _, file := range list {
+		files[file.Name] = file
+	}
+	return files, true
+}
+
+func (h *AlbumHandler) writeAlbum(w http.ResponseWriter, status int, album *models.Album, files map[string]models.FileInfo) {
+	resp := models.AlbumResponse{
+		Album: present(album, files),
+		Files: []models.FileInfo{},
+	}
+	for _, name := range resp.Photos {
+		resp.Files = append(resp.Files, files[name])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *AlbumHandler) albumError(w http.ResponseWriter, username string, err error) {
+	switch {
+	case errors.Is(err, albums.ErrNotFound), errors.Is(err, errPhotoNotInAlbum):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errCoverNotInAlbum), errors.Is(err, errInvalidPosition):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("Albums: Request for user %s failed: %v", username, err)
+		http.Error(w, "failed to access albums", http.StatusInternalServerError)
+	}
+}
+
+// present returns album as shown to clients: photos that no longer exist are
+// left out, though the album keeps referencing them in case they come back,
+// and the cover defaults to the first photo.
+func present(album *models.Album, files map[string]models.FileInfo) models.Album {
+	shown := *album
+	shown.Photos = []string{}
+	for _, name := range album.Photos {
+		if _, ok := files[name]; ok {
+			shown.Photos = append(shown.Photos, name)
+		}
+	}
+
+	if !slices.Contains(shown.Photos, shown.Cover) {
+		shown.Cover = ""
+		if len(shown.Photos) > 0 {
+			shown.Cover = shown.Photos[0]
+		}
+	}
+	return shown
+}
+
+// storedPosition maps a position among the presented photos of album to one
+// in album.Photos: before the photo shown at position, or at the end.
+func storedPosition(album *models.Album, files map[string]models.FileInfo, position int) (int, bool) {
+	if position < 0 {
+		return 0, false
+	}
+
+	shown := 0
+	for i, name := range album.Photos {
+		if _, ok := files[name]; !ok {
+			continue
+		}
+		if shown == position {
+			return i, true
+		}
+		shown++
+	}
+	if shown == position {
+		return len(album.Photos), true
+	}
+	return 0, false
+}
+
+func decodeAlbumRequest(w http.ResponseWriter, r *http.Request) (*models.AlbumRequest, bool) {
+	var req models.AlbumRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return nil, false
+	}
+
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" || len(name) > maxAlbumNameLength {
+			http.Error(w, "invalid album name", http.StatusBadRequest)
+			return nil, false
+		}
+		req.Name = &name
+	}
+	return &req, true
+}
+
+// checkPhotos validates the photo names of a request against the user's
+// files and removes duplicates, keeping the first occurrence.
+func checkPhotos(w http.ResponseWriter, names []string, files map[string]models.FileInfo) ([]string, bool) {
+	photos := make([]string, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		name, err := storage.CleanPath(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+		if _, ok := files[name]; !ok {
+			http.Error(w, "photo not found: "+name, http.StatusBadRequest)
+			return nil, false
+		}
+		if !seen[name] {
+			seen[name] = true
+			photos = append(photos, name)
+		}
+	}
+	return photos, true
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"photosync-backend/internal/models"
+)
+
+type albumServer struct {
+	*photoServer
+	router http.Handler
+}
+
+func newAlbumServer(t *testing.T, files ...string) *albumServer {
+	t.Helper()
+	s := newPhotoServer(t, files...)
+
+	h := NewAlbumHandler(s.pool, s.backend, s.index, s.handler.albums, s.sessions)
+	r := chi.NewRouter()
+	r.Get("/api/albums", h.ListAlbums)
+	r.Post("/api/albums", h.CreateAlbum)
+	r.Get("/api/albums/{albumID}", h.GetAlbum)
+	r.Patch("/api/albums/{albumID}", h.UpdateAlbum)
+	r.Delete("/api/albums/{albumID}", h.DeleteAlbum)
+	r.Post("/api/albums/{albumID}/photos", h.AddPhotos)
+	r.Delete("/api/albums/{albumID}/photos/{id}", h.RemovePhoto)
+	r.Post("/api/photos/{id}/move", s.handler.MovePhoto)
+
+	return &albumServer{photoServer: s, router: r}
+}
+
+func (s *albumServer) do(t *testing.T, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, s.request(context.Background(), method, target, body))
+	return w
+}
+
+// album creates an album as stored, which may reference missing photos.
+func (s *albumServer) album(t *testing.T, photos []string, cover string) string {
+	t.Helper()
+
+	album := &models.Album{Name: "Holiday", Photos: photos, Cover: cover}
+	if err := s.handler.albums.Create(s.connection(t), "alice", album); err != nil {
+		t.Fatal(err)
+	}
+	return album.ID
+}
+
+func decodeAlbum(t *testing.T, w *httptest.ResponseRecorder) models.AlbumResponse {
+	t.Helper()
+
+	var resp models.AlbumResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestAlbumCRUD(t *testing.T) {
+	s := newAlbumServer(t, "a.jpg", "b.jpg")
+
+	w := s.do(t, http.MethodPost, "/api/albums", `{"name": " Holiday ", "photos": ["a.jpg", "b.jpg", "a.jpg"], "cover": "b.jpg"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status %d: %s", w.Code, w.Body)
+	}
+	created := decodeAlbum(t, w)
+	if created.Name != "Holiday" || !slices.Equal(created.Photos, []string{"a.jpg", "b.jpg"}) || created.Cover != "b.jpg" || len(created.Files) != 2 {
+		t.Errorf("created = %+v", created)
+	}
+	target := "/api/albums/" + created.ID
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+	}{
+		{name: "no name", method: http.MethodPost, target: "/api/albums", body: `{}`, wantStatus: http.StatusBadRequest},
+		{name: "missing photo", method: http.MethodPost, target: "/api/albums", body: `{"name": "x", "photos": ["c.jpg"]}`, wantStatus: http.StatusBadRequest},
+		{name: "cover not in album", method: http.MethodPatch, target: target, body: `{"photos": ["a.jpg"], "cover": "b.jpg"}`, wantStatus: http.StatusBadRequest},
+		{name: "rename", method: http.MethodPatch, target: target, body: `{"name": "Summer"}`, wantStatus: http.StatusOK},
+		{name: "remove photo", method: http.MethodDelete, target: target + "/photos/a.jpg", wantStatus: http.StatusNoContent},
+		{name: "remove photo again", method: http.MethodDelete, target: target + "/photos/a.jpg", wantStatus: http.StatusNotFound},
+		{name: "unknown album", method: http.MethodGet, target: "/api/albums/missing", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if w := s.do(t, tt.method, tt.target, tt.body); w.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.wantStatus, w.Body)
+		}
+	}
+
+	got := decodeAlbum(t, s.do(t, http.MethodGet, target, ""))
+	if got.Name != "Summer" || !slices.Equal(got.Photos, []string{"b.jpg"}) || got.Cover != "b.jpg" {
+		t.Errorf("album = %+v, want Summer with b.jpg", got)
+	}
+
+	if w := s.do(t, http.MethodDelete, target, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("delete: status %d", w.Code)
+	}
+	w = s.do(t, http.MethodGet, "/api/albums", "")
+	var list []models.Album
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil || len(list) != 0 {
+		t.Errorf("albums after delete = %v, %v", list, err)
+	}
+	if !s.exists("a.jpg") || !s.exists("b.jpg") {
+		t.Error("photos deleted with the album")
+	}
+}
+
+func TestAlbumPresent(t *testing.T) {
+	s := newAlbumServer(t, "a.jpg", "b.jpg")
+
+	tests := []struct {
+		name       string
+		photos     []string
+		cover      string
+		wantPhotos []string
+		wantCover  string
+	}{
+		{name: "cover", photos: []string{"a.jpg", "b.jpg"}, cover: "b.jpg", wantPhotos: []string{"a.jpg", "b.jpg"}, wantCover: "b.jpg"},
+		{name: "no cover", photos: []string{"a.jpg", "b.jpg"}, wantPhotos: []string{"a.jpg", "b.jpg"}, wantCover: "a.jpg"},
+		{name: "missing cover", photos: []string{"gone.jpg", "b.jpg", "a.jpg"}, cover: "gone.jpg", wantPhotos: []string{"b.jpg", "a.jpg"}, wantCover: "b.jpg"},
+		{name: "all missing", photos: []string{"gone.jpg"}, cover: "gone.jpg", wantPhotos: []string{}, wantCover: ""},
+	}
+	for _, tt := range tests {
+		id := s.album(t, tt.photos, tt.cover)
+
+		got := decodeAlbum(t, s.do(t, http.MethodGet, "/api/albums/"+id, ""))
+		if !slices.Equal(got.Photos, tt.wantPhotos) || got.Cover != tt.wantCover || len(got.Files) != len(tt.wantPhotos) {
+			t.Errorf("%s: album = %v cover %q, want %v cover %q", tt.name, got.Photos, got.Cover, tt.wantPhotos, tt.wantCover)
+		}
+	}
+}
+
+func TestAlbumAddPhotosPosition(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantPhotos []string
+	}{
+		{name: "start", body: `{"photos": ["c.jpg"], "position": 0}`, wantStatus: http.StatusOK, wantPhotos: []string{"c.jpg", "a.jpg", "b.jpg"}},
+		{name: "between", body: `{"photos": ["c.jpg"], "position": 1}`, wantStatus: http.StatusOK, wantPhotos: []string{"a.jpg", "c.jpg", "b.jpg"}},
+		{name: "end", body: `{"photos": ["c.jpg"], "position": 2}`, wantStatus: http.StatusOK, wantPhotos: []string{"a.jpg", "b.jpg", "c.jpg"}},
+		{name: "append", body: `{"photos": ["c.jpg", "a.jpg"]}`, wantStatus: http.StatusOK, wantPhotos: []string{"a.jpg", "b.jpg", "c.jpg"}},
+		{name: "past the end", body: `{"photos": ["c.jpg"], "position": 3}`, wantStatus: http.StatusBadRequest},
+		{name: "negative", body: `{"photos": ["c.jpg"], "position": -1}`, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newAlbumServer(t, "a.jpg", "b.jpg", "c.jpg")
+			// The client sees a.jpg and b.jpg; gone.jpg is kept in case it
+			// comes back.
+			id := s.album(t, []string{"gone.jpg", "a.jpg", "b.jpg"}, "")
+
+			w := s.do(t, http.MethodPost, "/api/albums/"+id+"/photos", tt.body)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			if got := decodeAlbum(t, w); !slices.Equal(got.Photos, tt.wantPhotos) {
+				t.Errorf("photos = %v, want %v", got.Photos, tt.wantPhotos)
+			}
+		})
+	}
+}
+
+func TestAlbumFollowsMove(t *testing.T) {
+	s := newAlbumServer(t, "a.jpg", "b.jpg")
+	id := s.album(t, []string{"a.jpg", "b.jpg"}, "a.jpg")
+
+	if w := s.do(t, http.MethodPost, "/api/photos/a.jpg/move", `{"to": "2024/a.jpg"}`); w.Code != http.StatusOK {
+		t.Fatalf("move: status %d: %s", w.Code, w.Body)
+	}
+
+	got := decodeAlbum(t, s.do(t, http.MethodGet, "/api/albums/"+id, ""))
+	if !slices.Equal(got.Photos, []string{"2024/a.jpg", "b.jpg"}) || got.Cover != "2024/a.jpg" {
+		t.Errorf("album = %v cover %q, want the moved photo in place", got.Photos, got.Cover)
+	}
+}
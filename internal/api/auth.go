@@ -2,22 +2,37 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
-	"time"
+	"slices"
+	"sync"
 
+	"github.com/go-chi/chi/v5"
 	"photosync-backend/internal/auth"
 	"photosync-backend/internal/models"
+	"photosync-backend/internal/session"
 )
 
 type AuthHandler struct {
 	authenticator auth.Authenticator
 	jwtManager    *auth.JWTManager
+	sessions      *session.Store
+	appRedirects  []string
+
+	mu         sync.Mutex
+	loginCodes map[string]loginCode
 }
 
-func NewAuthHandler(authenticator auth.Authenticator, jwtManager *auth.JWTManager) *AuthHandler {
+// NewAuthHandler creates the handler. appRedirects lists the app URLs an
+// OAuth2 sign-in may return to.
+func NewAuthHandler(authenticator auth.Authenticator, jwtManager *auth.JWTManager, sessions *session.Store, appRedirects []string) *AuthHandler {
 	return &AuthHandler{
 		authenticator: authenticator,
 		jwtManager:    jwtManager,
+		sessions:      sessions,
+		appRedirects:  appRedirects,
+		loginCodes:    make(map[string]loginCode),
 	}
 }
 
@@ -34,17 +49,134 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := h.jwtManager.Generate(userInfo.Username, req.Password)
+	h.startSession(w, r, userInfo.Username, req.Password)
+}
+
+// startSession creates a session for an authenticated user and answers with
+// its tokens.
+func (h *AuthHandler) startSession(w http.ResponseWriter, r *http.Request, username, password string) {
+	sess, refreshToken, err := h.sessions.Create(username, password, r.UserAgent())
 	if err != nil {
-		http.Error(w, "failed to generate token", http.StatusInternalServerError)
+		log.Printf("Sessions: Failed to create session for user %s: %v", username, err)
+		http.Error(w, "failed to create session", http.StatusInternalServerError)
+		return
+	}
+
+	if !h.issueTokens(w, sess, refreshToken) {
+		h.sessions.Delete(sess.ID)
+	}
+}
+
+// Refresh exchanges a refresh token for a new access token and a new
+// refresh token. Each refresh token works once.
+func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req models.RefreshRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
 		return
 	}
 
+	sess, refreshToken, err := h.sessions.Refresh(req.RefreshToken)
+	if err != nil {
+		if !errors.Is(err, session.ErrNotFound) {
+			log.Printf("Sessions: Refresh failed: %v", err)
+			http.Error(w, "failed to refresh session", http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, "invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	h.issueTokens(w, sess, refreshToken)
+}
+
+// issueTokens answers with a new access token for sess and its refresh
+// token, reporting whether it could.
+func (h *AuthHandler) issueTokens(w http.ResponseWriter, sess *session.Session, refreshToken string) bool {
+	token, expiresAt, err := h.jwtManager.Generate(sess.Username, sess.ID)
+	if err != nil {
+		http.Error(w, "failed to generate token", http.StatusInternalServerError)
+		return false
+	}
+
 	resp := models.LoginResponse{
-		Token:     token,
-		ExpiresAt: time.Now().Add(24 * time.Hour).Unix(),
+		Token:            token,
+		ExpiresAt:        expiresAt.Unix(),
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: sess.ExpiresAt.Unix(),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
+	return true
+}
+
+// Logout ends the session of the presented token, wiping the stored
+// credentials. Its access and refresh tokens stop working at once.
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value("claims").(*auth.JWTClaims)
+
+	if err := h.sessions.Revoke(claims.Username, claims.SessionID); err != nil && !errors.Is(err, session.ErrNotFound) {
+		log.Printf("Sessions: Failed to revoke session of user %s: %v", claims.Username, err)
+		http.Error(w, "failed to log out", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListSessions returns the user's logged-in devices, most recently active
+// first.
+func (h *AuthHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value("claims").(*auth.JWTClaims)
+
+	sessions, err := h.sessions.List(claims.Username)
+	if err != nil {
+		log.Printf("Sessions: Failed to list sessions of user %s: %v", claims.Username, err)
+		http.Error(w, "failed to list sessions", http.StatusInternalServerError)
+		return
+	}
+
+	infos := make([]models.SessionInfo, 0, len(sessions))
+	for _, sess := range sessions {
+		infos = append(infos, models.SessionInfo{
+			ID:          sess.ID,
+			Device:      sess.Device,
+			CreatedAt:   sess.CreatedAt,
+			RefreshedAt: sess.RefreshedAt,
+			ExpiresAt:   sess.ExpiresAt,
+			Current:     sess.ID == claims.SessionID,
+		})
+	}
+	slices.SortFunc(infos, func(a, b models.SessionInfo) int {
+		return b.RefreshedAt.Compare(a.RefreshedAt)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(infos)
+}
+
+// RevokeSession logs out one of the user's devices, e.g. a lost phone.
+func (h *AuthHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value("claims").(*auth.JWTClaims)
+
+	if err := h.sessions.Revoke(claims.Username, chi.URLParam(r, "sessionID")); err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			http.Error(w, "session not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Sessions: Failed to revoke session of user %s: %v", claims.Username, err)
+		http.Error(w, "failed to revoke session", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// JWKS publishes the public keys access tokens are signed with, for
+// services that verify them.
+func (h *AuthHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(h.jwtManager.JWKS())
 }
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"sync"
+
+	"photosync-backend/internal/models"
+	"photosync-backend/internal/storage"
+	"photosync-backend/internal/trash"
+)
+
+const (
+	maxBatchItems    = 1000
+	batchConcurrency = 8
+)
+
+var batchFailures = map[string]string{
+	"delete":  "failed to delete photo",
+	"move":    "failed to move photo",
+	"info":    "failed to get photo info",
+	"restore": "failed to restore photo",
+}
+
+// Batch applies one operation to many photos in a single request. Items run
+// concurrently, at most batchConcurrency at a time, over the user's pooled
+// connection, and each reports its own status; the response is 200 whenever
+// the request itself was valid. Like a transfer, a batch gets the transfer
+// timeout; once the client is gone no further items are started.
+func (h *PhotoHandler) Batch(w http.ResponseWriter, r *http.Request) {
+	var req models.BatchRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<20)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if _, ok := batchFailures[req.Op]; !ok {
+		http.Error(w, "op must be delete, move, info or restore", http.StatusBadRequest)
+		return
+	}
+	if len(req.Items) == 0 {
+		http.Error(w, "items are required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Items) > maxBatchItems {
+		http.Error(w, fmt.Sprintf("at most %d items per batch", maxBatchItems), http.StatusBadRequest)
+		return
+	}
+	if req.Folder != nil {
+		folder, ok := folderParam(w, *req.Folder)
+		if !ok {
+			return
+		}
+		req.Folder = &folder
+	}
+
+	claims, conn, done, ok := userConnection(w, r, h.sessions, h.pool)
+	if !ok {
+		return
+	}
+	defer done()
+
+	extendTransferDeadlines(w, h.transferTimeout)
+
+	ctx := r.Context()
+	results := make([]models.BatchResult, len(req.Items))
+	slots := make(chan struct{}, batchConcurrency)
+	var wg sync.WaitGroup
+	started := 0
+	for i, item := range req.Items {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		started++
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			results[i] = h.batchItem(conn, claims.Username, &req, item)
+		}()
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		log.Printf("Batch %s for user %s stopped after %d of %d items: %v", req.Op, claims.Username, started, len(req.Items), err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.BatchResponse{Results: results})
+}
+
+func (h *PhotoHandler) batchItem(conn storage.Connection, username string, req *models.BatchRequest, item models.BatchItem) models.BatchResult {
+	file, status, err := h.runBatchItem(conn, username, req, item)
+	if err == nil {
+		return models.BatchResult{ID: item.ID, Status: status, File: file}
+	}
+
+	result := models.BatchResult{ID: item.ID, Status: storageErrorStatus(err)}
+	switch {
+	case errors.Is(err, errNoDestination), errors.Is(err, errSameFile), errors.Is(err, errInvalidTrashID):
+		result.Status = http.StatusBadRequest
+	}
+
+	switch result.Status {
+	case http.StatusBadRequest:
+		result.Error = err.Error()
+	case http.StatusNotFound:
+		result.Error = "photo not found"
+	case http.StatusConflict:
+		result.Error = "a photo with this name already exists"
+	default:
+		log.Printf("Batch %s of %s for user %s failed: %v", req.Op, item.ID, username, err)
+		result.Error = batchFailures[req.Op]
+	}
+	return result
+}
+
+func (h *PhotoHandler) runBatchItem(conn storage.Connection, username string, req *models.BatchRequest, item models.BatchItem) (*models.FileInfo, int, error) {
+	name, err := storage.CleanPath(item.ID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch req.Op {
+	case "delete":
+		return nil, http.StatusNoContent, h.deletePhoto(conn, username, name)
+
+	case "info":
+		info, err := h.photoInfo(conn, username, name)
+		return info, http.StatusOK, err
+
+	case "move":
+		to := item.To
+		if to == "" && req.Folder != nil {
+			to = path.Join(*req.Folder, path.Base(name))
+		}
+		if to, err = destination(name, to); err != nil {
+			return nil, 0, err
+		}
+		info, err := h.transfer(conn, username, name, to, req.Overwrite, false)
+		return &info, http.StatusOK, err
+
+	default:
+		if _, _, ok := trash.ParseID(name); !ok {
+			return nil, 0, errInvalidTrashID
+		}
+		to, err := restoreDestination(name, item.To)
+		if err != nil {
+			return nil, 0, err
+		}
+		info, err := h.restore(conn, username, name, to, req.Overwrite)
+		return &info, http.StatusOK, err
+	}
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"photosync-backend/internal/albums"
+	"photosync-backend/internal/models"
+	"photosync-backend/internal/storage"
+	"photosync-backend/internal/trash"
+)
+
+type photoServer struct {
+	*testServer
+	handler *PhotoHandler
+}
+
+// newPhotoServer serves alice's photos, created from files, from a local
+// backend.
+func newPhotoServer(t *testing.T, files ...string) *photoServer {
+	t.Helper()
+	s := newTestServer(t, files...)
+
+	h := NewPhotoHandler(s.pool, s.backend, storage.NewNameResolver(s.backend, storage.CollisionReject), s.changes, s.index,
+		albums.NewStore(s.backend), trash.NewBin(s.backend, time.Hour), s.sessions, time.Minute, nil, nil, 0)
+	return &photoServer{testServer: s, handler: h}
+}
+
+func TestBatchResults(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    []models.BatchResult
+		present []string
+		absent  []string
+	}{
+		{
+			name: "delete",
+			body: `{"op": "delete", "items": [{"id": "a.jpg"}, {"id": "missing.jpg"}, {"id": "../b.jpg"}, {"id": "2024/c.jpg"}, {"id": "2023"}]}`,
+			want: []models.BatchResult{
+				{ID: "a.jpg", Status: http.StatusNoContent},
+				{ID: "missing.jpg", Status: http.StatusNotFound, Error: "photo not found"},
+				{ID: "../b.jpg", Status: http.StatusBadRequest},
+				{ID: "2024/c.jpg", Status: http.StatusNoContent},
+				{ID: "2023", Status: http.StatusNotFound, Error: "photo not found"},
+			},
+			present: []string{"b.jpg", "2023/d.jpg", "2023/Holiday/e.jpg"},
+			absent:  []string{"a.jpg", "2024/c.jpg"},
+		},
+		{
+			name: "move",
+			body: `{"op": "move", "items": [{"id": "a.jpg", "to": "2025/a.jpg"}, {"id": "b.jpg", "to": "2024/c.jpg"}, {"id": "missing.jpg", "to": "x.jpg"}, {"id": "a.jpg"}]}`,
+			want: []models.BatchResult{
+				{ID: "a.jpg", Status: http.StatusOK},
+				{ID: "b.jpg", Status: http.StatusConflict, Error: "a photo with this name already exists"},
+				{ID: "missing.jpg", Status: http.StatusNotFound, Error: "photo not found"},
+				{ID: "a.jpg", Status: http.StatusBadRequest},
+			},
+			present: []string{"2025/a.jpg", "b.jpg", "2024/c.jpg"},
+			absent:  []string{"a.jpg", "x.jpg"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newPhotoServer(t, "a.jpg", "b.jpg", "2024/c.jpg", "2023/d.jpg", "2023/Holiday/e.jpg")
+
+			w := httptest.NewRecorder()
+			s.handler.Batch(w, s.request(context.Background(), http.MethodPost, "/api/photos/batch", tt.body))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+
+			var resp models.BatchResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if len(resp.Results) != len(tt.want) {
+				t.Fatalf("%d results, want %d", len(resp.Results), len(tt.want))
+			}
+			for i, want := range tt.want {
+				got := resp.Results[i]
+				if got.ID != want.ID || got.Status != want.Status {
+					t.Errorf("result %d = %s %d (%s), want %s %d", i, got.ID, got.Status, got.Error, want.ID, want.Status)
+				}
+				if want.Error != "" && got.Error != want.Error {
+					t.Errorf("result %d error = %q, want %q", i, got.Error, want.Error)
+				}
+				if got.Status >= 400 && got.Error == "" {
+					t.Errorf("result %d failed without an error", i)
+				}
+			}
+
+			for _, name := range tt.present {
+				if !s.exists(name) {
+					t.Errorf("%s missing", name)
+				}
+			}
+			for _, name := range tt.absent {
+				if s.exists(name) {
+					t.Errorf("%s still exists", name)
+				}
+			}
+		})
+	}
+}
+
+func TestBatchCancelled(t *testing.T) {
+	s := newPhotoServer(t, "a.jpg", "b.jpg")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	w := httptest.NewRecorder()
+	s.handler.Batch(w, s.request(ctx, http.MethodPost, "/api/photos/batch", `{"op": "delete", "items": [{"id": "a.jpg"}, {"id": "b.jpg"}]}`))
+	if w.Body.Len() != 0 {
+		t.Errorf("answered a client that is gone: %s", w.Body)
+	}
+	if !s.exists("a.jpg") || !s.exists("b.jpg") {
+		t.Error("items run after the client went away")
+	}
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"photosync-backend/internal/auth"
+	"photosync-backend/internal/models"
+)
+
+const maxHashBatch = 1000
+
+// CheckHashes reports which of a batch of SHA-256 digests the user already
+// has, so clients can skip uploading those files. It is answered from the
+// index alone; only before the user's files have first been read, when every
+// digest would be reported missing, is the client asked to retry while they
+// are read in the background.
+func (h *PhotoHandler) CheckHashes(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value("claims").(*auth.JWTClaims)
+
+	var req models.HashCheckRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.SHA256) > maxHashBatch {
+		http.Error(w, "too many hashes", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	sums := make([]string, len(req.SHA256))
+	for i, sum := range req.SHA256 {
+		sum = strings.ToLower(sum)
+		if b, err := hex.DecodeString(sum); err != nil || len(b) != 32 {
+			http.Error(w, "invalid sha256 digest", http.StatusBadRequest)
+			return
+		}
+		sums[i] = sum
+	}
+
+	inspected, err := h.index.Inspected(claims.Username)
+	if err != nil {
+		log.Printf("Index: Lookup for user %s failed: %v", claims.Username, err)
+		http.Error(w, "failed to check hashes", http.StatusInternalServerError)
+		return
+	}
+	if !inspected {
+		h.reconcile(w, r)
+		return
+	}
+
+	found, err := h.index.LookupHashes(claims.Username, sums)
+	if err != nil {
+		log.Printf("Hash lookup for user %s failed: %v", claims.Username, err)
+		http.Error(w, "failed to check hashes", http.StatusInternalServerError)
+		return
+	}
+
+	resp := models.HashCheckResponse{
+		Existing: found,
+		Missing:  []string{},
+	}
+	for _, sum := range sums {
+		if _, ok := found[sum]; !ok {
+			resp.Missing = append(resp.Missing, sum)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// reconcile starts reading the user's files in the background and asks the
+// client to retry.
+func (h *PhotoHandler) reconcile(w http.ResponseWriter, r *http.Request) {
+	claims, _, done, ok := userConnection(w, r, h.sessions, h.pool)
+	if !ok {
+		return
+	}
+	done()
+
+	if conn, release, ok := h.pool.Lease(claims.Username); ok {
+		go func() {
+			defer release()
+			if err := h.index.Reconcile(h.backend, conn, claims.Username); err != nil {
+				log.Printf("Index: Reconcile for user %s failed: %v", claims.Username, err)
+			}
+		}()
+	}
+
+	w.Header().Set("Retry-After", "5")
+	http.Error(w, "hashes are not ready yet, please retry", http.StatusServiceUnavailable)
+}
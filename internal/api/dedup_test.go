@@ -0,0 +1,81 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"photosync-backend/internal/models"
+)
+
+func digest(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestCheckHashes(t *testing.T) {
+	s := newPhotoServer(t, "a.jpg", "2024/b.jpg")
+
+	check := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.handler.CheckHashes(w, s.request(context.Background(), http.MethodPost, "/api/photos/check", body))
+		return w
+	}
+
+	body := `{"sha256": ["` + digest("a.jpg") + `", "` + strings.ToUpper(digest("2024/b.jpg")) + `", "` + digest("c.jpg") + `"]}`
+
+	// The first check starts reading the files instead of reporting them all
+	// missing.
+	w := check(body)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("first check: status %d, Retry-After %q, want 503 with Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for w.Code == http.StatusServiceUnavailable && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		w = check(body)
+	}
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+
+	var resp models.HashCheckResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{digest("a.jpg"): "a.jpg", digest("2024/b.jpg"): "2024/b.jpg"}
+	if len(resp.Existing) != len(want) {
+		t.Errorf("existing = %v, want %v", resp.Existing, want)
+	}
+	for sum, name := range want {
+		if resp.Existing[sum] != name {
+			t.Errorf("existing[%s] = %q, want %q", sum, resp.Existing[sum], name)
+		}
+	}
+	if len(resp.Missing) != 1 || resp.Missing[0] != digest("c.jpg") {
+		t.Errorf("missing = %v, want [%s]", resp.Missing, digest("c.jpg"))
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{name: "not hex", body: `{"sha256": ["xyz"]}`, wantStatus: http.StatusBadRequest},
+		{name: "short", body: `{"sha256": ["abcd"]}`, wantStatus: http.StatusBadRequest},
+		{name: "too many", body: `{"sha256": [` + strings.Repeat(`"`+digest("a")+`",`, maxHashBatch) + `"` + digest("a") + `"]}`, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "invalid body", body: `{`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w := check(tt.body); w.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.wantStatus)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"photosync-backend/internal/media"
+	"photosync-backend/internal/models"
+	"photosync-backend/internal/storage"
+)
+
+// ListFolder returns the files and subfolders directly inside the folder
+// given by the path query parameter, or the user's root without one.
+func (h *PhotoHandler) ListFolder(w http.ResponseWriter, r *http.Request) {
+	dir, ok := folderParam(w, r.URL.Query().Get("path"))
+	if !ok {
+		return
+	}
+
+	claims, conn, done, ok := userConnection(w, r, h.sessions, h.pool)
+	if !ok {
+		return
+	}
+	defer done()
+
+	entries, err := h.backend.ListDir(conn, claims.Username, dir)
+	if err != nil {
+		log.Printf("List of folder %q for user %s failed: %v", dir, claims.Username, err)
+		http.Error(w, "failed to list folder", storageErrorStatus(err))
+		return
+	}
+
+	for i, entry := range entries {
+		if entry.IsDir {
+			continue
+		}
+		if rec, err := h.index.Get(claims.Username, entry.Name); err == nil && rec.Complete() {
+			entries[i] = rec.FileInfo()
+			continue
+		}
+		entries[i].ContentType = media.ContentTypeByExtension(entry.Name)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.FolderResponse{Path: dir, Entries: entries})
+}
+
+// CreateFolder creates a folder and any missing parents.
+func (h *PhotoHandler) CreateFolder(w http.ResponseWriter, r *http.Request) {
+	var req models.FolderRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Path == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+	dir, ok := folderParam(w, req.Path)
+	if !ok {
+		return
+	}
+
+	claims, conn, done, ok := userConnection(w, r, h.sessions, h.pool)
+	if !ok {
+		return
+	}
+	defer done()
+
+	if err := h.backend.Mkdir(conn, claims.Username, dir); err != nil {
+		log.Printf("Mkdir %q for user %s failed: %v", dir, claims.Username, err)
+		http.Error(w, "failed to create folder", storageErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// DeleteFolder removes an empty folder.
+func (h *PhotoHandler) DeleteFolder(w http.ResponseWriter, r *http.Request) {
+	dir := r.URL.Query().Get("path")
+	if dir == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+	dir, ok := folderParam(w, dir)
+	if !ok {
+		return
+	}
+
+	claims, conn, done, ok := userConnection(w, r, h.sessions, h.pool)
+	if !ok {
+		return
+	}
+	defer done()
+
+	if err := h.backend.Rmdir(conn, claims.Username, dir); err != nil {
+		log.Printf("Rmdir %q for user %s failed: %v", dir, claims.Username, err)
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			http.Error(w, "folder not found", http.StatusNotFound)
+		case errors.Is(err, storage.ErrNotEmpty):
+			http.Error(w, "folder not empty", http.StatusConflict)
+		default:
+			http.Error(w, "failed to delete folder", storageErrorStatus(err))
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// folderParam validates an optional folder path supplied by a client,
+// answering 400 itself when it is unsafe. An empty path is the user's root.
+func folderParam(w http.ResponseWriter, dir string) (string, bool) {
+	if dir == "" {
+		return "", true
+	}
+	dir, err := storage.CleanPath(dir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	return dir, true
+}
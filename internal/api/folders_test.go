@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"photosync-backend/internal/models"
+)
+
+func TestListFolder(t *testing.T) {
+	s := newPhotoServer(t, "a.jpg", "2024/b.mov", "2024/06/c.jpg", ".photosync-albums.json")
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantPath   string
+		want       []string
+	}{
+		{name: "root", wantStatus: http.StatusOK, want: []string{"2024/", "a.jpg image/jpeg"}},
+		{name: "folder", query: "?path=2024", wantStatus: http.StatusOK, wantPath: "2024", want: []string{"2024/06/", "2024/b.mov video/quicktime"}},
+		{name: "nested", query: "?path=2024/06", wantStatus: http.StatusOK, wantPath: "2024/06", want: []string{"2024/06/c.jpg image/jpeg"}},
+		{name: "missing", query: "?path=2023", wantStatus: http.StatusNotFound},
+		{name: "outside", query: "?path=../bob", wantStatus: http.StatusBadRequest},
+		{name: "hidden", query: "?path=.trash", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.handler.ListFolder(w, s.request(context.Background(), http.MethodGet, "/api/folders"+tt.query, ""))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var resp models.FolderResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, entry := range resp.Entries {
+				if entry.IsDir {
+					got = append(got, entry.Name+"/")
+				} else {
+					got = append(got, entry.Name+" "+entry.ContentType)
+				}
+			}
+			slices.Sort(got)
+			if resp.Path != tt.wantPath || !slices.Equal(got, tt.want) {
+				t.Errorf("folder = %q %v, want %q %v", resp.Path, got, tt.wantPath, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateAndDeleteFolder(t *testing.T) {
+	s := newPhotoServer(t, "2024/b.jpg")
+
+	create := func(body string) int {
+		w := httptest.NewRecorder()
+		s.handler.CreateFolder(w, s.request(context.Background(), http.MethodPost, "/api/folders", body))
+		return w.Code
+	}
+	remove := func(query string) int {
+		w := httptest.NewRecorder()
+		s.handler.DeleteFolder(w, s.request(context.Background(), http.MethodDelete, "/api/folders"+query, ""))
+		return w.Code
+	}
+
+	tests := []struct {
+		name       string
+		do         func() int
+		wantStatus int
+		wantDir    string
+		wantGone   string
+	}{
+		{name: "create with parents", do: func() int { return create(`{"path": "2025/06/Holiday"}`) }, wantStatus: http.StatusCreated, wantDir: "2025/06/Holiday"},
+		{name: "create existing", do: func() int { return create(`{"path": "2024"}`) }, wantStatus: http.StatusCreated, wantDir: "2024"},
+		{name: "create without path", do: func() int { return create(`{}`) }, wantStatus: http.StatusBadRequest},
+		{name: "create outside", do: func() int { return create(`{"path": "../bob"}`) }, wantStatus: http.StatusBadRequest},
+		{name: "create hidden", do: func() int { return create(`{"path": ".trash/x"}`) }, wantStatus: http.StatusBadRequest},
+		{name: "delete empty", do: func() int { return remove("?path=2025/06/Holiday") }, wantStatus: http.StatusNoContent, wantGone: "2025/06/Holiday", wantDir: "2025/06"},
+		{name: "delete not empty", do: func() int { return remove("?path=2024") }, wantStatus: http.StatusConflict, wantDir: "2024"},
+		{name: "delete missing", do: func() int { return remove("?path=2023") }, wantStatus: http.StatusNotFound},
+		{name: "delete a file", do: func() int { return remove("?path=2024/b.jpg") }, wantStatus: http.StatusNotFound},
+		{name: "delete without path", do: func() int { return remove("") }, wantStatus: http.StatusBadRequest},
+		{name: "delete outside", do: func() int { return remove("?path=..") }, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		if status := tt.do(); status != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d", tt.name, status, tt.wantStatus)
+		}
+		if tt.wantDir != "" && !s.exists(tt.wantDir) {
+			t.Errorf("%s: %s missing", tt.name, tt.wantDir)
+		}
+		if tt.wantGone != "" && s.exists(tt.wantGone) {
+			t.Errorf("%s: %s still exists", tt.name, tt.wantGone)
+		}
+	}
+	if !s.exists("2024/b.jpg") {
+		t.Error("2024/b.jpg deleted")
+	}
+}
@@ -0,0 +1,285 @@
+package api
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"photosync-backend/internal/media"
+	"photosync-backend/internal/models"
+	"photosync-backend/internal/storage"
+)
+
+const maxListLimit = 1000
+
+// listQuery holds the pagination, sorting and filter parameters of a photo
+// listing. Pages are addressed with an opaque keyset cursor naming the last
+// entry of the previous page, so uploads and deletes between requests never
+// cause entries to be skipped or repeated.
+type listQuery struct {
+	limit int
+	sort  string
+	order string
+	after *listCursor
+	from  time.Time
+	to    time.Time
+	exts  []string
+	kind  string
+
+	folder    string
+	recursive bool
+}
+
+// listCursor identifies the last entry of a page. ModTime holds the capture
+// time when sorting by it.
+type listCursor struct {
+	Sort    string `json:"s"`
+	Order   string `json:"o"`
+	Name    string `json:"n"`
+	Size    int64  `json:"z,omitempty"`
+	ModTime int64  `json:"t,omitempty"`
+}
+
+func parseListQuery(values url.Values) (*listQuery, error) {
+	q := &listQuery{
+		sort:  values.Get("sort"),
+		order: values.Get("order"),
+		kind:  values.Get("type"),
+
+		recursive: true,
+	}
+
+	if q.sort == "" {
+		q.sort = "name"
+	}
+	if q.sort != "name" && q.sort != "taken" && q.sort != "mtime" && q.sort != "size" {
+		return nil, errors.New("sort must be name, taken, mtime or size")
+	}
+
+	if q.order == "" {
+		q.order = "asc"
+	}
+	if q.order != "asc" && q.order != "desc" {
+		return nil, errors.New("order must be asc or desc")
+	}
+
+	if q.kind != "" && q.kind != "image" && q.kind != "video" {
+		return nil, errors.New("type must be image or video")
+	}
+
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return nil, errors.New("invalid limit")
+		}
+		q.limit = min(limit, maxListLimit)
+	}
+
+	if v := values.Get("cursor"); v != "" {
+		cursor, err := decodeListCursor(v)
+		if err != nil {
+			return nil, errors.New("invalid cursor")
+		}
+		if cursor.Sort != q.sort || cursor.Order != q.order {
+			return nil, errors.New("cursor does not match sort and order")
+		}
+		q.after = cursor
+	}
+
+	var err error
+	if q.from, err = parseListDate(values.Get("from"), false); err != nil {
+		return nil, errors.New("invalid from date")
+	}
+	if q.to, err = parseListDate(values.Get("to"), true); err != nil {
+		return nil, errors.New("invalid to date")
+	}
+
+	if v := values.Get("folder"); v != "" {
+		if q.folder, err = storage.CleanPath(v); err != nil {
+			return nil, err
+		}
+	}
+	if v := values.Get("recursive"); v != "" {
+		if q.recursive, err = strconv.ParseBool(v); err != nil {
+			return nil, errors.New("invalid recursive")
+		}
+	}
+
+	if v := values.Get("ext"); v != "" {
+		for _, ext := range strings.Split(v, ",") {
+			ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+			if ext != "" {
+				q.exts = append(q.exts, "."+ext)
+			}
+		}
+	}
+
+	return q, nil
+}
+
+// parseListDate accepts RFC 3339 timestamps or plain dates. A plain date used
+// as an upper bound covers the whole day.
+func parseListDate(value string, end bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if end {
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return t, nil
+}
+
+// apply filters and sorts files and cuts out the requested page. It returns
+// the page, the number of files matching the filters and the cursor for the
+// next page, which is empty on the last page.
+func (q *listQuery) apply(files []models.FileInfo) ([]models.FileInfo, int, string) {
+	matched := slices.DeleteFunc(files, func(file models.FileInfo) bool {
+		return !q.matches(file)
+	})
+	total := len(matched)
+
+	slices.SortFunc(matched, q.compare)
+
+	start := 0
+	if q.after != nil {
+		last := q.after.fileInfo()
+		start, _ = slices.BinarySearchFunc(matched, last, q.compare)
+		if start < len(matched) && q.compare(matched[start], last) == 0 {
+			start++
+		}
+	}
+	page := matched[start:]
+
+	if q.limit == 0 || len(page) <= q.limit {
+		return page, total, ""
+	}
+
+	page = page[:q.limit]
+	return page, total, q.cursorAfter(page[len(page)-1])
+}
+
+func (q *listQuery) matches(file models.FileInfo) bool {
+	if !q.inFolder(file.Name) {
+		return false
+	}
+	date := q.date(file)
+	if !q.from.IsZero() && date.Before(q.from) {
+		return false
+	}
+	if !q.to.IsZero() && date.After(q.to) {
+		return false
+	}
+	if len(q.exts) > 0 && !slices.Contains(q.exts, strings.ToLower(path.Ext(file.Name))) {
+		return false
+	}
+	if q.kind != "" && media.Kind(contentType(file)) != q.kind {
+		return false
+	}
+	return true
+}
+
+// inFolder reports whether name lies within the requested folder, or
+// directly in it when the listing is not recursive. Without a folder the
+// listing starts at the user's root.
+func (q *listQuery) inFolder(name string) bool {
+	dir := path.Dir(name)
+	if dir == "." {
+		dir = ""
+	}
+	if !q.recursive {
+		return dir == q.folder
+	}
+	return q.folder == "" || dir == q.folder || strings.HasPrefix(dir, q.folder+"/")
+}
+
+// date is the time from and to apply to: the capture time when sorting by it,
+// the modification time otherwise.
+func (q *listQuery) date(file models.FileInfo) time.Time {
+	if q.sort == "taken" {
+		return takenAt(file)
+	}
+	return file.ModTime
+}
+
+// takenAt is the time a photo or video was captured, or its modification
+// time when the file carries no capture date.
+func takenAt(file models.FileInfo) time.Time {
+	if file.Metadata != nil && !file.Metadata.CapturedAt.IsZero() {
+		return file.Metadata.CapturedAt
+	}
+	return file.ModTime.UTC()
+}
+
+func contentType(file models.FileInfo) string {
+	if file.ContentType != "" {
+		return file.ContentType
+	}
+	return media.ContentTypeByExtension(file.Name)
+}
+
+func (q *listQuery) compare(a, b models.FileInfo) int {
+	c := 0
+	switch q.sort {
+	case "taken":
+		c = takenAt(a).Compare(takenAt(b))
+	case "mtime":
+		c = a.ModTime.Compare(b.ModTime)
+	case "size":
+		c = cmp.Compare(a.Size, b.Size)
+	}
+	if c == 0 {
+		c = strings.Compare(a.Name, b.Name)
+	}
+	if q.order == "desc" {
+		c = -c
+	}
+	return c
+}
+
+func (q *listQuery) cursorAfter(file models.FileInfo) string {
+	data, _ := json.Marshal(listCursor{
+		Sort:    q.sort,
+		Order:   q.order,
+		Name:    file.Name,
+		Size:    file.Size,
+		ModTime: q.date(file).UnixNano(),
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeListCursor(value string) (*listCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var cursor listCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+func (c *listCursor) fileInfo() models.FileInfo {
+	return models.FileInfo{
+		Name:    c.Name,
+		Size:    c.Size,
+		ModTime: time.Unix(0, c.ModTime),
+	}
+}
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"photosync-backend/internal/models"
+)
+
+var listBase = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func listFixture() []models.FileInfo {
+	return []models.FileInfo{
+		{Name: "a.jpg", Size: 300, ModTime: listBase.Add(2 * time.Hour)},
+		{Name: "b.jpg", Size: 100, ModTime: listBase},
+		{Name: "c.mp4", Size: 100, ModTime: listBase.Add(time.Hour)},
+		{Name: "d.png", Size: 200, ModTime: listBase, Metadata: &models.PhotoMetadata{CapturedAt: listBase.Add(-48 * time.Hour)}},
+		{Name: "2024/e.jpg", Size: 200, ModTime: listBase.Add(3 * time.Hour)},
+		{Name: "2024/Trip/f.heic", Size: 50, ModTime: listBase.Add(time.Hour)},
+	}
+}
+
+func listedNames(files []models.FileInfo) []string {
+	out := make([]string, len(files))
+	for i, file := range files {
+		out[i] = file.Name
+	}
+	return out
+}
+
+// pageThrough follows cursors until the last page. Before each request after
+// the first, mutate may change the files as a concurrent upload would.
+func pageThrough(t *testing.T, values url.Values, files []models.FileInfo, mutate func(page int, files []models.FileInfo) []models.FileInfo) []string {
+	t.Helper()
+
+	var got []string
+	for page := 0; ; page++ {
+		if page > len(files)+1 {
+			t.Fatal("cursor did not advance")
+		}
+		if page > 0 && mutate != nil {
+			files = mutate(page, files)
+		}
+
+		q, err := parseListQuery(values)
+		if err != nil {
+			t.Fatalf("parseListQuery(%v) error = %v", values, err)
+		}
+		items, _, next := q.apply(slices.Clone(files))
+		got = append(got, listedNames(items)...)
+		if next == "" {
+			return got
+		}
+		values.Set("cursor", next)
+	}
+}
+
+func TestListQueryPages(t *testing.T) {
+	tests := []struct {
+		sort  string
+		order string
+		want  []string
+	}{
+		{"name", "asc", []string{"2024/Trip/f.heic", "2024/e.jpg", "a.jpg", "b.jpg", "c.mp4", "d.png"}},
+		{"name", "desc", []string{"d.png", "c.mp4", "b.jpg", "a.jpg", "2024/e.jpg", "2024/Trip/f.heic"}},
+		{"size", "asc", []string{"2024/Trip/f.heic", "b.jpg", "c.mp4", "2024/e.jpg", "d.png", "a.jpg"}},
+		{"size", "desc", []string{"a.jpg", "d.png", "2024/e.jpg", "c.mp4", "b.jpg", "2024/Trip/f.heic"}},
+		{"mtime", "asc", []string{"b.jpg", "d.png", "2024/Trip/f.heic", "c.mp4", "a.jpg", "2024/e.jpg"}},
+		{"taken", "asc", []string{"d.png", "b.jpg", "2024/Trip/f.heic", "c.mp4", "a.jpg", "2024/e.jpg"}},
+		{"taken", "desc", []string{"2024/e.jpg", "a.jpg", "c.mp4", "2024/Trip/f.heic", "b.jpg", "d.png"}},
+	}
+
+	for _, tt := range tests {
+		for _, limit := range []string{"1", "2", "4", "6", "100"} {
+			t.Run(tt.sort+"/"+tt.order+"/"+limit, func(t *testing.T) {
+				values := url.Values{"sort": {tt.sort}, "order": {tt.order}, "limit": {limit}}
+				got := pageThrough(t, values, listFixture(), nil)
+				if !slices.Equal(got, tt.want) {
+					t.Errorf("pages = %v, want %v", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestListQueryPagesStableUnderChanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(page int, files []models.FileInfo) []models.FileInfo
+		want   []string
+	}{
+		{
+			name: "delete listed entry",
+			mutate: func(page int, files []models.FileInfo) []models.FileInfo {
+				if page != 1 {
+					return files
+				}
+				return slices.DeleteFunc(files, func(file models.FileInfo) bool { return file.Name == "2024/e.jpg" })
+			},
+			want: []string{"2024/Trip/f.heic", "2024/e.jpg", "a.jpg", "b.jpg", "c.mp4", "d.png"},
+		},
+		{
+			name: "insert before cursor",
+			mutate: func(page int, files []models.FileInfo) []models.FileInfo {
+				if page != 1 {
+					return files
+				}
+				return append(files, models.FileInfo{Name: "0.jpg", ModTime: listBase})
+			},
+			want: []string{"2024/Trip/f.heic", "2024/e.jpg", "a.jpg", "b.jpg", "c.mp4", "d.png"},
+		},
+		{
+			name: "insert after cursor",
+			mutate: func(page int, files []models.FileInfo) []models.FileInfo {
+				if page != 1 {
+					return files
+				}
+				return append(files, models.FileInfo{Name: "bb.jpg", ModTime: listBase})
+			},
+			want: []string{"2024/Trip/f.heic", "2024/e.jpg", "a.jpg", "b.jpg", "bb.jpg", "c.mp4", "d.png"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := url.Values{"limit": {"2"}}
+			got := pageThrough(t, values, listFixture(), tt.mutate)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("pages = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListQueryFilters(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{name: "all", query: "", want: []string{"2024/Trip/f.heic", "2024/e.jpg", "a.jpg", "b.jpg", "c.mp4", "d.png"}},
+		{name: "folder", query: "folder=2024", want: []string{"2024/Trip/f.heic", "2024/e.jpg"}},
+		{name: "folder not recursive", query: "folder=2024&recursive=false", want: []string{"2024/e.jpg"}},
+		{name: "root not recursive", query: "recursive=false", want: []string{"a.jpg", "b.jpg", "c.mp4", "d.png"}},
+		{name: "extensions", query: "ext=JPG,.png", want: []string{"2024/e.jpg", "a.jpg", "b.jpg", "d.png"}},
+		{name: "videos", query: "type=video", want: []string{"c.mp4"}},
+		{name: "mtime range", query: "from=2024-05-01T13:00:00Z&to=2024-05-01T14:00:00Z", want: []string{"2024/Trip/f.heic", "a.jpg", "c.mp4"}},
+		{name: "taken on day", query: "sort=taken&from=2024-04-29&to=2024-04-29", want: []string{"d.png"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			q, err := parseListQuery(values)
+			if err != nil {
+				t.Fatalf("parseListQuery(%q) error = %v", tt.query, err)
+			}
+
+			items, total, next := q.apply(listFixture())
+			if got := listedNames(items); !slices.Equal(got, tt.want) {
+				t.Errorf("apply = %v, want %v", got, tt.want)
+			}
+			if total != len(tt.want) {
+				t.Errorf("total = %d, want %d", total, len(tt.want))
+			}
+			if next != "" {
+				t.Errorf("cursor = %q on the only page", next)
+			}
+		})
+	}
+}
+
+func TestParseListQueryErrors(t *testing.T) {
+	nameCursor := (&listQuery{sort: "name", order: "asc"}).cursorAfter(models.FileInfo{Name: "a.jpg"})
+
+	tests := []struct {
+		name  string
+		query url.Values
+	}{
+		{name: "sort", query: url.Values{"sort": {"color"}}},
+		{name: "order", query: url.Values{"order": {"up"}}},
+		{name: "type", query: url.Values{"type": {"audio"}}},
+		{name: "zero limit", query: url.Values{"limit": {"0"}}},
+		{name: "limit", query: url.Values{"limit": {"ten"}}},
+		{name: "cursor encoding", query: url.Values{"cursor": {"not base64!"}}},
+		{name: "cursor json", query: url.Values{"cursor": {"bm90IGpzb24"}}},
+		{name: "cursor for other sort", query: url.Values{"sort": {"size"}, "cursor": {nameCursor}}},
+		{name: "cursor for other order", query: url.Values{"order": {"desc"}, "cursor": {nameCursor}}},
+		{name: "from", query: url.Values{"from": {"yesterday"}}},
+		{name: "to", query: url.Values{"to": {"2024-13-01"}}},
+		{name: "folder", query: url.Values{"folder": {"../bob"}}},
+		{name: "recursive", query: url.Values{"recursive": {"maybe"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseListQuery(tt.query); err == nil {
+				t.Errorf("parseListQuery(%v) succeeded", tt.query)
+			}
+		})
+	}
+}
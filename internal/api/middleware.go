@@ -6,9 +6,12 @@ import (
 	"strings"
 
 	"photosync-backend/internal/auth"
+	"photosync-backend/internal/session"
 )
 
-func JWTMiddleware(jwtManager *auth.JWTManager) func(http.Handler) http.Handler {
+// JWTMiddleware accepts signed, unexpired access tokens whose session is not
+// on the revocation list, so that logging out cuts off a device at once.
+func JWTMiddleware(jwtManager *auth.JWTManager, sessions *session.Store) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			authHeader := r.Header.Get("Authorization")
@@ -31,6 +34,11 @@ func JWTMiddleware(jwtManager *auth.JWTManager) func(http.Handler) http.Handler
 				return
 			}
 
+			if sessions.Revoked(claims.SessionID) {
+				http.Error(w, "token revoked", http.StatusUnauthorized)
+				return
+			}
+
 			ctx := context.WithValue(r.Context(), "claims", claims)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
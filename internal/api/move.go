@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"photosync-backend/internal/models"
+	"photosync-backend/internal/storage"
+)
+
+var (
+	errNoDestination = errors.New("to is required")
+	errSameFile      = errors.New("destination is the photo itself")
+)
+
+// MovePhoto renames or moves a photo on the backend without transferring
+// its data through the client. Albums follow the photo to its new name.
+func (h *PhotoHandler) MovePhoto(w http.ResponseWriter, r *http.Request) {
+	h.serveTransfer(w, r, false)
+}
+
+func (h *PhotoHandler) CopyPhoto(w http.ResponseWriter, r *http.Request) {
+	h.serveTransfer(w, r, true)
+}
+
+func (h *PhotoHandler) serveTransfer(w http.ResponseWriter, r *http.Request, duplicate bool) {
+	action := "move"
+	if duplicate {
+		action = "copy"
+	}
+
+	from, ok := photoName(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.MoveRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	to, err := destination(from, req.To)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	claims, conn, done, ok := userConnection(w, r, h.sessions, h.pool)
+	if !ok {
+		return
+	}
+	defer done()
+
+	info, err := h.transfer(conn, claims.Username, from, to, req.Overwrite, duplicate)
+	if err != nil {
+		if errors.Is(err, storage.ErrExists) {
+			http.Error(w, "a photo with this name already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, "failed to "+action+" photo", storageErrorStatus(err))
+		return
+	}
+
+	status := http.StatusOK
+	if duplicate {
+		status = http.StatusCreated
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(info)
+}
+
+// transfer moves or copies from to to, a destination already checked with
+// destination, and returns the info of the new file.
+func (h *PhotoHandler) transfer(conn storage.Connection, username, from, to string, overwrite, duplicate bool) (models.FileInfo, error) {
+	if _, err := h.backend.Stat(conn, username, from); err != nil {
+		return models.FileInfo{}, err
+	}
+
+	release, err := h.names.Claim(conn, username, to, overwrite)
+	if err != nil {
+		if !errors.Is(err, storage.ErrExists) {
+			log.Printf("Name check for %s for user %s failed: %v", to, username, err)
+		}
+		return models.FileInfo{}, err
+	}
+	defer release()
+
+	action := "move"
+	if duplicate {
+		action = "copy"
+		err = h.backend.Copy(conn, username, from, to)
+	} else {
+		err = h.backend.Rename(conn, username, from, to)
+	}
+	if err != nil {
+		log.Printf("Failed to %s %s to %s for user %s: %v", action, from, to, username, err)
+		return models.FileInfo{}, err
+	}
+
+	if duplicate {
+		return h.recorder.copied(conn, username, from, to), nil
+	}
+
+	info := h.recorder.moved(conn, username, from, to)
+	if err := h.albums.RenamePhoto(conn, username, from, to); err != nil {
+		log.Printf("Albums: Failed to follow move of %s for user %s: %v", from, username, err)
+	}
+	return info, nil
+}
+
+// destination validates the destination path of a move or copy of from.
+func destination(from, to string) (string, error) {
+	if to == "" {
+		return "", errNoDestination
+	}
+	to, err := storage.CleanPath(to)
+	if err != nil {
+		return "", err
+	}
+	if to == from {
+		return "", errSameFile
+	}
+	return to, nil
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"photosync-backend/internal/index"
+	"photosync-backend/internal/models"
+)
+
+func TestTransferPhoto(t *testing.T) {
+	tests := []struct {
+		name       string
+		action     string
+		id         string
+		body       string
+		wantStatus int
+		wantFile   string
+		wantData   string
+		wantKept   bool
+	}{
+		{name: "move into a new folder", action: "move", id: "a.jpg", body: `{"to": "2025/06/a.jpg"}`, wantStatus: http.StatusOK, wantFile: "2025/06/a.jpg", wantData: "a.jpg"},
+		{name: "move out of a folder", action: "move", id: "2024/b.jpg", body: `{"to": "b.jpg"}`, wantStatus: http.StatusOK, wantFile: "b.jpg", wantData: "2024/b.jpg"},
+		{name: "move onto a photo", action: "move", id: "a.jpg", body: `{"to": "2024/b.jpg"}`, wantStatus: http.StatusConflict, wantFile: "2024/b.jpg", wantData: "2024/b.jpg", wantKept: true},
+		{name: "move with overwrite", action: "move", id: "a.jpg", body: `{"to": "2024/b.jpg", "overwrite": true}`, wantStatus: http.StatusOK, wantFile: "2024/b.jpg", wantData: "a.jpg"},
+		{name: "move onto a folder", action: "move", id: "a.jpg", body: `{"to": "2024", "overwrite": true}`, wantStatus: http.StatusConflict, wantKept: true},
+		{name: "move onto itself", action: "move", id: "a.jpg", body: `{"to": "a.jpg"}`, wantStatus: http.StatusBadRequest, wantKept: true},
+		{name: "move without destination", action: "move", id: "a.jpg", body: `{}`, wantStatus: http.StatusBadRequest, wantKept: true},
+		{name: "move outside", action: "move", id: "a.jpg", body: `{"to": "../bob/a.jpg"}`, wantStatus: http.StatusBadRequest, wantKept: true},
+		{name: "move a missing photo", action: "move", id: "missing.jpg", body: `{"to": "c.jpg"}`, wantStatus: http.StatusNotFound},
+		{name: "move a folder", action: "move", id: "2024", body: `{"to": "2023"}`, wantStatus: http.StatusNotFound, wantFile: "2024/b.jpg", wantData: "2024/b.jpg"},
+		{name: "copy", action: "copy", id: "a.jpg", body: `{"to": "2024/a.jpg"}`, wantStatus: http.StatusCreated, wantFile: "2024/a.jpg", wantData: "a.jpg", wantKept: true},
+		{name: "copy onto a photo", action: "copy", id: "a.jpg", body: `{"to": "2024/b.jpg"}`, wantStatus: http.StatusConflict, wantFile: "2024/b.jpg", wantData: "2024/b.jpg", wantKept: true},
+		{name: "copy with overwrite", action: "copy", id: "a.jpg", body: `{"to": "2024/b.jpg", "overwrite": true}`, wantStatus: http.StatusCreated, wantFile: "2024/b.jpg", wantData: "a.jpg", wantKept: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newPhotoServer(t, "a.jpg", "2024/b.jpg")
+
+			r := chi.NewRouter()
+			r.Post("/api/photos/{id}/move", s.handler.MovePhoto)
+			r.Post("/api/photos/{id}/copy", s.handler.CopyPhoto)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, s.request(context.Background(), http.MethodPost, "/api/photos/"+url.PathEscape(tt.id)+"/"+tt.action, tt.body))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+
+			if w.Code < 300 {
+				var info models.FileInfo
+				if err := json.NewDecoder(w.Body).Decode(&info); err != nil {
+					t.Fatal(err)
+				}
+				if info.Name != tt.wantFile || info.Size != int64(len(tt.wantData)) {
+					t.Errorf("response = %+v, want %s of %d bytes", info, tt.wantFile, len(tt.wantData))
+				}
+			}
+			if tt.wantFile != "" {
+				data, err := os.ReadFile(filepath.Join(s.base, filepath.FromSlash(tt.wantFile)))
+				if err != nil || string(data) != tt.wantData {
+					t.Errorf("%s = %q, %v, want %q", tt.wantFile, data, err, tt.wantData)
+				}
+			}
+			if tt.id == "a.jpg" && s.exists("a.jpg") != tt.wantKept {
+				t.Errorf("a.jpg kept = %v, want %v", !tt.wantKept, tt.wantKept)
+			}
+		})
+	}
+}
+
+func TestTransferPhotoIndex(t *testing.T) {
+	for _, action := range []string{"move", "copy"} {
+		t.Run(action, func(t *testing.T) {
+			s := newPhotoServer(t, "a.jpg")
+			info, err := s.backend.Stat(s.connection(t), "alice", "a.jpg")
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = s.index.Put("alice", &index.Record{Name: "a.jpg", Size: info.Size, ModTime: info.ModTime, ContentType: "image/jpeg", SHA256: "abc"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := chi.NewRouter()
+			r.Post("/api/photos/{id}/move", s.handler.MovePhoto)
+			r.Post("/api/photos/{id}/copy", s.handler.CopyPhoto)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, s.request(context.Background(), http.MethodPost, "/api/photos/a.jpg/"+action, `{"to": "2024/a.jpg"}`))
+			if w.Code >= 300 {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+
+			// The content is unchanged, so the hash comes along.
+			rec, err := s.index.Get("alice", "2024/a.jpg")
+			if err != nil || rec.SHA256 != "abc" {
+				t.Errorf("record of the new file = %+v, %v, want the hash kept", rec, err)
+			}
+			_, err = s.index.Get("alice", "a.jpg")
+			if moved := errors.Is(err, index.ErrNotFound); moved != (action == "move") {
+				t.Errorf("record of a.jpg after %s: %v", action, err)
+			}
+		})
+	}
+}
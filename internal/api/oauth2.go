@@ -0,0 +1,266 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"photosync-backend/internal/auth"
+	"photosync-backend/internal/models"
+	"photosync-backend/internal/session"
+)
+
+const (
+	// oauth2Cookie binds a sign-in to the browser that started it. It lives
+	// as long as the provider lets a sign-in take.
+	oauth2Cookie    = "photosync_oauth2"
+	oauth2CookieAge = 10 * time.Minute
+
+	// loginCodeExpiry is how long the app has to redeem its login code.
+	loginCodeExpiry = time.Minute
+	maxLoginCodes   = 1000
+)
+
+// loginCode holds the session of a finished OAuth2 sign-in until the app
+// redeems it with the verifier of challenge.
+type loginCode struct {
+	sess         *session.Session
+	refreshToken string
+	challenge    string
+	expires      time.Time
+}
+
+// oauth2Login is what the cookie remembers of a sign-in.
+type oauth2Login struct {
+	state     string
+	appURL    string
+	challenge string
+}
+
+// binding is what the state of the sign-in is signed with, so that it only
+// completes with this cookie.
+func (l oauth2Login) binding() string {
+	return l.appURL + "\x00" + l.challenge
+}
+
+func (l oauth2Login) cookie() *http.Cookie {
+	return &http.Cookie{
+		Name:     oauth2Cookie,
+		Value:    l.state + "." + base64.RawURLEncoding.EncodeToString([]byte(l.appURL)) + "." + l.challenge,
+		Path:     "/api/auth/oauth2",
+		MaxAge:   int(oauth2CookieAge.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// OAuth2Start sends the browser to the identity provider to sign in. The
+// provider returns it to OAuth2Callback, which passes a one-time code on to
+// the app at redirect_uri. The app redeems the code at OAuth2Token with the
+// verifier of code_challenge.
+func (h *AuthHandler) OAuth2Start(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.authenticator.(auth.RedirectAuthenticator)
+	if !ok {
+		http.Error(w, "oauth2 login is not enabled", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	login := oauth2Login{appURL: query.Get("redirect_uri"), challenge: query.Get("code_challenge")}
+	if login.appURL == "" && len(h.appRedirects) > 0 {
+		login.appURL = h.appRedirects[0]
+	}
+	if !slices.Contains(h.appRedirects, login.appURL) {
+		http.Error(w, "redirect_uri is not registered", http.StatusBadRequest)
+		return
+	}
+	if method := query.Get("code_challenge_method"); method != "" && method != "S256" {
+		http.Error(w, "code_challenge_method must be S256", http.StatusBadRequest)
+		return
+	}
+	if challenge, err := base64.RawURLEncoding.DecodeString(login.challenge); err != nil || len(challenge) != sha256.Size {
+		http.Error(w, "code_challenge must be an S256 challenge", http.StatusBadRequest)
+		return
+	}
+
+	authURL, state, err := provider.AuthCodeURL(login.binding())
+	if err != nil {
+		log.Printf("OAuth2: Failed to start login: %v", err)
+		http.Error(w, "failed to start login", http.StatusServiceUnavailable)
+		return
+	}
+	login.state = state
+
+	http.SetCookie(w, login.cookie())
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OAuth2Callback completes a sign-in at the identity provider and sends the
+// browser back to the app with a one-time code for the session's tokens.
+// The tokens themselves never pass through the browser.
+func (h *AuthHandler) OAuth2Callback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.authenticator.(auth.RedirectAuthenticator)
+	if !ok {
+		http.Error(w, "oauth2 login is not enabled", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	login, ok := h.readLoginCookie(r, query.Get("state"))
+	clearCookie := login.cookie()
+	clearCookie.Value, clearCookie.MaxAge = "", -1
+	http.SetCookie(w, clearCookie)
+	if !ok {
+		http.Error(w, "invalid or expired login, please start again", http.StatusBadRequest)
+		return
+	}
+
+	if query.Get("error") != "" {
+		redirectApp(w, r, login.appURL, "error", "access_denied")
+		return
+	}
+
+	userInfo, err := provider.Exchange(r.Context(), login.state, login.binding(), query.Get("code"))
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidState) {
+			http.Error(w, "invalid or expired login, please start again", http.StatusBadRequest)
+			return
+		}
+		log.Printf("OAuth2: Login failed: %v", err)
+		redirectApp(w, r, login.appURL, "error", "login_failed")
+		return
+	}
+
+	sess, refreshToken, err := h.sessions.Create(userInfo.Username, "", r.UserAgent())
+	if err != nil {
+		log.Printf("Sessions: Failed to create session for user %s: %v", userInfo.Username, err)
+		redirectApp(w, r, login.appURL, "error", "server_error")
+		return
+	}
+
+	code, err := h.addLoginCode(loginCode{sess: sess, refreshToken: refreshToken, challenge: login.challenge})
+	if err != nil {
+		log.Printf("OAuth2: Failed to hand out login code for user %s: %v", userInfo.Username, err)
+		h.sessions.Delete(sess.ID)
+		redirectApp(w, r, login.appURL, "error", "server_error")
+		return
+	}
+
+	redirectApp(w, r, login.appURL, "code", code)
+}
+
+// OAuth2Token redeems the one-time code of an OAuth2 sign-in for the
+// session's tokens, answering like Login. A code works once, also when the
+// verifier is wrong: a second attempt means the code leaked.
+func (h *AuthHandler) OAuth2Token(w http.ResponseWriter, r *http.Request) {
+	var req models.OAuth2TokenRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil || req.Code == "" || req.CodeVerifier == "" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	h.mu.Lock()
+	login, ok := h.loginCodes[req.Code]
+	delete(h.loginCodes, req.Code)
+	h.mu.Unlock()
+	if !ok || time.Now().After(login.expires) {
+		if ok {
+			h.sessions.Delete(login.sess.ID)
+		}
+		http.Error(w, "invalid or expired code", http.StatusUnauthorized)
+		return
+	}
+
+	sum := sha256.Sum256([]byte(req.CodeVerifier))
+	if subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(login.challenge)) != 1 {
+		h.sessions.Delete(login.sess.ID)
+		http.Error(w, "invalid or expired code", http.StatusUnauthorized)
+		return
+	}
+
+	if !h.issueTokens(w, login.sess, login.refreshToken) {
+		h.sessions.Delete(login.sess.ID)
+	}
+}
+
+// readLoginCookie returns the sign-in the browser started, if it is the one
+// with state and its app URL is still registered.
+func (h *AuthHandler) readLoginCookie(r *http.Request, state string) (oauth2Login, bool) {
+	cookie, err := r.Cookie(oauth2Cookie)
+	if err != nil || state == "" {
+		return oauth2Login{}, false
+	}
+
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 3 {
+		return oauth2Login{}, false
+	}
+	appURL, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return oauth2Login{}, false
+	}
+	login := oauth2Login{state: parts[0], appURL: string(appURL), challenge: parts[2]}
+
+	if subtle.ConstantTimeCompare([]byte(login.state), []byte(state)) != 1 || !slices.Contains(h.appRedirects, login.appURL) {
+		return oauth2Login{}, false
+	}
+	return login, true
+}
+
+// addLoginCode stores login under a new one-time code. Sessions whose codes
+// expired unredeemed are deleted.
+func (h *AuthHandler) addLoginCode(login loginCode) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	code := base64.RawURLEncoding.EncodeToString(buf)
+
+	var expired []string
+	h.mu.Lock()
+	now := time.Now()
+	for c, l := range h.loginCodes {
+		if now.After(l.expires) {
+			expired = append(expired, l.sess.ID)
+			delete(h.loginCodes, c)
+		}
+	}
+	full := len(h.loginCodes) >= maxLoginCodes
+	if !full {
+		login.expires = now.Add(loginCodeExpiry)
+		h.loginCodes[code] = login
+	}
+	h.mu.Unlock()
+
+	for _, id := range expired {
+		h.sessions.Delete(id)
+	}
+	if full {
+		return "", errors.New("too many unredeemed login codes")
+	}
+	return code, nil
+}
+
+// redirectApp sends the browser to the app at appURL with key set to value
+// in the query.
+func redirectApp(w http.ResponseWriter, r *http.Request, appURL, key, value string) {
+	u, err := url.Parse(appURL)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusInternalServerError)
+		return
+	}
+	query := u.Query()
+	query.Set(key, value)
+	u.RawQuery = query.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
@@ -0,0 +1,225 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"photosync-backend/internal/auth"
+	"photosync-backend/internal/models"
+	"photosync-backend/internal/session"
+)
+
+// fakeProvider signs in as alice for every state it handed out, along with
+// the binding it was handed out for.
+type fakeProvider struct {
+	auth.Authenticator
+	next   int
+	states map[string]string
+}
+
+func (p *fakeProvider) AuthCodeURL(binding string) (string, string, error) {
+	p.next++
+	state := "state" + strconv.Itoa(p.next)
+	p.states[state] = binding
+	return "https://idp.example/authorize?state=" + state, state, nil
+}
+
+func (p *fakeProvider) Exchange(ctx context.Context, state, binding, code string) (*models.UserInfo, error) {
+	if bound, ok := p.states[state]; !ok || bound != binding {
+		return nil, auth.ErrInvalidState
+	}
+	delete(p.states, state)
+	return &models.UserInfo{Username: "alice"}, nil
+}
+
+const testVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+func testChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func newOAuth2Handler(t *testing.T) *AuthHandler {
+	t.Helper()
+
+	sessions, err := session.Open(filepath.Join(t.TempDir(), "sessions.db"), []session.Key{{ID: "test", Key: make([]byte, 32)}}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sessions.Close() })
+	keys, err := auth.NewKeySet(auth.NewHMACKey("test", []byte("secret")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider := &fakeProvider{states: make(map[string]string)}
+	return NewAuthHandler(provider, auth.NewJWTManager(keys, "test", time.Hour), sessions, []string{"photosync://login", "https://app.example/done"})
+}
+
+// start begins a sign-in and returns its state and cookie.
+func start(t *testing.T, h *AuthHandler, query string) (string, *http.Cookie) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	h.OAuth2Start(w, httptest.NewRequest(http.MethodGet, "/api/auth/oauth2/start?"+query, nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("start: status %d: %s", w.Code, w.Body)
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly || !cookies[0].Secure {
+		t.Fatalf("start: cookies %v, want one HttpOnly, Secure cookie", cookies)
+	}
+	return location.Query().Get("state"), cookies[0]
+}
+
+func callback(h *AuthHandler, query string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/oauth2/callback?"+query, nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	h.OAuth2Callback(w, req)
+	return w
+}
+
+func redeem(h *AuthHandler, code, verifier string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(models.OAuth2TokenRequest{Code: code, CodeVerifier: verifier})
+	w := httptest.NewRecorder()
+	h.OAuth2Token(w, httptest.NewRequest(http.MethodPost, "/api/auth/oauth2/token", strings.NewReader(string(body))))
+	return w
+}
+
+func TestOAuth2Start(t *testing.T) {
+	h := newOAuth2Handler(t)
+	challenge := testChallenge(testVerifier)
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+	}{
+		{name: "default app URL", query: "code_challenge=" + challenge, wantStatus: http.StatusFound},
+		{name: "registered app URL", query: "code_challenge=" + challenge + "&code_challenge_method=S256&redirect_uri=" + url.QueryEscape("https://app.example/done"), wantStatus: http.StatusFound},
+		{name: "unregistered app URL", query: "code_challenge=" + challenge + "&redirect_uri=" + url.QueryEscape("https://evil.example/"), wantStatus: http.StatusBadRequest},
+		{name: "no challenge", wantStatus: http.StatusBadRequest},
+		{name: "plain challenge", query: "code_challenge=" + testVerifier + "&code_challenge_method=plain", wantStatus: http.StatusBadRequest},
+		{name: "short challenge", query: "code_challenge=abc", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.OAuth2Start(w, httptest.NewRequest(http.MethodGet, "/api/auth/oauth2/start?"+tt.query, nil))
+			if w.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
+
+func TestOAuth2Callback(t *testing.T) {
+	h := newOAuth2Handler(t)
+	challenge := testChallenge(testVerifier)
+
+	state, cookie := start(t, h, "code_challenge="+challenge)
+	otherState, otherCookie := start(t, h, "code_challenge="+challenge)
+
+	tests := []struct {
+		name         string
+		query        string
+		cookie       *http.Cookie
+		wantStatus   int
+		wantRedirect string
+	}{
+		// An attacker's sign-in completed in the victim's browser, which has
+		// no cookie or one of its own sign-in.
+		{name: "no cookie", query: "state=" + state + "&code=c", wantStatus: http.StatusBadRequest},
+		{name: "cookie of another sign-in", query: "state=" + state + "&code=c", cookie: otherCookie, wantStatus: http.StatusBadRequest},
+		{name: "denied", query: "state=" + otherState + "&error=access_denied", cookie: otherCookie, wantStatus: http.StatusFound, wantRedirect: "photosync://login?error=access_denied"},
+		{name: "signed in", query: "state=" + state + "&code=c", cookie: cookie, wantStatus: http.StatusFound, wantRedirect: "photosync://login?code="},
+		{name: "state used", query: "state=" + state + "&code=c", cookie: cookie, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := callback(h, tt.query, tt.cookie)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if location := w.Header().Get("Location"); !strings.HasPrefix(location, tt.wantRedirect) {
+				t.Errorf("redirected to %q, want %q", location, tt.wantRedirect)
+			}
+			if strings.Contains(w.Body.String(), "token") {
+				t.Errorf("tokens in the callback body: %s", w.Body)
+			}
+			if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+				t.Errorf("cookies %v, want the sign-in cookie cleared", cookies)
+			}
+		})
+	}
+}
+
+func TestOAuth2Token(t *testing.T) {
+	h := newOAuth2Handler(t)
+
+	signIn := func(t *testing.T) string {
+		t.Helper()
+		state, cookie := start(t, h, "code_challenge="+testChallenge(testVerifier))
+		w := callback(h, "state="+state+"&code=c", cookie)
+		location, err := url.Parse(w.Header().Get("Location"))
+		if err != nil || location.Query().Get("code") == "" {
+			t.Fatalf("callback: status %d, redirected to %q", w.Code, w.Header().Get("Location"))
+		}
+		return location.Query().Get("code")
+	}
+
+	code := signIn(t)
+	if w := redeem(h, code, testVerifier); w.Code != http.StatusOK {
+		t.Fatalf("redeem: status %d: %s", w.Code, w.Body)
+	} else {
+		var resp models.LoginResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.Token == "" || resp.RefreshToken == "" {
+			t.Errorf("redeem = %+v, %v, want tokens", resp, err)
+		}
+	}
+	if w := redeem(h, code, testVerifier); w.Code != http.StatusUnauthorized {
+		t.Errorf("second redeem: status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	// A code intercepted by another app is useless without the verifier,
+	// and is gone after one try.
+	code = signIn(t)
+	if w := redeem(h, code, "wrong-verifier"); w.Code != http.StatusUnauthorized {
+		t.Errorf("redeem with the wrong verifier: status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if w := redeem(h, code, testVerifier); w.Code != http.StatusUnauthorized {
+		t.Errorf("redeem after a wrong verifier: status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if sessions, err := h.sessions.List("alice"); err != nil || len(sessions) != 1 {
+		t.Errorf("alice has %d sessions, %v, want only the redeemed one", len(sessions), err)
+	}
+
+	code = signIn(t)
+	h.mu.Lock()
+	login := h.loginCodes[code]
+	login.expires = time.Now().Add(-time.Second)
+	h.loginCodes[code] = login
+	h.mu.Unlock()
+	if w := redeem(h, code, testVerifier); w.Code != http.StatusUnauthorized {
+		t.Errorf("redeem after expiry: status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
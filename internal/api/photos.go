@@ -2,180 +2,476 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
-	"photosync-backend/internal/auth"
+	"photosync-backend/internal/albums"
+	"photosync-backend/internal/index"
+	"photosync-backend/internal/journal"
+	"photosync-backend/internal/media"
 	"photosync-backend/internal/models"
+	"photosync-backend/internal/session"
 	"photosync-backend/internal/storage"
+	"photosync-backend/internal/thumbnail"
+	"photosync-backend/internal/trash"
 )
 
 type PhotoHandler struct {
-	pool       *storage.GenericConnectionPool
-	backend    storage.StorageBackend
-	jwtManager *auth.JWTManager
+	pool                 *storage.GenericConnectionPool
+	backend              storage.StorageBackend
+	names                *storage.NameResolver
+	index                *index.Index
+	albums               *albums.Store
+	trash                *trash.Bin
+	recorder             *fileRecorder
+	sessions             *session.Store
+	transferTimeout      time.Duration
+	thumbnails           *thumbnail.Generator
+	thumbnailSizes       []int
+	defaultThumbnailSize int
 }
 
-func NewPhotoHandler(pool *storage.GenericConnectionPool, backend storage.StorageBackend, jwtManager *auth.JWTManager) *PhotoHandler {
+func NewPhotoHandler(pool *storage.GenericConnectionPool, backend storage.StorageBackend, names *storage.NameResolver, changes *journal.Journal, files *index.Index, albumStore *albums.Store, bin *trash.Bin, sessions *session.Store, transferTimeout time.Duration, thumbnails *thumbnail.Generator, thumbnailSizes []int, defaultThumbnailSize int) *PhotoHandler {
 	return &PhotoHandler{
-		pool:       pool,
-		backend:    backend,
-		jwtManager: jwtManager,
+		pool:                 pool,
+		backend:              backend,
+		names:                names,
+		index:                files,
+		albums:               albumStore,
+		trash:                bin,
+		recorder:             &fileRecorder{backend: backend, changes: changes, index: files},
+		sessions:             sessions,
+		transferTimeout:      transferTimeout,
+		thumbnails:           thumbnails,
+		thumbnailSizes:       thumbnailSizes,
+		defaultThumbnailSize: defaultThumbnailSize,
 	}
 }
 
 func (h *PhotoHandler) ListPhotos(w http.ResponseWriter, r *http.Request) {
-	claims := r.Context().Value("claims").(*auth.JWTClaims)
-
-	password, err := h.jwtManager.DecryptPassword(claims.EncryptedPassword)
+	query, err := parseListQuery(r.URL.Query())
 	if err != nil {
-		http.Error(w, "authentication error", http.StatusUnauthorized)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	conn, err := h.pool.GetConnection(claims.Username, password)
-	if err != nil {
-		http.Error(w, "failed to connect to storage", http.StatusInternalServerError)
+	claims, conn, done, ok := userConnection(w, r, h.sessions, h.pool)
+	if !ok {
 		return
 	}
+	defer done()
 
-	files, err := h.backend.List(conn, claims.Username)
+	files, err := listFiles(h.index, h.backend, conn, claims.Username)
 	if err != nil {
+		log.Printf("List for user %s failed: %v", claims.Username, err)
 		http.Error(w, "failed to list photos", http.StatusInternalServerError)
 		return
 	}
 
+	writePage(w, query, files)
+}
+
+// writePage responds with the page of files selected by query.
+func writePage(w http.ResponseWriter, query *listQuery, files []models.FileInfo) {
+	page, total, next := query.apply(files)
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(files)
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if next != "" {
+		w.Header().Set("X-Next-Cursor", next)
+	}
+	json.NewEncoder(w).Encode(page)
 }
 
 func (h *PhotoHandler) UploadPhoto(w http.ResponseWriter, r *http.Request) {
-	claims := r.Context().Value("claims").(*auth.JWTClaims)
+	extendTransferDeadlines(w, h.transferTimeout)
+
+	folder, ok := folderParam(w, r.URL.Query().Get("folder"))
+	if !ok {
+		return
+	}
 
-	err := r.ParseMultipartForm(32 << 20)
+	reader, err := r.MultipartReader()
 	if err != nil {
 		http.Error(w, "failed to parse form", http.StatusBadRequest)
 		return
 	}
 
-	file, header, err := r.FormFile("photo")
+	file, err := nextFilePart(reader, "photo")
 	if err != nil {
 		http.Error(w, "no photo provided", http.StatusBadRequest)
 		return
 	}
 	defer file.Close()
 
-	password, err := h.jwtManager.DecryptPassword(claims.EncryptedPassword)
+	filename, err := storage.CleanFilename(file.FileName())
 	if err != nil {
-		http.Error(w, "authentication error", http.StatusUnauthorized)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filename = path.Join(folder, filename)
+
+	claims, conn, done, ok := userConnection(w, r, h.sessions, h.pool)
+	if !ok {
 		return
 	}
+	defer done()
 
-	conn, err := h.pool.GetConnection(claims.Username, password)
+	storedName, release, err := h.names.Reserve(conn, claims.Username, filename)
 	if err != nil {
-		http.Error(w, "failed to connect to storage", http.StatusInternalServerError)
+		if errors.Is(err, storage.ErrExists) {
+			http.Error(w, "a photo with this name already exists", http.StatusConflict)
+			return
+		}
+		log.Printf("Name check for %s for user %s failed: %v", filename, claims.Username, err)
+		http.Error(w, "failed to upload photo", storageErrorStatus(err))
 		return
 	}
+	defer release()
 
-	err = h.backend.Upload(conn, claims.Username, header.Filename, file)
+	digest := index.NewDigest()
+	err = h.backend.Upload(conn, claims.Username, storedName, io.TeeReader(file, digest))
 	if err != nil {
-		http.Error(w, "failed to upload photo", http.StatusInternalServerError)
+		log.Printf("Upload of %s for user %s failed: %v", storedName, claims.Username, err)
+		http.Error(w, "failed to upload photo", storageErrorStatus(err))
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(models.UploadResponse{
+	h.recorder.uploaded(conn, claims.Username, storedName, digest)
+
+	resp := models.UploadResponse{
 		Message:  "photo uploaded successfully",
-		Filename: header.Filename,
-	})
+		Filename: storedName,
+	}
+	if storedName != filename {
+		resp.OriginalFilename = filename
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
 }
 
-func (h *PhotoHandler) DownloadPhoto(w http.ResponseWriter, r *http.Request) {
-	claims := r.Context().Value("claims").(*auth.JWTClaims)
-	photoID := chi.URLParam(r, "id")
+// photoName returns the validated {id} URL parameter, answering 400 itself
+// when the path is unsafe. Files in folders are addressed with the slashes
+// escaped as %2F; chi then matches against the escaped path, so the
+// parameter is unescaped here.
+func photoName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name := chi.URLParam(r, "id")
+	if r.URL.RawPath != "" {
+		unescaped, err := url.PathUnescape(name)
+		if err != nil {
+			http.Error(w, "invalid file name", http.StatusBadRequest)
+			return "", false
+		}
+		name = unescaped
+	}
 
-	password, err := h.jwtManager.DecryptPassword(claims.EncryptedPassword)
+	name, err := storage.CleanPath(name)
 	if err != nil {
-		http.Error(w, "authentication error", http.StatusUnauthorized)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	return name, true
+}
+
+func storageErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrInvalidPath):
+		return http.StatusBadRequest
+	case errors.Is(err, storage.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrNotEmpty), errors.Is(err, storage.ErrExists):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// nextFilePart advances the multipart stream to the named file field so its
+// body can be handed to the backend without buffering the whole form.
+func nextFilePart(reader *multipart.Reader, field string) (*multipart.Part, error) {
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == field && part.FileName() != "" {
+			return part, nil
+		}
+		part.Close()
+	}
+}
+
+// extendTransferDeadlines replaces the server-wide read/write timeouts, which
+// are sized for small API calls, with the longer per-transfer timeout.
+func extendTransferDeadlines(w http.ResponseWriter, timeout time.Duration) {
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(timeout)
+	if err := rc.SetReadDeadline(deadline); err != nil {
+		log.Printf("Could not extend read deadline: %v", err)
+	}
+	if err := rc.SetWriteDeadline(deadline); err != nil {
+		log.Printf("Could not extend write deadline: %v", err)
+	}
+}
+
+func (h *PhotoHandler) DownloadPhoto(w http.ResponseWriter, r *http.Request) {
+	photoID, ok := photoName(w, r)
+	if !ok {
 		return
 	}
 
-	conn, err := h.pool.GetConnection(claims.Username, password)
-	if err != nil {
-		http.Error(w, "failed to connect to storage", http.StatusInternalServerError)
+	claims, conn, done, ok := userConnection(w, r, h.sessions, h.pool)
+	if !ok {
 		return
 	}
+	defer done()
 
-	data, err := h.backend.Download(conn, claims.Username, photoID)
+	info, err := h.backend.Stat(conn, claims.Username, photoID)
 	if err != nil {
-		http.Error(w, "photo not found", http.StatusNotFound)
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, "photo not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Stat of %s for user %s failed: %v", photoID, claims.Username, err)
+		http.Error(w, "failed to read photo", http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "image/jpeg")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", photoID))
-	w.Write(data)
+	content := storage.NewRangeReader(h.backend, conn, claims.Username, info)
+	defer content.Close()
+
+	extendTransferDeadlines(w, h.transferTimeout)
+
+	// Only files known neither to the index nor by their extension are
+	// sniffed, with the same detection as photoInfo. ServeContent reads on
+	// from the peeked header.
+	contentType := h.knownContentType(claims.Username, info)
+	if contentType == "" {
+		contentType = h.detectContentType(claims.Username, info, content)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(photoID)}))
+	w.Header().Set("ETag", fileETag(info))
+
+	http.ServeContent(w, r, photoID, info.ModTime, content)
 }
 
 func (h *PhotoHandler) DeletePhoto(w http.ResponseWriter, r *http.Request) {
-	claims := r.Context().Value("claims").(*auth.JWTClaims)
-	photoID := chi.URLParam(r, "id")
-
-	password, err := h.jwtManager.DecryptPassword(claims.EncryptedPassword)
-	if err != nil {
-		http.Error(w, "authentication error", http.StatusUnauthorized)
+	photoID, ok := photoName(w, r)
+	if !ok {
 		return
 	}
 
-	conn, err := h.pool.GetConnection(claims.Username, password)
-	if err != nil {
-		http.Error(w, "failed to connect to storage", http.StatusInternalServerError)
+	claims, conn, done, ok := userConnection(w, r, h.sessions, h.pool)
+	if !ok {
 		return
 	}
+	defer done()
 
-	err = h.backend.Delete(conn, claims.Username, photoID)
-	if err != nil {
-		http.Error(w, "failed to delete photo", http.StatusInternalServerError)
+	if err := h.deletePhoto(conn, claims.Username, photoID); err != nil {
+		http.Error(w, "failed to delete photo", storageErrorStatus(err))
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// deletePhoto moves a photo to the trash, or deletes it for good when the
+// trash is disabled. Stat fails with ErrNotFound on folders, which would
+// otherwise be trashed whole.
+func (h *PhotoHandler) deletePhoto(conn storage.Connection, username, name string) error {
+	if _, err := h.backend.Stat(conn, username, name); err != nil {
+		return err
+	}
+
+	var err error
+	if h.trash.Enabled() {
+		_, err = h.trash.Delete(conn, username, name)
+	} else {
+		err = h.backend.Delete(conn, username, name)
+	}
+	if err != nil {
+		return err
+	}
+
+	h.recorder.deleted(username, name)
+	return nil
+}
+
 func (h *PhotoHandler) GetPhotoInfo(w http.ResponseWriter, r *http.Request) {
-	claims := r.Context().Value("claims").(*auth.JWTClaims)
-	photoID := chi.URLParam(r, "id")
+	photoID, ok := photoName(w, r)
+	if !ok {
+		return
+	}
+
+	claims, conn, done, ok := userConnection(w, r, h.sessions, h.pool)
+	if !ok {
+		return
+	}
+	defer done()
 
-	password, err := h.jwtManager.DecryptPassword(claims.EncryptedPassword)
+	info, err := h.photoInfo(conn, claims.Username, photoID)
 	if err != nil {
-		http.Error(w, "authentication error", http.StatusUnauthorized)
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, "photo not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Stat of %s for user %s failed: %v", photoID, claims.Username, err)
+		http.Error(w, "failed to get photo info", http.StatusInternalServerError)
 		return
 	}
 
-	conn, err := h.pool.GetConnection(claims.Username, password)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// photoInfo returns the index record of a photo, or reads the file when the
+// index has not fully inspected it yet.
+func (h *PhotoHandler) photoInfo(conn storage.Connection, username, name string) (*models.FileInfo, error) {
+	if rec, err := h.index.Get(username, name); err == nil && rec.Complete() {
+		info := rec.FileInfo()
+		return &info, nil
+	} else if err != nil && !errors.Is(err, index.ErrNotFound) {
+		log.Printf("Index: Lookup of %s for user %s failed: %v", name, username, err)
+	}
+
+	info, err := h.backend.Stat(conn, username, name)
 	if err != nil {
-		http.Error(w, "failed to connect to storage", http.StatusInternalServerError)
+		return nil, err
+	}
+
+	content := storage.NewRangeReader(h.backend, conn, username, info)
+	info.ContentType = h.detectContentType(username, info, content)
+	content.Close()
+	if info.Metadata, err = index.ReadMetadata(h.backend, conn, username, info, info.ContentType); err != nil {
+		log.Printf("Could not read metadata of %s for user %s: %v", name, username, err)
+	}
+	return info, nil
+}
+
+// listFiles returns the user's files from the index, or from the backend
+// while the index has not been built for the user yet.
+func listFiles(ix *index.Index, backend storage.StorageBackend, conn storage.Connection, username string) ([]models.FileInfo, error) {
+	files, ok, err := ix.List(username)
+	if err != nil {
+		log.Printf("Index: List for user %s failed: %v", username, err)
+	}
+	if err == nil && ok {
+		return files, nil
+	}
+
+	files, err = backend.List(conn, username)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range files {
+		files[i].ContentType = media.ContentTypeByExtension(files[i].Name)
+	}
+	return files, nil
+}
+
+// detectContentType sniffs the first bytes of a stored file, falling back to
+// the file extension if they cannot be read. The bytes stay buffered in
+// content for reading the file.
+func (h *PhotoHandler) detectContentType(username string, info *models.FileInfo, content *storage.RangeReader) string {
+	header, err := content.Peek(media.SniffLength)
+	if err != nil && len(header) == 0 {
+		log.Printf("Could not read header of %s for user %s: %v", info.Name, username, err)
+	}
+	return media.DetectContentType(header, info.Name)
+}
+
+// knownContentType is the content type of a file as far as it is known
+// without reading it: the indexed type while the index still describes the
+// file, or else the type of its extension, which may be empty.
+func (h *PhotoHandler) knownContentType(username string, info *models.FileInfo) string {
+	if rec, err := h.index.Get(username, info.Name); err == nil && rec.ContentType != "" && rec.Current(*info) {
+		return rec.ContentType
+	}
+	return media.ContentTypeByExtension(info.Name)
+}
+
+func fileETag(info *models.FileInfo) string {
+	return fmt.Sprintf("\"%x-%x\"", info.Size, info.ModTime.UnixNano())
+}
+
+func (h *PhotoHandler) GetThumbnail(w http.ResponseWriter, r *http.Request) {
+	photoID, ok := photoName(w, r)
+	if !ok {
 		return
 	}
 
-	files, err := h.backend.List(conn, claims.Username)
+	size := h.defaultThumbnailSize
+	if param := r.URL.Query().Get("size"); param != "" {
+		parsed, err := strconv.Atoi(param)
+		if err != nil || !slices.Contains(h.thumbnailSizes, parsed) {
+			http.Error(w, fmt.Sprintf("invalid size, supported sizes: %v", h.thumbnailSizes), http.StatusBadRequest)
+			return
+		}
+		size = parsed
+	}
+
+	claims, conn, done, ok := userConnection(w, r, h.sessions, h.pool)
+	if !ok {
+		return
+	}
+	defer done()
+
+	info, err := h.backend.Stat(conn, claims.Username, photoID)
 	if err != nil {
-		http.Error(w, "failed to get photo info", http.StatusInternalServerError)
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, "photo not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Stat of %s for user %s failed: %v", photoID, claims.Username, err)
+		http.Error(w, "failed to read photo", http.StatusInternalServerError)
+		return
+	}
+
+	if contentType := h.knownContentType(claims.Username, info); contentType != "" && !thumbnail.Supported(contentType) {
+		http.Error(w, "thumbnail not available for this file type", http.StatusUnsupportedMediaType)
 		return
 	}
 
-	for _, file := range files {
-		if file.Name == photoID {
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(file)
+	key := thumbnail.Key(claims.Username, info, size)
+	thumbPath, err := h.thumbnails.Get(key, size, func() (io.ReadCloser, error) {
+		body, _, err := h.backend.Download(conn, claims.Username, photoID)
+		return body, err
+	})
+	if err != nil {
+		if errors.Is(err, thumbnail.ErrUnsupported) {
+			http.Error(w, "thumbnail not available for this file type", http.StatusUnsupportedMediaType)
 			return
 		}
+		log.Printf("Thumbnail of %s for user %s failed: %v", photoID, claims.Username, err)
+		http.Error(w, "failed to generate thumbnail", http.StatusInternalServerError)
+		return
 	}
 
-	http.Error(w, "photo not found", http.StatusNotFound)
-}
+	file, err := os.Open(thumbPath)
+	if err != nil {
+		http.Error(w, "failed to read thumbnail", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
 
-func (h *PhotoHandler) GetThumbnail(w http.ResponseWriter, r *http.Request) {
-	h.DownloadPhoto(w, r)
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Header().Set("ETag", "\""+key[:32]+"\"")
+
+	http.ServeContent(w, r, "", info.ModTime, file)
 }
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"photosync-backend/internal/storage"
+	"photosync-backend/internal/thumbnail"
+)
+
+// rangeCountingBackend records the offsets and lengths of ranged downloads.
+type rangeCountingBackend struct {
+	storage.StorageBackend
+	ranges [][2]int64
+}
+
+func (b *rangeCountingBackend) DownloadRange(conn storage.Connection, username, filename string, offset, length int64) (io.ReadCloser, error) {
+	b.ranges = append(b.ranges, [2]int64{offset, length})
+	return b.StorageBackend.DownloadRange(conn, username, filename, offset, length)
+}
+
+func TestDownloadPhotoConditional(t *testing.T) {
+	// An ftyp box of a HEIC image, which net/http does not recognise.
+	const heic = "\x00\x00\x00\x10ftypheic\x00\x00\x00\x00\x00\x00\x00\x08mdat"
+
+	s := newPhotoServer(t)
+	s.write(t, "a.jpg", "0123456789")
+	s.write(t, "notes", "plain text, no extension")
+	s.write(t, "IMG_0001", heic)
+	backend := &rangeCountingBackend{StorageBackend: s.backend}
+	s.handler.backend = backend
+
+	info, err := s.backend.Stat(s.connection(t), "alice", "a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := fileETag(info)
+
+	tests := []struct {
+		name            string
+		id              string
+		headers         map[string]string
+		wantStatus      int
+		wantBody        string
+		wantContentType string
+		wantRanges      [][2]int64
+	}{
+		{name: "full", id: "a.jpg", wantStatus: http.StatusOK, wantBody: "0123456789", wantContentType: "image/jpeg", wantRanges: [][2]int64{{0, 10}}},
+		{name: "not modified", id: "a.jpg", headers: map[string]string{"If-None-Match": etag}, wantStatus: http.StatusNotModified},
+		{name: "range", id: "a.jpg", headers: map[string]string{"Range": "bytes=6-7"}, wantStatus: http.StatusPartialContent, wantBody: "67", wantContentType: "image/jpeg", wantRanges: [][2]int64{{6, 4}}},
+		{name: "stale If-Range", id: "a.jpg", headers: map[string]string{"Range": "bytes=6-7", "If-Range": `"old"`}, wantStatus: http.StatusOK, wantBody: "0123456789", wantContentType: "image/jpeg", wantRanges: [][2]int64{{0, 10}}},
+		{name: "unknown type", id: "notes", wantStatus: http.StatusOK, wantBody: "plain text, no extension", wantContentType: "text/plain; charset=utf-8", wantRanges: [][2]int64{{0, 24}}},
+		{name: "unknown photo", id: "IMG_0001", wantStatus: http.StatusOK, wantBody: heic, wantContentType: "image/heic", wantRanges: [][2]int64{{0, 24}}},
+	}
+
+	r := chi.NewRouter()
+	r.Get("/api/photos/{id}", s.handler.DownloadPhoto)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend.ranges = nil
+
+			req := s.request(context.Background(), http.MethodGet, "/api/photos/"+tt.id, "")
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus || w.Body.String() != tt.wantBody {
+				t.Fatalf("response = %d %q, want %d %q", w.Code, w.Body, tt.wantStatus, tt.wantBody)
+			}
+			if tt.wantContentType != "" && w.Header().Get("Content-Type") != tt.wantContentType {
+				t.Errorf("Content-Type = %q, want %q", w.Header().Get("Content-Type"), tt.wantContentType)
+			}
+			if len(backend.ranges) != len(tt.wantRanges) {
+				t.Fatalf("downloads = %v, want %v", backend.ranges, tt.wantRanges)
+			}
+			for i := range tt.wantRanges {
+				if backend.ranges[i] != tt.wantRanges[i] {
+					t.Errorf("downloads = %v, want %v", backend.ranges, tt.wantRanges)
+				}
+			}
+		})
+	}
+}
+
+func TestGetThumbnailUnsupported(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 64, 48))); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every file holds a PNG, so only a file turned away by its type before
+	// the download fails.
+	tests := []struct {
+		name       string
+		wantStatus int
+	}{
+		{name: "a.png", wantStatus: http.StatusOK},
+		{name: "b.jpg.heic", wantStatus: http.StatusUnsupportedMediaType},
+		{name: "c.mov", wantStatus: http.StatusUnsupportedMediaType},
+		{name: "d", wantStatus: http.StatusOK},
+	}
+
+	s := newPhotoServer(t)
+	generator, err := thumbnail.NewGenerator(t.TempDir(), 80, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.handler.thumbnails = generator
+	s.handler.thumbnailSizes = []int{32}
+	s.handler.defaultThumbnailSize = 32
+
+	r := chi.NewRouter()
+	r.Get("/api/photos/{id}/thumbnail", s.handler.GetThumbnail)
+	for _, tt := range tests {
+		s.write(t, tt.name, buf.String())
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, s.request(context.Background(), http.MethodGet, "/api/photos/"+tt.name+"/thumbnail", ""))
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.wantStatus, w.Body)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"errors"
+	"log"
+
+	"photosync-backend/internal/index"
+	"photosync-backend/internal/journal"
+	"photosync-backend/internal/media"
+	"photosync-backend/internal/models"
+	"photosync-backend/internal/storage"
+)
+
+// fileRecorder keeps the change journal and the index in step with files
+// written and deleted through the API. Failures only delay an update until
+// the next background scan, so they are logged and not returned.
+type fileRecorder struct {
+	backend storage.StorageBackend
+	changes *journal.Journal
+	index   *index.Index
+}
+
+func (f *fileRecorder) uploaded(conn storage.Connection, username, filename string, digest *index.Digest) {
+	info, err := f.backend.Stat(conn, username, filename)
+	if err != nil {
+		log.Printf("Failed to stat uploaded file %s for user %s: %v", filename, username, err)
+		return
+	}
+
+	if err := f.changes.Upserted(username, info); err != nil {
+		log.Printf("Journal: Failed to record upload of %s for user %s: %v", filename, username, err)
+	}
+
+	rec := digest.Record(info)
+	if rec.Metadata, err = index.ReadMetadata(f.backend, conn, username, info, rec.ContentType); err != nil {
+		log.Printf("Failed to read metadata of uploaded file %s for user %s: %v", filename, username, err)
+	}
+	if err := f.index.Put(username, rec); err != nil {
+		log.Printf("Index: Failed to add %s for user %s: %v", filename, username, err)
+	}
+}
+
+// restored records a file that reappeared through the API, such as one
+// restored from the trash, and returns its info. The file is read by the
+// next reconcile.
+func (f *fileRecorder) restored(conn storage.Connection, username, filename string) models.FileInfo {
+	info, err := f.backend.Stat(conn, username, filename)
+	if err != nil {
+		log.Printf("Failed to stat restored file %s for user %s: %v", filename, username, err)
+		return models.FileInfo{Name: filename, ContentType: media.ContentTypeByExtension(filename)}
+	}
+	info.ContentType = media.ContentTypeByExtension(filename)
+
+	if err := f.changes.Upserted(username, info); err != nil {
+		log.Printf("Journal: Failed to record restore of %s for user %s: %v", filename, username, err)
+	}
+
+	rec := &index.Record{
+		Name:        info.Name,
+		Size:        info.Size,
+		ModTime:     info.ModTime,
+		ContentType: info.ContentType,
+	}
+	if err := f.index.Put(username, rec); err != nil {
+		log.Printf("Index: Failed to add %s for user %s: %v", filename, username, err)
+	}
+	return *info
+}
+
+// copied records to as a copy of from and returns its info. The content is
+// unchanged, so the index record of from is reused; files not indexed yet
+// are read by the next reconcile.
+func (f *fileRecorder) copied(conn storage.Connection, username, from, to string) models.FileInfo {
+	info, err := f.backend.Stat(conn, username, to)
+	if err != nil {
+		log.Printf("Failed to stat copied file %s for user %s: %v", to, username, err)
+		return models.FileInfo{Name: to, ContentType: media.ContentTypeByExtension(to)}
+	}
+
+	if err := f.changes.Upserted(username, info); err != nil {
+		log.Printf("Journal: Failed to record copy of %s for user %s: %v", to, username, err)
+	}
+
+	rec, err := f.index.Get(username, from)
+	if err != nil {
+		if !errors.Is(err, index.ErrNotFound) {
+			log.Printf("Index: Lookup of %s for user %s failed: %v", from, username, err)
+		}
+		info.ContentType = media.ContentTypeByExtension(to)
+		return *info
+	}
+
+	rec.Name, rec.Size, rec.ModTime = info.Name, info.Size, info.ModTime
+	if err := f.index.Put(username, rec); err != nil {
+		log.Printf("Index: Failed to add %s for user %s: %v", to, username, err)
+	}
+	return rec.FileInfo()
+}
+
+func (f *fileRecorder) moved(conn storage.Connection, username, from, to string) models.FileInfo {
+	info := f.copied(conn, username, from, to)
+	f.deleted(username, from)
+	return info
+}
+
+func (f *fileRecorder) deleted(username, filename string) {
+	if err := f.changes.Deleted(username, filename); err != nil {
+		log.Printf("Journal: Failed to record delete of %s for user %s: %v", filename, username, err)
+	}
+	if err := f.index.Delete(username, filename); err != nil {
+		log.Printf("Index: Failed to remove %s for user %s: %v", filename, username, err)
+	}
+}
@@ -5,7 +5,7 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 )
 
-func NewRouter(authHandler *AuthHandler, photoHandler *PhotoHandler) *chi.Mux {
+func NewRouter(authHandler *AuthHandler, photoHandler *PhotoHandler, uploadHandler *UploadHandler, syncHandler *SyncHandler, albumHandler *AlbumHandler) *chi.Mux {
 	r := chi.NewRouter()
 
 	r.Use(middleware.Logger)
@@ -14,16 +14,59 @@ func NewRouter(authHandler *AuthHandler, photoHandler *PhotoHandler) *chi.Mux {
 	r.Use(middleware.RealIP)
 
 	r.Post("/api/auth/login", authHandler.Login)
+	r.Post("/api/auth/refresh", authHandler.Refresh)
+	r.Get("/api/auth/oauth2/start", authHandler.OAuth2Start)
+	r.Get("/api/auth/oauth2/callback", authHandler.OAuth2Callback)
+	r.Post("/api/auth/oauth2/token", authHandler.OAuth2Token)
+	r.Get("/.well-known/jwks.json", authHandler.JWKS)
+	r.Options("/api/uploads", uploadHandler.Options)
 
 	r.Group(func(r chi.Router) {
-		r.Use(JWTMiddleware(authHandler.jwtManager))
+		r.Use(JWTMiddleware(authHandler.jwtManager, authHandler.sessions))
+
+		r.Post("/api/auth/logout", authHandler.Logout)
+		r.Get("/api/auth/sessions", authHandler.ListSessions)
+		r.Delete("/api/auth/sessions/{sessionID}", authHandler.RevokeSession)
 
 		r.Get("/api/photos", photoHandler.ListPhotos)
 		r.Post("/api/photos", photoHandler.UploadPhoto)
+		r.Post("/api/photos/check", photoHandler.CheckHashes)
+		r.Post("/api/photos/batch", photoHandler.Batch)
+		r.Get("/api/photos/timeline", photoHandler.GetTimeline)
 		r.Get("/api/photos/{id}", photoHandler.DownloadPhoto)
 		r.Get("/api/photos/{id}/thumbnail", photoHandler.GetThumbnail)
 		r.Delete("/api/photos/{id}", photoHandler.DeletePhoto)
 		r.Get("/api/photos/{id}/info", photoHandler.GetPhotoInfo)
+		r.Post("/api/photos/{id}/move", photoHandler.MovePhoto)
+		r.Post("/api/photos/{id}/copy", photoHandler.CopyPhoto)
+
+		r.Get("/api/trash", photoHandler.ListTrash)
+		r.Delete("/api/trash", photoHandler.EmptyTrash)
+		r.Post("/api/trash/{id}/restore", photoHandler.RestoreTrash)
+		r.Delete("/api/trash/{id}", photoHandler.DeleteTrash)
+
+		r.Get("/api/folders", photoHandler.ListFolder)
+		r.Post("/api/folders", photoHandler.CreateFolder)
+		r.Delete("/api/folders", photoHandler.DeleteFolder)
+
+		r.Get("/api/albums", albumHandler.ListAlbums)
+		r.Post("/api/albums", albumHandler.CreateAlbum)
+		r.Get("/api/albums/{albumID}", albumHandler.GetAlbum)
+		r.Patch("/api/albums/{albumID}", albumHandler.UpdateAlbum)
+		r.Delete("/api/albums/{albumID}", albumHandler.DeleteAlbum)
+		r.Post("/api/albums/{albumID}/photos", albumHandler.AddPhotos)
+		r.Delete("/api/albums/{albumID}/photos/{id}", albumHandler.RemovePhoto)
+
+		r.Get("/api/sync/changes", syncHandler.GetChanges)
+
+		r.Group(func(r chi.Router) {
+			r.Use(uploadHandler.TusResumable)
+
+			r.Post("/api/uploads", uploadHandler.CreateUpload)
+			r.Head("/api/uploads/{uploadID}", uploadHandler.GetUploadOffset)
+			r.Patch("/api/uploads/{uploadID}", uploadHandler.PatchUpload)
+			r.Delete("/api/uploads/{uploadID}", uploadHandler.TerminateUpload)
+		})
 	})
 
 	return r
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"photosync-backend/internal/auth"
+	"photosync-backend/internal/config"
+	"photosync-backend/internal/index"
+	"photosync-backend/internal/journal"
+	"photosync-backend/internal/session"
+	"photosync-backend/internal/storage"
+)
+
+// testServer holds what the handlers under test are built from: a session
+// of alice's, the change journal, the index and a pool over a local backend
+// storing her files in base.
+type testServer struct {
+	dir      string
+	sessions *session.Store
+	changes  *journal.Journal
+	index    *index.Index
+	backend  *storage.SafeBackend
+	pool     *storage.GenericConnectionPool
+	claims   *auth.JWTClaims
+	base     string
+}
+
+// newTestServer creates alice's files, each holding its own name.
+func newTestServer(t *testing.T, files ...string) *testServer {
+	t.Helper()
+	dir := t.TempDir()
+
+	sessions, err := session.Open(filepath.Join(dir, "sessions.db"), []session.Key{{ID: "test", Key: make([]byte, 32)}}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sessions.Close() })
+	sess, _, err := sessions.Create("alice", "secret", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := journal.New(filepath.Join(dir, "journal"), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { changes.Close() })
+
+	ix, err := index.Open(filepath.Join(dir, "index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ix.Close() })
+
+	base := filepath.Join(dir, "photos")
+	backend := storage.NewSafeBackend(storage.NewLocalBackend(&config.LocalConfig{BasePath: base}))
+	pool := storage.NewGenericConnectionPool(backend, time.Hour)
+	t.Cleanup(pool.Close)
+
+	s := &testServer{
+		dir:      dir,
+		sessions: sessions,
+		changes:  changes,
+		index:    ix,
+		backend:  backend,
+		pool:     pool,
+		claims:   &auth.JWTClaims{Username: "alice", SessionID: sess.ID},
+		base:     filepath.Join(base, "alice"),
+	}
+	for _, name := range files {
+		s.write(t, name, name)
+	}
+	return s
+}
+
+// write stores data as alice's file name, creating its folders.
+func (s *testServer) write(t *testing.T, name, data string) {
+	t.Helper()
+
+	path := filepath.Join(s.base, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (s *testServer) exists(name string) bool {
+	_, err := os.Stat(filepath.Join(s.base, filepath.FromSlash(name)))
+	return err == nil
+}
+
+// request returns a request made with alice's claims.
+func (s *testServer) request(ctx context.Context, method, target, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	return req.WithContext(context.WithValue(ctx, "claims", s.claims))
+}
+
+// connection returns a pooled connection of alice's.
+func (s *testServer) connection(t *testing.T) storage.Connection {
+	t.Helper()
+
+	conn, done, err := s.pool.GetConnection("alice", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(done)
+	return conn
+}
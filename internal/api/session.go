@@ -0,0 +1,34 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"photosync-backend/internal/auth"
+	"photosync-backend/internal/session"
+	"photosync-backend/internal/storage"
+)
+
+// userConnection returns the caller's claims and storage connection. The
+// connection is leased for the request, so done must be deferred once ok.
+func userConnection(w http.ResponseWriter, r *http.Request, sessions *session.Store, pool *storage.GenericConnectionPool) (claims *auth.JWTClaims, conn storage.Connection, done func(), ok bool) {
+	claims = r.Context().Value("claims").(*auth.JWTClaims)
+
+	password, err := sessions.Password(claims.SessionID)
+	if err != nil {
+		if !errors.Is(err, session.ErrNotFound) {
+			log.Printf("Sessions: Failed to read session of user %s: %v", claims.Username, err)
+		}
+		http.Error(w, "authentication error", http.StatusUnauthorized)
+		return nil, nil, nil, false
+	}
+
+	conn, done, err = pool.GetConnection(claims.Username, password)
+	if err != nil {
+		http.Error(w, "failed to connect to storage", http.StatusInternalServerError)
+		return nil, nil, nil, false
+	}
+
+	return claims, conn, done, true
+}
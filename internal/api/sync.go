@@ -0,0 +1,128 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"photosync-backend/internal/index"
+	"photosync-backend/internal/journal"
+	"photosync-backend/internal/media"
+	"photosync-backend/internal/models"
+	"photosync-backend/internal/session"
+	"photosync-backend/internal/storage"
+)
+
+const maxSyncLimit = 1000
+
+type SyncHandler struct {
+	pool           *storage.GenericConnectionPool
+	backend        storage.StorageBackend
+	journal        *journal.Journal
+	files          *index.Index
+	sessions       *session.Store
+	rescanInterval time.Duration
+}
+
+func NewSyncHandler(pool *storage.GenericConnectionPool, backend storage.StorageBackend, changes *journal.Journal, files *index.Index, sessions *session.Store, rescanInterval time.Duration) *SyncHandler {
+	return &SyncHandler{
+		pool:           pool,
+		backend:        backend,
+		journal:        changes,
+		files:          files,
+		sessions:       sessions,
+		rescanInterval: rescanInterval,
+	}
+}
+
+func (h *SyncHandler) GetChanges(w http.ResponseWriter, r *http.Request) {
+	limit := maxSyncLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxSyncLimit)
+	}
+
+	// The connection itself is only used by the rescan, which leases it.
+	claims, _, done, ok := userConnection(w, r, h.sessions, h.pool)
+	if !ok {
+		return
+	}
+	defer done()
+
+	lastScan, err := h.journal.LastScan(claims.Username)
+	if err != nil {
+		log.Printf("Journal: Failed to load journal for user %s: %v", claims.Username, err)
+		http.Error(w, "failed to load changes", http.StatusInternalServerError)
+		return
+	}
+
+	// A stale journal is rescanned in the background and the client gets
+	// what the journal knows now; the rescan's changes come with its next
+	// request. Before the first scan there is nothing to answer from.
+	if time.Since(lastScan) >= h.rescanInterval {
+		h.rescan(claims.Username)
+	}
+	if lastScan.IsZero() {
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "changes are not ready yet, please retry", http.StatusServiceUnavailable)
+		return
+	}
+
+	result, err := h.journal.Changes(claims.Username, r.URL.Query().Get("since"), limit)
+	if err != nil {
+		if errors.Is(err, journal.ErrInvalidCursor) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Journal: Failed to read changes for user %s: %v", claims.Username, err)
+		http.Error(w, "failed to load changes", http.StatusInternalServerError)
+		return
+	}
+
+	resp := models.SyncResponse{
+		Cursor:  result.Cursor,
+		Reset:   result.Reset,
+		HasMore: result.More,
+		Changes: make([]models.SyncChange, 0, len(result.Changes)),
+	}
+	for _, change := range result.Changes {
+		item := models.SyncChange{
+			Op:      change.Op,
+			Name:    change.Name,
+			Size:    change.Size,
+			ModTime: change.ModTime,
+		}
+		if change.Op == journal.OpUpsert {
+			item.ContentType = media.ContentTypeByExtension(change.Name)
+		}
+		resp.Changes = append(resp.Changes, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// rescan reconciles the user's files in the background over a lease on the
+// pooled connection; the index passes the listing on to the journal. A user
+// already being reconciled is skipped.
+func (h *SyncHandler) rescan(username string) {
+	conn, release, ok := h.pool.Lease(username)
+	if !ok {
+		return
+	}
+
+	go func() {
+		defer release()
+		if err := h.files.Reconcile(h.backend, conn, username); err != nil {
+			log.Printf("Journal: Rescan for user %s failed: %v", username, err)
+		}
+	}()
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"photosync-backend/internal/models"
+)
+
+type syncServer struct {
+	*testServer
+	handler *SyncHandler
+}
+
+func newSyncServer(t *testing.T, rescanInterval time.Duration) *syncServer {
+	t.Helper()
+	s := newTestServer(t, "a.jpg")
+	s.index.OnListing(func(username string, listing []models.FileInfo, started time.Time, err error) {
+		if err == nil {
+			s.changes.Apply(username, listing, started)
+		}
+	})
+
+	return &syncServer{
+		testServer: s,
+		handler:    NewSyncHandler(s.pool, s.backend, s.changes, s.index, s.sessions, rescanInterval),
+	}
+}
+
+func (s *syncServer) changes(t *testing.T) (int, []string) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/sync/changes", nil)
+	req = req.WithContext(context.WithValue(req.Context(), "claims", s.claims))
+	w := httptest.NewRecorder()
+	s.handler.GetChanges(w, req)
+	if w.Code != http.StatusOK {
+		return w.Code, nil
+	}
+
+	var resp models.SyncResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, change := range resp.Changes {
+		names = append(names, change.Name)
+	}
+	slices.Sort(names)
+	return w.Code, names
+}
+
+// eventually polls the changes until they list want.
+func (s *syncServer) eventually(t *testing.T, want []string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		code, names := s.changes(t)
+		if code == http.StatusOK && slices.Equal(names, want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("changes = %d %v, want %v", code, names, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSyncFirstScan(t *testing.T) {
+	s := newSyncServer(t, time.Hour)
+
+	// The first request starts the scan rather than waiting for it.
+	if code, _ := s.changes(t); code != http.StatusServiceUnavailable {
+		t.Fatalf("first request: status %d, want %d", code, http.StatusServiceUnavailable)
+	}
+	s.eventually(t, []string{"a.jpg"})
+}
+
+func TestSyncRescan(t *testing.T) {
+	s := newSyncServer(t, 0)
+	s.changes(t)
+	s.eventually(t, []string{"a.jpg"})
+
+	// A file added on the share shows up once the background rescan a
+	// request triggered has landed in the journal.
+	s.write(t, "b.jpg", "b.jpg")
+	s.eventually(t, []string{"a.jpg", "b.jpg"})
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"photosync-backend/internal/models"
+)
+
+var timelineLayouts = map[string]string{
+	"year":  "2006",
+	"month": "2006-01",
+	"day":   "2006-01-02",
+}
+
+// GetTimeline counts the user's photos per year, month or day of capture,
+// falling back to the modification time for files without a capture date.
+// With a bucket parameter it lists the photos in that bucket instead, paged
+// like ListPhotos. Dates are the camera's local date where it recorded one.
+func (h *PhotoHandler) GetTimeline(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+
+	group := values.Get("group")
+	if group == "" {
+		group = "month"
+	}
+	layout, ok := timelineLayouts[group]
+	if !ok {
+		http.Error(w, "group must be year, month or day", http.StatusBadRequest)
+		return
+	}
+
+	bucket := values.Get("bucket")
+	if bucket != "" {
+		if _, err := time.Parse(layout, bucket); err != nil {
+			http.Error(w, "invalid bucket", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if values.Get("sort") == "" {
+		values.Set("sort", "taken")
+	}
+	if values.Get("order") == "" {
+		values.Set("order", "desc")
+	}
+	query, err := parseListQuery(values)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	claims, conn, done, ok := userConnection(w, r, h.sessions, h.pool)
+	if !ok {
+		return
+	}
+	defer done()
+
+	files, err := listFiles(h.index, h.backend, conn, claims.Username)
+	if err != nil {
+		log.Printf("List for user %s failed: %v", claims.Username, err)
+		http.Error(w, "failed to list photos", http.StatusInternalServerError)
+		return
+	}
+
+	if bucket != "" {
+		files = slices.DeleteFunc(files, func(file models.FileInfo) bool {
+			return takenAt(file).Format(layout) != bucket
+		})
+		writePage(w, query, files)
+		return
+	}
+
+	counts := make(map[string]int)
+	total := 0
+	for _, file := range files {
+		if query.matches(file) {
+			counts[takenAt(file).Format(layout)]++
+			total++
+		}
+	}
+
+	resp := models.TimelineResponse{
+		Group:   group,
+		Total:   total,
+		Buckets: make([]models.TimelineBucket, 0, len(counts)),
+	}
+	for date, count := range counts {
+		resp.Buckets = append(resp.Buckets, models.TimelineBucket{Date: date, Count: count})
+	}
+	slices.SortFunc(resp.Buckets, func(a, b models.TimelineBucket) int {
+		if query.order == "desc" {
+			return strings.Compare(b.Date, a.Date)
+		}
+		return strings.Compare(a.Date, b.Date)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"photosync-backend/internal/index"
+	"photosync-backend/internal/models"
+)
+
+// newTimelineServer indexes alice's photos with the given capture dates;
+// photos without one are dated by their modification time.
+func newTimelineServer(t *testing.T) *photoServer {
+	t.Helper()
+
+	photos := []struct {
+		name     string
+		captured time.Time
+		modified time.Time
+	}{
+		{name: "a.jpg", captured: time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC), modified: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{name: "b.jpg", captured: time.Date(2023, 6, 20, 10, 0, 0, 0, time.UTC), modified: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{name: "c.jpg", modified: time.Date(2023, 7, 4, 12, 0, 0, 0, time.UTC)},
+		{name: "d.mov", captured: time.Date(2022, 12, 31, 23, 30, 0, 0, time.UTC), modified: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{name: "2024/e.jpg", modified: time.Date(2024, 1, 2, 8, 0, 0, 0, time.UTC)},
+	}
+
+	s := newPhotoServer(t)
+	for _, photo := range photos {
+		s.write(t, photo.name, photo.name)
+	}
+	if err := s.index.Reconcile(s.backend, s.connection(t), "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, photo := range photos {
+		rec := &index.Record{Name: photo.name, ModTime: photo.modified, ContentType: contentType(models.FileInfo{Name: photo.name})}
+		if !photo.captured.IsZero() {
+			rec.Metadata = &models.PhotoMetadata{CapturedAt: photo.captured}
+		}
+		if err := s.index.Put("alice", rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestTimelineBuckets(t *testing.T) {
+	s := newTimelineServer(t)
+
+	tests := []struct {
+		name      string
+		query     string
+		wantTotal int
+		want      []models.TimelineBucket
+	}{
+		{
+			name:      "months",
+			wantTotal: 5,
+			want:      []models.TimelineBucket{{Date: "2024-01", Count: 1}, {Date: "2023-07", Count: 1}, {Date: "2023-06", Count: 2}, {Date: "2022-12", Count: 1}},
+		},
+		{
+			name:      "years ascending",
+			query:     "?group=year&order=asc",
+			wantTotal: 5,
+			want:      []models.TimelineBucket{{Date: "2022", Count: 1}, {Date: "2023", Count: 3}, {Date: "2024", Count: 1}},
+		},
+		{
+			name:      "days of videos",
+			query:     "?group=day&type=video",
+			wantTotal: 1,
+			want:      []models.TimelineBucket{{Date: "2022-12-31", Count: 1}},
+		},
+		{
+			name:      "folder",
+			query:     "?folder=2024",
+			wantTotal: 1,
+			want:      []models.TimelineBucket{{Date: "2024-01", Count: 1}},
+		},
+		{
+			name:  "nothing",
+			query: "?ext=png",
+			want:  []models.TimelineBucket{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.handler.GetTimeline(w, s.request(context.Background(), http.MethodGet, "/api/photos/timeline"+tt.query, ""))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+
+			var resp models.TimelineResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Total != tt.wantTotal || !slices.Equal(resp.Buckets, tt.want) {
+				t.Errorf("timeline = %d %v, want %d %v", resp.Total, resp.Buckets, tt.wantTotal, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimelineBucketPages(t *testing.T) {
+	s := newTimelineServer(t)
+
+	var names []string
+	target := "/api/photos/timeline?bucket=2023-06&limit=1"
+	for page := 0; page < 3; page++ {
+		w := httptest.NewRecorder()
+		s.handler.GetTimeline(w, s.request(context.Background(), http.MethodGet, target, ""))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", w.Code, w.Body)
+		}
+		if total := w.Header().Get("X-Total-Count"); total != "2" {
+			t.Errorf("X-Total-Count = %q, want 2", total)
+		}
+
+		var files []models.FileInfo
+		if err := json.NewDecoder(w.Body).Decode(&files); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, listedNames(files)...)
+
+		next := w.Header().Get("X-Next-Cursor")
+		if next == "" {
+			break
+		}
+		target = "/api/photos/timeline?bucket=2023-06&limit=1&cursor=" + next
+	}
+
+	// Newest capture first.
+	if want := []string{"b.jpg", "a.jpg"}; !slices.Equal(names, want) {
+		t.Errorf("bucket = %v, want %v", names, want)
+	}
+}
+
+func TestTimelineErrors(t *testing.T) {
+	s := newTimelineServer(t)
+
+	for _, query := range []string{"?group=week", "?bucket=2023", "?group=year&bucket=2023-06", "?bucket=2023-13", "?sort=size&order=up"} {
+		w := httptest.NewRecorder()
+		s.handler.GetTimeline(w, s.request(context.Background(), http.MethodGet, "/api/photos/timeline"+query, ""))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", query, w.Code)
+		}
+	}
+}
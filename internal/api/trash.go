@@ -0,0 +1,157 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"photosync-backend/internal/models"
+	"photosync-backend/internal/storage"
+	"photosync-backend/internal/trash"
+)
+
+var errInvalidTrashID = errors.New("invalid trash id")
+
+func (h *PhotoHandler) ListTrash(w http.ResponseWriter, r *http.Request) {
+	claims, conn, done, ok := userConnection(w, r, h.sessions, h.pool)
+	if !ok {
+		return
+	}
+	defer done()
+
+	items, err := h.trash.List(conn, claims.Username)
+	if err != nil {
+		log.Printf("Trash: List for user %s failed: %v", claims.Username, err)
+		http.Error(w, "failed to list trash", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+// RestoreTrash moves a trashed file back to where it was deleted from, or
+// to the path given in the optional request body.
+func (h *PhotoHandler) RestoreTrash(w http.ResponseWriter, r *http.Request) {
+	id, ok := trashID(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.MoveRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	to, err := restoreDestination(id, req.To)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	claims, conn, done, ok := userConnection(w, r, h.sessions, h.pool)
+	if !ok {
+		return
+	}
+	defer done()
+
+	info, err := h.restore(conn, claims.Username, id, to, req.Overwrite)
+	if err != nil {
+		if errors.Is(err, storage.ErrExists) {
+			http.Error(w, "a photo with this name already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, "failed to restore photo", storageErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// restore moves the trashed file id back into the library as to.
+func (h *PhotoHandler) restore(conn storage.Connection, username, id, to string, overwrite bool) (models.FileInfo, error) {
+	release, err := h.names.Claim(conn, username, to, overwrite)
+	if err != nil {
+		if !errors.Is(err, storage.ErrExists) {
+			log.Printf("Name check for %s for user %s failed: %v", to, username, err)
+		}
+		return models.FileInfo{}, err
+	}
+	defer release()
+
+	if err := h.trash.Restore(conn, username, id, to); err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Trash: Restore of %s for user %s failed: %v", id, username, err)
+		}
+		return models.FileInfo{}, err
+	}
+
+	return h.recorder.restored(conn, username, to), nil
+}
+
+// restoreDestination is where the trashed file id is restored to: to if
+// given, otherwise the path it was deleted from.
+func restoreDestination(id, to string) (string, error) {
+	if to == "" {
+		name, _, _ := trash.ParseID(id)
+		return name, nil
+	}
+	return storage.CleanPath(to)
+}
+
+// DeleteTrash permanently deletes a single trashed file.
+func (h *PhotoHandler) DeleteTrash(w http.ResponseWriter, r *http.Request) {
+	id, ok := trashID(w, r)
+	if !ok {
+		return
+	}
+
+	claims, conn, done, ok := userConnection(w, r, h.sessions, h.pool)
+	if !ok {
+		return
+	}
+	defer done()
+
+	if err := h.trash.Remove(conn, claims.Username, id); err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Trash: Delete of %s for user %s failed: %v", id, claims.Username, err)
+		}
+		http.Error(w, "failed to delete photo", storageErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *PhotoHandler) EmptyTrash(w http.ResponseWriter, r *http.Request) {
+	claims, conn, done, ok := userConnection(w, r, h.sessions, h.pool)
+	if !ok {
+		return
+	}
+	defer done()
+
+	if _, err := h.trash.Empty(conn, claims.Username); err != nil {
+		log.Printf("Trash: Emptying for user %s failed: %v", claims.Username, err)
+		http.Error(w, "failed to empty trash", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// trashID returns the validated {id} of a trashed file, answering 400 itself
+// when it is not one.
+func trashID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id, ok := photoName(w, r)
+	if !ok {
+		return "", false
+	}
+	if _, _, ok := trash.ParseID(id); !ok {
+		http.Error(w, errInvalidTrashID.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	return id, true
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"photosync-backend/internal/trash"
+)
+
+func TestRestoreTrashCollision(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantFile   string
+		wantData   string
+		wantKept   bool
+	}{
+		{name: "taken", wantStatus: http.StatusConflict, wantFile: "a.jpg", wantData: "replacement", wantKept: true},
+		{name: "overwrite", body: `{"overwrite": true}`, wantStatus: http.StatusOK, wantFile: "a.jpg", wantData: "a.jpg"},
+		{name: "other name", body: `{"to": "a (1).jpg"}`, wantStatus: http.StatusOK, wantFile: "a (1).jpg", wantData: "a.jpg"},
+		{name: "folder in the way", body: `{"to": "2024"}`, wantStatus: http.StatusConflict, wantKept: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newPhotoServer(t, "a.jpg", "2024/b.jpg")
+			conn := s.connection(t)
+			id, err := s.handler.trash.Delete(conn, "alice", "a.jpg")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// A new photo took the name while the old one was in the trash.
+			s.write(t, "a.jpg", "replacement")
+
+			r := chi.NewRouter()
+			r.Post("/api/trash/{id}/restore", s.handler.RestoreTrash)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, s.request(context.Background(), http.MethodPost, "/api/trash/"+url.PathEscape(id)+"/restore", tt.body))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+
+			if tt.wantFile != "" {
+				data, err := os.ReadFile(filepath.Join(s.base, filepath.FromSlash(tt.wantFile)))
+				if err != nil || string(data) != tt.wantData {
+					t.Errorf("%s = %q, %v, want %q", tt.wantFile, data, err, tt.wantData)
+				}
+			}
+			items, err := s.handler.trash.List(conn, "alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kept := len(items) == 1; kept != tt.wantKept {
+				t.Errorf("trash = %v, want the photo kept %v", items, tt.wantKept)
+			}
+		})
+	}
+}
+
+func TestDeletePhotoFolder(t *testing.T) {
+	tests := []struct {
+		name       string
+		id         string
+		trash      bool
+		wantStatus int
+		wantGone   string
+		wantKept   []string
+	}{
+		{name: "photo", id: "a.jpg", trash: true, wantStatus: http.StatusNoContent, wantGone: "a.jpg"},
+		{name: "folder", id: "2023", trash: true, wantStatus: http.StatusNotFound, wantKept: []string{"2023/b.jpg", "2023/Holiday/c.jpg"}},
+		{name: "folder without trash", id: "2023/Holiday", wantStatus: http.StatusNotFound, wantKept: []string{"2023/Holiday/c.jpg"}},
+		{name: "empty folder without trash", id: "empty", wantStatus: http.StatusNotFound, wantKept: []string{"empty"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newPhotoServer(t, "a.jpg", "2023/b.jpg", "2023/Holiday/c.jpg")
+			if err := os.Mkdir(filepath.Join(s.base, "empty"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if !tt.trash {
+				s.handler.trash = trash.NewBin(s.backend, 0)
+			}
+
+			r := chi.NewRouter()
+			r.Delete("/api/photos/{id}", s.handler.DeletePhoto)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, s.request(context.Background(), http.MethodDelete, "/api/photos/"+url.PathEscape(tt.id), ""))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+
+			if tt.wantGone != "" && s.exists(tt.wantGone) {
+				t.Errorf("%s still exists", tt.wantGone)
+			}
+			for _, name := range tt.wantKept {
+				if !s.exists(name) {
+					t.Errorf("%s was deleted", name)
+				}
+			}
+			items, err := s.handler.trash.List(s.connection(t), "alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if trashed := len(items) > 0; trashed != (tt.wantGone != "") {
+				t.Errorf("trash = %v", items)
+			}
+		})
+	}
+}
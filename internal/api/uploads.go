@@ -0,0 +1,362 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"photosync-backend/internal/auth"
+	"photosync-backend/internal/index"
+	"photosync-backend/internal/journal"
+	"photosync-backend/internal/session"
+	"photosync-backend/internal/storage"
+	"photosync-backend/internal/upload"
+)
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,expiration"
+	tusBasePath   = "/api/uploads/"
+)
+
+// UploadHandler implements the tus 1.0 resumable upload protocol. Chunks are
+// staged in an upload.Store and committed to the storage backend once the
+// final byte arrives.
+type UploadHandler struct {
+	store           *upload.Store
+	pool            *storage.GenericConnectionPool
+	backend         storage.StorageBackend
+	names           *storage.NameResolver
+	recorder        *fileRecorder
+	sessions        *session.Store
+	maxSize         int64
+	transferTimeout time.Duration
+}
+
+func NewUploadHandler(store *upload.Store, pool *storage.GenericConnectionPool, backend storage.StorageBackend, names *storage.NameResolver, changes *journal.Journal, files *index.Index, sessions *session.Store, maxSize int64, transferTimeout time.Duration) *UploadHandler {
+	return &UploadHandler{
+		store:           store,
+		pool:            pool,
+		backend:         backend,
+		names:           names,
+		recorder:        &fileRecorder{backend: backend, changes: changes, index: files},
+		sessions:        sessions,
+		maxSize:         maxSize,
+		transferTimeout: transferTimeout,
+	}
+}
+
+func (h *UploadHandler) Options(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	if h.maxSize > 0 {
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.maxSize, 10))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *UploadHandler) TusResumable(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+		if r.Header.Get("Tus-Resumable") != tusVersion {
+			w.Header().Set("Tus-Version", tusVersion)
+			http.Error(w, "unsupported tus version", http.StatusPreconditionFailed)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *UploadHandler) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value("claims").(*auth.JWTClaims)
+
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		http.Error(w, "deferred upload length is not supported", http.StatusBadRequest)
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+
+	if h.maxSize > 0 && length > h.maxSize {
+		http.Error(w, "upload exceeds maximum size", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	metadata, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, "invalid Upload-Metadata", http.StatusBadRequest)
+		return
+	}
+
+	if metadata["filename"] == "" {
+		http.Error(w, "filename metadata is required", http.StatusBadRequest)
+		return
+	}
+
+	filename, err := storage.CleanFilename(metadata["filename"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	metadata["filename"] = filename
+
+	folder, ok := folderParam(w, metadata["folder"])
+	if !ok {
+		return
+	}
+	if folder != "" {
+		metadata["folder"] = folder
+	}
+
+	// With the reject policy a taken name fails before the client sends the
+	// file rather than after; commit checks again.
+	if h.names.Policy() == storage.CollisionReject && !h.checkName(w, r, path.Join(folder, filename)) {
+		return
+	}
+
+	info, err := h.store.Create(claims.Username, length, metadata)
+	if err != nil {
+		log.Printf("Uploads: Failed to create upload for user %s: %v", claims.Username, err)
+		http.Error(w, "failed to create upload", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", tusBasePath+info.ID)
+
+	// Clients send no PATCH for an empty file, so it is complete on creation.
+	if info.Complete() {
+		unlock, err := h.store.Lock(info.ID)
+		if err != nil {
+			http.Error(w, "upload is in use", http.StatusLocked)
+			return
+		}
+		defer unlock()
+		if !h.commit(w, r, info) {
+			return
+		}
+		w.Header().Set("Upload-Offset", "0")
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	w.Header().Set("Upload-Expires", info.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *UploadHandler) GetUploadOffset(w http.ResponseWriter, r *http.Request) {
+	info, ok := h.lookupUpload(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(info.Length, 10))
+	w.Header().Set("Upload-Expires", info.ExpiresAt.UTC().Format(http.TimeFormat))
+	if len(info.Metadata) > 0 {
+		w.Header().Set("Upload-Metadata", formatUploadMetadata(info.Metadata))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *UploadHandler) PatchUpload(w http.ResponseWriter, r *http.Request) {
+	info, ok := h.lookupUpload(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+
+	extendTransferDeadlines(w, h.transferTimeout)
+
+	// The lock is held through the commit, see upload.Store.Lock.
+	unlock, err := h.store.Lock(info.ID)
+	if err != nil {
+		http.Error(w, "upload is in use", http.StatusLocked)
+		return
+	}
+	defer unlock()
+
+	info, err = h.store.Write(info.ID, offset, r.Body)
+	if err != nil {
+		switch {
+		case errors.Is(err, upload.ErrOffsetMismatch):
+			http.Error(w, "upload offset mismatch", http.StatusConflict)
+		case errors.Is(err, upload.ErrNotFound):
+			http.Error(w, "upload not found", http.StatusNotFound)
+		default:
+			log.Printf("Uploads: Write to %s failed: %v", chi.URLParam(r, "uploadID"), err)
+			http.Error(w, "failed to write upload", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+	if info.Complete() {
+		if !h.commit(w, r, info) {
+			return
+		}
+	} else {
+		w.Header().Set("Upload-Expires", info.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *UploadHandler) TerminateUpload(w http.ResponseWriter, r *http.Request) {
+	info, ok := h.lookupUpload(w, r)
+	if !ok {
+		return
+	}
+
+	unlock, err := h.store.Lock(info.ID)
+	if err != nil {
+		http.Error(w, "upload is in use", http.StatusLocked)
+		return
+	}
+	defer unlock()
+
+	if err := h.store.Remove(info.ID); err != nil {
+		http.Error(w, "failed to terminate upload", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// commit moves a fully received upload into the storage backend. The caller
+// holds the upload's lock. If the backend write fails the staged data is
+// kept, and a repeated zero-length PATCH at the final offset retries the
+// commit.
+func (h *UploadHandler) commit(w http.ResponseWriter, r *http.Request, info *upload.Info) bool {
+	claims, conn, done, ok := userConnection(w, r, h.sessions, h.pool)
+	if !ok {
+		return false
+	}
+	defer done()
+
+	file, err := h.store.Open(info.ID)
+	if err != nil {
+		log.Printf("Uploads: Failed to open staged upload %s: %v", info.ID, err)
+		http.Error(w, "failed to commit upload", http.StatusInternalServerError)
+		return false
+	}
+	defer file.Close()
+
+	filename, release, err := h.names.Reserve(conn, claims.Username, path.Join(info.Metadata["folder"], info.Metadata["filename"]))
+	if err != nil {
+		if errors.Is(err, storage.ErrExists) {
+			http.Error(w, "a photo with this name already exists", http.StatusConflict)
+			return false
+		}
+		log.Printf("Uploads: Name check for %s failed: %v", info.ID, err)
+		http.Error(w, "failed to store upload", http.StatusInternalServerError)
+		return false
+	}
+	defer release()
+
+	digest := index.NewDigest()
+	if err := h.backend.Upload(conn, claims.Username, filename, io.TeeReader(file, digest)); err != nil {
+		log.Printf("Uploads: Commit of %s (%s) for user %s failed: %v", info.ID, filename, claims.Username, err)
+		http.Error(w, "failed to store upload", http.StatusInternalServerError)
+		return false
+	}
+
+	h.recorder.uploaded(conn, claims.Username, filename, digest)
+
+	w.Header().Set("Upload-Stored-Filename", filename)
+
+	if err := h.store.Remove(info.ID); err != nil {
+		log.Printf("Uploads: Failed to remove staged upload %s: %v", info.ID, err)
+	}
+
+	return true
+}
+
+// checkName answers 409 itself when filename is taken, reporting whether it
+// is free.
+func (h *UploadHandler) checkName(w http.ResponseWriter, r *http.Request, filename string) bool {
+	claims, conn, done, ok := userConnection(w, r, h.sessions, h.pool)
+	if !ok {
+		return false
+	}
+	defer done()
+
+	release, err := h.names.Claim(conn, claims.Username, filename, false)
+	if err != nil {
+		if errors.Is(err, storage.ErrExists) {
+			http.Error(w, "a photo with this name already exists", http.StatusConflict)
+			return false
+		}
+		log.Printf("Uploads: Name check for %s failed: %v", filename, err)
+		http.Error(w, "failed to create upload", http.StatusInternalServerError)
+		return false
+	}
+	release()
+	return true
+}
+
+func (h *UploadHandler) lookupUpload(w http.ResponseWriter, r *http.Request) (*upload.Info, bool) {
+	claims := r.Context().Value("claims").(*auth.JWTClaims)
+
+	info, err := h.store.Get(chi.URLParam(r, "uploadID"))
+	if err != nil || info.Username != claims.Username {
+		if err != nil && !errors.Is(err, upload.ErrNotFound) {
+			log.Printf("Uploads: Lookup failed: %v", err)
+		}
+		http.Error(w, "upload not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return info, true
+}
+
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("empty metadata key")
+		}
+
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		metadata[key] = string(value)
+	}
+
+	return metadata, nil
+}
+
+func formatUploadMetadata(metadata map[string]string) string {
+	pairs := make([]string, 0, len(metadata))
+	for key, value := range metadata {
+		pairs = append(pairs, key+" "+base64.StdEncoding.EncodeToString([]byte(value)))
+	}
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,301 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"photosync-backend/internal/storage"
+	"photosync-backend/internal/upload"
+)
+
+type tusServer struct {
+	*testServer
+	router http.Handler
+	store  *upload.Store
+}
+
+func newTusServer(t *testing.T, policy string) *tusServer {
+	t.Helper()
+	s := newTestServer(t)
+
+	store, err := upload.NewStore(filepath.Join(s.dir, "uploads"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := NewUploadHandler(store, s.pool, s.backend, storage.NewNameResolver(s.backend, policy), s.changes, s.index, s.sessions, 1<<20, time.Minute)
+
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), "claims", s.claims)))
+		})
+	})
+	r.Use(h.TusResumable)
+	r.Post("/api/uploads", h.CreateUpload)
+	r.Head("/api/uploads/{uploadID}", h.GetUploadOffset)
+	r.Patch("/api/uploads/{uploadID}", h.PatchUpload)
+	r.Delete("/api/uploads/{uploadID}", h.TerminateUpload)
+
+	return &tusServer{testServer: s, router: r, store: store}
+}
+
+func (s *tusServer) do(t *testing.T, method, target string, headers map[string]string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Tus-Resumable", tusVersion)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+func (s *tusServer) create(t *testing.T, filename string, length int) string {
+	t.Helper()
+
+	w := s.do(t, http.MethodPost, "/api/uploads", map[string]string{
+		"Upload-Length":   strconv.Itoa(length),
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte(filename)),
+	}, "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status %d: %s", w.Code, w.Body)
+	}
+	return w.Header().Get("Location")
+}
+
+func (s *tusServer) patch(t *testing.T, location string, offset int, data string) *httptest.ResponseRecorder {
+	t.Helper()
+	return s.do(t, http.MethodPatch, location, map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": strconv.Itoa(offset),
+	}, data)
+}
+
+func (s *tusServer) stored(t *testing.T, name string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join(s.base, name))
+	if err != nil {
+		t.Fatalf("stored file %s: %v", name, err)
+	}
+	return string(data)
+}
+
+func TestTusResume(t *testing.T) {
+	s := newTusServer(t, storage.CollisionRename)
+	location := s.create(t, "a.jpg", 11)
+
+	tests := []struct {
+		name       string
+		offset     int
+		data       string
+		wantStatus int
+		wantOffset string
+	}{
+		{name: "first chunk", offset: 0, data: "hello", wantStatus: http.StatusNoContent, wantOffset: "5"},
+		{name: "repeated chunk", offset: 0, data: "hello", wantStatus: http.StatusConflict},
+		{name: "gap", offset: 6, data: "world", wantStatus: http.StatusConflict},
+		{name: "empty chunk", offset: 5, data: "", wantStatus: http.StatusNoContent, wantOffset: "5"},
+		{name: "last chunk", offset: 5, data: " world", wantStatus: http.StatusNoContent, wantOffset: "11"},
+	}
+
+	for _, tt := range tests {
+		w := s.patch(t, location, tt.offset, tt.data)
+		if w.Code != tt.wantStatus {
+			t.Fatalf("%s: status %d, want %d: %s", tt.name, w.Code, tt.wantStatus, w.Body)
+		}
+		if got := w.Header().Get("Upload-Offset"); got != tt.wantOffset {
+			t.Errorf("%s: Upload-Offset = %q, want %q", tt.name, got, tt.wantOffset)
+		}
+		if w.Code == http.StatusNoContent && tt.wantOffset != "11" && w.Header().Get("Upload-Expires") == "" {
+			t.Errorf("%s: no Upload-Expires on a partial upload", tt.name)
+		}
+
+		if tt.name == "first chunk" {
+			head := s.do(t, http.MethodHead, location, nil, "")
+			if head.Code != http.StatusOK || head.Header().Get("Upload-Offset") != "5" || head.Header().Get("Upload-Length") != "11" {
+				t.Fatalf("HEAD: status %d, offset %q, length %q", head.Code, head.Header().Get("Upload-Offset"), head.Header().Get("Upload-Length"))
+			}
+			if head.Header().Get("Upload-Expires") == "" {
+				t.Error("HEAD: no Upload-Expires")
+			}
+		}
+	}
+
+	if got := s.stored(t, "a.jpg"); got != "hello world" {
+		t.Errorf("stored = %q, want hello world", got)
+	}
+
+	// The upload is gone once committed, so a retry cannot store it twice.
+	if w := s.patch(t, location, 11, ""); w.Code != http.StatusNotFound {
+		t.Errorf("PATCH after commit: status %d, want 404", w.Code)
+	}
+	if w := s.do(t, http.MethodHead, location, nil, ""); w.Code != http.StatusNotFound {
+		t.Errorf("HEAD after commit: status %d, want 404", w.Code)
+	}
+}
+
+func TestTusCommit(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string
+		filename string
+		data     string
+		wantName string
+	}{
+		{name: "new name", filename: "b.jpg", data: "abc", wantName: "b.jpg"},
+		{name: "collision", existing: "b.jpg", filename: "b.jpg", data: "abc", wantName: "b (1).jpg"},
+		{name: "empty file", filename: "empty.txt", data: "", wantName: "empty.txt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTusServer(t, storage.CollisionRename)
+			if tt.existing != "" {
+				s.write(t, tt.existing, "old")
+			}
+
+			w := s.do(t, http.MethodPost, "/api/uploads", map[string]string{
+				"Upload-Length":   strconv.Itoa(len(tt.data)),
+				"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte(tt.filename)),
+			}, "")
+			if w.Code != http.StatusCreated {
+				t.Fatalf("create: status %d: %s", w.Code, w.Body)
+			}
+
+			// Empty uploads are committed on creation.
+			if tt.data != "" {
+				w = s.patch(t, w.Header().Get("Location"), 0, tt.data)
+				if w.Code != http.StatusNoContent {
+					t.Fatalf("PATCH: status %d: %s", w.Code, w.Body)
+				}
+			}
+
+			if got := w.Header().Get("Upload-Stored-Filename"); got != tt.wantName {
+				t.Errorf("Upload-Stored-Filename = %q, want %q", got, tt.wantName)
+			}
+			if got := s.stored(t, tt.wantName); got != tt.data {
+				t.Errorf("stored %s = %q, want %q", tt.wantName, got, tt.data)
+			}
+			if tt.existing != "" {
+				if got := s.stored(t, tt.existing); got != "old" {
+					t.Errorf("existing %s = %q, was overwritten", tt.existing, got)
+				}
+			}
+		})
+	}
+}
+
+func TestTusReject(t *testing.T) {
+	s := newTusServer(t, storage.CollisionReject)
+	for _, name := range []string{"b.jpg", "2024/c.jpg"} {
+		s.write(t, name, "old")
+	}
+
+	metadata := func(filename, folder string) string {
+		m := "filename " + base64.StdEncoding.EncodeToString([]byte(filename))
+		if folder != "" {
+			m += ",folder " + base64.StdEncoding.EncodeToString([]byte(folder))
+		}
+		return m
+	}
+
+	// Taken names are refused before any data is sent.
+	tests := []struct {
+		name       string
+		filename   string
+		folder     string
+		wantStatus int
+	}{
+		{name: "taken", filename: "b.jpg", wantStatus: http.StatusConflict},
+		{name: "taken in a folder", filename: "c.jpg", folder: "2024", wantStatus: http.StatusConflict},
+		{name: "folder of that name", filename: "2024", wantStatus: http.StatusConflict},
+		{name: "free", filename: "d.jpg", wantStatus: http.StatusCreated},
+	}
+	for _, tt := range tests {
+		w := s.do(t, http.MethodPost, "/api/uploads", map[string]string{
+			"Upload-Length":   "3",
+			"Upload-Metadata": metadata(tt.filename, tt.folder),
+		}, "")
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.wantStatus, w.Body)
+		}
+	}
+
+	// A name taken while the upload was in progress is caught at commit.
+	location := s.create(t, "e.jpg", 3)
+	s.write(t, "e.jpg", "new")
+	if w := s.patch(t, location, 0, "abc"); w.Code != http.StatusConflict {
+		t.Errorf("PATCH onto a name taken since creation: status %d, want 409", w.Code)
+	}
+	if got := s.stored(t, "e.jpg"); got != "new" {
+		t.Errorf("e.jpg = %q, was overwritten", got)
+	}
+}
+
+func TestTusLocked(t *testing.T) {
+	s := newTusServer(t, storage.CollisionRename)
+	location := s.create(t, "c.jpg", 3)
+	id := location[strings.LastIndex(location, "/")+1:]
+
+	unlock, err := s.store.Lock(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w := s.patch(t, location, 0, "abc"); w.Code != http.StatusLocked {
+		t.Errorf("PATCH while locked: status %d, want 423", w.Code)
+	}
+	if w := s.do(t, http.MethodDelete, location, nil, ""); w.Code != http.StatusLocked {
+		t.Errorf("DELETE while locked: status %d, want 423", w.Code)
+	}
+
+	unlock()
+	if w := s.do(t, http.MethodDelete, location, nil, ""); w.Code != http.StatusNoContent {
+		t.Errorf("DELETE: status %d, want 204", w.Code)
+	}
+	if w := s.patch(t, location, 0, "abc"); w.Code != http.StatusNotFound {
+		t.Errorf("PATCH after DELETE: status %d, want 404", w.Code)
+	}
+}
+
+func TestTusCreateErrors(t *testing.T) {
+	s := newTusServer(t, storage.CollisionRename)
+
+	tests := []struct {
+		name       string
+		headers    map[string]string
+		wantStatus int
+	}{
+		{name: "no length", headers: map[string]string{"Upload-Metadata": "filename YS5qcGc="}, wantStatus: http.StatusBadRequest},
+		{name: "negative length", headers: map[string]string{"Upload-Length": "-1", "Upload-Metadata": "filename YS5qcGc="}, wantStatus: http.StatusBadRequest},
+		{name: "deferred length", headers: map[string]string{"Upload-Defer-Length": "1", "Upload-Metadata": "filename YS5qcGc="}, wantStatus: http.StatusBadRequest},
+		{name: "too large", headers: map[string]string{"Upload-Length": "2097152", "Upload-Metadata": "filename YS5qcGc="}, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "no filename", headers: map[string]string{"Upload-Length": "1"}, wantStatus: http.StatusBadRequest},
+		{name: "bad metadata", headers: map[string]string{"Upload-Length": "1", "Upload-Metadata": "filename !!"}, wantStatus: http.StatusBadRequest},
+		{name: "hidden filename", headers: map[string]string{"Upload-Length": "1", "Upload-Metadata": "filename LmFsYnVtcw=="}, wantStatus: http.StatusBadRequest},
+		{name: "wrong version", headers: map[string]string{"Tus-Resumable": "0.2.2", "Upload-Length": "1", "Upload-Metadata": "filename YS5qcGc="}, wantStatus: http.StatusPreconditionFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.do(t, http.MethodPost, "/api/uploads", tt.headers, "")
+			if w.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
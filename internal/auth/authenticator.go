@@ -1,8 +1,23 @@
 package auth
 
-import "photosync-backend/internal/models"
+import (
+	"context"
+
+	"photosync-backend/internal/models"
+)
 
 type Authenticator interface {
 	Authenticate(username, password string) (*models.UserInfo, error)
 	GetName() string
 }
+
+// RedirectAuthenticator is implemented by authenticators whose users sign in
+// at an identity provider rather than by sending a password.
+type RedirectAuthenticator interface {
+	Authenticator
+	// AuthCodeURL returns the provider URL to send the browser to and the
+	// state that Exchange is called with. The state is only accepted along
+	// with the same binding, which ties the sign-in to the browser.
+	AuthCodeURL(binding string) (url, state string, err error)
+	Exchange(ctx context.Context, state, binding, code string) (*models.UserInfo, error)
+}
@@ -20,7 +20,7 @@ func NewAuthenticator(cfg *config.Config) (Authenticator, error) {
 	case "local":
 		return NewLocalAuth(&cfg.LocalAuth), nil
 	case "oauth2":
-		return NewOAuth2Auth(&cfg.OAuth2), nil
+		return NewOAuth2Auth(&cfg.OAuth2)
 	default:
 		return nil, fmt.Errorf("unknown auth type: %s", authType)
 	}
@@ -1,75 +1,58 @@
 package auth
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
-	"encoding/base64"
 	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// JWTClaims identify the user and their server-side session, which holds the
+// credentials for the storage backend. Tokens carry no password material.
 type JWTClaims struct {
-	Username          string `json:"username"`
-	EncryptedPassword string `json:"encrypted_password"`
+	Username  string `json:"username"`
+	SessionID string `json:"sid"`
 	jwt.RegisteredClaims
 }
 
 type JWTManager struct {
-	secretKey     []byte
-	encryptionKey []byte
-	issuer        string
-	expiry        time.Duration
+	keys   *KeySet
+	issuer string
+	expiry time.Duration
 }
 
-func NewJWTManager(secretKey, encryptionKey []byte, issuer string, expiry time.Duration) *JWTManager {
+func NewJWTManager(keys *KeySet, issuer string, expiry time.Duration) *JWTManager {
 	return &JWTManager{
-		secretKey:     secretKey,
-		encryptionKey: encryptionKey,
-		issuer:        issuer,
-		expiry:        expiry,
+		keys:   keys,
+		issuer: issuer,
+		expiry: expiry,
 	}
 }
 
-func (m *JWTManager) Generate(username, password string) (string, error) {
-	encryptedPassword, err := m.encrypt(password)
-	if err != nil {
-		return "", fmt.Errorf("failed to encrypt password: %w", err)
-	}
-
+// Generate returns an access token for the session and when it expires.
+func (m *JWTManager) Generate(username, sessionID string) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(m.expiry)
 	claims := JWTClaims{
-		Username:          username,
-		EncryptedPassword: encryptedPassword,
+		Username:  username,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			Issuer:    m.issuer,
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(m.expiry)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	signedToken, err := token.SignedString(m.secretKey)
+	signedToken, err := m.keys.sign(claims)
 	if err != nil {
-		return "", fmt.Errorf("failed to sign token: %w", err)
+		return "", time.Time{}, fmt.Errorf("failed to sign token: %w", err)
 	}
 
-	return signedToken, nil
+	return signedToken, expiresAt, nil
 }
 
 func (m *JWTManager) Validate(tokenString string) (*JWTClaims, error) {
-	token, err := jwt.ParseWithClaims(
-		tokenString,
-		&JWTClaims{},
-		func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return m.secretKey, nil
-		},
-	)
+	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, m.keys.keyFunc)
 
 	if err != nil {
 		return nil, err
@@ -83,56 +66,7 @@ func (m *JWTManager) Validate(tokenString string) (*JWTClaims, error) {
 	return claims, nil
 }
 
-func (m *JWTManager) DecryptPassword(encryptedPassword string) (string, error) {
-	return m.decrypt(encryptedPassword)
-}
-
-func (m *JWTManager) encrypt(plaintext string) (string, error) {
-	block, err := aes.NewCipher(m.encryptionKey)
-	if err != nil {
-		return "", err
-	}
-
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return "", err
-	}
-
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := rand.Read(nonce); err != nil {
-		return "", err
-	}
-
-	ciphertext := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
-	return base64.StdEncoding.EncodeToString(ciphertext), nil
-}
-
-func (m *JWTManager) decrypt(ciphertext string) (string, error) {
-	data, err := base64.StdEncoding.DecodeString(ciphertext)
-	if err != nil {
-		return "", err
-	}
-
-	block, err := aes.NewCipher(m.encryptionKey)
-	if err != nil {
-		return "", err
-	}
-
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return "", err
-	}
-
-	nonceSize := gcm.NonceSize()
-	if len(data) < nonceSize {
-		return "", fmt.Errorf("ciphertext too short")
-	}
-
-	nonce, ciphertextBytes := data[:nonceSize], data[nonceSize:]
-	plaintext, err := gcm.Open(nil, nonce, ciphertextBytes, nil)
-	if err != nil {
-		return "", err
-	}
-
-	return string(plaintext), nil
+// JWKS returns the public keys tokens can be verified with.
+func (m *JWTManager) JWKS() JWKS {
+	return m.keys.JWKS()
 }
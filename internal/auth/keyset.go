@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+	"photosync-backend/internal/config"
+)
+
+// SigningKey is one key of a KeySet. signKey is nil for keys that only
+// verify tokens signed before a rotation.
+type SigningKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// KeySet holds the key new tokens are signed with and the older keys that
+// tokens are still accepted from, each named by the kid header of its
+// tokens. Rotating keys therefore does not log anyone out.
+type KeySet struct {
+	keys []*SigningKey
+	byID map[string]*SigningKey
+}
+
+// NewKeySet returns a keyset signing with the first key.
+func NewKeySet(keys ...*SigningKey) (*KeySet, error) {
+	if len(keys) == 0 || keys[0].signKey == nil {
+		return nil, fmt.Errorf("the current signing key needs a private key")
+	}
+
+	ks := &KeySet{keys: keys, byID: make(map[string]*SigningKey)}
+	for _, key := range keys {
+		if _, ok := ks.byID[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate signing key id %q", key.ID)
+		}
+		ks.byID[key.ID] = key
+	}
+	return ks, nil
+}
+
+func NewHMACKey(id string, secret []byte) *SigningKey {
+	return &SigningKey{ID: id, Method: jwt.SigningMethodHS256, signKey: secret, verifyKey: secret}
+}
+
+// LoadKeySet builds the keyset configured under jwt.
+func LoadKeySet(cfg *config.Config) (*KeySet, error) {
+	var keys []*SigningKey
+	for _, kc := range cfg.GetJWTSigningKeys() {
+		key, err := loadSigningKey(kc)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %s: %w", kc.ID, err)
+		}
+		keys = append(keys, key)
+	}
+	return NewKeySet(keys...)
+}
+
+func loadSigningKey(kc config.SigningKeyConfig) (*SigningKey, error) {
+	if kc.Algorithm == "HS256" {
+		return NewHMACKey(kc.ID, []byte(kc.Secret)), nil
+	}
+
+	key := &SigningKey{ID: kc.ID}
+	switch kc.Algorithm {
+	case "EdDSA":
+		key.Method = jwt.SigningMethodEdDSA
+	case "RS256":
+		key.Method = jwt.SigningMethodRS256
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", kc.Algorithm)
+	}
+
+	if kc.PrivateKeyFile != "" {
+		data, err := os.ReadFile(kc.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read private key: %w", err)
+		}
+		var private crypto.Signer
+		if kc.Algorithm == "EdDSA" {
+			parsed, err := jwt.ParseEdPrivateKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			private = parsed.(crypto.Signer)
+		} else {
+			if private, err = jwt.ParseRSAPrivateKeyFromPEM(data); err != nil {
+				return nil, err
+			}
+		}
+		key.signKey = private
+		key.verifyKey = private.Public()
+		return key, nil
+	}
+
+	data, err := os.ReadFile(kc.PublicKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key: %w", err)
+	}
+	if kc.Algorithm == "EdDSA" {
+		key.verifyKey, err = jwt.ParseEdPublicKeyFromPEM(data)
+	} else {
+		key.verifyKey, err = jwt.ParseRSAPublicKeyFromPEM(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// sign signs claims with the current key, naming it in the kid header.
+func (ks *KeySet) sign(claims jwt.Claims) (string, error) {
+	current := ks.keys[0]
+	token := jwt.NewWithClaims(current.Method, claims)
+	token.Header["kid"] = current.ID
+	return token.SignedString(current.signKey)
+}
+
+// keyFunc resolves the verification key of a token from its kid header,
+// refusing tokens whose algorithm is not the one of that key.
+func (ks *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ks.byID[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.verifyKey, nil
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the keyset. HMAC keys are secret and never
+// published, so a keyset of HS256 keys only has an empty JWKS.
+func (ks *KeySet) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range ks.keys {
+		jwk := JWK{KeyID: key.ID, Use: "sig", Algorithm: key.Method.Alg()}
+		switch public := key.verifyKey.(type) {
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
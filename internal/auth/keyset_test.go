@@ -0,0 +1,247 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"photosync-backend/internal/config"
+)
+
+func newEdKey(t *testing.T, id string) *SigningKey {
+	t.Helper()
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &SigningKey{ID: id, Method: jwt.SigningMethodEdDSA, signKey: private, verifyKey: public}
+}
+
+func newKeySet(t *testing.T, keys ...*SigningKey) *KeySet {
+	t.Helper()
+
+	ks, err := NewKeySet(keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ks
+}
+
+// signWith signs claims with method and secret, naming kid in the header
+// unless it is empty.
+func signWith(t *testing.T, method jwt.SigningMethod, kid string, secret interface{}, claims jwt.Claims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestKeySetRotation(t *testing.T) {
+	old, current := newEdKey(t, "old"), NewHMACKey("current", []byte("secret"))
+
+	token, _, err := NewJWTManager(newKeySet(t, old), "test", time.Hour).Generate("alice", "sid")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		keys    []*SigningKey
+		wantErr bool
+	}{
+		{name: "before rotation", keys: []*SigningKey{old}},
+		{name: "after rotation", keys: []*SigningKey{current, {ID: old.ID, Method: old.Method, verifyKey: old.verifyKey}}},
+		{name: "old key dropped", keys: []*SigningKey{current}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := NewJWTManager(newKeySet(t, tt.keys...), "test", time.Hour).Validate(token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && (claims.Username != "alice" || claims.SessionID != "sid") {
+				t.Errorf("claims = %+v", claims)
+			}
+		})
+	}
+}
+
+func TestKeyFunc(t *testing.T) {
+	ed, hmac := newEdKey(t, "ed"), NewHMACKey("hs", []byte("secret"))
+	ks := newKeySet(t, ed, hmac)
+	m := NewJWTManager(ks, "test", time.Hour)
+
+	claims := JWTClaims{Username: "alice", SessionID: "sid", RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))}}
+	public := []byte(ed.verifyKey.(ed25519.PublicKey))
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{name: "EdDSA", token: signWith(t, jwt.SigningMethodEdDSA, "ed", ed.signKey, claims)},
+		{name: "HS256", token: signWith(t, jwt.SigningMethodHS256, "hs", []byte("secret"), claims)},
+		{
+			name:    "HMAC keyed with the public key",
+			token:   signWith(t, jwt.SigningMethodHS256, "ed", public, claims),
+			wantErr: jwt.ErrTokenUnverifiable,
+		},
+		{
+			name:    "EdDSA under the HMAC kid",
+			token:   signWith(t, jwt.SigningMethodEdDSA, "hs", ed.signKey, claims),
+			wantErr: jwt.ErrTokenUnverifiable,
+		},
+		{
+			name:    "HS384 under the HMAC kid",
+			token:   signWith(t, jwt.SigningMethodHS384, "hs", []byte("secret"), claims),
+			wantErr: jwt.ErrTokenUnverifiable,
+		},
+		{
+			name:    "unsigned",
+			token:   signWith(t, jwt.SigningMethodNone, "hs", jwt.UnsafeAllowNoneSignatureType, claims),
+			wantErr: jwt.ErrTokenUnverifiable,
+		},
+		{
+			name:    "no kid",
+			token:   signWith(t, jwt.SigningMethodHS256, "", []byte("secret"), claims),
+			wantErr: jwt.ErrTokenUnverifiable,
+		},
+		{
+			name:    "unknown kid",
+			token:   signWith(t, jwt.SigningMethodHS256, "other", []byte("secret"), claims),
+			wantErr: jwt.ErrTokenUnverifiable,
+		},
+		{
+			name:    "wrong secret",
+			token:   signWith(t, jwt.SigningMethodHS256, "hs", []byte("guess"), claims),
+			wantErr: jwt.ErrTokenSignatureInvalid,
+		},
+		{
+			name:    "expired",
+			token:   signWith(t, jwt.SigningMethodHS256, "hs", []byte("secret"), JWTClaims{RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute))}}),
+			wantErr: jwt.ErrTokenExpired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := m.Validate(tt.token)
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("Validate error = %v", err)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Validate error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewKeySet(t *testing.T) {
+	ed := newEdKey(t, "ed")
+	verifyOnly := &SigningKey{ID: "old", Method: ed.Method, verifyKey: ed.verifyKey}
+
+	tests := []struct {
+		name    string
+		keys    []*SigningKey
+		wantErr bool
+	}{
+		{name: "signing key first", keys: []*SigningKey{ed, verifyOnly}},
+		{name: "no keys", wantErr: true},
+		{name: "verify-only key first", keys: []*SigningKey{verifyOnly, ed}, wantErr: true},
+		{name: "duplicate ids", keys: []*SigningKey{ed, NewHMACKey("ed", []byte("secret"))}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		if _, err := NewKeySet(tt.keys...); (err != nil) != tt.wantErr {
+			t.Errorf("%s: NewKeySet error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLoadSigningKey(t *testing.T) {
+	dir := t.TempDir()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writePEM := func(name, typ string, der []byte, err error) string {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	privateFile := writePEM("private.pem", "PRIVATE KEY", der, err)
+	der, err = x509.MarshalPKIXPublicKey(public)
+	publicFile := writePEM("public.pem", "PUBLIC KEY", der, err)
+
+	tests := []struct {
+		name       string
+		cfg        config.SigningKeyConfig
+		wantSigner bool
+		wantErr    bool
+	}{
+		{name: "HMAC", cfg: config.SigningKeyConfig{ID: "a", Algorithm: "HS256", Secret: "secret"}, wantSigner: true},
+		{name: "private key", cfg: config.SigningKeyConfig{ID: "b", Algorithm: "EdDSA", PrivateKeyFile: privateFile}, wantSigner: true},
+		{name: "public key only", cfg: config.SigningKeyConfig{ID: "c", Algorithm: "EdDSA", PublicKeyFile: publicFile}},
+		{name: "wrong key type", cfg: config.SigningKeyConfig{ID: "d", Algorithm: "RS256", PublicKeyFile: publicFile}, wantErr: true},
+		{name: "missing file", cfg: config.SigningKeyConfig{ID: "e", Algorithm: "EdDSA", PrivateKeyFile: filepath.Join(dir, "nope.pem")}, wantErr: true},
+		{name: "unsupported algorithm", cfg: config.SigningKeyConfig{ID: "f", Algorithm: "none"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := loadSigningKey(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadSigningKey error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if (key.signKey != nil) != tt.wantSigner {
+				t.Errorf("signKey = %T, want a signer %v", key.signKey, tt.wantSigner)
+			}
+			if tt.cfg.Algorithm == "EdDSA" && !public.Equal(key.verifyKey) {
+				t.Errorf("verifyKey = %v, want the generated public key", key.verifyKey)
+			}
+		})
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	ed := newEdKey(t, "ed")
+	set := newKeySet(t, ed, NewHMACKey("hs", []byte("secret"))).JWKS()
+
+	if len(set.Keys) != 1 {
+		t.Fatalf("JWKS has %d keys, want only the Ed25519 one", len(set.Keys))
+	}
+	jwk := set.Keys[0]
+	if jwk.KeyID != "ed" || jwk.KeyType != "OKP" || jwk.Algorithm != "EdDSA" {
+		t.Errorf("JWK = %+v", jwk)
+	}
+	if key, err := jwk.publicKey(); err != nil || !ed.verifyKey.(ed25519.PublicKey).Equal(key) {
+		t.Errorf("JWK decodes to %v, %v", key, err)
+	}
+}
@@ -2,58 +2,409 @@ package auth
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
-	"photosync-backend/internal/config"
-	"photosync-backend/internal/models"
+	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/github"
 	"golang.org/x/oauth2/google"
+	"photosync-backend/internal/config"
+	"photosync-backend/internal/models"
 )
 
+// loginExpiry is how long a user has to sign in at the provider.
+const loginExpiry = 10 * time.Minute
+
+var ErrInvalidState = errors.New("invalid or expired oauth2 state")
+
+const googleJWKSURL = "https://www.googleapis.com/oauth2/v3/certs"
+
+// githubUserURL is a variable so tests can point it at a fake API.
+var githubUserURL = "https://api.github.com/user"
+
+// oidcScopes are requested from OIDC providers unless scopes are configured.
+var oidcScopes = []string{"openid", "profile", "email"}
+
+var googleIssuers = []string{"https://accounts.google.com", "accounts.google.com"}
+
+// OAuth2Auth signs users in with the authorization code flow: the browser is
+// sent to the provider with a state, a PKCE challenge and an OIDC nonce, and
+// the code it returns with is exchanged here. Users are identified only from
+// what the provider vouches for: the signed ID token, or for GitHub, the
+// account the access token belongs to.
+//
+// Sign-ins in progress are not remembered. The state carries a random ID
+// and an expiry, signed together with the caller's binding to the browser;
+// the PKCE verifier and the nonce are derived from the ID with the same
+// key, which never leaves the process.
+//
+// The "oidc" provider works with any OpenID Connect provider. Its endpoints
+// and keys are read from the issuer's discovery document when first needed,
+// so that the server starts while the provider is unreachable.
 type OAuth2Auth struct {
 	config *config.OAuth2Config
 	oauth  *oauth2.Config
-}
+	client *http.Client
+	claims config.OAuth2ClaimsConfig
 
-func NewOAuth2Auth(cfg *config.OAuth2Config) *OAuth2Auth {
-	var endpoint oauth2.Endpoint
+	discoverMu sync.Mutex
+	issuers    []string
+	keys       *remoteKeySet
+	identify   func(ctx context.Context, token *oauth2.Token, nonce string) (*models.UserInfo, error)
 
-	switch cfg.Provider {
-	case "google":
-		endpoint = google.Endpoint
-	case "github":
-		endpoint = github.Endpoint
-	default:
-		endpoint = oauth2.Endpoint{}
+	stateKey []byte
+}
+
+func NewOAuth2Auth(cfg *config.OAuth2Config) (*OAuth2Auth, error) {
+	stateKey := make([]byte, 32)
+	if _, err := rand.Read(stateKey); err != nil {
+		return nil, err
 	}
 
-	return &OAuth2Auth{
+	a := &OAuth2Auth{
 		config: cfg,
 		oauth: &oauth2.Config{
 			ClientID:     cfg.ClientID,
 			ClientSecret: cfg.ClientSecret,
 			RedirectURL:  cfg.RedirectURL,
-			Endpoint:     endpoint,
-			Scopes:       []string{"openid", "profile", "email"},
 		},
+		client:   &http.Client{Timeout: 10 * time.Second},
+		stateKey: stateKey,
+	}
+
+	switch cfg.Provider {
+	case "google":
+		a.oauth.Endpoint = google.Endpoint
+		a.oauth.Scopes = oidcScopes
+		a.issuers = googleIssuers
+		a.keys = newRemoteKeySet(googleJWKSURL, a.client)
+		a.claims = config.OAuth2ClaimsConfig{Username: "email", Name: "name"}
+		a.identify = a.oidcUser
+	case "oidc":
+		a.oauth.Scopes = oidcScopes
+		a.claims = config.OAuth2ClaimsConfig{Username: "sub", Name: "name"}
+		a.identify = a.oidcUser
+	case "github":
+		a.oauth.Endpoint = github.Endpoint
+		a.oauth.Scopes = []string{"read:user"}
+		a.identify = a.githubUser
+	default:
+		return nil, fmt.Errorf("unknown oauth2 provider: %s", cfg.Provider)
+	}
+
+	if len(cfg.Scopes) > 0 {
+		a.oauth.Scopes = cfg.Scopes
+	}
+	switch cfg.Claims.Username {
+	case "":
+	case "sub", "email":
+		a.claims.Username = cfg.Claims.Username
+	default:
+		return nil, fmt.Errorf("oauth2 users cannot be identified by the %s claim", cfg.Claims.Username)
+	}
+	if cfg.Claims.Name != "" {
+		a.claims.Name = cfg.Claims.Name
+	}
+
+	return a, nil
+}
+
+// discover reads the endpoints and keys of an "oidc" provider from its
+// discovery document, once.
+func (a *OAuth2Auth) discover(ctx context.Context) error {
+	a.discoverMu.Lock()
+	defer a.discoverMu.Unlock()
+
+	if a.config.Provider != "oidc" || a.keys != nil {
+		return nil
+	}
+
+	issuer := strings.TrimSuffix(a.config.Issuer, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("oidc discovery failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc discovery failed: %s", resp.Status)
+	}
+
+	var doc struct {
+		Issuer                        string   `json:"issuer"`
+		AuthorizationEndpoint         string   `json:"authorization_endpoint"`
+		TokenEndpoint                 string   `json:"token_endpoint"`
+		JWKSURI                       string   `json:"jwks_uri"`
+		CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return fmt.Errorf("invalid oidc discovery document: %w", err)
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != issuer {
+		return fmt.Errorf("oidc discovery document is for issuer %q, not %q", doc.Issuer, a.config.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return fmt.Errorf("oidc discovery document lacks endpoints")
 	}
+	if len(doc.CodeChallengeMethodsSupported) > 0 && !slices.Contains(doc.CodeChallengeMethodsSupported, "S256") {
+		return fmt.Errorf("oidc provider does not support PKCE with S256")
+	}
+
+	a.oauth.Endpoint = oauth2.Endpoint{AuthURL: doc.AuthorizationEndpoint, TokenURL: doc.TokenEndpoint}
+	a.issuers = []string{doc.Issuer}
+	a.keys = newRemoteKeySet(doc.JWKSURI, a.client)
+	return nil
 }
 
 func (a *OAuth2Auth) GetName() string {
 	return "oauth2"
 }
 
+// Authenticate always fails: OAuth2 users have no password here and sign in
+// at the provider instead.
 func (a *OAuth2Auth) Authenticate(username, password string) (*models.UserInfo, error) {
-	token, err := a.oauth.Exchange(context.Background(), password)
+	return nil, fmt.Errorf("oauth2 users cannot log in with a password")
+}
+
+// AuthCodeURL starts a sign-in bound to binding and returns the provider
+// URL to send the browser to, along with the sign-in's state.
+func (a *OAuth2Auth) AuthCodeURL(binding string) (string, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := a.discover(ctx); err != nil {
+		return "", "", err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", err
+	}
+	state := a.signState(id, time.Now().Add(loginExpiry), binding)
+	verifier, nonce := a.loginSecrets(id)
+
+	return a.oauth.AuthCodeURL(state,
+		oauth2.S256ChallengeOption(verifier),
+		oauth2.SetAuthURLParam("nonce", nonce),
+	), state, nil
+}
+
+// Exchange completes the sign-in started with state and binding, redeeming
+// code and returning the verified user.
+func (a *OAuth2Auth) Exchange(ctx context.Context, state, binding, code string) (*models.UserInfo, error) {
+	id, ok := a.openState(state, binding)
+	if !ok {
+		return nil, ErrInvalidState
+	}
+	verifier, nonce := a.loginSecrets(id)
+
+	if err := a.discover(ctx); err != nil {
+		return nil, err
+	}
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, a.client)
+	token, err := a.oauth.Exchange(ctx, code, oauth2.VerifierOption(verifier))
 	if err != nil {
 		return nil, fmt.Errorf("oauth2 token exchange failed: %w", err)
 	}
 
+	return a.identify(ctx, token, nonce)
+}
+
+// signState returns the state of the sign-in id: the ID and expiry followed
+// by their MAC, which also covers binding.
+func (a *OAuth2Auth) signState(id []byte, expires time.Time, binding string) string {
+	payload := binary.BigEndian.AppendUint64(slices.Clone(id), uint64(expires.Unix()))
+	return base64.RawURLEncoding.EncodeToString(append(payload, a.stateMAC(payload, binding)...))
+}
+
+// openState returns the ID of the sign-in with state if it was signed here
+// for binding and has not expired.
+func (a *OAuth2Auth) openState(state, binding string) ([]byte, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(state)
+	if err != nil || len(raw) != 16+8+sha256.Size {
+		return nil, false
+	}
+	payload, mac := raw[:16+8], raw[16+8:]
+	if !hmac.Equal(mac, a.stateMAC(payload, binding)) {
+		return nil, false
+	}
+	if time.Now().After(time.Unix(int64(binary.BigEndian.Uint64(payload[16:])), 0)) {
+		return nil, false
+	}
+	return payload[:16], true
+}
+
+func (a *OAuth2Auth) stateMAC(payload []byte, binding string) []byte {
+	mac := hmac.New(sha256.New, a.stateKey)
+	mac.Write([]byte("state\x00"))
+	mac.Write(payload)
+	mac.Write([]byte(binding))
+	return mac.Sum(nil)
+}
+
+// loginSecrets derives the PKCE verifier and the nonce of the sign-in id.
+func (a *OAuth2Auth) loginSecrets(id []byte) (string, string) {
+	derive := func(label string) string {
+		mac := hmac.New(sha256.New, a.stateKey)
+		mac.Write([]byte(label + "\x00"))
+		mac.Write(id)
+		return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	}
+	return derive("verifier"), derive("nonce")
+}
+
+// oidcUser maps the claims of the verified ID token to the user. The email
+// address is only taken when the provider has verified it.
+func (a *OAuth2Auth) oidcUser(ctx context.Context, token *oauth2.Token, nonce string) (*models.UserInfo, error) {
+	claims, err := a.verifyIDToken(token, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	email := claimString(claims, "email")
+	if !claimBool(claims, "email_verified") {
+		email = ""
+	}
+
+	username := email
+	if a.claims.Username == "sub" {
+		username = subjectUsername(a.config.Provider, claimString(claims, "sub"))
+	}
+	if username == "" {
+		return nil, fmt.Errorf("id_token has no verified %s claim", a.claims.Username)
+	}
+
 	return &models.UserInfo{
 		Username: username,
 		DN:       "oauth2:" + username,
-		Email:    username,
-		FullName: token.Extra("name").(string),
+		Email:    email,
+		FullName: claimString(claims, a.claims.Name),
 	}, nil
 }
+
+// subjectUsername derives a username from the provider's subject identifier.
+// Subjects are opaque: they may hold characters no directory name can, like
+// "auth0|abc123", or differ only in case, which some shares ignore. So the
+// username is a digest of the subject, prefixed with the provider.
+func subjectUsername(provider, sub string) string {
+	if sub == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(sub))
+	return provider + "-" + hex.EncodeToString(sum[:16])
+}
+
+// githubUser identifies the GitHub account the access token was issued
+// for. GitHub does not issue ID tokens. Accounts are keyed by their numeric
+// id: logins can be renamed and then claimed by someone else, who would
+// inherit the library, so the login is only shown as a name.
+func (a *OAuth2Auth) githubUser(ctx context.Context, token *oauth2.Token, _ string) (*models.UserInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, githubUserURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := a.oauth.Client(ctx, token).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch github user: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch github user: %s", resp.Status)
+	}
+
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return nil, fmt.Errorf("invalid github user: %w", err)
+	}
+	if user.ID <= 0 {
+		return nil, fmt.Errorf("github user has no id")
+	}
+
+	id := strconv.FormatInt(user.ID, 10)
+	name := user.Name
+	if name == "" {
+		name = user.Login
+	}
+
+	return &models.UserInfo{
+		Username: id,
+		DN:       "oauth2:github:" + id,
+		Email:    user.Email,
+		FullName: name,
+	}, nil
+}
+
+// verifyIDToken checks the signature of the ID token against the provider's
+// keys, that it was issued by the provider for this client and has not
+// expired, and that it carries the nonce of this sign-in.
+func (a *OAuth2Auth) verifyIDToken(token *oauth2.Token, nonce string) (jwt.MapClaims, error) {
+	raw, _ := token.Extra("id_token").(string)
+	if raw == "" {
+		return nil, fmt.Errorf("provider returned no id_token")
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, a.keys.keyFunc,
+		jwt.WithValidMethods([]string{"RS256", "ES256", "EdDSA"}),
+		jwt.WithAudience(a.oauth.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id_token: %w", err)
+	}
+
+	if !slices.Contains(a.issuers, claimString(claims, "iss")) {
+		return nil, fmt.Errorf("invalid id_token: unexpected issuer %q", claimString(claims, "iss"))
+	}
+	if subtle.ConstantTimeCompare([]byte(claimString(claims, "nonce")), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("invalid id_token: nonce mismatch")
+	}
+	return claims, nil
+}
+
+// claimString returns a string claim, or "" when it is missing or not a
+// string.
+func claimString(claims jwt.MapClaims, name string) string {
+	s, _ := claims[name].(string)
+	return s
+}
+
+// claimBool returns a boolean claim. Some providers send booleans as
+// strings.
+func claimBool(claims jwt.MapClaims, name string) bool {
+	switch v := claims[name].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	default:
+		return false
+	}
+}
@@ -0,0 +1,411 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+	"photosync-backend/internal/config"
+	"photosync-backend/internal/storage"
+)
+
+const testIssuer = "https://issuer.example"
+
+// testProvider publishes the public keys of its signers as a JWKS.
+type testProvider struct {
+	url string
+
+	mu      sync.Mutex
+	jwks    JWKS
+	fetches int
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	t.Helper()
+
+	p := &testProvider{jwks: JWKS{Keys: []JWK{}}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.fetches++
+		json.NewEncoder(w).Encode(p.jwks)
+	}))
+	t.Cleanup(srv.Close)
+	p.url = srv.URL
+	return p
+}
+
+// publish adds the public key of signer to the JWKS under kid.
+func (p *testProvider) publish(kid, use string, signer interface{}) {
+	encode := base64.RawURLEncoding.EncodeToString
+	jwk := JWK{KeyID: kid, Use: use}
+	switch key := signer.(type) {
+	case *rsa.PrivateKey:
+		jwk.KeyType, jwk.Algorithm = "RSA", "RS256"
+		jwk.N = encode(key.N.Bytes())
+		jwk.E = encode(big.NewInt(int64(key.E)).Bytes())
+	case *ecdsa.PrivateKey:
+		jwk.KeyType, jwk.Algorithm, jwk.Curve = "EC", "ES256", "P-256"
+		jwk.X = encode(key.X.FillBytes(make([]byte, 32)))
+		jwk.Y = encode(key.Y.FillBytes(make([]byte, 32)))
+	case ed25519.PrivateKey:
+		jwk.KeyType, jwk.Algorithm, jwk.Curve = "OKP", "EdDSA", "Ed25519"
+		jwk.X = encode(key.Public().(ed25519.PublicKey))
+	}
+
+	p.mu.Lock()
+	p.jwks.Keys = append(p.jwks.Keys, jwk)
+	p.mu.Unlock()
+}
+
+func (p *testProvider) fetched() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.fetches
+}
+
+func (p *testProvider) auth() *OAuth2Auth {
+	return &OAuth2Auth{
+		config:  &config.OAuth2Config{Provider: "oidc"},
+		oauth:   &oauth2.Config{ClientID: "client"},
+		claims:  config.OAuth2ClaimsConfig{Username: "sub", Name: "name"},
+		issuers: []string{testIssuer},
+		keys:    newRemoteKeySet(p.url, http.DefaultClient),
+	}
+}
+
+func idClaims(changes jwt.MapClaims) jwt.MapClaims {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":   testIssuer,
+		"aud":   "client",
+		"sub":   "1234",
+		"exp":   now.Add(time.Hour).Unix(),
+		"iat":   now.Unix(),
+		"nonce": "nonce",
+	}
+	for name, value := range changes {
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+	}
+	return claims
+}
+
+func withIDToken(raw string) *oauth2.Token {
+	return (&oauth2.Token{AccessToken: "access"}).WithExtra(map[string]interface{}{"id_token": raw})
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := newTestProvider(t)
+	p.publish("rsa", "sig", rsaKey)
+	p.publish("ec", "", ecKey)
+	p.publish("ed", "sig", edKey)
+	p.publish("enc", "enc", otherKey)
+	a := p.auth()
+
+	now := time.Now()
+	tests := []struct {
+		name    string
+		token   *oauth2.Token
+		wantErr bool
+	}{
+		{name: "RS256", token: withIDToken(signWith(t, jwt.SigningMethodRS256, "rsa", rsaKey, idClaims(nil)))},
+		{name: "ES256", token: withIDToken(signWith(t, jwt.SigningMethodES256, "ec", ecKey, idClaims(nil)))},
+		{name: "EdDSA", token: withIDToken(signWith(t, jwt.SigningMethodEdDSA, "ed", edKey, idClaims(nil)))},
+		{name: "audience list", token: withIDToken(signWith(t, jwt.SigningMethodEdDSA, "ed", edKey, idClaims(jwt.MapClaims{"aud": []string{"other", "client"}})))},
+		{name: "expired within leeway", token: withIDToken(signWith(t, jwt.SigningMethodEdDSA, "ed", edKey, idClaims(jwt.MapClaims{"exp": now.Add(-30 * time.Second).Unix()})))},
+		{name: "no id_token", token: &oauth2.Token{AccessToken: "access"}, wantErr: true},
+		{name: "HS256", token: withIDToken(signWith(t, jwt.SigningMethodHS256, "rsa", []byte("secret"), idClaims(nil))), wantErr: true},
+		{name: "RS256 under the EC kid", token: withIDToken(signWith(t, jwt.SigningMethodRS256, "ec", rsaKey, idClaims(nil))), wantErr: true},
+		{name: "signed by another key", token: withIDToken(signWith(t, jwt.SigningMethodEdDSA, "ed", otherKey, idClaims(nil))), wantErr: true},
+		{name: "encryption key", token: withIDToken(signWith(t, jwt.SigningMethodEdDSA, "enc", otherKey, idClaims(nil))), wantErr: true},
+		{name: "unknown kid", token: withIDToken(signWith(t, jwt.SigningMethodEdDSA, "new", edKey, idClaims(nil))), wantErr: true},
+		{name: "other audience", token: withIDToken(signWith(t, jwt.SigningMethodEdDSA, "ed", edKey, idClaims(jwt.MapClaims{"aud": "other"}))), wantErr: true},
+		{name: "other issuer", token: withIDToken(signWith(t, jwt.SigningMethodEdDSA, "ed", edKey, idClaims(jwt.MapClaims{"iss": "https://evil.example"}))), wantErr: true},
+		{name: "expired", token: withIDToken(signWith(t, jwt.SigningMethodEdDSA, "ed", edKey, idClaims(jwt.MapClaims{"exp": now.Add(-2 * time.Minute).Unix()}))), wantErr: true},
+		{name: "no expiry", token: withIDToken(signWith(t, jwt.SigningMethodEdDSA, "ed", edKey, idClaims(jwt.MapClaims{"exp": nil}))), wantErr: true},
+		{name: "issued in the future", token: withIDToken(signWith(t, jwt.SigningMethodEdDSA, "ed", edKey, idClaims(jwt.MapClaims{"iat": now.Add(5 * time.Minute).Unix()}))), wantErr: true},
+		{name: "other nonce", token: withIDToken(signWith(t, jwt.SigningMethodEdDSA, "ed", edKey, idClaims(jwt.MapClaims{"nonce": "replayed"}))), wantErr: true},
+		{name: "no nonce", token: withIDToken(signWith(t, jwt.SigningMethodEdDSA, "ed", edKey, idClaims(jwt.MapClaims{"nonce": nil}))), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := a.verifyIDToken(tt.token, "nonce")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verifyIDToken error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && claimString(claims, "sub") != "1234" {
+				t.Errorf("sub = %q, want 1234", claimString(claims, "sub"))
+			}
+		})
+	}
+}
+
+func TestRemoteKeySetRotation(t *testing.T) {
+	_, oldKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, newKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := newTestProvider(t)
+	p.publish("old", "sig", oldKey)
+	a := p.auth()
+
+	if _, err := a.verifyIDToken(withIDToken(signWith(t, jwt.SigningMethodEdDSA, "old", oldKey, idClaims(nil))), "nonce"); err != nil {
+		t.Fatalf("verifyIDToken: %v", err)
+	}
+
+	// A key the provider has just rotated to is only fetched once the
+	// keys are older than jwksRefreshInterval.
+	p.publish("new", "sig", newKey)
+	rotated := withIDToken(signWith(t, jwt.SigningMethodEdDSA, "new", newKey, idClaims(nil)))
+	if _, err := a.verifyIDToken(rotated, "nonce"); err == nil {
+		t.Fatal("verifyIDToken fetched the keys again within the refresh interval")
+	}
+	if n := p.fetched(); n != 1 {
+		t.Fatalf("fetches = %d, want 1", n)
+	}
+
+	a.keys.fetched = time.Now().Add(-2 * jwksRefreshInterval)
+	if _, err := a.verifyIDToken(rotated, "nonce"); err != nil {
+		t.Fatalf("verifyIDToken after the refresh interval: %v", err)
+	}
+	if n := p.fetched(); n != 2 {
+		t.Errorf("fetches = %d, want 2", n)
+	}
+}
+
+func TestOIDCUser(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newTestProvider(t)
+	p.publish("ed", "sig", key)
+
+	tests := []struct {
+		name      string
+		claim     string
+		claims    jwt.MapClaims
+		wantUser  string
+		wantEmail string
+		wantErr   bool
+	}{
+		{name: "by subject", claim: "sub", claims: jwt.MapClaims{"email": "a@example.com", "email_verified": true}, wantUser: "oidc-03ac674216f3e15c761ee1a5e255f067", wantEmail: "a@example.com"},
+		{name: "subject not a directory name", claim: "sub", claims: jwt.MapClaims{"sub": "auth0|abc123"}, wantUser: "oidc-ef0e3f35fd0c280f81918756941a9a21"},
+		{name: "by verified email", claim: "email", claims: jwt.MapClaims{"email": "a@example.com", "email_verified": "true"}, wantUser: "a@example.com", wantEmail: "a@example.com"},
+		{name: "unverified email dropped", claim: "sub", claims: jwt.MapClaims{"email": "a@example.com", "email_verified": false}, wantUser: "oidc-03ac674216f3e15c761ee1a5e255f067"},
+		{name: "by unverified email", claim: "email", claims: jwt.MapClaims{"email": "a@example.com"}, wantErr: true},
+		{name: "no subject", claim: "sub", claims: jwt.MapClaims{"sub": nil}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := p.auth()
+			a.claims.Username = tt.claim
+			tt.claims["name"] = "Alice"
+
+			user, err := a.oidcUser(context.Background(), withIDToken(signWith(t, jwt.SigningMethodEdDSA, "ed", key, idClaims(tt.claims))), "nonce")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("oidcUser error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if user.Username != tt.wantUser || user.Email != tt.wantEmail || user.FullName != "Alice" || user.DN != "oauth2:"+tt.wantUser {
+				t.Errorf("user = %+v, want %s with email %q", user, tt.wantUser, tt.wantEmail)
+			}
+			if cleaned, err := storage.CleanUsername(user.Username); err != nil || cleaned != user.Username {
+				t.Errorf("username %q is not a valid library name: %v", user.Username, err)
+			}
+		})
+	}
+}
+
+func TestGitHubUser(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantUser string
+		wantName string
+		wantErr  bool
+	}{
+		{name: "named", body: `{"id": 583231, "login": "octocat", "name": "The Octocat"}`, wantUser: "583231", wantName: "The Octocat"},
+		{name: "renamed login", body: `{"id": 583231, "login": "octocat-2"}`, wantUser: "583231", wantName: "octocat-2"},
+		{name: "no id", body: `{"login": "octocat"}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") != "Bearer access" {
+					http.Error(w, "unauthorized", http.StatusUnauthorized)
+					return
+				}
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			saved := githubUserURL
+			githubUserURL = srv.URL
+			defer func() { githubUserURL = saved }()
+
+			a := &OAuth2Auth{oauth: &oauth2.Config{}}
+			user, err := a.githubUser(context.Background(), &oauth2.Token{AccessToken: "access"}, "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("githubUser error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if user.Username != tt.wantUser || user.DN != "oauth2:github:"+tt.wantUser || user.FullName != tt.wantName {
+				t.Errorf("user = %+v, want %s named %q", user, tt.wantUser, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     map[string]any
+		wantErr bool
+	}{
+		{name: "complete", doc: map[string]any{}},
+		{name: "S256 listed", doc: map[string]any{"code_challenge_methods_supported": []string{"plain", "S256"}}},
+		{name: "other issuer", doc: map[string]any{"issuer": "https://other.example"}, wantErr: true},
+		{name: "no token endpoint", doc: map[string]any{"token_endpoint": ""}, wantErr: true},
+		{name: "no jwks", doc: map[string]any{"jwks_uri": ""}, wantErr: true},
+		{name: "no S256", doc: map[string]any{"code_challenge_methods_supported": []string{"plain"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fetches int
+			var srv *httptest.Server
+			srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/.well-known/openid-configuration" {
+					http.NotFound(w, r)
+					return
+				}
+				fetches++
+				doc := map[string]any{
+					"issuer":                 srv.URL,
+					"authorization_endpoint": srv.URL + "/authorize",
+					"token_endpoint":         srv.URL + "/token",
+					"jwks_uri":               srv.URL + "/jwks",
+				}
+				for key, value := range tt.doc {
+					doc[key] = value
+				}
+				json.NewEncoder(w).Encode(doc)
+			}))
+			defer srv.Close()
+
+			a := &OAuth2Auth{
+				config: &config.OAuth2Config{Provider: "oidc", Issuer: srv.URL + "/"},
+				oauth:  &oauth2.Config{},
+				client: srv.Client(),
+			}
+			err := a.discover(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("discover error = %v, want error %v", err, tt.wantErr)
+			}
+
+			// A failed discovery is tried again; a successful one is kept.
+			a.discover(context.Background())
+			wantFetches := 1
+			if tt.wantErr {
+				wantFetches = 2
+			}
+			if fetches != wantFetches {
+				t.Errorf("discovery document fetched %d times, want %d", fetches, wantFetches)
+			}
+			if err != nil {
+				return
+			}
+			if a.oauth.Endpoint.AuthURL != srv.URL+"/authorize" || a.oauth.Endpoint.TokenURL != srv.URL+"/token" || len(a.issuers) != 1 || a.issuers[0] != srv.URL {
+				t.Errorf("discovered endpoint %+v and issuers %v", a.oauth.Endpoint, a.issuers)
+			}
+		})
+	}
+}
+
+func TestLoginState(t *testing.T) {
+	a := &OAuth2Auth{stateKey: make([]byte, 32)}
+	id := []byte("0123456789abcdef")
+	state := a.signState(id, time.Now().Add(loginExpiry), "binding")
+
+	tests := []struct {
+		name    string
+		auth    *OAuth2Auth
+		state   string
+		binding string
+		wantOK  bool
+	}{
+		{name: "valid", auth: a, state: state, binding: "binding", wantOK: true},
+		{name: "other binding", auth: a, state: state, binding: "other"},
+		{name: "expired", auth: a, state: a.signState(id, time.Now().Add(-time.Second), "binding"), binding: "binding"},
+		{name: "tampered", auth: a, state: "A" + state[1:], binding: "binding"},
+		{name: "truncated", auth: a, state: state[:20], binding: "binding"},
+		{name: "other key", auth: &OAuth2Auth{stateKey: []byte("another key of thirty-two bytes!")}, state: state, binding: "binding"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.auth.openState(tt.state, tt.binding)
+			if ok != tt.wantOK {
+				t.Fatalf("openState ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && string(got) != string(id) {
+				t.Errorf("openState = %q, want %q", got, id)
+			}
+		})
+	}
+
+	// The verifier and nonce stay on the server and differ per sign-in.
+	verifier, nonce := a.loginSecrets(id)
+	otherVerifier, _ := a.loginSecrets([]byte("fedcba9876543210"))
+	if verifier == nonce || verifier == otherVerifier || strings.Contains(state, verifier) || len(verifier) < 43 {
+		t.Errorf("login secrets %q, %q of state %q", verifier, nonce, state)
+	}
+}
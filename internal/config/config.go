@@ -2,7 +2,11 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -19,8 +23,15 @@ type Config struct {
 	SMB         SMBConfig         `yaml:"smb"`
 	S3          S3Config          `yaml:"s3"`
 	NFS         NFSConfig         `yaml:"nfs"`
+	Local       LocalConfig       `yaml:"local"`
 	JWT         JWTConfig         `yaml:"jwt"`
+	Sessions    SessionsConfig    `yaml:"sessions"`
 	Pool        PoolConfig        `yaml:"pool"`
+	Uploads     UploadsConfig     `yaml:"uploads"`
+	Thumbnails  ThumbnailsConfig  `yaml:"thumbnails"`
+	Sync        SyncConfig        `yaml:"sync"`
+	Index       IndexConfig       `yaml:"index"`
+	Trash       TrashConfig       `yaml:"trash"`
 	Logging     LoggingConfig     `yaml:"logging"`
 }
 
@@ -29,13 +40,16 @@ type AuthConfig struct {
 }
 
 type StorageConfig struct {
-	Type string `yaml:"type"`
+	Type            string `yaml:"type"`
+	CollisionPolicy string `yaml:"collision_policy"`
 }
 
 type ServerConfig struct {
-	Host string    `yaml:"host"`
-	Port string    `yaml:"port"`
-	TLS  TLSConfig `yaml:"tls"`
+	Host            string    `yaml:"host"`
+	Port            string    `yaml:"port"`
+	TLS             TLSConfig `yaml:"tls"`
+	TransferTimeout string    `yaml:"transfer_timeout"`
+	DataDir         string    `yaml:"data_dir"`
 }
 
 type TLSConfig struct {
@@ -65,10 +79,25 @@ type LocalAuthConfig struct {
 }
 
 type OAuth2Config struct {
-	Provider     string `yaml:"provider"`
-	ClientID     string `yaml:"client_id"`
-	ClientSecret string `yaml:"client_secret"`
-	RedirectURL  string `yaml:"redirect_url"`
+	Provider     string             `yaml:"provider"`
+	Issuer       string             `yaml:"issuer"`
+	ClientID     string             `yaml:"client_id"`
+	ClientSecret string             `yaml:"client_secret"`
+	RedirectURL  string             `yaml:"redirect_url"`
+	Scopes       []string           `yaml:"scopes"`
+	Claims       OAuth2ClaimsConfig `yaml:"claims"`
+	// AppRedirectURLs lists where the app may ask to be sent back to with
+	// its one-time login code, e.g. "photosync://login". The first one is
+	// the default.
+	AppRedirectURLs []string `yaml:"app_redirect_urls"`
+}
+
+// OAuth2ClaimsConfig names the ID token claims user details are read from.
+// Username must be a claim the provider vouches for: "sub", or "email",
+// which is only used when email_verified is set.
+type OAuth2ClaimsConfig struct {
+	Username string `yaml:"username"`
+	Name     string `yaml:"name"`
 }
 
 type SMBConfig struct {
@@ -95,17 +124,74 @@ type NFSConfig struct {
 	Path   string `yaml:"path"`
 }
 
+type LocalConfig struct {
+	BasePath string `yaml:"base_path"`
+}
+
 type JWTConfig struct {
-	SecretKey     string `yaml:"secret_key"`
-	EncryptionKey string `yaml:"encryption_key"`
-	Issuer        string `yaml:"issuer"`
-	Expiry        string `yaml:"expiry"`
+	SecretKey      string                `yaml:"secret_key"`
+	EncryptionKey  string                `yaml:"encryption_key"`
+	SigningKeys    []SigningKeyConfig    `yaml:"signing_keys"`
+	EncryptionKeys []EncryptionKeyConfig `yaml:"encryption_keys"`
+	Issuer         string                `yaml:"issuer"`
+	Expiry         string                `yaml:"expiry"`
+	RefreshExpiry  string                `yaml:"refresh_expiry"`
+}
+
+// SigningKeyConfig is one key of the token keyset. HS256 keys have a
+// secret; EdDSA and RS256 keys a PEM private key, or for keys that only
+// verify older tokens, a public key.
+type SigningKeyConfig struct {
+	ID             string `yaml:"id"`
+	Algorithm      string `yaml:"algorithm"`
+	Secret         string `yaml:"secret"`
+	PrivateKeyFile string `yaml:"private_key_file"`
+	PublicKeyFile  string `yaml:"public_key_file"`
+}
+
+type EncryptionKeyConfig struct {
+	ID  string `yaml:"id"`
+	Key string `yaml:"key"`
+}
+
+type SessionsConfig struct {
+	Path string `yaml:"path"`
 }
 
 type PoolConfig struct {
 	ConnectionTTL string `yaml:"connection_ttl"`
 }
 
+type UploadsConfig struct {
+	StagingDir string `yaml:"staging_dir"`
+	Expiry     string `yaml:"expiry"`
+	MaxSize    int64  `yaml:"max_size"`
+}
+
+type ThumbnailsConfig struct {
+	CacheDir    string `yaml:"cache_dir"`
+	Sizes       []int  `yaml:"sizes"`
+	DefaultSize int    `yaml:"default_size"`
+	Quality     int    `yaml:"quality"`
+	MaxAge      string `yaml:"max_age"`
+}
+
+type SyncConfig struct {
+	JournalDir     string `yaml:"journal_dir"`
+	RescanInterval string `yaml:"rescan_interval"`
+	MaxChanges     int    `yaml:"max_changes"`
+}
+
+type IndexConfig struct {
+	Path              string `yaml:"path"`
+	ReconcileInterval string `yaml:"reconcile_interval"`
+}
+
+type TrashConfig struct {
+	Retention     string `yaml:"retention"`
+	PurgeInterval string `yaml:"purge_interval"`
+}
+
 type LoggingConfig struct {
 	Level string `yaml:"level"`
 }
@@ -158,18 +244,99 @@ func (c *Config) Validate() error {
 		return err
 	}
 
+	if authType == "oauth2" && storageType == "smb" {
+		return fmt.Errorf("oauth2 auth cannot be used with smb storage, which connects with the user's password")
+	}
+
+	if err := c.validateThumbnails(); err != nil {
+		return err
+	}
+
+	if err := c.validateSync(); err != nil {
+		return err
+	}
+
+	if err := c.validateIndex(); err != nil {
+		return err
+	}
+
+	if err := c.validateTrash(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
 func (c *Config) validateJWT() error {
-	if c.JWT.SecretKey == "" || containsPlaceholder(c.JWT.SecretKey) {
-		return fmt.Errorf("jwt secret_key must be set (no placeholders allowed)")
+	if err := c.validateSigningKeys(); err != nil {
+		return err
+	}
+	if err := c.validateEncryptionKeys(); err != nil {
+		return err
 	}
-	if c.JWT.EncryptionKey == "" || containsPlaceholder(c.JWT.EncryptionKey) {
-		return fmt.Errorf("jwt encryption_key must be set (no placeholders allowed)")
+	expiry, err := c.GetJWTExpiry()
+	if err != nil || expiry <= 0 {
+		return fmt.Errorf("jwt expiry must be a positive duration")
 	}
-	if len(c.JWT.EncryptionKey) != 32 {
-		return fmt.Errorf("jwt encryption_key must be exactly 32 bytes")
+	refreshExpiry, err := c.GetJWTRefreshExpiry()
+	if err != nil || refreshExpiry < expiry {
+		return fmt.Errorf("jwt refresh_expiry must be a duration no shorter than expiry")
+	}
+	return nil
+}
+
+func (c *Config) validateSigningKeys() error {
+	if len(c.JWT.SigningKeys) == 0 {
+		if c.JWT.SecretKey == "" || containsPlaceholder(c.JWT.SecretKey) {
+			return fmt.Errorf("jwt secret_key must be set (no placeholders allowed)")
+		}
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	for i, key := range c.JWT.SigningKeys {
+		if key.ID == "" || seen[key.ID] {
+			return fmt.Errorf("jwt signing_keys need a unique id each")
+		}
+		seen[key.ID] = true
+
+		switch key.Algorithm {
+		case "HS256":
+			if len(key.Secret) < 32 || containsPlaceholder(key.Secret) {
+				return fmt.Errorf("jwt signing key %s needs a secret of at least 32 bytes (no placeholders allowed)", key.ID)
+			}
+		case "EdDSA", "RS256":
+			if key.PrivateKeyFile == "" && (i == 0 || key.PublicKeyFile == "") {
+				return fmt.Errorf("jwt signing key %s needs a private_key_file", key.ID)
+			}
+		default:
+			return fmt.Errorf("jwt signing key %s algorithm must be one of HS256, EdDSA, RS256", key.ID)
+		}
+	}
+	return nil
+}
+
+func (c *Config) validateEncryptionKeys() error {
+	if len(c.JWT.EncryptionKeys) == 0 {
+		if c.JWT.EncryptionKey == "" || containsPlaceholder(c.JWT.EncryptionKey) {
+			return fmt.Errorf("jwt encryption_key must be set (no placeholders allowed)")
+		}
+		if len(c.JWT.EncryptionKey) != 32 {
+			return fmt.Errorf("jwt encryption_key must be exactly 32 bytes")
+		}
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	for _, key := range c.JWT.EncryptionKeys {
+		if key.ID == "" || seen[key.ID] {
+			return fmt.Errorf("jwt encryption_keys need a unique id each")
+		}
+		seen[key.ID] = true
+
+		if containsPlaceholder(key.Key) || len(key.Key) != 32 {
+			return fmt.Errorf("jwt encryption key %s must be exactly 32 bytes (no placeholders allowed)", key.ID)
+		}
 	}
 	return nil
 }
@@ -195,8 +362,36 @@ func (c *Config) validateAuth(authType string) error {
 			return fmt.Errorf("local_auth users_file is required for local auth")
 		}
 	case "oauth2":
-		if c.OAuth2.Provider == "" {
+		switch c.OAuth2.Provider {
+		case "google", "github":
+		case "oidc":
+			if !strings.HasPrefix(c.OAuth2.Issuer, "https://") {
+				return fmt.Errorf("oauth2 issuer must be an https URL for the oidc provider")
+			}
+		case "":
 			return fmt.Errorf("oauth2 provider is required for oauth2 auth")
+		default:
+			return fmt.Errorf("oauth2 provider must be one of google, github, oidc")
+		}
+		switch c.OAuth2.Claims.Username {
+		case "", "sub", "email":
+		default:
+			return fmt.Errorf("oauth2 claims.username must be sub or email, other claims can be changed by users")
+		}
+		if c.OAuth2.Provider != "github" && len(c.OAuth2.Scopes) > 0 && !slices.Contains(c.OAuth2.Scopes, "openid") {
+			return fmt.Errorf("oauth2 scopes must include openid")
+		}
+		if c.OAuth2.RedirectURL == "" {
+			return fmt.Errorf("oauth2 redirect_url is required for oauth2 auth")
+		}
+		if len(c.OAuth2.AppRedirectURLs) == 0 {
+			return fmt.Errorf("oauth2 app_redirect_urls is required for oauth2 auth")
+		}
+		for _, appURL := range c.OAuth2.AppRedirectURLs {
+			u, err := url.Parse(appURL)
+			if err != nil || u.Scheme == "" || u.Scheme == "http" || u.Fragment != "" {
+				return fmt.Errorf("oauth2 app_redirect_urls must be absolute https or app URLs without a fragment: %q", appURL)
+			}
 		}
 		if c.OAuth2.ClientID == "" || containsPlaceholder(c.OAuth2.ClientID) {
 			return fmt.Errorf("oauth2 client_id must be set (no placeholders)")
@@ -209,6 +404,12 @@ func (c *Config) validateAuth(authType string) error {
 }
 
 func (c *Config) validateStorage(storageType string) error {
+	switch c.Storage.CollisionPolicy {
+	case "", "reject", "overwrite", "rename":
+	default:
+		return fmt.Errorf("storage collision_policy must be one of reject, overwrite, rename")
+	}
+
 	switch storageType {
 	case "smb":
 		if c.SMB.Server == "" {
@@ -231,6 +432,62 @@ func (c *Config) validateStorage(storageType string) error {
 		if c.NFS.Export == "" {
 			return fmt.Errorf("nfs export is required for nfs storage")
 		}
+	case "local":
+		if c.Local.BasePath == "" || containsPlaceholder(c.Local.BasePath) {
+			return fmt.Errorf("local base_path is required for local storage")
+		}
+		if !filepath.IsAbs(c.Local.BasePath) {
+			return fmt.Errorf("local base_path must be an absolute path")
+		}
+		if info, err := os.Stat(c.Local.BasePath); err == nil && !info.IsDir() {
+			return fmt.Errorf("local base_path %s is not a directory", c.Local.BasePath)
+		}
+	}
+	return nil
+}
+
+func (c *Config) validateThumbnails() error {
+	for _, size := range c.Thumbnails.Sizes {
+		if size <= 0 {
+			return fmt.Errorf("thumbnails sizes must be positive")
+		}
+	}
+	if c.Thumbnails.DefaultSize != 0 && !slices.Contains(c.GetThumbnailSizes(), c.Thumbnails.DefaultSize) {
+		return fmt.Errorf("thumbnails default_size must be one of the configured sizes")
+	}
+	if c.Thumbnails.Quality < 0 || c.Thumbnails.Quality > 100 {
+		return fmt.Errorf("thumbnails quality must be between 1 and 100, or 0 for the default")
+	}
+	return nil
+}
+
+func (c *Config) validateSync() error {
+	interval, err := c.GetSyncRescanInterval()
+	if err != nil || interval <= 0 {
+		return fmt.Errorf("sync rescan_interval must be a positive duration")
+	}
+	if c.Sync.MaxChanges < 0 {
+		return fmt.Errorf("sync max_changes must not be negative")
+	}
+	return nil
+}
+
+func (c *Config) validateIndex() error {
+	interval, err := c.GetIndexReconcileInterval()
+	if err != nil || interval <= 0 {
+		return fmt.Errorf("index reconcile_interval must be a positive duration")
+	}
+	return nil
+}
+
+func (c *Config) validateTrash() error {
+	retention, err := c.GetTrashRetention()
+	if err != nil || retention < 0 {
+		return fmt.Errorf("trash retention must be a duration, or 0 to disable the trash")
+	}
+	interval, err := c.GetTrashPurgeInterval()
+	if err != nil || interval <= 0 {
+		return fmt.Errorf("trash purge_interval must be a positive duration")
 	}
 	return nil
 }
@@ -258,6 +515,158 @@ func (c *Config) GetJWTExpiry() (time.Duration, error) {
 	return time.ParseDuration(c.JWT.Expiry)
 }
 
+// GetJWTSigningKeys returns the token keyset, current key first. Without
+// signing_keys, secret_key is the only key, as HS256 key "default".
+func (c *Config) GetJWTSigningKeys() []SigningKeyConfig {
+	if len(c.JWT.SigningKeys) == 0 {
+		return []SigningKeyConfig{{ID: "default", Algorithm: "HS256", Secret: c.JWT.SecretKey}}
+	}
+	return c.JWT.SigningKeys
+}
+
+// GetJWTEncryptionKeys returns the session encryption keys, current key
+// first. Without encryption_keys, encryption_key is key "default".
+func (c *Config) GetJWTEncryptionKeys() []EncryptionKeyConfig {
+	if len(c.JWT.EncryptionKeys) == 0 {
+		return []EncryptionKeyConfig{{ID: "default", Key: c.JWT.EncryptionKey}}
+	}
+	return c.JWT.EncryptionKeys
+}
+
+// GetJWTRefreshExpiry returns how long a session lasts without being
+// refreshed. Each refresh extends it by this much again.
+func (c *Config) GetJWTRefreshExpiry() (time.Duration, error) {
+	if c.JWT.RefreshExpiry == "" {
+		return 30 * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(c.JWT.RefreshExpiry)
+}
+
+// GetDataDir returns the directory sessions, staged uploads, the journal, the
+// index and the thumbnail cache are kept in unless configured otherwise. It
+// must survive restarts: losing it signs everyone out and makes every client
+// resync.
+func (c *Config) GetDataDir() string {
+	if c.Server.DataDir == "" {
+		return "data"
+	}
+	return c.Server.DataDir
+}
+
+func (c *Config) GetSessionsPath() string {
+	if c.Sessions.Path == "" {
+		return filepath.Join(c.GetDataDir(), "sessions.db")
+	}
+	return c.Sessions.Path
+}
+
 func (c *Config) GetPoolTTL() (time.Duration, error) {
 	return time.ParseDuration(c.Pool.ConnectionTTL)
 }
+
+func (c *Config) GetUploadStagingDir() string {
+	if c.Uploads.StagingDir == "" {
+		return filepath.Join(c.GetDataDir(), "uploads")
+	}
+	return c.Uploads.StagingDir
+}
+
+func (c *Config) GetUploadExpiry() (time.Duration, error) {
+	if c.Uploads.Expiry == "" {
+		return 24 * time.Hour, nil
+	}
+	return time.ParseDuration(c.Uploads.Expiry)
+}
+
+func (c *Config) GetThumbnailCacheDir() string {
+	if c.Thumbnails.CacheDir == "" {
+		return filepath.Join(c.GetDataDir(), "thumbnails")
+	}
+	return c.Thumbnails.CacheDir
+}
+
+func (c *Config) GetThumbnailSizes() []int {
+	if len(c.Thumbnails.Sizes) == 0 {
+		return []int{256, 1024}
+	}
+	return c.Thumbnails.Sizes
+}
+
+func (c *Config) GetThumbnailDefaultSize() int {
+	if c.Thumbnails.DefaultSize == 0 {
+		return c.GetThumbnailSizes()[0]
+	}
+	return c.Thumbnails.DefaultSize
+}
+
+func (c *Config) GetThumbnailQuality() int {
+	if c.Thumbnails.Quality == 0 {
+		return 80
+	}
+	return c.Thumbnails.Quality
+}
+
+func (c *Config) GetThumbnailMaxAge() (time.Duration, error) {
+	if c.Thumbnails.MaxAge == "" {
+		return 30 * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(c.Thumbnails.MaxAge)
+}
+
+func (c *Config) GetSyncJournalDir() string {
+	if c.Sync.JournalDir == "" {
+		return filepath.Join(c.GetDataDir(), "journal")
+	}
+	return c.Sync.JournalDir
+}
+
+func (c *Config) GetSyncRescanInterval() (time.Duration, error) {
+	if c.Sync.RescanInterval == "" {
+		return 5 * time.Minute, nil
+	}
+	return time.ParseDuration(c.Sync.RescanInterval)
+}
+
+func (c *Config) GetSyncMaxChanges() int {
+	if c.Sync.MaxChanges == 0 {
+		return 10000
+	}
+	return c.Sync.MaxChanges
+}
+
+func (c *Config) GetIndexPath() string {
+	if c.Index.Path == "" {
+		return filepath.Join(c.GetDataDir(), "index.db")
+	}
+	return c.Index.Path
+}
+
+func (c *Config) GetIndexReconcileInterval() (time.Duration, error) {
+	if c.Index.ReconcileInterval == "" {
+		return 5 * time.Minute, nil
+	}
+	return time.ParseDuration(c.Index.ReconcileInterval)
+}
+
+// GetTrashRetention returns how long deleted files are kept. 0 disables the
+// trash, so deletes are permanent.
+func (c *Config) GetTrashRetention() (time.Duration, error) {
+	if c.Trash.Retention == "" {
+		return 30 * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(c.Trash.Retention)
+}
+
+func (c *Config) GetTrashPurgeInterval() (time.Duration, error) {
+	if c.Trash.PurgeInterval == "" {
+		return time.Hour, nil
+	}
+	return time.ParseDuration(c.Trash.PurgeInterval)
+}
+
+func (c *Config) GetTransferTimeout() (time.Duration, error) {
+	if c.Server.TransferTimeout == "" {
+		return time.Hour, nil
+	}
+	return time.ParseDuration(c.Server.TransferTimeout)
+}
@@ -0,0 +1,263 @@
+package index
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"photosync-backend/internal/models"
+)
+
+var ErrNotFound = errors.New("file not indexed")
+
+var (
+	bucketUsers  = []byte("users")
+	bucketFiles  = []byte("files")
+	bucketHashes = []byte("sha256")
+	keyScanned   = []byte("reconciled")
+	keyInspected = []byte("inspected")
+)
+
+// recordVersion is bumped whenever inspect learns to extract more from a
+// file, so that records written by older versions are read again.
+const recordVersion = 1
+
+type Record struct {
+	Name        string                `json:"name"`
+	Size        int64                 `json:"size"`
+	ModTime     time.Time             `json:"mod_time"`
+	ContentType string                `json:"content_type,omitempty"`
+	SHA256      string                `json:"sha256,omitempty"`
+	Metadata    *models.PhotoMetadata `json:"metadata,omitempty"`
+	Version     int                   `json:"version,omitempty"`
+	Indexed     time.Time             `json:"indexed"`
+}
+
+func (r *Record) FileInfo() models.FileInfo {
+	return models.FileInfo{
+		Name:        r.Name,
+		Size:        r.Size,
+		ModTime:     r.ModTime,
+		ContentType: r.ContentType,
+		SHA256:      r.SHA256,
+		Metadata:    r.Metadata,
+	}
+}
+
+// Complete reports whether the file has been fully inspected.
+func (r *Record) Complete() bool {
+	return r.SHA256 != "" && r.Version >= recordVersion
+}
+
+// Current reports whether the record still describes file.
+func (r *Record) Current(file models.FileInfo) bool {
+	return r.Size == file.Size && r.ModTime.Equal(file.ModTime)
+}
+
+// Index is an embedded bbolt database of per-user file records. Each user has
+// a bucket holding a files bucket keyed by name, a sha256 bucket keyed by
+// "<digest>/<name>" for duplicate lookups, and the time of the last
+// reconcile against the backend.
+type Index struct {
+	db       *bolt.DB
+	mu       sync.Mutex
+	scanning map[string]bool
+	listed   []ListingFunc
+}
+
+// ListingFunc receives every listing of a user's files taken by the index,
+// with the time the listing started. When the listing failed, err is set and
+// listing is nil; it must not be taken for an empty directory.
+type ListingFunc func(username string, listing []models.FileInfo, started time.Time, err error)
+
+func Open(path string) (*Index, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open index: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketUsers)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialise index: %w", err)
+	}
+
+	return &Index{db: db, scanning: make(map[string]bool)}, nil
+}
+
+// OnListing registers fn to be called with each listing of a user's files, so
+// other components can follow changes on the backend without listing it
+// again. It must be called before the index is used.
+func (ix *Index) OnListing(fn ListingFunc) {
+	ix.listed = append(ix.listed, fn)
+}
+
+func (ix *Index) Close() error {
+	return ix.db.Close()
+}
+
+func (ix *Index) Get(username, filename string) (*Record, error) {
+	var rec *Record
+	err := ix.db.View(func(tx *bolt.Tx) error {
+		files := userBucket(tx, username, bucketFiles)
+		if files == nil {
+			return ErrNotFound
+		}
+
+		data := files.Get([]byte(filename))
+		if data == nil {
+			return ErrNotFound
+		}
+
+		rec = &Record{}
+		return json.Unmarshal(data, rec)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// List returns all records of a user, sorted by name. ok is false until the
+// user's files have been reconciled at least once, as the index may then be
+// incomplete.
+func (ix *Index) List(username string) (files []models.FileInfo, ok bool, err error) {
+	err = ix.db.View(func(tx *bolt.Tx) error {
+		user := tx.Bucket(bucketUsers).Bucket([]byte(username))
+		if user == nil || user.Get(keyScanned) == nil {
+			return nil
+		}
+		ok = true
+
+		return user.Bucket(bucketFiles).ForEach(func(_, data []byte) error {
+			var rec Record
+			if err := json.Unmarshal(data, &rec); err != nil {
+				return err
+			}
+			files = append(files, rec.FileInfo())
+			return nil
+		})
+	})
+	return files, ok, err
+}
+
+// Inspected reports whether a reconcile has read all of the user's files it
+// found, so that digests of files on the backend can be looked up.
+func (ix *Index) Inspected(username string) (bool, error) {
+	var ok bool
+	err := ix.db.View(func(tx *bolt.Tx) error {
+		user := tx.Bucket(bucketUsers).Bucket([]byte(username))
+		ok = user != nil && user.Get(keyInspected) != nil
+		return nil
+	})
+	return ok, err
+}
+
+func (ix *Index) Put(username string, rec *Record) error {
+	return ix.db.Update(func(tx *bolt.Tx) error {
+		return putRecord(tx, username, rec)
+	})
+}
+
+func (ix *Index) Delete(username, filename string) error {
+	return ix.db.Update(func(tx *bolt.Tx) error {
+		return deleteRecord(tx, username, filename)
+	})
+}
+
+// LookupHashes returns, for each of the given digests the user already has,
+// the name of a file with that content.
+func (ix *Index) LookupHashes(username string, sums []string) (map[string]string, error) {
+	found := make(map[string]string)
+	err := ix.db.View(func(tx *bolt.Tx) error {
+		hashes := userBucket(tx, username, bucketHashes)
+		if hashes == nil {
+			return nil
+		}
+
+		c := hashes.Cursor()
+		for _, sum := range sums {
+			prefix := []byte(sum + "/")
+			if k, _ := c.Seek(prefix); k != nil && strings.HasPrefix(string(k), string(prefix)) {
+				found[sum] = string(k[len(prefix):])
+			}
+		}
+		return nil
+	})
+	return found, err
+}
+
+func putRecord(tx *bolt.Tx, username string, rec *Record) error {
+	user, err := tx.Bucket(bucketUsers).CreateBucketIfNotExists([]byte(username))
+	if err != nil {
+		return err
+	}
+	files, err := user.CreateBucketIfNotExists(bucketFiles)
+	if err != nil {
+		return err
+	}
+	hashes, err := user.CreateBucketIfNotExists(bucketHashes)
+	if err != nil {
+		return err
+	}
+
+	if err := dropHash(files, hashes, rec.Name); err != nil {
+		return err
+	}
+
+	rec.Indexed = time.Now()
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if err := files.Put([]byte(rec.Name), data); err != nil {
+		return err
+	}
+
+	if rec.SHA256 != "" {
+		return hashes.Put([]byte(rec.SHA256+"/"+rec.Name), nil)
+	}
+	return nil
+}
+
+func deleteRecord(tx *bolt.Tx, username, filename string) error {
+	files := userBucket(tx, username, bucketFiles)
+	hashes := userBucket(tx, username, bucketHashes)
+	if files == nil || hashes == nil {
+		return nil
+	}
+
+	if err := dropHash(files, hashes, filename); err != nil {
+		return err
+	}
+	return files.Delete([]byte(filename))
+}
+
+// dropHash removes the hash entry of the record currently stored for name.
+func dropHash(files, hashes *bolt.Bucket, filename string) error {
+	data := files.Get([]byte(filename))
+	if data == nil {
+		return nil
+	}
+
+	var old Record
+	if err := json.Unmarshal(data, &old); err != nil || old.SHA256 == "" {
+		return nil
+	}
+	return hashes.Delete([]byte(old.SHA256 + "/" + filename))
+}
+
+func userBucket(tx *bolt.Tx, username string, name []byte) *bolt.Bucket {
+	user := tx.Bucket(bucketUsers).Bucket([]byte(username))
+	if user == nil {
+		return nil
+	}
+	return user.Bucket(name)
+}
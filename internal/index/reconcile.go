@@ -0,0 +1,271 @@
+package index
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"photosync-backend/internal/media"
+	"photosync-backend/internal/models"
+	"photosync-backend/internal/storage"
+)
+
+// Digest computes the SHA-256 and content type of a file as it streams past,
+// so uploads can be indexed without reading them back in full.
+type Digest struct {
+	hash   hash.Hash
+	header []byte
+}
+
+func NewDigest() *Digest {
+	return &Digest{
+		hash:   sha256.New(),
+		header: make([]byte, 0, media.SniffLength),
+	}
+}
+
+func (d *Digest) Write(p []byte) (int, error) {
+	if n := media.SniffLength - len(d.header); n > 0 {
+		d.header = append(d.header, p[:min(n, len(p))]...)
+	}
+	return d.hash.Write(p)
+}
+
+// Record returns the index record for the file the digest has seen.
+func (d *Digest) Record(info *models.FileInfo) *Record {
+	return &Record{
+		Name:        info.Name,
+		Size:        info.Size,
+		ModTime:     info.ModTime,
+		ContentType: media.DetectContentType(d.header, info.Name),
+		SHA256:      hex.EncodeToString(d.hash.Sum(nil)),
+		Version:     recordVersion,
+	}
+}
+
+// ReadMetadata extracts the capture metadata of a stored file. Only the
+// parts of the file holding metadata are downloaded.
+func ReadMetadata(backend storage.StorageBackend, conn storage.Connection, username string, info *models.FileInfo, contentType string) (*models.PhotoMetadata, error) {
+	r := storage.NewRangeReader(backend, conn, username, info)
+	defer r.Close()
+
+	return media.ExtractMetadata(r, contentType)
+}
+
+// Reconcile brings the user's records in line with the backend: records of
+// files that are gone are removed, new and changed files are added right away
+// and then read once to fill in their hash, content type and metadata. Only
+// one reconcile per user runs at a time. The listing is passed on to the
+// OnListing functions, and the user counts as Inspected once all files have
+// been read, until a file fails to be read.
+func (ix *Index) Reconcile(backend storage.StorageBackend, conn storage.Connection, username string) error {
+	ix.mu.Lock()
+	if ix.scanning[username] {
+		ix.mu.Unlock()
+		return nil
+	}
+	ix.scanning[username] = true
+	ix.mu.Unlock()
+
+	defer func() {
+		ix.mu.Lock()
+		delete(ix.scanning, username)
+		ix.mu.Unlock()
+	}()
+
+	pending, err := ix.refresh(backend, conn, username)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, file := range pending {
+		rec, err := inspect(backend, conn, username, file)
+		if err != nil {
+			log.Printf("Index: Failed to read %s for user %s: %v", file.Name, username, err)
+			failed++
+			continue
+		}
+
+		if err := ix.fill(username, file, rec); err != nil {
+			return fmt.Errorf("failed to update index: %w", err)
+		}
+	}
+
+	// A file that could not be read has no digest to look up, so the user
+	// is not inspected until a later reconcile reads it.
+	err = ix.db.Update(func(tx *bolt.Tx) error {
+		user := tx.Bucket(bucketUsers).Bucket([]byte(username))
+		if failed > 0 {
+			return user.Delete(keyInspected)
+		}
+		return user.Put(keyInspected, []byte(time.Now().Format(time.RFC3339)))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update index: %w", err)
+	}
+	return nil
+}
+
+func (ix *Index) refresh(backend storage.StorageBackend, conn storage.Connection, username string) ([]models.FileInfo, error) {
+	started := time.Now()
+	listing, err := backend.List(conn, username)
+	if err != nil {
+		// Records are kept as they are: a failed listing says nothing about
+		// which files are gone.
+		for _, fn := range ix.listed {
+			fn(username, nil, started, err)
+		}
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+
+	pending, err := ix.apply(username, listing, started)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update index: %w", err)
+	}
+
+	for _, fn := range ix.listed {
+		fn(username, listing, started, nil)
+	}
+	return pending, nil
+}
+
+// Watch reconciles every user with a pooled connection each interval.
+func (ix *Index) Watch(pool *storage.GenericConnectionPool, backend storage.StorageBackend, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for _, username := range pool.Active() {
+			conn, release, ok := pool.Lease(username)
+			if !ok {
+				continue
+			}
+			if err := ix.Reconcile(backend, conn, username); err != nil {
+				log.Printf("Index: Reconcile for user %s failed: %v", username, err)
+			}
+			release()
+		}
+	}
+}
+
+// apply diffs the listing against the user's records and returns the files
+// that still need to be read. Records written through the API after the
+// listing started are newer than the listing and left alone.
+func (ix *Index) apply(username string, listing []models.FileInfo, started time.Time) ([]models.FileInfo, error) {
+	var pending []models.FileInfo
+
+	err := ix.db.Update(func(tx *bolt.Tx) error {
+		user, err := tx.Bucket(bucketUsers).CreateBucketIfNotExists([]byte(username))
+		if err != nil {
+			return err
+		}
+		files, err := user.CreateBucketIfNotExists(bucketFiles)
+		if err != nil {
+			return err
+		}
+
+		known := make(map[string]Record)
+		err = files.ForEach(func(name, data []byte) error {
+			var rec Record
+			if err := json.Unmarshal(data, &rec); err != nil {
+				return err
+			}
+			known[string(name)] = rec
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, file := range listing {
+			rec, ok := known[file.Name]
+			delete(known, file.Name)
+
+			if ok && rec.Indexed.After(started) {
+				continue
+			}
+			if ok && rec.Current(file) {
+				if !rec.Complete() {
+					pending = append(pending, file)
+				}
+				continue
+			}
+
+			err := putRecord(tx, username, &Record{
+				Name:        file.Name,
+				Size:        file.Size,
+				ModTime:     file.ModTime,
+				ContentType: media.ContentTypeByExtension(file.Name),
+			})
+			if err != nil {
+				return err
+			}
+			pending = append(pending, file)
+		}
+
+		for name, rec := range known {
+			if rec.Indexed.After(started) {
+				continue
+			}
+			if err := deleteRecord(tx, username, name); err != nil {
+				return err
+			}
+		}
+
+		return user.Put(keyScanned, []byte(time.Now().Format(time.RFC3339)))
+	})
+
+	return pending, err
+}
+
+// fill stores rec unless the file changed again while it was being read or
+// has been inspected in full meanwhile.
+func (ix *Index) fill(username string, file models.FileInfo, rec *Record) error {
+	return ix.db.Update(func(tx *bolt.Tx) error {
+		files := userBucket(tx, username, bucketFiles)
+		if files == nil {
+			return nil
+		}
+
+		data := files.Get([]byte(file.Name))
+		if data == nil {
+			return nil
+		}
+
+		var existing Record
+		if err := json.Unmarshal(data, &existing); err != nil {
+			return err
+		}
+		if !existing.Current(file) || existing.Complete() {
+			return nil
+		}
+
+		return putRecord(tx, username, rec)
+	})
+}
+
+func inspect(backend storage.StorageBackend, conn storage.Connection, username string, file models.FileInfo) (*Record, error) {
+	body, _, err := backend.Download(conn, username, file.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	digest := NewDigest()
+	if _, err := io.Copy(digest, body); err != nil {
+		return nil, err
+	}
+
+	rec := digest.Record(&file)
+	if rec.Metadata, err = ReadMetadata(backend, conn, username, &file, rec.ContentType); err != nil {
+		log.Printf("Index: Failed to read metadata of %s for user %s: %v", file.Name, username, err)
+	}
+	return rec, nil
+}
@@ -0,0 +1,257 @@
+package index
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"photosync-backend/internal/config"
+	"photosync-backend/internal/models"
+	"photosync-backend/internal/storage"
+)
+
+// flakyBackend fails List while failing is set, like a share that dropped
+// the connection.
+type flakyBackend struct {
+	storage.StorageBackend
+	failing bool
+}
+
+var errShare = errors.New("share unavailable")
+
+func (b *flakyBackend) List(conn storage.Connection, username string) ([]models.FileInfo, error) {
+	if b.failing {
+		return nil, errShare
+	}
+	return b.StorageBackend.List(conn, username)
+}
+
+// countingBackend counts the downloads of whole files.
+type countingBackend struct {
+	storage.StorageBackend
+	downloads int
+}
+
+func (b *countingBackend) Download(conn storage.Connection, username, filename string) (io.ReadCloser, *models.FileInfo, error) {
+	b.downloads++
+	return b.StorageBackend.Download(conn, username, filename)
+}
+
+// brokenBackend fails to download the file named broken, like a file the
+// share's user has no permission to read.
+type brokenBackend struct {
+	storage.StorageBackend
+	broken string
+}
+
+func (b *brokenBackend) Download(conn storage.Connection, username, filename string) (io.ReadCloser, *models.FileInfo, error) {
+	if filename == b.broken {
+		return nil, nil, os.ErrPermission
+	}
+	return b.StorageBackend.Download(conn, username, filename)
+}
+
+type listingCall struct {
+	names []string
+	err   error
+}
+
+func indexedNames(t *testing.T, ix *Index) []string {
+	t.Helper()
+
+	files, ok, err := ix.List("alice")
+	if err != nil || !ok {
+		t.Fatalf("List = %v, %v", ok, err)
+	}
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestReconcile(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "photos")
+	for _, name := range []string{"a.jpg", "2024/b.jpg"} {
+		path := filepath.Join(base, "alice", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backend := &flakyBackend{StorageBackend: storage.NewLocalBackend(&config.LocalConfig{BasePath: base})}
+	conn, err := backend.Connect("alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ix, err := Open(filepath.Join(dir, "index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+
+	var calls []listingCall
+	ix.OnListing(func(username string, listing []models.FileInfo, started time.Time, err error) {
+		call := listingCall{err: err}
+		for _, file := range listing {
+			call.names = append(call.names, file.Name)
+		}
+		slices.Sort(call.names)
+		calls = append(calls, call)
+	})
+
+	tests := []struct {
+		name      string
+		failing   bool
+		remove    string
+		want      []string
+		wantErr   bool
+		wantCall  listingCall
+		wantFills bool
+	}{
+		{name: "first scan", want: []string{"2024/b.jpg", "a.jpg"}, wantCall: listingCall{names: []string{"2024/b.jpg", "a.jpg"}}, wantFills: true},
+		{name: "failed listing", failing: true, remove: "a.jpg", want: []string{"2024/b.jpg", "a.jpg"}, wantErr: true, wantCall: listingCall{err: errShare}},
+		{name: "recovered", want: []string{"2024/b.jpg"}, wantCall: listingCall{names: []string{"2024/b.jpg"}}},
+	}
+
+	for _, tt := range tests {
+		if tt.remove != "" {
+			if err := os.Remove(filepath.Join(base, "alice", tt.remove)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		backend.failing = tt.failing
+		calls = nil
+
+		err := ix.Reconcile(backend, conn, "alice")
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%s: Reconcile error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if got := indexedNames(t, ix); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: indexed %v, want %v", tt.name, got, tt.want)
+		}
+		if len(calls) != 1 || !slices.Equal(calls[0].names, tt.wantCall.names) || !errors.Is(calls[0].err, tt.wantCall.err) {
+			t.Errorf("%s: listeners got %+v, want one call with %+v", tt.name, calls, tt.wantCall)
+		}
+
+		if tt.wantFills {
+			rec, err := ix.Get("alice", "a.jpg")
+			if err != nil || !rec.Complete() {
+				t.Errorf("%s: record of a.jpg = %+v, %v, want it read", tt.name, rec, err)
+			}
+		}
+	}
+}
+
+func TestReconcileOldRecord(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "photos")
+	if err := os.MkdirAll(filepath.Join(base, "alice"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(base, "alice", "a.jpg"), []byte("a.jpg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	backend := &countingBackend{StorageBackend: storage.NewLocalBackend(&config.LocalConfig{BasePath: base})}
+	conn, err := backend.Connect("alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := backend.Stat(conn, "alice", "a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ix, err := Open(filepath.Join(dir, "index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+
+	// A record hashed before the index learned to read metadata.
+	err = ix.Put("alice", &Record{Name: "a.jpg", Size: info.Size, ModTime: info.ModTime, ContentType: "image/jpeg", SHA256: "old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, wantDownloads := range []int{1, 0} {
+		backend.downloads = 0
+		if err := ix.Reconcile(backend, conn, "alice"); err != nil {
+			t.Fatalf("reconcile %d: %v", i+1, err)
+		}
+		if backend.downloads != wantDownloads {
+			t.Errorf("reconcile %d: %d downloads, want %d", i+1, backend.downloads, wantDownloads)
+		}
+
+		rec, err := ix.Get("alice", "a.jpg")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !rec.Complete() || rec.Version != recordVersion || rec.SHA256 == "old" {
+			t.Errorf("reconcile %d: record = %+v, want it read again", i+1, rec)
+		}
+	}
+}
+
+func TestReconcileInspected(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "photos")
+	if err := os.MkdirAll(filepath.Join(base, "alice"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.jpg", "b.jpg"} {
+		if err := os.WriteFile(filepath.Join(base, "alice", name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backend := &brokenBackend{StorageBackend: storage.NewLocalBackend(&config.LocalConfig{BasePath: base})}
+	conn, err := backend.Connect("alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ix, err := Open(filepath.Join(dir, "index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+
+	tests := []struct {
+		name   string
+		broken string
+		add    string
+		want   bool
+	}{
+		{name: "unreadable file", broken: "b.jpg", want: false},
+		{name: "read on retry", want: true},
+		{name: "unreadable new file", broken: "c.jpg", add: "c.jpg", want: false},
+	}
+
+	for _, tt := range tests {
+		if tt.add != "" {
+			if err := os.WriteFile(filepath.Join(base, "alice", tt.add), []byte(tt.add), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		backend.broken = tt.broken
+
+		if err := ix.Reconcile(backend, conn, "alice"); err != nil {
+			t.Fatalf("%s: Reconcile = %v", tt.name, err)
+		}
+		if got, err := ix.Inspected("alice"); err != nil || got != tt.want {
+			t.Errorf("%s: Inspected = %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+}
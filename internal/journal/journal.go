@@ -0,0 +1,478 @@
+package journal
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"photosync-backend/internal/models"
+)
+
+const (
+	OpUpsert = "upsert"
+	OpDelete = "delete"
+)
+
+var ErrInvalidCursor = errors.New("invalid sync cursor")
+
+var (
+	bucketUsers   = []byte("users")
+	bucketFiles   = []byte("files")
+	bucketChanges = []byte("changes")
+	keyState      = []byte("state")
+)
+
+type Change struct {
+	Seq     uint64    `json:"seq"`
+	Op      string    `json:"op"`
+	Name    string    `json:"name"`
+	Size    int64     `json:"size,omitempty"`
+	ModTime time.Time `json:"mod_time,omitzero"`
+	// Recorded is when the change was written, so that a listing started
+	// before it does not undo it.
+	Recorded time.Time `json:"recorded"`
+}
+
+// Result is one page of changes. When Reset is set the cursor was unknown or
+// too old and Changes lists files instead; the client must drop its local
+// state and rebuild it from them and the pages that follow while More is set.
+type Result struct {
+	Changes []Change
+	Cursor  string
+	Reset   bool
+	More    bool
+}
+
+type fileState struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// userState is the persisted position of one user's journal. Seq numbers the
+// changes; changes with Seq <= Floor have been trimmed. A new Epoch
+// invalidates all cursors handed out before, e.g. when the journal was lost.
+type userState struct {
+	Epoch   string    `json:"epoch"`
+	Seq     uint64    `json:"seq"`
+	Floor   uint64    `json:"floor"`
+	Scanned time.Time `json:"scanned"`
+}
+
+// Journal records per-user file changes so clients can sync incrementally.
+// It is fed by the API on uploads and deletes, and by the listings of the
+// index reconciler, which pick up changes made directly on the share. Each
+// user has a bucket holding its state, the last known files keyed by name and
+// the changes keyed by sequence number.
+type Journal struct {
+	db         *bolt.DB
+	maxChanges int
+}
+
+func New(dir string, maxChanges int) (*Journal, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create journal directory: %w", err)
+	}
+
+	db, err := bolt.Open(filepath.Join(dir, "journal.db"), 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketUsers)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialise journal: %w", err)
+	}
+
+	return &Journal{db: db, maxChanges: maxChanges}, nil
+}
+
+func (j *Journal) Close() error {
+	return j.db.Close()
+}
+
+// Upserted records a file written through the API.
+func (j *Journal) Upserted(username string, info *models.FileInfo) error {
+	return j.record(username, Change{Op: OpUpsert, Name: info.Name, Size: info.Size, ModTime: info.ModTime})
+}
+
+// Deleted records a file deleted through the API.
+func (j *Journal) Deleted(username, filename string) error {
+	return j.record(username, Change{Op: OpDelete, Name: filename})
+}
+
+func (j *Journal) record(username string, change Change) error {
+	return j.db.Update(func(tx *bolt.Tx) error {
+		ul, err := j.user(tx, username)
+		if err != nil {
+			return err
+		}
+
+		// Until the first scan there is no baseline to diff against; that
+		// scan will pick the file up.
+		if ul.state.Scanned.IsZero() {
+			return nil
+		}
+
+		if err := ul.append(change); err != nil {
+			return err
+		}
+		return ul.save(j.maxChanges)
+	})
+}
+
+// Apply records every difference between a complete listing of the user's
+// files and the last known state, so it must never be given the result of a
+// failed listing. Files changed through the API after the listing started are
+// left as recorded. The first listing of a user only establishes the
+// baseline.
+func (j *Journal) Apply(username string, listing []models.FileInfo, started time.Time) error {
+	return j.db.Update(func(tx *bolt.Tx) error {
+		ul, err := j.user(tx, username)
+		if err != nil {
+			return err
+		}
+
+		if ul.state.Scanned.IsZero() {
+			for _, file := range listing {
+				if err := ul.putFile(file.Name, fileState{Size: file.Size, ModTime: file.ModTime}); err != nil {
+					return err
+				}
+			}
+			ul.state.Scanned = time.Now()
+			return ul.save(j.maxChanges)
+		}
+
+		known := make(map[string]fileState)
+		err = ul.files.ForEach(func(name, data []byte) error {
+			var state fileState
+			if err := json.Unmarshal(data, &state); err != nil {
+				return err
+			}
+			known[string(name)] = state
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		changed := make(map[string]bool)
+		c := ul.changes.Cursor()
+		for k, data := c.Last(); k != nil; k, data = c.Prev() {
+			var change Change
+			if err := json.Unmarshal(data, &change); err != nil {
+				return err
+			}
+			if !change.Recorded.After(started) {
+				break
+			}
+			changed[change.Name] = true
+		}
+
+		for _, file := range listing {
+			state, ok := known[file.Name]
+			delete(known, file.Name)
+			if changed[file.Name] {
+				continue
+			}
+			if !ok || state.Size != file.Size || !state.ModTime.Equal(file.ModTime) {
+				if err := ul.append(Change{Op: OpUpsert, Name: file.Name, Size: file.Size, ModTime: file.ModTime}); err != nil {
+					return err
+				}
+			}
+		}
+
+		for name := range known {
+			if changed[name] {
+				continue
+			}
+			if err := ul.append(Change{Op: OpDelete, Name: name}); err != nil {
+				return err
+			}
+		}
+
+		ul.state.Scanned = time.Now()
+		return ul.save(j.maxChanges)
+	})
+}
+
+// LastScan returns when the user's files were last rescanned.
+func (j *Journal) LastScan(username string) (time.Time, error) {
+	var scanned time.Time
+	err := j.db.View(func(tx *bolt.Tx) error {
+		ul, err := readUser(tx, username)
+		if ul != nil {
+			scanned = ul.state.Scanned
+		}
+		return err
+	})
+	return scanned, err
+}
+
+// Changes returns up to limit changes after cursor, keeping only the latest
+// change per file. An empty, stale or foreign cursor yields a reset, which is
+// itself paged by name.
+func (j *Journal) Changes(username, cursor string, limit int) (*Result, error) {
+	epoch, since, after, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *Result
+	err = j.db.View(func(tx *bolt.Tx) error {
+		ul, err := readUser(tx, username)
+		if err != nil || ul == nil {
+			result = &Result{Changes: []Change{}, Cursor: encodeCursor("", 0, ""), Reset: true}
+			return err
+		}
+
+		state := ul.state
+		switch {
+		case cursor == "" || epoch != state.Epoch || since < state.Floor || since > state.Seq:
+			result, err = ul.snapshot(state.Seq, "", limit)
+			if err == nil {
+				result.Reset = true
+			}
+		case after != "":
+			result, err = ul.snapshot(since, after, limit)
+		default:
+			result, err = ul.since(since, limit)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// userLog is one user's journal within a transaction.
+type userLog struct {
+	bucket  *bolt.Bucket
+	files   *bolt.Bucket
+	changes *bolt.Bucket
+	state   userState
+}
+
+// user opens the user's buckets, creating them and a new epoch if needed.
+func (j *Journal) user(tx *bolt.Tx, username string) (*userLog, error) {
+	bucket, err := tx.Bucket(bucketUsers).CreateBucketIfNotExists([]byte(username))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := bucket.CreateBucketIfNotExists(bucketFiles); err != nil {
+		return nil, err
+	}
+	if _, err := bucket.CreateBucketIfNotExists(bucketChanges); err != nil {
+		return nil, err
+	}
+
+	ul, err := readUser(tx, username)
+	if err != nil {
+		return nil, err
+	}
+	if ul.state.Epoch == "" {
+		if ul.state.Epoch, err = newEpoch(); err != nil {
+			return nil, err
+		}
+	}
+	return ul, nil
+}
+
+// readUser returns the user's journal, or nil if nothing was recorded yet.
+func readUser(tx *bolt.Tx, username string) (*userLog, error) {
+	bucket := tx.Bucket(bucketUsers).Bucket([]byte(username))
+	if bucket == nil {
+		return nil, nil
+	}
+
+	ul := &userLog{
+		bucket:  bucket,
+		files:   bucket.Bucket(bucketFiles),
+		changes: bucket.Bucket(bucketChanges),
+	}
+	if data := bucket.Get(keyState); data != nil {
+		if err := json.Unmarshal(data, &ul.state); err != nil {
+			return nil, fmt.Errorf("failed to read journal state: %w", err)
+		}
+	}
+	return ul, nil
+}
+
+// append adds the change under the next sequence number and updates the
+// known state of the file.
+func (ul *userLog) append(change Change) error {
+	ul.state.Seq++
+	change.Seq = ul.state.Seq
+	change.Recorded = time.Now()
+
+	data, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	if err := ul.changes.Put(seqKey(change.Seq), data); err != nil {
+		return err
+	}
+
+	if change.Op == OpDelete {
+		return ul.files.Delete([]byte(change.Name))
+	}
+	return ul.putFile(change.Name, fileState{Size: change.Size, ModTime: change.ModTime})
+}
+
+func (ul *userLog) putFile(name string, state fileState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return ul.files.Put([]byte(name), data)
+}
+
+// save trims the changes to the newest maxChanges and stores the state.
+func (ul *userLog) save(maxChanges int) error {
+	if ul.state.Seq-ul.state.Floor > uint64(maxChanges) {
+		floor := ul.state.Seq - uint64(maxChanges)
+		c := ul.changes.Cursor()
+		for k, _ := c.First(); k != nil && binary.BigEndian.Uint64(k) <= floor; k, _ = c.Next() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		ul.state.Floor = floor
+	}
+
+	data, err := json.Marshal(ul.state)
+	if err != nil {
+		return err
+	}
+	return ul.bucket.Put(keyState, data)
+}
+
+// since returns up to limit changes with a sequence number above seq.
+func (ul *userLog) since(seq uint64, limit int) (*Result, error) {
+	result := &Result{Changes: []Change{}}
+
+	var pending []Change
+	next := seq
+	c := ul.changes.Cursor()
+	for k, data := c.Seek(seqKey(seq + 1)); k != nil; k, data = c.Next() {
+		if limit > 0 && len(pending) == limit {
+			result.More = true
+			break
+		}
+
+		var change Change
+		if err := json.Unmarshal(data, &change); err != nil {
+			return nil, err
+		}
+		pending = append(pending, change)
+		next = change.Seq
+	}
+
+	seen := make(map[string]bool, len(pending))
+	for i := len(pending) - 1; i >= 0; i-- {
+		if !seen[pending[i].Name] {
+			seen[pending[i].Name] = true
+			result.Changes = append(result.Changes, pending[i])
+		}
+	}
+	slices.Reverse(result.Changes)
+
+	result.Cursor = encodeCursor(ul.state.Epoch, next, "")
+	return result, nil
+}
+
+// snapshot returns up to limit known files named after after. While more
+// follow, the cursor continues the snapshot; the last page hands out a cursor
+// at seq, so changes made while the client paged are replayed afterwards.
+func (ul *userLog) snapshot(seq uint64, after string, limit int) (*Result, error) {
+	result := &Result{Changes: []Change{}}
+
+	c := ul.files.Cursor()
+	k, data := c.First()
+	if after != "" {
+		k, data = c.Seek([]byte(after))
+		if k != nil && string(k) == after {
+			k, data = c.Next()
+		}
+	}
+
+	for ; k != nil; k, data = c.Next() {
+		if limit > 0 && len(result.Changes) == limit {
+			result.More = true
+			break
+		}
+
+		var state fileState
+		if err := json.Unmarshal(data, &state); err != nil {
+			return nil, err
+		}
+		result.Changes = append(result.Changes, Change{Op: OpUpsert, Name: string(k), Size: state.Size, ModTime: state.ModTime})
+	}
+
+	result.Cursor = encodeCursor(ul.state.Epoch, seq, "")
+	if result.More {
+		result.Cursor = encodeCursor(ul.state.Epoch, seq, result.Changes[len(result.Changes)-1].Name)
+	}
+	return result, nil
+}
+
+func seqKey(seq uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, seq)
+}
+
+// encodeCursor encodes a position in the journal. A non-empty after marks a
+// snapshot that continues with the files named after it.
+func encodeCursor(epoch string, seq uint64, after string) string {
+	cursor := epoch + ":" + strconv.FormatUint(seq, 10)
+	if after != "" {
+		cursor += ":" + after
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(cursor))
+}
+
+func decodeCursor(cursor string) (string, uint64, string, error) {
+	if cursor == "" {
+		return "", 0, "", nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", 0, "", ErrInvalidCursor
+	}
+
+	epoch, rest, ok := strings.Cut(string(data), ":")
+	if !ok {
+		return "", 0, "", ErrInvalidCursor
+	}
+	seq, after, _ := strings.Cut(rest, ":")
+
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return "", 0, "", ErrInvalidCursor
+	}
+
+	return epoch, n, after, nil
+}
+
+func newEpoch() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate journal epoch: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,303 @@
+package journal
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"photosync-backend/internal/models"
+)
+
+func newTestJournal(t *testing.T, maxChanges int, files ...string) *Journal {
+	t.Helper()
+
+	j, err := New(t.TempDir(), maxChanges)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { j.Close() })
+
+	if err := j.Apply("alice", listing(files...), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+func listing(names ...string) []models.FileInfo {
+	files := make([]models.FileInfo, len(names))
+	for i, name := range names {
+		files[i] = models.FileInfo{Name: name, Size: 1, ModTime: time.Unix(1700000000, 0)}
+	}
+	return files
+}
+
+func upsert(t *testing.T, j *Journal, name string, size int64) {
+	t.Helper()
+	if err := j.Upserted("alice", &models.FileInfo{Name: name, Size: size, ModTime: time.Unix(1700000000, 0)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func changed(changes []Change) []string {
+	out := make([]string, len(changes))
+	for i, change := range changes {
+		out[i] = change.Op + " " + change.Name
+	}
+	return out
+}
+
+func TestChanges(t *testing.T) {
+	j := newTestJournal(t, 4, "a.jpg", "b.jpg")
+
+	start, err := j.Changes("alice", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upsert(t, j, "c.jpg", 1)
+	upsert(t, j, "a.jpg", 2)
+	if err := j.Deleted("alice", "c.jpg"); err != nil {
+		t.Fatal(err)
+	}
+
+	other := newTestJournal(t, 4, "a.jpg")
+	foreign, err := other.Changes("alice", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		cursor    string
+		limit     int
+		want      []string
+		wantReset bool
+		wantMore  bool
+	}{
+		{name: "no cursor", want: []string{"upsert a.jpg", "upsert b.jpg"}, wantReset: true},
+		{name: "since start", cursor: start.Cursor, want: []string{"upsert a.jpg", "delete c.jpg"}},
+		{name: "since start limited", cursor: start.Cursor, limit: 2, want: []string{"upsert c.jpg", "upsert a.jpg"}, wantMore: true},
+		{name: "since latest", cursor: encodeCursor(epochOf(t, start.Cursor), 3, ""), want: []string{}},
+		{name: "foreign epoch", cursor: foreign.Cursor, want: []string{"upsert a.jpg", "upsert b.jpg"}, wantReset: true},
+		{name: "ahead of journal", cursor: encodeCursor(epochOf(t, start.Cursor), 9, ""), want: []string{"upsert a.jpg", "upsert b.jpg"}, wantReset: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := j.Changes("alice", tt.cursor, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := changed(result.Changes); !slices.Equal(got, tt.want) {
+				t.Errorf("Changes = %v, want %v", got, tt.want)
+			}
+			if result.Reset != tt.wantReset {
+				t.Errorf("Reset = %v, want %v", result.Reset, tt.wantReset)
+			}
+			if result.More != tt.wantMore {
+				t.Errorf("More = %v, want %v", result.More, tt.wantMore)
+			}
+		})
+	}
+}
+
+func TestChangesStaleCursor(t *testing.T) {
+	j := newTestJournal(t, 2, "a.jpg")
+
+	start, err := j.Changes("alice", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upsert(t, j, "b.jpg", 1)
+	within, err := j.Changes("alice", start.Cursor, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if within.Reset {
+		t.Fatal("cursor within max_changes was reset")
+	}
+
+	upsert(t, j, "c.jpg", 1)
+	upsert(t, j, "d.jpg", 1)
+
+	stale, err := j.Changes("alice", start.Cursor, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stale.Reset {
+		t.Errorf("trimmed cursor was not reset")
+	}
+	want := []string{"upsert a.jpg", "upsert b.jpg", "upsert c.jpg", "upsert d.jpg"}
+	if got := changed(stale.Changes); !slices.Equal(got, want) {
+		t.Errorf("reset = %v, want %v", got, want)
+	}
+
+	kept, err := j.Changes("alice", within.Cursor, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kept.Reset {
+		t.Errorf("cursor at the floor was reset")
+	}
+}
+
+func TestChangesResetPages(t *testing.T) {
+	j := newTestJournal(t, 100, "a.jpg", "b.jpg", "c.jpg", "d.jpg", "e.jpg")
+
+	first, err := j.Changes("alice", "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first.Reset || !first.More {
+		t.Fatalf("first page Reset = %v, More = %v", first.Reset, first.More)
+	}
+
+	// Changes while the client pages through the reset are replayed after it.
+	upsert(t, j, "a.jpg", 2)
+	upsert(t, j, "bb.jpg", 1)
+	if err := j.Deleted("alice", "e.jpg"); err != nil {
+		t.Fatal(err)
+	}
+
+	got := changed(first.Changes)
+	cursor := first.Cursor
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("reset did not end")
+		}
+		page, err := j.Changes("alice", cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.Reset {
+			t.Fatal("continuation page was reset")
+		}
+		got = append(got, changed(page.Changes)...)
+		cursor = page.Cursor
+		if !page.More {
+			break
+		}
+	}
+
+	replay, err := j.Changes("alice", cursor, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, changed(replay.Changes)...)
+
+	want := []string{
+		"upsert a.jpg", "upsert b.jpg",
+		"upsert bb.jpg", "upsert c.jpg", "upsert d.jpg",
+		"upsert a.jpg", "upsert bb.jpg", "delete e.jpg",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("pages = %v, want %v", got, want)
+	}
+}
+
+func TestApply(t *testing.T) {
+	j := newTestJournal(t, 100, "a.jpg", "b.jpg", "c.jpg")
+
+	start, err := j.Changes("alice", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := time.Now()
+	upsert(t, j, "new.jpg", 1)
+	if err := j.Deleted("alice", "c.jpg"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The listing started before the API changes above, so it shows c.jpg
+	// and misses new.jpg; neither may be undone.
+	files := listing("b.jpg", "c.jpg", "d.jpg")
+	files[0].Size = 5
+	if err := j.Apply("alice", files, started); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := j.Changes("alice", start.Cursor, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := changed(result.Changes)
+	slices.Sort(got)
+	want := []string{"delete a.jpg", "delete c.jpg", "upsert b.jpg", "upsert d.jpg", "upsert new.jpg"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Changes = %v, want %v", got, want)
+	}
+}
+
+func TestRecordBeforeFirstScan(t *testing.T) {
+	j, err := New(t.TempDir(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	upsert(t, j, "a.jpg", 1)
+
+	scanned, err := j.LastScan("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !scanned.IsZero() {
+		t.Errorf("LastScan = %v before the first scan", scanned)
+	}
+
+	result, err := j.Changes("alice", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Reset || len(result.Changes) != 0 {
+		t.Errorf("Changes = %+v, want an empty reset", result)
+	}
+}
+
+func TestDecodeCursor(t *testing.T) {
+	tests := []struct {
+		name      string
+		cursor    string
+		wantEpoch string
+		wantSeq   uint64
+		wantAfter string
+		wantErr   bool
+	}{
+		{name: "empty"},
+		{name: "position", cursor: encodeCursor("abc", 42, ""), wantEpoch: "abc", wantSeq: 42},
+		{name: "snapshot", cursor: encodeCursor("abc", 7, "2024/a:b.jpg"), wantEpoch: "abc", wantSeq: 7, wantAfter: "2024/a:b.jpg"},
+		{name: "not base64", cursor: "???", wantErr: true},
+		{name: "no separator", cursor: "YWJj", wantErr: true},
+		{name: "bad sequence", cursor: "YWJjOng", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			epoch, seq, after, err := decodeCursor(tt.cursor)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidCursor) {
+					t.Fatalf("decodeCursor(%q) error = %v, want ErrInvalidCursor", tt.cursor, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if epoch != tt.wantEpoch || seq != tt.wantSeq || after != tt.wantAfter {
+				t.Errorf("decodeCursor(%q) = %q, %d, %q", tt.cursor, epoch, seq, after)
+			}
+		})
+	}
+}
+
+func epochOf(t *testing.T, cursor string) string {
+	t.Helper()
+	epoch, _, _, err := decodeCursor(cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return epoch
+}
@@ -0,0 +1,173 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+)
+
+// SniffLength is the number of leading bytes DetectContentType looks at.
+const SniffLength = 512
+
+var extensionTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".heic": "image/heic",
+	".heif": "image/heif",
+	".avif": "image/avif",
+	".tif":  "image/tiff",
+	".tiff": "image/tiff",
+	".bmp":  "image/bmp",
+	".dng":  "image/x-adobe-dng",
+	".cr2":  "image/x-canon-cr2",
+	".nef":  "image/x-nikon-nef",
+	".arw":  "image/x-sony-arw",
+	".mp4":  "video/mp4",
+	".m4v":  "video/x-m4v",
+	".mov":  "video/quicktime",
+	".3gp":  "video/3gpp",
+	".avi":  "video/x-msvideo",
+	".mkv":  "video/x-matroska",
+	".webm": "video/webm",
+}
+
+var isoBrands = map[string]string{
+	"heic": "image/heic",
+	"heix": "image/heic",
+	"heim": "image/heic",
+	"heis": "image/heic",
+	"hevc": "image/heic-sequence",
+	"hevx": "image/heic-sequence",
+	"mif1": "image/heif",
+	"msf1": "image/heif-sequence",
+	"avif": "image/avif",
+	"avis": "image/avif",
+	"qt  ": "video/quicktime",
+	"isom": "video/mp4",
+	"iso2": "video/mp4",
+	"iso4": "video/mp4",
+	"iso5": "video/mp4",
+	"iso6": "video/mp4",
+	"mp41": "video/mp4",
+	"mp42": "video/mp4",
+	"avc1": "video/mp4",
+	"dash": "video/mp4",
+	"M4V ": "video/x-m4v",
+	"3gp4": "video/3gpp",
+	"3gp5": "video/3gpp",
+	"3gp6": "video/3gpp",
+	"3g2a": "video/3gpp2",
+}
+
+// DetectContentType identifies common photo and video formats from their
+// leading bytes, falling back to the file extension and finally to the
+// generic net/http sniffer.
+func DetectContentType(header []byte, filename string) string {
+	if contentType := sniff(header, filename); contentType != "" {
+		return contentType
+	}
+
+	if contentType := ContentTypeByExtension(filename); contentType != "" {
+		return contentType
+	}
+
+	if len(header) == 0 {
+		return "application/octet-stream"
+	}
+
+	return http.DetectContentType(header)
+}
+
+func ContentTypeByExtension(filename string) string {
+	return extensionTypes[strings.ToLower(path.Ext(filename))]
+}
+
+// Kind reports whether a content type is an "image" or a "video".
+func Kind(contentType string) string {
+	switch {
+	case strings.HasPrefix(contentType, "image/"):
+		return "image"
+	case strings.HasPrefix(contentType, "video/"):
+		return "video"
+	}
+	return ""
+}
+
+func sniff(header []byte, filename string) string {
+	switch {
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8, 0xFF}):
+		return "image/jpeg"
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png"
+	case bytes.HasPrefix(header, []byte("GIF87a")), bytes.HasPrefix(header, []byte("GIF89a")):
+		return "image/gif"
+	case len(header) >= 12 && string(header[:4]) == "RIFF" && string(header[8:12]) == "WEBP":
+		return "image/webp"
+	case len(header) >= 12 && string(header[:4]) == "RIFF" && string(header[8:12]) == "AVI ":
+		return "video/x-msvideo"
+	case bytes.HasPrefix(header, []byte("II*\x00")), bytes.HasPrefix(header, []byte("MM\x00*")):
+		// Most camera raw formats are TIFF containers; trust a raw extension.
+		if contentType := ContentTypeByExtension(filename); strings.HasPrefix(contentType, "image/x-") {
+			return contentType
+		}
+		return "image/tiff"
+	case bytes.HasPrefix(header, []byte("BM")) && len(header) >= 14:
+		return "image/bmp"
+	case bytes.HasPrefix(header, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		if strings.EqualFold(path.Ext(filename), ".webm") {
+			return "video/webm"
+		}
+		return "video/x-matroska"
+	}
+
+	if len(header) >= 12 && string(header[4:8]) == "ftyp" {
+		return sniffISOBMFF(header)
+	}
+
+	if len(header) >= 8 {
+		switch string(header[4:8]) {
+		case "moov", "mdat", "wide", "free", "skip":
+			return "video/quicktime"
+		}
+	}
+
+	return ""
+}
+
+// sniffISOBMFF maps the major brand of an ISO base media file (MP4, MOV,
+// HEIF, ...) to a content type, checking the compatible brands when the major
+// brand is not recognised.
+func sniffISOBMFF(header []byte) string {
+	compatible := compatibleBrands(header)
+
+	if contentType, ok := isoBrands[string(header[8:12])]; ok {
+		if contentType == "image/heif" && slices.Contains(compatible, "heic") {
+			return "image/heic"
+		}
+		return contentType
+	}
+
+	for _, brand := range compatible {
+		if contentType, ok := isoBrands[brand]; ok {
+			return contentType
+		}
+	}
+
+	return "video/mp4"
+}
+
+func compatibleBrands(header []byte) []string {
+	end := min(int(binary.BigEndian.Uint32(header[:4])), len(header))
+
+	brands := []string{}
+	for offset := 16; offset+4 <= end; offset += 4 {
+		brands = append(brands, string(header[offset:offset+4]))
+	}
+	return brands
+}
@@ -0,0 +1,77 @@
+package media
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// ftyp returns the header of an ISO base media file with the given major and
+// compatible brands.
+func ftyp(major string, compatible ...string) []byte {
+	box := make([]byte, 16, 16+4*len(compatible))
+	binary.BigEndian.PutUint32(box, uint32(16+4*len(compatible)))
+	copy(box[4:], "ftyp")
+	copy(box[8:], major)
+	for _, brand := range compatible {
+		box = append(box, brand...)
+	}
+	return append(box, "\x00\x00\x00\x08mdat"...)
+}
+
+func TestDetectContentType(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   []byte
+		filename string
+		want     string
+	}{
+		{name: "jpeg", header: []byte{0xFF, 0xD8, 0xFF, 0xE1}, filename: "a.png", want: "image/jpeg"},
+		{name: "png", header: []byte("\x89PNG\r\n\x1a\n\x00"), filename: "a", want: "image/png"},
+		{name: "gif", header: []byte("GIF89a"), filename: "a.jpg", want: "image/gif"},
+		{name: "webp", header: []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), filename: "a", want: "image/webp"},
+		{name: "avi", header: []byte("RIFF\x00\x00\x00\x00AVI LIST"), filename: "a", want: "video/x-msvideo"},
+		{name: "tiff", header: []byte("II*\x00\x08\x00"), filename: "a.tif", want: "image/tiff"},
+		{name: "raw in tiff", header: []byte("II*\x00\x08\x00"), filename: "a.CR2", want: "image/x-canon-cr2"},
+		{name: "tiff named jpeg", header: []byte("MM\x00*\x00\x08"), filename: "a.jpg", want: "image/tiff"},
+		{name: "bmp", header: []byte("BM\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"), filename: "a", want: "image/bmp"},
+		{name: "webm", header: []byte{0x1A, 0x45, 0xDF, 0xA3}, filename: "a.WebM", want: "video/webm"},
+		{name: "matroska", header: []byte{0x1A, 0x45, 0xDF, 0xA3}, filename: "a", want: "video/x-matroska"},
+		{name: "heic", header: ftyp("heic", "mif1", "heic"), filename: "a.jpg", want: "image/heic"},
+		{name: "heif with heic brand", header: ftyp("mif1", "mif1", "heic"), filename: "a", want: "image/heic"},
+		{name: "heif", header: ftyp("mif1", "mif1"), filename: "a", want: "image/heif"},
+		{name: "avif", header: ftyp("avif", "mif1"), filename: "a", want: "image/avif"},
+		{name: "quicktime", header: ftyp("qt  ", "qt  "), filename: "a.mp4", want: "video/quicktime"},
+		{name: "mp4", header: ftyp("isom", "iso2", "mp41"), filename: "a", want: "video/mp4"},
+		{name: "compatible brand", header: ftyp("xxxx", "M4V "), filename: "a", want: "video/x-m4v"},
+		{name: "unknown brand", header: ftyp("xxxx", "yyyy"), filename: "a", want: "video/mp4"},
+		{name: "old quicktime", header: []byte("\x00\x00\x00\x08wide\x00\x00"), filename: "a", want: "video/quicktime"},
+		{name: "extension", header: []byte("not an image at all"), filename: "a.HEIC", want: "image/heic"},
+		{name: "empty by extension", filename: "a.mov", want: "video/quicktime"},
+		{name: "empty", filename: "a", want: "application/octet-stream"},
+		{name: "text", header: []byte("hello, world"), filename: "notes", want: "text/plain; charset=utf-8"},
+		{name: "short header", header: []byte("RIFF"), filename: "a.webp", want: "image/webp"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectContentType(tt.header, tt.filename); got != tt.want {
+				t.Errorf("DetectContentType = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKind(t *testing.T) {
+	tests := map[string]string{
+		"image/jpeg":               "image",
+		"image/x-canon-cr2":        "image",
+		"video/quicktime":          "video",
+		"application/octet-stream": "",
+		"":                         "",
+	}
+	for contentType, want := range tests {
+		if got := Kind(contentType); got != want {
+			t.Errorf("Kind(%q) = %q, want %q", contentType, got, want)
+		}
+	}
+}
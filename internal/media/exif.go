@@ -0,0 +1,398 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"photosync-backend/internal/models"
+)
+
+const (
+	tagMake             = 0x010F
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagExposureTime     = 0x829A
+	tagFNumber          = 0x829D
+	tagISO              = 0x8827
+	tagDateTimeOriginal = 0x9003
+	tagOffsetTime       = 0x9011
+	tagFocalLength      = 0x920A
+	tagPixelXDimension  = 0xA002
+	tagPixelYDimension  = 0xA003
+	tagLensModel        = 0xA434
+
+	tagGPSLatitudeRef  = 0x0001
+	tagGPSLatitude     = 0x0002
+	tagGPSLongitudeRef = 0x0003
+	tagGPSLongitude    = 0x0004
+	tagGPSAltitudeRef  = 0x0005
+	tagGPSAltitude     = 0x0006
+)
+
+const exifDateLayout = "2006:01:02 15:04:05"
+
+var errNoExif = errors.New("no exif data")
+
+var exifHeader = []byte("Exif\x00\x00")
+
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+type ifdEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+func newTIFFReader(data []byte) (*tiffReader, error) {
+	if len(data) < 8 {
+		return nil, errNoExif
+	}
+
+	var order binary.ByteOrder
+	switch string(data[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return nil, errNoExif
+	}
+
+	return &tiffReader{data: data, order: order}, nil
+}
+
+func (t *tiffReader) firstIFD() uint32 {
+	return t.order.Uint32(t.data[4:8])
+}
+
+func (t *tiffReader) readIFD(offset uint32) (map[uint16]ifdEntry, error) {
+	if uint64(offset)+2 > uint64(len(t.data)) {
+		return nil, errNoExif
+	}
+
+	count := int(t.order.Uint16(t.data[offset:]))
+	start := int(offset) + 2
+	if start+count*12 > len(t.data) {
+		return nil, errNoExif
+	}
+
+	entries := make(map[uint16]ifdEntry, count)
+	for i := 0; i < count; i++ {
+		raw := t.data[start+i*12 : start+(i+1)*12]
+		entry := ifdEntry{
+			tag:   t.order.Uint16(raw[0:2]),
+			typ:   t.order.Uint16(raw[2:4]),
+			count: t.order.Uint32(raw[4:8]),
+		}
+
+		size := uint64(typeSize(entry.typ)) * uint64(entry.count)
+		if size <= 4 {
+			entry.value = raw[8 : 8+size]
+		} else {
+			valueOffset := uint64(t.order.Uint32(raw[8:12]))
+			if valueOffset+size > uint64(len(t.data)) {
+				continue
+			}
+			entry.value = t.data[valueOffset : valueOffset+size]
+		}
+
+		entries[entry.tag] = entry
+	}
+
+	return entries, nil
+}
+
+func (t *tiffReader) uint(entry ifdEntry) (uint32, bool) {
+	switch entry.typ {
+	case 3:
+		if len(entry.value) >= 2 {
+			return uint32(t.order.Uint16(entry.value)), true
+		}
+	case 4:
+		if len(entry.value) >= 4 {
+			return t.order.Uint32(entry.value), true
+		}
+	}
+	return 0, false
+}
+
+func (t *tiffReader) ascii(entry ifdEntry) string {
+	if entry.typ != 2 {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(entry.value), "\x00"))
+}
+
+// rational returns the i-th (signed or unsigned) rational of entry.
+func (t *tiffReader) rational(entry ifdEntry, i int) (float64, bool) {
+	if (entry.typ != 5 && entry.typ != 10) || len(entry.value) < (i+1)*8 {
+		return 0, false
+	}
+
+	num := t.order.Uint32(entry.value[i*8:])
+	den := t.order.Uint32(entry.value[i*8+4:])
+	if den == 0 {
+		return 0, false
+	}
+	if entry.typ == 10 {
+		return float64(int32(num)) / float64(int32(den)), true
+	}
+	return float64(num) / float64(den), true
+}
+
+// subIFD follows a pointer tag such as the Exif or GPS IFD.
+func (t *tiffReader) subIFD(ifd map[uint16]ifdEntry, tag uint16) map[uint16]ifdEntry {
+	entry, ok := ifd[tag]
+	if !ok {
+		return nil
+	}
+	offset, ok := t.uint(entry)
+	if !ok {
+		return nil
+	}
+	sub, err := t.readIFD(offset)
+	if err != nil {
+		return nil
+	}
+	return sub
+}
+
+func typeSize(typ uint16) int {
+	switch typ {
+	case 1, 2, 6, 7:
+		return 1
+	case 3, 8:
+		return 2
+	case 4, 9, 11:
+		return 4
+	case 5, 10, 12:
+		return 8
+	}
+	return 0
+}
+
+// jpegSegments calls fn with every marker segment up to the start of the
+// image data, until fn returns false.
+func jpegSegments(r io.Reader, fn func(marker byte, data []byte) bool) error {
+	var marker [2]byte
+	if _, err := io.ReadFull(r, marker[:]); err != nil || marker != [2]byte{0xFF, 0xD8} {
+		return errNoExif
+	}
+
+	for {
+		if _, err := io.ReadFull(r, marker[:]); err != nil {
+			return err
+		}
+		if marker[0] != 0xFF {
+			return errNoExif
+		}
+		if marker[1] == 0xD8 || (marker[1] >= 0xD0 && marker[1] <= 0xD7) || marker[1] == 0x01 || marker[1] == 0xFF {
+			continue
+		}
+		if marker[1] == 0xDA || marker[1] == 0xD9 {
+			return nil
+		}
+
+		var length [2]byte
+		if _, err := io.ReadFull(r, length[:]); err != nil {
+			return err
+		}
+		size := int(binary.BigEndian.Uint16(length[:])) - 2
+		if size < 0 {
+			return errNoExif
+		}
+
+		segment := make([]byte, size)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return err
+		}
+		if !fn(marker[1], segment) {
+			return nil
+		}
+	}
+}
+
+// jpegExif returns the TIFF block of the first "Exif" APP1 segment.
+func jpegExif(r io.Reader) ([]byte, error) {
+	var tiff []byte
+	jpegSegments(r, func(marker byte, data []byte) bool {
+		if block, ok := bytes.CutPrefix(data, exifHeader); marker == 0xE1 && ok {
+			tiff = block
+			return false
+		}
+		return true
+	})
+
+	if tiff == nil {
+		return nil, errNoExif
+	}
+	return tiff, nil
+}
+
+// parseExif fills meta from a TIFF-structured EXIF block. Fields already set
+// are kept.
+func parseExif(data []byte, meta *models.PhotoMetadata) {
+	tiff, err := newTIFFReader(data)
+	if err != nil {
+		return
+	}
+
+	ifd0, err := tiff.readIFD(tiff.firstIFD())
+	if err != nil {
+		return
+	}
+
+	setString(&meta.Make, tiff.ascii(ifd0[tagMake]))
+	setString(&meta.Model, tiff.ascii(ifd0[tagModel]))
+	if v, ok := tiff.uint(ifd0[tagOrientation]); ok && v >= 1 && v <= 8 && meta.Orientation == 0 {
+		meta.Orientation = int(v)
+	}
+
+	exif := tiff.subIFD(ifd0, tagExifIFD)
+
+	if meta.CapturedAt.IsZero() {
+		date := tiff.ascii(exif[tagDateTimeOriginal])
+		if date == "" {
+			date = tiff.ascii(ifd0[tagDateTime])
+		}
+		meta.CapturedAt = parseExifDate(date, tiff.ascii(exif[tagOffsetTime]))
+	}
+
+	if v, ok := tiff.rational(exif[tagExposureTime], 0); ok && meta.ExposureTime == "" {
+		meta.ExposureTime = formatExposure(v)
+	}
+	if v, ok := tiff.rational(exif[tagFNumber], 0); ok && meta.FNumber == 0 {
+		meta.FNumber = v
+	}
+	if v, ok := tiff.uint(exif[tagISO]); ok && meta.ISO == 0 {
+		meta.ISO = int(v)
+	}
+	if v, ok := tiff.rational(exif[tagFocalLength], 0); ok && meta.FocalLength == 0 {
+		meta.FocalLength = v
+	}
+	if w, ok := tiff.uint(exif[tagPixelXDimension]); ok && meta.Width == 0 {
+		meta.Width = int(w)
+	}
+	if h, ok := tiff.uint(exif[tagPixelYDimension]); ok && meta.Height == 0 {
+		meta.Height = int(h)
+	}
+	setString(&meta.Lens, tiff.ascii(exif[tagLensModel]))
+
+	if meta.Location == nil {
+		meta.Location = parseGPS(tiff, tiff.subIFD(ifd0, tagGPSIFD))
+	}
+}
+
+func parseGPS(tiff *tiffReader, gps map[uint16]ifdEntry) *models.GeoLocation {
+	lat, ok := gpsCoordinate(tiff, gps[tagGPSLatitude], tiff.ascii(gps[tagGPSLatitudeRef]), "S")
+	if !ok {
+		return nil
+	}
+	lon, ok := gpsCoordinate(tiff, gps[tagGPSLongitude], tiff.ascii(gps[tagGPSLongitudeRef]), "W")
+	if !ok {
+		return nil
+	}
+
+	location := &models.GeoLocation{Latitude: lat, Longitude: lon}
+	if alt, ok := tiff.rational(gps[tagGPSAltitude], 0); ok {
+		if ref := gps[tagGPSAltitudeRef].value; len(ref) > 0 && ref[0] == 1 {
+			alt = -alt
+		}
+		location.Altitude = alt
+	}
+	return location
+}
+
+// gpsCoordinate converts degrees, minutes and seconds to decimal degrees.
+func gpsCoordinate(tiff *tiffReader, entry ifdEntry, ref, negative string) (float64, bool) {
+	degrees, ok1 := tiff.rational(entry, 0)
+	minutes, ok2 := tiff.rational(entry, 1)
+	seconds, ok3 := tiff.rational(entry, 2)
+	if !ok1 || !ok2 || !ok3 {
+		return 0, false
+	}
+
+	value := degrees + minutes/60 + seconds/3600
+	if ref == negative {
+		value = -value
+	}
+	return value, true
+}
+
+// parseExifDate parses an EXIF date, using offset ("+02:00") when the camera
+// recorded one.
+func parseExifDate(date, offset string) time.Time {
+	if date == "" {
+		return time.Time{}
+	}
+
+	if offset != "" {
+		if t, err := time.Parse(exifDateLayout+"-07:00", date+offset); err == nil {
+			return t
+		}
+	}
+
+	t, err := time.Parse(exifDateLayout, date)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+func formatExposure(seconds float64) string {
+	if seconds <= 0 || seconds >= 1 {
+		return strconv.FormatFloat(seconds, 'f', -1, 64)
+	}
+	return fmt.Sprintf("1/%d", int(math.Round(1/seconds)))
+}
+
+func setString(field *string, value string) {
+	if *field == "" {
+		*field = value
+	}
+}
+
+// Orientation returns the EXIF orientation (1-8) of a JPEG image, or 1 when
+// the image carries no orientation tag.
+func Orientation(r io.Reader) int {
+	data, err := jpegExif(r)
+	if err != nil {
+		return 1
+	}
+
+	tiff, err := newTIFFReader(data)
+	if err != nil {
+		return 1
+	}
+
+	ifd0, err := tiff.readIFD(tiff.firstIFD())
+	if err != nil {
+		return 1
+	}
+
+	entry, ok := ifd0[tagOrientation]
+	if !ok {
+		return 1
+	}
+
+	value, ok := tiff.uint(entry)
+	if !ok || value < 1 || value > 8 {
+		return 1
+	}
+
+	return int(value)
+}
@@ -0,0 +1,317 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"photosync-backend/internal/models"
+)
+
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// tiffTag is an IFD entry for buildTIFF. When sub is set the entry points to
+// the IFD with that index.
+type tiffTag struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+	sub   int
+}
+
+func asciiTag(tag uint16, s string) tiffTag {
+	return tiffTag{tag: tag, typ: 2, count: uint32(len(s) + 1), value: append([]byte(s), 0)}
+}
+
+func shortTag(order byteOrder, tag, v uint16) tiffTag {
+	return tiffTag{tag: tag, typ: 3, count: 1, value: order.AppendUint16(nil, v)}
+}
+
+func rationalTag(order byteOrder, tag uint16, pairs ...uint32) tiffTag {
+	var value []byte
+	for _, v := range pairs {
+		value = order.AppendUint32(value, v)
+	}
+	return tiffTag{tag: tag, typ: 5, count: uint32(len(pairs) / 2), value: value}
+}
+
+func pointerTag(tag uint16, sub int) tiffTag {
+	return tiffTag{tag: tag, typ: 4, count: 1, sub: sub}
+}
+
+// buildTIFF lays out the IFDs one after another, followed by the values that
+// do not fit into their entries.
+func buildTIFF(order byteOrder, ifds ...[]tiffTag) []byte {
+	offsets := make([]uint32, len(ifds))
+	end := uint32(8)
+	for i, ifd := range ifds {
+		offsets[i] = end
+		end += 2 + 12*uint32(len(ifd)) + 4
+	}
+
+	var out, data []byte
+	if order == byteOrder(binary.LittleEndian) {
+		out = []byte("II*\x00")
+	} else {
+		out = []byte("MM\x00*")
+	}
+	out = order.AppendUint32(out, 8)
+
+	for _, ifd := range ifds {
+		out = order.AppendUint16(out, uint16(len(ifd)))
+		for _, entry := range ifd {
+			out = order.AppendUint16(out, entry.tag)
+			out = order.AppendUint16(out, entry.typ)
+			out = order.AppendUint32(out, entry.count)
+			switch {
+			case entry.sub > 0:
+				out = order.AppendUint32(out, offsets[entry.sub])
+			case len(entry.value) <= 4:
+				out = append(out, entry.value...)
+				out = append(out, make([]byte, 4-len(entry.value))...)
+			default:
+				out = order.AppendUint32(out, end+uint32(len(data)))
+				data = append(data, entry.value...)
+			}
+		}
+		out = order.AppendUint32(out, 0)
+	}
+	return append(out, data...)
+}
+
+// sampleExif is a camera-style EXIF block with an Exif and a GPS IFD.
+func sampleExif(order byteOrder) []byte {
+	return buildTIFF(order,
+		[]tiffTag{
+			asciiTag(tagMake, "Canon"),
+			asciiTag(tagModel, "EOS R5"),
+			shortTag(order, tagOrientation, 6),
+			asciiTag(tagDateTime, "2024:01:01 00:00:00"),
+			pointerTag(tagExifIFD, 1),
+			pointerTag(tagGPSIFD, 2),
+		},
+		[]tiffTag{
+			rationalTag(order, tagExposureTime, 1, 250),
+			rationalTag(order, tagFNumber, 28, 10),
+			shortTag(order, tagISO, 400),
+			asciiTag(tagDateTimeOriginal, "2023:07:14 18:30:05"),
+			asciiTag(tagOffsetTime, "+02:00"),
+			rationalTag(order, tagFocalLength, 50, 1),
+			shortTag(order, tagPixelXDimension, 8192),
+			shortTag(order, tagPixelYDimension, 5464),
+			asciiTag(tagLensModel, "RF50mm F1.2 L USM"),
+		},
+		[]tiffTag{
+			asciiTag(tagGPSLatitudeRef, "S"),
+			rationalTag(order, tagGPSLatitude, 33, 1, 51, 1, 3600, 100),
+			asciiTag(tagGPSLongitudeRef, "E"),
+			rationalTag(order, tagGPSLongitude, 151, 1, 12, 1, 0, 1),
+			{tag: tagGPSAltitudeRef, typ: 1, count: 1, value: []byte{1}},
+			rationalTag(order, tagGPSAltitude, 5, 2),
+		},
+	)
+}
+
+func sampleMetadata() models.PhotoMetadata {
+	return models.PhotoMetadata{
+		CapturedAt:   time.Date(2023, 7, 14, 18, 30, 5, 0, time.FixedZone("", 2*3600)),
+		Make:         "Canon",
+		Model:        "EOS R5",
+		Lens:         "RF50mm F1.2 L USM",
+		Width:        8192,
+		Height:       5464,
+		Orientation:  6,
+		Location:     &models.GeoLocation{Latitude: -(33 + 51.0/60 + 36.0/3600), Longitude: 151 + 12.0/60, Altitude: -2.5},
+		ExposureTime: "1/250",
+		FNumber:      2.8,
+		ISO:          400,
+		FocalLength:  50,
+	}
+}
+
+func equalMetadata(t *testing.T, got, want *models.PhotoMetadata) {
+	t.Helper()
+
+	if got == nil || want == nil {
+		if got != want {
+			t.Fatalf("metadata = %+v, want %+v", got, want)
+		}
+		return
+	}
+
+	g, w := *got, *want
+	if !g.CapturedAt.Equal(w.CapturedAt) {
+		t.Errorf("CapturedAt = %v, want %v", g.CapturedAt, w.CapturedAt)
+	}
+	g.CapturedAt, w.CapturedAt = time.Time{}, time.Time{}
+
+	if (g.Location == nil) != (w.Location == nil) {
+		t.Errorf("Location = %+v, want %+v", g.Location, w.Location)
+	} else if g.Location != nil {
+		if math.Abs(g.Location.Latitude-w.Location.Latitude) > 1e-9 ||
+			math.Abs(g.Location.Longitude-w.Location.Longitude) > 1e-9 ||
+			math.Abs(g.Location.Altitude-w.Location.Altitude) > 1e-9 {
+			t.Errorf("Location = %+v, want %+v", *g.Location, *w.Location)
+		}
+	}
+	g.Location, w.Location = nil, nil
+
+	if g != w {
+		t.Errorf("metadata = %+v, want %+v", g, w)
+	}
+}
+
+func TestParseExif(t *testing.T) {
+	le, be := binary.LittleEndian, binary.BigEndian
+	sample := sampleMetadata()
+
+	tests := []struct {
+		name  string
+		data  []byte
+		start models.PhotoMetadata
+		want  models.PhotoMetadata
+	}{
+		{name: "little endian", data: sampleExif(le), want: sample},
+		{name: "big endian", data: sampleExif(be), want: sample},
+		{
+			name:  "keeps fields already set",
+			data:  sampleExif(le),
+			start: models.PhotoMetadata{Make: "Apple", Width: 10, Height: 20, Location: &models.GeoLocation{Latitude: 1}},
+			want: func() models.PhotoMetadata {
+				m := sample
+				m.Make, m.Width, m.Height, m.Location = "Apple", 10, 20, &models.GeoLocation{Latitude: 1}
+				return m
+			}(),
+		},
+		{
+			name: "DateTime without DateTimeOriginal",
+			data: buildTIFF(le, []tiffTag{asciiTag(tagDateTime, "2024:01:02 03:04:05")}),
+			want: models.PhotoMetadata{CapturedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		},
+		{
+			name: "orientation out of range",
+			data: buildTIFF(le, []tiffTag{shortTag(le, tagOrientation, 9), asciiTag(tagMake, "X")}),
+			want: models.PhotoMetadata{Make: "X"},
+		},
+		{
+			name: "zero denominator",
+			data: buildTIFF(le, []tiffTag{pointerTag(tagExifIFD, 1)}, []tiffTag{rationalTag(le, tagFNumber, 28, 0)}),
+			want: models.PhotoMetadata{},
+		},
+		{
+			name: "value beyond the block",
+			data: func() []byte {
+				data := buildTIFF(le, []tiffTag{asciiTag(tagMake, "A long camera make"), asciiTag(tagModel, "M")})
+				return data[:len(data)-4]
+			}(),
+			want: models.PhotoMetadata{Model: "M"},
+		},
+		{name: "truncated IFD", data: sampleExif(le)[:20]},
+		{name: "IFD offset out of range", data: []byte("II*\x00\xff\xff\xff\xff")},
+		{name: "not TIFF", data: []byte("GIF89a\x00\x00\x00\x00")},
+		{name: "empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta := tt.start
+			parseExif(tt.data, &meta)
+			equalMetadata(t, &meta, &tt.want)
+		})
+	}
+}
+
+func TestParseExifDate(t *testing.T) {
+	tests := []struct {
+		date, offset string
+		want         time.Time
+	}{
+		{"2023:07:14 18:30:05", "", time.Date(2023, 7, 14, 18, 30, 5, 0, time.UTC)},
+		{"2023:07:14 18:30:05", "-05:00", time.Date(2023, 7, 14, 23, 30, 5, 0, time.UTC)},
+		{"2023:07:14 18:30:05", "bogus", time.Date(2023, 7, 14, 18, 30, 5, 0, time.UTC)},
+		{"0000:00:00 00:00:00", "", time.Time{}},
+		{"2023-07-14", "", time.Time{}},
+		{"", "+02:00", time.Time{}},
+	}
+
+	for _, tt := range tests {
+		if got := parseExifDate(tt.date, tt.offset); !got.Equal(tt.want) {
+			t.Errorf("parseExifDate(%q, %q) = %v, want %v", tt.date, tt.offset, got, tt.want)
+		}
+	}
+}
+
+func TestFormatExposure(t *testing.T) {
+	tests := []struct {
+		seconds float64
+		want    string
+	}{
+		{1.0 / 250, "1/250"},
+		{1.0 / 3, "1/3"},
+		{0.3, "1/3"},
+		{1, "1"},
+		{2.5, "2.5"},
+		{30, "30"},
+	}
+
+	for _, tt := range tests {
+		if got := formatExposure(tt.seconds); got != tt.want {
+			t.Errorf("formatExposure(%v) = %q, want %q", tt.seconds, got, tt.want)
+		}
+	}
+}
+
+// jpegSegment encodes a marker segment.
+func jpegSegment(marker byte, data []byte) []byte {
+	out := []byte{0xFF, marker}
+	out = binary.BigEndian.AppendUint16(out, uint16(len(data)+2))
+	return append(out, data...)
+}
+
+func sampleJPEG(segments ...[]byte) []byte {
+	out := []byte{0xFF, 0xD8}
+	for _, segment := range segments {
+		out = append(out, segment...)
+	}
+	out = append(out, jpegSegment(0xDA, []byte{0, 0, 0})...)
+	return append(out, 0x12, 0x34, 0xFF, 0xD9)
+}
+
+func sof(width, height uint16) []byte {
+	data := []byte{8}
+	data = binary.BigEndian.AppendUint16(data, height)
+	data = binary.BigEndian.AppendUint16(data, width)
+	return jpegSegment(0xC0, append(data, 3))
+}
+
+func TestOrientation(t *testing.T) {
+	le := binary.LittleEndian
+
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{name: "tagged", data: sampleJPEG(jpegSegment(0xE1, append(exifHeader, sampleExif(le)...))), want: 6},
+		{name: "after APP0", data: sampleJPEG(jpegSegment(0xE0, []byte("JFIF\x00")), jpegSegment(0xE1, append(exifHeader, buildTIFF(le, []tiffTag{shortTag(le, tagOrientation, 3)})...))), want: 3},
+		{name: "no orientation tag", data: sampleJPEG(jpegSegment(0xE1, append(exifHeader, buildTIFF(le, []tiffTag{asciiTag(tagMake, "X")})...))), want: 1},
+		{name: "no exif", data: sampleJPEG(sof(10, 10)), want: 1},
+		{name: "XMP APP1 only", data: sampleJPEG(jpegSegment(0xE1, append(xmpHeader, "<x/>"...))), want: 1},
+		{name: "not JPEG", data: []byte("\x89PNG\r\n\x1a\n"), want: 1},
+		{name: "truncated", data: sampleJPEG(jpegSegment(0xE1, append(exifHeader, sampleExif(le)...)))[:30], want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Orientation(bytes.NewReader(tt.data)); got != tt.want {
+				t.Errorf("Orientation = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
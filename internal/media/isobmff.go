@@ -0,0 +1,619 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"photosync-backend/internal/models"
+)
+
+// mp4Epoch is the zero point of MP4/QuickTime timestamps.
+var mp4Epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var iso6709 = regexp.MustCompile(`^([+-][0-9.]+)([+-][0-9.]+)([+-][0-9.]+)?`)
+
+var quickTimeDateLayouts = []string{
+	"2006-01-02T15:04:05-0700",
+	time.RFC3339,
+	"2006-01-02T15:04:05Z",
+	"2006-01-02",
+}
+
+type itemLocation struct {
+	offset uint64
+	length uint64
+}
+
+// extractISOBMFF reads the meta box of a HEIF/AVIF image or the moov box of
+// an MP4/MOV video, seeking over everything else.
+func extractISOBMFF(r io.ReadSeeker, meta *models.PhotoMetadata) error {
+	for {
+		typ, size, err := readBoxHeader(r)
+		if err != nil {
+			return err
+		}
+
+		switch typ {
+		case "meta", "moov":
+			if size < 0 || size > maxMetadataBlock {
+				return errTooLarge
+			}
+			data := make([]byte, size)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return err
+			}
+
+			if typ == "moov" {
+				parseMoov(data, meta)
+				return nil
+			}
+
+			items := parseHEIFMeta(data, meta)
+			if loc, ok := items["Exif"]; ok {
+				if block, err := readItem(r, loc); err == nil && len(block) >= 4 {
+					skip := uint64(binary.BigEndian.Uint32(block))
+					if 4+skip <= uint64(len(block)) {
+						parseExif(bytes.TrimPrefix(block[4+skip:], exifHeader), meta)
+					}
+				}
+			}
+			if loc, ok := items["application/rdf+xml"]; ok {
+				if block, err := readItem(r, loc); err == nil {
+					parseXMP(block, meta)
+				}
+			}
+			return nil
+		}
+
+		if size < 0 {
+			return nil
+		}
+		if _, err := r.Seek(size, io.SeekCurrent); err != nil {
+			return err
+		}
+	}
+}
+
+// readBoxHeader returns the type and payload size of the next box. A size of
+// -1 means the box extends to the end of the file.
+func readBoxHeader(r io.Reader) (string, int64, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return "", 0, err
+	}
+
+	size := int64(binary.BigEndian.Uint32(header[:4]))
+	typ := string(header[4:8])
+
+	switch size {
+	case 0:
+		return typ, -1, nil
+	case 1:
+		var large [8]byte
+		if _, err := io.ReadFull(r, large[:]); err != nil {
+			return "", 0, err
+		}
+		size = int64(binary.BigEndian.Uint64(large[:])) - 16
+	default:
+		size -= 8
+	}
+
+	if size < 0 {
+		return "", 0, errMalformed
+	}
+	return typ, size, nil
+}
+
+func readItem(r io.ReadSeeker, loc itemLocation) ([]byte, error) {
+	if loc.length > maxMetadataBlock {
+		return nil, errTooLarge
+	}
+	if _, err := r.Seek(int64(loc.offset), io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, loc.length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// forEachBox calls fn for each box in data.
+func forEachBox(data []byte, fn func(typ string, payload []byte)) {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		typ := string(data[4:8])
+		header := uint64(8)
+
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return
+		}
+
+		fn(typ, data[header:size])
+		data = data[size:]
+	}
+}
+
+// parseHEIFMeta reads dimensions from the primary item's properties and
+// returns the locations of the Exif and XMP items, keyed by "Exif" and by
+// the XMP MIME type.
+func parseHEIFMeta(data []byte, meta *models.PhotoMetadata) map[string]itemLocation {
+	if len(data) < 4 {
+		return nil
+	}
+
+	var (
+		primary    uint32
+		itemTypes  = make(map[uint32]string)
+		locations  = make(map[uint32]itemLocation)
+		properties []heifProperty
+		assoc      = make(map[uint32][]int)
+	)
+
+	forEachBox(data[4:], func(typ string, payload []byte) {
+		switch typ {
+		case "pitm":
+			b := &boxReader{data: payload}
+			if b.u8() == 0 {
+				b.skip(3)
+				primary = uint32(b.u16())
+			} else {
+				b.skip(3)
+				primary = b.u32()
+			}
+		case "iinf":
+			parseItemInfo(payload, itemTypes)
+		case "iloc":
+			parseItemLocations(payload, locations)
+		case "iprp":
+			forEachBox(payload, func(typ string, payload []byte) {
+				switch typ {
+				case "ipco":
+					forEachBox(payload, func(typ string, payload []byte) {
+						properties = append(properties, heifProperty{typ, payload})
+					})
+				case "ipma":
+					parseItemAssociations(payload, assoc)
+				}
+			})
+		}
+	})
+
+	for _, index := range assoc[primary] {
+		if index < 1 || index > len(properties) {
+			continue
+		}
+		if w, h, ok := spatialExtent(properties[index-1]); ok {
+			meta.Width, meta.Height = w, h
+		}
+	}
+
+	// Without associations, the largest image spatial extent is the
+	// primary image; grid tiles and thumbnails are smaller.
+	if meta.Width == 0 {
+		for _, prop := range properties {
+			if w, h, ok := spatialExtent(prop); ok && w*h > meta.Width*meta.Height {
+				meta.Width, meta.Height = w, h
+			}
+		}
+	}
+
+	items := make(map[string]itemLocation)
+	for id, typ := range itemTypes {
+		if loc, ok := locations[id]; ok {
+			items[typ] = loc
+		}
+	}
+	return items
+}
+
+type heifProperty struct {
+	typ     string
+	payload []byte
+}
+
+func spatialExtent(prop heifProperty) (int, int, bool) {
+	if prop.typ != "ispe" || len(prop.payload) < 12 {
+		return 0, 0, false
+	}
+	return int(binary.BigEndian.Uint32(prop.payload[4:8])), int(binary.BigEndian.Uint32(prop.payload[8:12])), true
+}
+
+// parseItemInfo maps item IDs to their type, or to the content type for
+// "mime" items.
+func parseItemInfo(data []byte, types map[uint32]string) {
+	b := &boxReader{data: data}
+	version := b.u8()
+	b.skip(3)
+	if version == 0 {
+		b.u16()
+	} else {
+		b.u32()
+	}
+	if b.failed {
+		return
+	}
+
+	forEachBox(data[b.pos:], func(typ string, payload []byte) {
+		if typ != "infe" {
+			return
+		}
+
+		e := &boxReader{data: payload}
+		version := e.u8()
+		e.skip(3)
+		if version < 2 {
+			return
+		}
+
+		var id uint32
+		if version == 2 {
+			id = uint32(e.u16())
+		} else {
+			id = e.u32()
+		}
+		e.u16()
+		itemType := string(e.bytes(4))
+		if e.failed {
+			return
+		}
+
+		if itemType == "mime" {
+			fields := bytes.SplitN(payload[e.pos:], []byte{0}, 3)
+			if len(fields) >= 2 {
+				itemType = string(fields[1])
+			}
+		}
+		types[id] = itemType
+	})
+}
+
+// parseItemLocations records the location of every item stored as a single
+// extent in the file itself.
+func parseItemLocations(data []byte, locations map[uint32]itemLocation) {
+	b := &boxReader{data: data}
+	version := b.u8()
+	b.skip(3)
+
+	sizes := b.u8()
+	offsetSize, lengthSize := int(sizes>>4), int(sizes&0x0F)
+	sizes = b.u8()
+	baseOffsetSize, indexSize := int(sizes>>4), 0
+	if version == 1 || version == 2 {
+		indexSize = int(sizes & 0x0F)
+	}
+
+	var count uint32
+	if version < 2 {
+		count = uint32(b.u16())
+	} else {
+		count = b.u32()
+	}
+
+	for i := uint32(0); i < count && !b.failed; i++ {
+		var id uint32
+		if version < 2 {
+			id = uint32(b.u16())
+		} else {
+			id = b.u32()
+		}
+
+		method := uint16(0)
+		if version == 1 || version == 2 {
+			method = b.u16() & 0x0F
+		}
+		b.u16()
+		base := b.uint(baseOffsetSize)
+
+		extents := b.u16()
+		var first itemLocation
+		for e := uint16(0); e < extents; e++ {
+			b.uint(indexSize)
+			offset := b.uint(offsetSize)
+			length := b.uint(lengthSize)
+			if e == 0 {
+				first = itemLocation{offset: base + offset, length: length}
+			}
+		}
+
+		if !b.failed && method == 0 && extents == 1 {
+			locations[id] = first
+		}
+	}
+}
+
+func parseItemAssociations(data []byte, assoc map[uint32][]int) {
+	b := &boxReader{data: data}
+	version := b.u8()
+	b.skip(2)
+	flags := b.u8()
+
+	count := b.u32()
+	for i := uint32(0); i < count && !b.failed; i++ {
+		var id uint32
+		if version < 1 {
+			id = uint32(b.u16())
+		} else {
+			id = b.u32()
+		}
+
+		n := int(b.u8())
+		for j := 0; j < n; j++ {
+			if flags&1 != 0 {
+				assoc[id] = append(assoc[id], int(b.u16()&0x7FFF))
+			} else {
+				assoc[id] = append(assoc[id], int(b.u8()&0x7F))
+			}
+		}
+	}
+}
+
+// parseMoov reads creation time, duration, dimensions, rotation and the
+// QuickTime/iTunes metadata of a movie.
+func parseMoov(data []byte, meta *models.PhotoMetadata) {
+	var created time.Time
+
+	forEachBox(data, func(typ string, payload []byte) {
+		switch typ {
+		case "mvhd":
+			created = parseMovieHeader(payload, meta)
+		case "trak":
+			forEachBox(payload, func(typ string, payload []byte) {
+				if typ == "tkhd" {
+					parseTrackHeader(payload, meta)
+				}
+			})
+		case "udta":
+			forEachBox(payload, func(typ string, payload []byte) {
+				switch typ {
+				case "meta":
+					parseQuickTimeMeta(payload, meta)
+				case "\xa9xyz", "\xa9mak", "\xa9mod":
+					applyQuickTimeValue(typ, userDataText(payload), meta)
+				}
+			})
+		case "meta":
+			parseQuickTimeMeta(payload, meta)
+		}
+	})
+
+	if meta.CapturedAt.IsZero() && !created.IsZero() {
+		meta.CapturedAt = created
+	}
+}
+
+func parseMovieHeader(data []byte, meta *models.PhotoMetadata) time.Time {
+	b := &boxReader{data: data}
+	version := b.u8()
+	b.skip(3)
+
+	var created, duration uint64
+	var timescale uint32
+	if version == 1 {
+		created = b.u64()
+		b.u64()
+		timescale = b.u32()
+		duration = b.u64()
+	} else {
+		created = uint64(b.u32())
+		b.u32()
+		timescale = b.u32()
+		duration = uint64(b.u32())
+	}
+	if b.failed {
+		return time.Time{}
+	}
+
+	if timescale > 0 && duration > 0 {
+		meta.Duration = float64(duration) / float64(timescale)
+	}
+	if created == 0 {
+		return time.Time{}
+	}
+	return mp4Epoch.Add(time.Duration(created) * time.Second)
+}
+
+// parseTrackHeader takes dimensions and rotation from the first visual
+// track.
+func parseTrackHeader(data []byte, meta *models.PhotoMetadata) {
+	if meta.Width != 0 {
+		return
+	}
+
+	matrix := 40
+	if len(data) > 0 && data[0] == 1 {
+		matrix = 52
+	}
+	if len(data) < matrix+44 {
+		return
+	}
+
+	width := int(binary.BigEndian.Uint32(data[matrix+36:]) >> 16)
+	height := int(binary.BigEndian.Uint32(data[matrix+40:]) >> 16)
+	if width == 0 || height == 0 {
+		return
+	}
+	meta.Width, meta.Height = width, height
+
+	// The first row of the transformation matrix (16.16 fixed point) gives
+	// the rotation applied on playback.
+	a := int32(binary.BigEndian.Uint32(data[matrix:]))
+	b := int32(binary.BigEndian.Uint32(data[matrix+4:]))
+	switch {
+	case a == 0 && b == 1<<16:
+		meta.Orientation = 6
+	case a == -1<<16 && b == 0:
+		meta.Orientation = 3
+	case a == 0 && b == -1<<16:
+		meta.Orientation = 8
+	}
+}
+
+// parseQuickTimeMeta reads a keys/ilst metadata box as written by iPhones
+// and most Android cameras. In MP4 files the box carries a version and flags
+// header that QuickTime files omit.
+func parseQuickTimeMeta(data []byte, meta *models.PhotoMetadata) {
+	if len(data) >= 8 && string(data[4:8]) != "hdlr" {
+		data = data[4:]
+	}
+
+	var keys []string
+	var items [][2][]byte
+	forEachBox(data, func(typ string, payload []byte) {
+		switch typ {
+		case "keys":
+			b := &boxReader{data: payload}
+			b.skip(4)
+			count := b.u32()
+			for i := uint32(0); i < count && !b.failed; i++ {
+				size := int(b.u32())
+				b.skip(4)
+				keys = append(keys, string(b.bytes(size-8)))
+			}
+		case "ilst":
+			forEachBox(payload, func(typ string, payload []byte) {
+				forEachBox(payload, func(dataType string, value []byte) {
+					if dataType == "data" && len(value) >= 8 {
+						items = append(items, [2][]byte{[]byte(typ), value[8:]})
+					}
+				})
+			})
+		}
+	})
+
+	for _, item := range items {
+		key := string(item[0])
+		if index := binary.BigEndian.Uint32(item[0]); keys != nil && index >= 1 && int(index) <= len(keys) {
+			key = keys[index-1]
+		}
+		applyQuickTimeValue(key, string(item[1]), meta)
+	}
+}
+
+func applyQuickTimeValue(key, value string, meta *models.PhotoMetadata) {
+	value = strings.TrimSpace(strings.TrimRight(value, "\x00"))
+
+	switch key {
+	case "com.apple.quicktime.creationdate", "\xa9day":
+		for _, layout := range quickTimeDateLayouts {
+			if t, err := time.Parse(layout, value); err == nil {
+				meta.CapturedAt = t
+				break
+			}
+		}
+	case "com.apple.quicktime.location.ISO6709", "\xa9xyz":
+		if meta.Location == nil {
+			meta.Location = parseISO6709(value)
+		}
+	case "com.apple.quicktime.make", "\xa9mak":
+		setString(&meta.Make, value)
+	case "com.apple.quicktime.model", "\xa9mod":
+		setString(&meta.Model, value)
+	}
+}
+
+// userDataText decodes a QuickTime user data text item: a 16-bit length,
+// a 16-bit language code and the text.
+func userDataText(data []byte) string {
+	if len(data) < 4 {
+		return ""
+	}
+	n := int(binary.BigEndian.Uint16(data))
+	return string(data[4:min(4+n, len(data))])
+}
+
+func parseISO6709(value string) *models.GeoLocation {
+	m := iso6709.FindStringSubmatch(value)
+	if m == nil {
+		return nil
+	}
+
+	lat, err1 := strconv.ParseFloat(m[1], 64)
+	lon, err2 := strconv.ParseFloat(m[2], 64)
+	if err1 != nil || err2 != nil {
+		return nil
+	}
+
+	location := &models.GeoLocation{Latitude: lat, Longitude: lon}
+	if m[3] != "" {
+		location.Altitude, _ = strconv.ParseFloat(m[3], 64)
+	}
+	return location
+}
+
+// boxReader reads big-endian fields from a box payload. Reads past the end
+// return zero and set failed.
+type boxReader struct {
+	data   []byte
+	pos    int
+	failed bool
+}
+
+func (b *boxReader) bytes(n int) []byte {
+	if n < 0 || b.pos+n > len(b.data) {
+		b.failed = true
+		return nil
+	}
+	v := b.data[b.pos : b.pos+n]
+	b.pos += n
+	return v
+}
+
+func (b *boxReader) skip(n int) {
+	b.bytes(n)
+}
+
+func (b *boxReader) u8() uint8 {
+	if v := b.bytes(1); v != nil {
+		return v[0]
+	}
+	return 0
+}
+
+func (b *boxReader) u16() uint16 {
+	if v := b.bytes(2); v != nil {
+		return binary.BigEndian.Uint16(v)
+	}
+	return 0
+}
+
+func (b *boxReader) u32() uint32 {
+	if v := b.bytes(4); v != nil {
+		return binary.BigEndian.Uint32(v)
+	}
+	return 0
+}
+
+func (b *boxReader) u64() uint64 {
+	if v := b.bytes(8); v != nil {
+		return binary.BigEndian.Uint64(v)
+	}
+	return 0
+}
+
+// uint reads an unsigned integer of n bytes (0, 4 or 8), as used by iloc.
+func (b *boxReader) uint(n int) uint64 {
+	switch n {
+	case 4:
+		return uint64(b.u32())
+	case 8:
+		return b.u64()
+	}
+	return 0
+}
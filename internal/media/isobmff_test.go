@@ -0,0 +1,245 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"photosync-backend/internal/models"
+)
+
+func box(typ string, payloads ...[]byte) []byte {
+	var payload []byte
+	for _, p := range payloads {
+		payload = append(payload, p...)
+	}
+	out := binary.BigEndian.AppendUint32(nil, uint32(len(payload)+8))
+	out = append(out, typ...)
+	return append(out, payload...)
+}
+
+func u16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+func u32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+func u64(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
+
+// fullBox prefixes a version and zero flags.
+func fullBox(typ string, version byte, payloads ...[]byte) []byte {
+	return box(typ, append([][]byte{{version, 0, 0, 0}}, payloads...)...)
+}
+
+var sampleMovieTime = time.Date(2022, 8, 9, 10, 11, 12, 0, time.UTC)
+
+func movieHeader(version byte) []byte {
+	created := uint64(sampleMovieTime.Sub(mp4Epoch) / time.Second)
+	if version == 1 {
+		return fullBox("mvhd", 1, u64(created), u64(created), u32(600), u64(6000), make([]byte, 80))
+	}
+	return fullBox("mvhd", 0, u32(uint32(created)), u32(uint32(created)), u32(600), u32(6000), make([]byte, 80))
+}
+
+// trackHeader has a matrix rotating by 90 degrees on playback.
+func trackHeader(version byte, width, height uint32) []byte {
+	var times []byte
+	if version == 1 {
+		times = append(u64(0), u64(0)...)
+		times = append(times, u32(1)...)
+		times = append(times, u32(0)...)
+		times = append(times, u64(6000)...)
+	} else {
+		times = append(u32(0), u32(0)...)
+		times = append(times, u32(1)...)
+		times = append(times, u32(0)...)
+		times = append(times, u32(6000)...)
+	}
+
+	matrix := [][]byte{u32(0), u32(1 << 16), u32(0), u32(0xFFFF0000), u32(0), u32(0), u32(0), u32(0), u32(1 << 30)}
+	return fullBox("tkhd", version, times, make([]byte, 16), bytes.Join(matrix, nil), u32(width<<16), u32(height<<16))
+}
+
+// quickTimeMeta is a keys/ilst box as written by phones. MP4 files carry a
+// version and flags header before the handler box.
+func quickTimeMeta(mp4 bool, values map[string]string) []byte {
+	var keys, items []byte
+	index := uint32(0)
+	for _, key := range []string{"com.apple.quicktime.creationdate", "com.apple.quicktime.make", "com.apple.quicktime.model", "com.apple.quicktime.location.ISO6709"} {
+		value, ok := values[key]
+		if !ok {
+			continue
+		}
+		index++
+		keys = append(keys, u32(uint32(8+len(key)))...)
+		keys = append(keys, "mdta"+key...)
+		items = append(items, box(string(u32(index)), box("data", u32(1), u32(0), []byte(value)))...)
+	}
+
+	var header []byte
+	if mp4 {
+		header = []byte{0, 0, 0, 0}
+	}
+	return box("meta", header, fullBox("hdlr", 0, make([]byte, 4), []byte("mdta"), make([]byte, 13)), fullBox("keys", 0, u32(index), keys), box("ilst", items))
+}
+
+// sampleMP4 is an MP4 (version 1 headers, keys in udta) or a QuickTime movie
+// (version 0 headers, keys in moov and a user data location), each with the
+// media data before the movie box.
+func sampleMP4(version byte) []byte {
+	values := map[string]string{
+		"com.apple.quicktime.make":  "Apple",
+		"com.apple.quicktime.model": "iPhone 15",
+	}
+
+	var moov []byte
+	if version == 1 {
+		values["com.apple.quicktime.creationdate"] = "2023-05-06T07:08:09+0200"
+		values["com.apple.quicktime.location.ISO6709"] = "+48.8584+002.2945+035.000/"
+		moov = box("moov", movieHeader(1), box("trak", trackHeader(1, 1920, 1080)), box("udta", quickTimeMeta(true, values)))
+	} else {
+		moov = box("moov", movieHeader(0), box("trak", trackHeader(0, 1920, 1080)), quickTimeMeta(false, values),
+			box("udta", box("\xa9xyz", u16(18), u16(0), []byte("-33.8688+151.2093/"))))
+	}
+
+	return bytes.Join([][]byte{
+		box("ftyp", []byte("isom"), u32(0), []byte("isommp41")),
+		box("mdat", make([]byte, 4096)),
+		moov,
+	}, nil)
+}
+
+// sampleHEIF is a HEIC image whose primary item is associated with the
+// second of two spatial extents, with Exif and XMP items in the media data.
+func sampleHEIF() []byte {
+	exif := append(u32(6), exifHeader...)
+	exif = append(exif, buildTIFF(binary.BigEndian, []tiffTag{
+		asciiTag(tagMake, "Apple"),
+		asciiTag(tagModel, "iPhone 15 Pro"),
+		shortTag(binary.BigEndian, tagOrientation, 6),
+	})...)
+	xmp := []byte(sampleXMP)
+
+	ftyp := box("ftyp", []byte("heic"), u32(0), []byte("mif1heic"))
+	meta := func(exifOffset, xmpOffset uint32) []byte {
+		infe := func(id uint16, typ string, extra string) []byte {
+			return fullBox("infe", 2, u16(id), u16(0), []byte(typ), []byte("\x00"+extra))
+		}
+		iloc := func(id uint16, offset, length uint32) []byte {
+			return bytes.Join([][]byte{u16(id), u16(0), u16(1), u32(offset), u32(length)}, nil)
+		}
+		ispe := func(w, h uint32) []byte {
+			return fullBox("ispe", 0, u32(w), u32(h))
+		}
+
+		return fullBox("meta", 0,
+			fullBox("hdlr", 0, make([]byte, 4), []byte("pict"), make([]byte, 13)),
+			fullBox("pitm", 0, u16(1)),
+			fullBox("iinf", 0, u16(3), infe(1, "hvc1", ""), infe(2, "Exif", ""), infe(3, "mime", "application/rdf+xml\x00")),
+			fullBox("iloc", 0, []byte{0x44, 0x00}, u16(3), iloc(1, 0, 0), iloc(2, exifOffset, uint32(len(exif))), iloc(3, xmpOffset, uint32(len(xmp)))),
+			box("iprp",
+				box("ipco", ispe(8000, 6000), ispe(4032, 3024)),
+				fullBox("ipma", 0, u32(1), u16(1), []byte{1, 0x82}),
+			),
+		)
+	}
+
+	exifOffset := uint32(len(ftyp) + len(meta(0, 0)) + 8)
+	xmpOffset := exifOffset + uint32(len(exif))
+	return bytes.Join([][]byte{ftyp, meta(exifOffset, xmpOffset), box("mdat", exif, xmp)}, nil)
+}
+
+func TestExtractISOBMFF(t *testing.T) {
+	heif := sampleXMPMetadata()
+	heif.Make, heif.Model, heif.Orientation = "Apple", "iPhone 15 Pro", 6
+	heif.Width, heif.Height = 4032, 3024
+
+	tests := []struct {
+		name        string
+		contentType string
+		data        []byte
+		want        *models.PhotoMetadata
+		wantErr     error
+	}{
+		{
+			name:        "HEIF",
+			contentType: "image/heic",
+			data:        sampleHEIF(),
+			want:        &heif,
+		},
+		{
+			name:        "MP4",
+			contentType: "video/mp4",
+			data:        sampleMP4(1),
+			want: &models.PhotoMetadata{
+				CapturedAt:  time.Date(2023, 5, 6, 5, 8, 9, 0, time.UTC),
+				Make:        "Apple",
+				Model:       "iPhone 15",
+				Width:       1920,
+				Height:      1080,
+				Orientation: 6,
+				Location:    &models.GeoLocation{Latitude: 48.8584, Longitude: 2.2945, Altitude: 35},
+				Duration:    10,
+			},
+		},
+		{
+			name:        "QuickTime",
+			contentType: "video/quicktime",
+			data:        sampleMP4(0),
+			want: &models.PhotoMetadata{
+				CapturedAt:  sampleMovieTime,
+				Make:        "Apple",
+				Model:       "iPhone 15",
+				Width:       1920,
+				Height:      1080,
+				Orientation: 6,
+				Location:    &models.GeoLocation{Latitude: -33.8688, Longitude: 151.2093},
+				Duration:    10,
+			},
+		},
+		{
+			name:        "truncated movie box",
+			contentType: "video/mp4",
+			data:        sampleMP4(1)[:4200],
+		},
+		{
+			name:        "movie box too large",
+			contentType: "video/mp4",
+			data:        append(box("ftyp", []byte("isom")), append(u32(0xFFFFFFF0), "moov"...)...),
+			wantErr:     errTooLarge,
+		},
+		{
+			name:        "box smaller than its header",
+			contentType: "video/mp4",
+			data:        append(u32(4), "ftyp"...),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractMetadata(bytes.NewReader(tt.data), tt.contentType)
+			if err != tt.wantErr {
+				t.Fatalf("ExtractMetadata error = %v, want %v", err, tt.wantErr)
+			}
+			equalMetadata(t, got, tt.want)
+		})
+	}
+}
+
+func TestParseISO6709(t *testing.T) {
+	tests := []struct {
+		value string
+		want  *models.GeoLocation
+	}{
+		{"+48.8584+002.2945/", &models.GeoLocation{Latitude: 48.8584, Longitude: 2.2945}},
+		{"-33.8688+151.2093+012.500/", &models.GeoLocation{Latitude: -33.8688, Longitude: 151.2093, Altitude: 12.5}},
+		{"+40.7128-074.0060-010.000/", &models.GeoLocation{Latitude: 40.7128, Longitude: -74.006, Altitude: -10}},
+		{"48.8584,2.2945", nil},
+		{"+48.8584", nil},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		got := parseISO6709(tt.value)
+		if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+			t.Errorf("parseISO6709(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
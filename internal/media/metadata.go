@@ -0,0 +1,307 @@
+package media
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"photosync-backend/internal/models"
+)
+
+// maxMetadataBlock caps how much of a file is read into memory for a single
+// metadata structure (PNG chunk, HEIF meta box, MP4 moov box).
+const maxMetadataBlock = 32 << 20
+
+var (
+	errTooLarge  = errors.New("metadata block too large")
+	errMalformed = errors.New("malformed file")
+)
+
+var xmpHeader = []byte("http://ns.adobe.com/xap/1.0/\x00")
+
+// ExtractMetadata reads capture, camera and location metadata from JPEG,
+// PNG, HEIF/AVIF and MP4/MOV files. Only the headers are read; r is seeked
+// past image and video data. It returns nil for other formats and for files
+// that carry no metadata.
+func ExtractMetadata(r io.ReadSeeker, contentType string) (*models.PhotoMetadata, error) {
+	meta := &models.PhotoMetadata{}
+	br := newBufferedSeeker(r)
+
+	var err error
+	switch contentType {
+	case "image/jpeg":
+		err = extractJPEG(br, meta)
+	case "image/png":
+		err = extractPNG(br, meta)
+	case "image/heic", "image/heif", "image/avif", "image/heic-sequence", "image/heif-sequence",
+		"video/mp4", "video/quicktime", "video/x-m4v", "video/3gpp", "video/3gpp2":
+		err = extractISOBMFF(br, meta)
+	default:
+		return nil, nil
+	}
+	// Truncated and malformed files yield whatever was found before the
+	// damage; only failures to read the file are reported.
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, errNoExif) && !errors.Is(err, errMalformed) {
+		return nil, err
+	}
+
+	if *meta == (models.PhotoMetadata{}) {
+		return nil, nil
+	}
+	return meta, nil
+}
+
+func extractJPEG(r io.Reader, meta *models.PhotoMetadata) error {
+	var xmp []byte
+	err := jpegSegments(r, func(marker byte, data []byte) bool {
+		switch {
+		case marker == 0xE1 && bytes.HasPrefix(data, exifHeader):
+			parseExif(data[len(exifHeader):], meta)
+		case marker == 0xE1 && bytes.HasPrefix(data, xmpHeader):
+			xmp = data[len(xmpHeader):]
+		case isSOF(marker) && len(data) >= 5:
+			if meta.Width == 0 {
+				meta.Height = int(binary.BigEndian.Uint16(data[1:3]))
+				meta.Width = int(binary.BigEndian.Uint16(data[3:5]))
+			}
+		}
+		return true
+	})
+
+	if xmp != nil {
+		parseXMP(xmp, meta)
+	}
+	return err
+}
+
+func isSOF(marker byte) bool {
+	return marker >= 0xC0 && marker <= 0xCF && marker != 0xC4 && marker != 0xC8 && marker != 0xCC
+}
+
+// extractPNG reads the chunks before the image data. eXIf must precede IDAT;
+// XMP stored after the image data is not looked for.
+func extractPNG(r io.ReadSeeker, meta *models.PhotoMetadata) error {
+	var signature [8]byte
+	if _, err := io.ReadFull(r, signature[:]); err != nil || string(signature[:]) != "\x89PNG\r\n\x1a\n" {
+		return nil
+	}
+
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return err
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		typ := string(header[4:8])
+
+		switch typ {
+		case "IDAT", "IEND":
+			return nil
+		case "IHDR", "eXIf", "iTXt":
+			if length > maxMetadataBlock {
+				return errTooLarge
+			}
+			data := make([]byte, length)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return err
+			}
+			parsePNGChunk(typ, data, meta)
+			length = 0
+		}
+
+		if _, err := r.Seek(length+4, io.SeekCurrent); err != nil {
+			return err
+		}
+	}
+}
+
+func parsePNGChunk(typ string, data []byte, meta *models.PhotoMetadata) {
+	switch typ {
+	case "IHDR":
+		if len(data) >= 8 {
+			meta.Width = int(binary.BigEndian.Uint32(data[0:4]))
+			meta.Height = int(binary.BigEndian.Uint32(data[4:8]))
+		}
+	case "eXIf":
+		parseExif(data, meta)
+	case "iTXt":
+		// keyword \0 compressed method language \0 translated keyword \0 text
+		keyword, rest, ok := bytes.Cut(data, []byte{0})
+		if !ok || string(keyword) != "XML:com.adobe.xmp" || len(rest) < 2 {
+			return
+		}
+		compressed := rest[0] == 1
+		parts := bytes.SplitN(rest[2:], []byte{0}, 3)
+		if len(parts) != 3 {
+			return
+		}
+		text := parts[2]
+		if compressed {
+			zr, err := zlib.NewReader(bytes.NewReader(text))
+			if err != nil {
+				return
+			}
+			defer zr.Close()
+			if text, err = io.ReadAll(io.LimitReader(zr, maxMetadataBlock)); err != nil {
+				return
+			}
+		}
+		parseXMP(text, meta)
+	}
+}
+
+var xmpDateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// parseXMP fills fields EXIF did not provide from the common XMP properties.
+// Properties may be written as attributes or as elements.
+func parseXMP(data []byte, meta *models.PhotoMetadata) {
+	if meta.CapturedAt.IsZero() {
+		for _, name := range []string{"exif:DateTimeOriginal", "photoshop:DateCreated", "xmp:CreateDate"} {
+			if t := parseXMPDate(xmpValue(data, name)); !t.IsZero() {
+				meta.CapturedAt = t
+				break
+			}
+		}
+	}
+
+	setString(&meta.Make, xmpValue(data, "tiff:Make"))
+	setString(&meta.Model, xmpValue(data, "tiff:Model"))
+	setString(&meta.Lens, xmpValue(data, "exifEX:LensModel"))
+	setString(&meta.Lens, xmpValue(data, "aux:Lens"))
+
+	if meta.Location == nil {
+		lat, ok1 := parseXMPCoordinate(xmpValue(data, "exif:GPSLatitude"))
+		lon, ok2 := parseXMPCoordinate(xmpValue(data, "exif:GPSLongitude"))
+		if ok1 && ok2 {
+			meta.Location = &models.GeoLocation{Latitude: lat, Longitude: lon}
+		}
+	}
+}
+
+// xmpProperties holds a pattern for each property parseXMP reads, matching
+// it as an attribute or as an element.
+var xmpProperties = compileXMPProperties(
+	"exif:DateTimeOriginal", "photoshop:DateCreated", "xmp:CreateDate",
+	"tiff:Make", "tiff:Model", "exifEX:LensModel", "aux:Lens",
+	"exif:GPSLatitude", "exif:GPSLongitude",
+)
+
+func compileXMPProperties(names ...string) map[string]*regexp.Regexp {
+	patterns := make(map[string]*regexp.Regexp, len(names))
+	for _, name := range names {
+		quoted := regexp.QuoteMeta(name)
+		patterns[name] = regexp.MustCompile(quoted + `\s*=\s*"([^"]*)"|<` + quoted + `>([^<]*)</` + quoted + `>`)
+	}
+	return patterns
+}
+
+// xmpValue returns the value of name, which must be one of xmpProperties.
+func xmpValue(data []byte, name string) string {
+	m := xmpProperties[name].FindSubmatch(data)
+	if m == nil {
+		return ""
+	}
+	return strings.TrimSpace(string(append(m[1], m[2]...)))
+}
+
+func parseXMPDate(value string) time.Time {
+	for _, layout := range xmpDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// parseXMPCoordinate parses the XMP GPS form "DDD,MM.mmk" or "DDD,MM,SSk"
+// where k is N, S, E or W.
+func parseXMPCoordinate(value string) (float64, bool) {
+	if len(value) < 2 {
+		return 0, false
+	}
+
+	ref := value[len(value)-1]
+	parts := strings.Split(value[:len(value)-1], ",")
+	if len(parts) > 3 {
+		return 0, false
+	}
+
+	var result float64
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, false
+		}
+		result += v / []float64{1, 60, 3600}[i]
+	}
+
+	switch ref {
+	case 'S', 'W':
+		return -result, true
+	case 'N', 'E':
+		return result, true
+	}
+	return 0, false
+}
+
+// bufferedSeeker adds read buffering to a seekable source without losing
+// seekability, so parsing headers issues a few large reads against the
+// storage backend rather than many tiny ones.
+type bufferedSeeker struct {
+	rs  io.ReadSeeker
+	br  *bufio.Reader
+	pos int64
+}
+
+func newBufferedSeeker(rs io.ReadSeeker) *bufferedSeeker {
+	return &bufferedSeeker{rs: rs, br: bufio.NewReaderSize(rs, 64<<10)}
+}
+
+func (b *bufferedSeeker) Read(p []byte) (int, error) {
+	n, err := b.br.Read(p)
+	b.pos += int64(n)
+	return n, err
+}
+
+func (b *bufferedSeeker) Seek(offset int64, whence int) (int64, error) {
+	var target int64
+	switch whence {
+	case io.SeekStart:
+		target = offset
+	case io.SeekCurrent:
+		target = b.pos + offset
+	default:
+		abs, err := b.rs.Seek(offset, whence)
+		if err != nil {
+			return 0, err
+		}
+		b.br.Reset(b.rs)
+		b.pos = abs
+		return abs, nil
+	}
+
+	if ahead := target - b.pos; ahead >= 0 && ahead <= int64(b.br.Buffered()) {
+		b.br.Discard(int(ahead))
+		b.pos = target
+		return target, nil
+	}
+
+	if _, err := b.rs.Seek(target, io.SeekStart); err != nil {
+		return 0, err
+	}
+	b.br.Reset(b.rs)
+	b.pos = target
+	return target, nil
+}
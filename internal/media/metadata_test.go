@@ -0,0 +1,257 @@
+package media
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"math"
+	"testing"
+	"time"
+
+	"photosync-backend/internal/models"
+)
+
+const sampleXMP = `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF><rdf:Description
+ xmp:CreateDate="2021-03-04T05:06:07+01:00"
+ tiff:Make="Sony"
+ exif:GPSLatitude="48,51.5N" exif:GPSLongitude="2,17,24W">
+ <tiff:Model> ILCE-7M3 </tiff:Model>
+ <aux:Lens>FE 35mm F1.8</aux:Lens>
+</rdf:Description></rdf:RDF></x:xmpmeta>`
+
+func sampleXMPMetadata() models.PhotoMetadata {
+	return models.PhotoMetadata{
+		CapturedAt: time.Date(2021, 3, 4, 4, 6, 7, 0, time.UTC),
+		Make:       "Sony",
+		Model:      "ILCE-7M3",
+		Lens:       "FE 35mm F1.8",
+		Location:   &models.GeoLocation{Latitude: 48 + 51.5/60, Longitude: -(2 + 17.0/60 + 24.0/3600)},
+	}
+}
+
+func TestParseXMP(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		start models.PhotoMetadata
+		want  models.PhotoMetadata
+	}{
+		{name: "attributes and elements", data: sampleXMP, want: sampleXMPMetadata()},
+		{
+			name:  "EXIF values win",
+			data:  sampleXMP,
+			start: models.PhotoMetadata{CapturedAt: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), Make: "Canon", Location: &models.GeoLocation{Latitude: 1}},
+			want: models.PhotoMetadata{
+				CapturedAt: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+				Make:       "Canon",
+				Model:      "ILCE-7M3",
+				Lens:       "FE 35mm F1.8",
+				Location:   &models.GeoLocation{Latitude: 1},
+			},
+		},
+		{
+			name: "DateTimeOriginal before CreateDate",
+			data: `<d xmp:CreateDate="2021-01-01" exif:DateTimeOriginal="2020-06-07T08:09:10"/>`,
+			want: models.PhotoMetadata{CapturedAt: time.Date(2020, 6, 7, 8, 9, 10, 0, time.UTC)},
+		},
+		{
+			name: "unparsable date falls through",
+			data: `<d exif:DateTimeOriginal="last summer" photoshop:DateCreated="2019-02-03T04:05"/>`,
+			want: models.PhotoMetadata{CapturedAt: time.Date(2019, 2, 3, 4, 5, 0, 0, time.UTC)},
+		},
+		{
+			name: "lens model before aux lens",
+			data: `<d exifEX:LensModel="EF 24-70" aux:Lens="other"/>`,
+			want: models.PhotoMetadata{Lens: "EF 24-70"},
+		},
+		{
+			name: "latitude without longitude",
+			data: `<d exif:GPSLatitude="48,51.5N"/>`,
+		},
+		{name: "empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta := tt.start
+			parseXMP([]byte(tt.data), &meta)
+			equalMetadata(t, &meta, &tt.want)
+		})
+	}
+}
+
+func TestParseXMPCoordinate(t *testing.T) {
+	tests := []struct {
+		value  string
+		want   float64
+		wantOK bool
+	}{
+		{"48,51.5N", 48 + 51.5/60, true},
+		{"48,51,30S", -(48 + 51.0/60 + 30.0/3600), true},
+		{"2.5E", 2.5, true},
+		{"122,25.2W", -(122 + 25.2/60), true},
+		{"48,51.5", 0, false},
+		{"48,51.5X", 0, false},
+		{"a,bN", 0, false},
+		{"1,2,3,4N", 0, false},
+		{"N", 0, false},
+		{"", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseXMPCoordinate(tt.value)
+		if ok != tt.wantOK || math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("parseXMPCoordinate(%q) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func pngChunk(typ string, data []byte) []byte {
+	out := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	out = append(out, typ...)
+	out = append(out, data...)
+	return binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(append([]byte(typ), data...)))
+}
+
+func xmpITXt(compressed bool) []byte {
+	text := []byte(sampleXMP)
+	flag := byte(0)
+	if compressed {
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		zw.Write(text)
+		zw.Close()
+		text, flag = buf.Bytes(), 1
+	}
+	data := append([]byte("XML:com.adobe.xmp\x00"), flag, 0)
+	data = append(data, "\x00\x00"...)
+	return pngChunk("iTXt", append(data, text...))
+}
+
+func samplePNG(chunks ...[]byte) []byte {
+	ihdr := binary.BigEndian.AppendUint32(nil, 640)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, 480)
+	ihdr = append(ihdr, 8, 2, 0, 0, 0)
+
+	out := []byte("\x89PNG\r\n\x1a\n")
+	out = append(out, pngChunk("IHDR", ihdr)...)
+	for _, chunk := range chunks {
+		out = append(out, chunk...)
+	}
+	out = append(out, pngChunk("IDAT", []byte{1, 2, 3})...)
+	return append(out, pngChunk("IEND", nil)...)
+}
+
+func TestExtractMetadata(t *testing.T) {
+	le := binary.LittleEndian
+
+	exifJPEG := sampleMetadata()
+	xmpPNG := sampleXMPMetadata()
+	xmpPNG.Width, xmpPNG.Height = 640, 480
+	exifPNG := sampleMetadata()
+	exifPNG.Width, exifPNG.Height = 640, 480
+
+	tests := []struct {
+		name        string
+		contentType string
+		data        []byte
+		want        *models.PhotoMetadata
+	}{
+		{
+			name:        "JPEG with EXIF",
+			contentType: "image/jpeg",
+			data:        sampleJPEG(jpegSegment(0xE0, []byte("JFIF\x00")), jpegSegment(0xE1, append(exifHeader, sampleExif(le)...)), sof(100, 50)),
+			want:        &exifJPEG,
+		},
+		{
+			name:        "JPEG dimensions from SOF",
+			contentType: "image/jpeg",
+			data:        sampleJPEG(sof(4032, 3024), sof(160, 120)),
+			want:        &models.PhotoMetadata{Width: 4032, Height: 3024},
+		},
+		{
+			name:        "JPEG with XMP",
+			contentType: "image/jpeg",
+			data:        sampleJPEG(jpegSegment(0xE1, append(xmpHeader, sampleXMP...))),
+			want:        func() *models.PhotoMetadata { m := sampleXMPMetadata(); return &m }(),
+		},
+		{
+			name:        "JPEG truncated after EXIF",
+			contentType: "image/jpeg",
+			data: func() []byte {
+				data := sampleJPEG(jpegSegment(0xE1, append(exifHeader, buildTIFF(le, []tiffTag{asciiTag(tagMake, "Nikon")})...)), sof(100, 50))
+				return data[:len(data)-20]
+			}(),
+			want: &models.PhotoMetadata{Make: "Nikon"},
+		},
+		{
+			name:        "JPEG without metadata",
+			contentType: "image/jpeg",
+			data:        sampleJPEG(),
+		},
+		{
+			name:        "PNG with XMP",
+			contentType: "image/png",
+			data:        samplePNG(xmpITXt(false)),
+			want:        &xmpPNG,
+		},
+		{
+			name:        "PNG with compressed XMP",
+			contentType: "image/png",
+			data:        samplePNG(xmpITXt(true)),
+			want:        &xmpPNG,
+		},
+		{
+			name:        "PNG with eXIf",
+			contentType: "image/png",
+			data:        samplePNG(pngChunk("tEXt", []byte("Software\x00test")), pngChunk("eXIf", sampleExif(le))),
+			want:        &exifPNG,
+		},
+		{
+			name:        "PNG metadata after image data",
+			contentType: "image/png",
+			data:        append(samplePNG()[:len(samplePNG())-12], append(xmpITXt(false), pngChunk("IEND", nil)...)...),
+			want:        &models.PhotoMetadata{Width: 640, Height: 480},
+		},
+		{
+			name:        "not a PNG",
+			contentType: "image/png",
+			data:        []byte("GIF89a"),
+		},
+		{
+			name:        "unsupported type",
+			contentType: "image/gif",
+			data:        sampleJPEG(jpegSegment(0xE1, append(exifHeader, sampleExif(le)...))),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractMetadata(bytes.NewReader(tt.data), tt.contentType)
+			if err != nil {
+				t.Fatalf("ExtractMetadata error = %v", err)
+			}
+			equalMetadata(t, got, tt.want)
+		})
+	}
+}
+
+// FuzzExtractMetadata checks that no input makes the parsers panic or
+// report anything but a read failure, which bytes.Reader never causes.
+func FuzzExtractMetadata(f *testing.F) {
+	le, be := binary.LittleEndian, binary.BigEndian
+
+	f.Add("image/jpeg", sampleJPEG(jpegSegment(0xE1, append(exifHeader, sampleExif(le)...)), sof(100, 50)))
+	f.Add("image/jpeg", sampleJPEG(jpegSegment(0xE1, append(exifHeader, sampleExif(be)...)), jpegSegment(0xE1, append(xmpHeader, sampleXMP...))))
+	f.Add("image/png", samplePNG(xmpITXt(true), pngChunk("eXIf", sampleExif(be))))
+	f.Add("image/heic", sampleHEIF())
+	f.Add("video/mp4", sampleMP4(1))
+	f.Add("video/quicktime", sampleMP4(0))
+
+	f.Fuzz(func(t *testing.T, contentType string, data []byte) {
+		if _, err := ExtractMetadata(bytes.NewReader(data), contentType); err != nil && err != errTooLarge {
+			t.Fatalf("ExtractMetadata error = %v", err)
+		}
+	})
+}
@@ -10,9 +10,38 @@ type UserInfo struct {
 }
 
 type FileInfo struct {
-	Name    string    `json:"name"`
-	Size    int64     `json:"size"`
-	ModTime time.Time `json:"mod_time"`
+	Name        string         `json:"name"`
+	Size        int64          `json:"size"`
+	ModTime     time.Time      `json:"mod_time"`
+	ContentType string         `json:"content_type,omitempty"`
+	SHA256      string         `json:"sha256,omitempty"`
+	Metadata    *PhotoMetadata `json:"metadata,omitempty"`
+	IsDir       bool           `json:"is_dir,omitempty"`
+}
+
+// PhotoMetadata is what could be read from a file's EXIF, XMP or container
+// headers. CapturedAt carries the camera's UTC offset when it recorded one and
+// is otherwise the camera's wall clock time in UTC.
+type PhotoMetadata struct {
+	CapturedAt   time.Time    `json:"captured_at,omitzero"`
+	Make         string       `json:"make,omitempty"`
+	Model        string       `json:"model,omitempty"`
+	Lens         string       `json:"lens,omitempty"`
+	Width        int          `json:"width,omitempty"`
+	Height       int          `json:"height,omitempty"`
+	Orientation  int          `json:"orientation,omitempty"`
+	Location     *GeoLocation `json:"location,omitempty"`
+	ExposureTime string       `json:"exposure_time,omitempty"`
+	FNumber      float64      `json:"f_number,omitempty"`
+	ISO          int          `json:"iso,omitempty"`
+	FocalLength  float64      `json:"focal_length,omitempty"`
+	Duration     float64      `json:"duration,omitempty"`
+}
+
+type GeoLocation struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Altitude  float64 `json:"altitude,omitempty"`
 }
 
 type LoginRequest struct {
@@ -20,9 +49,35 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+// LoginResponse carries a short-lived access token and the refresh token
+// that replaces it. Times are Unix seconds.
 type LoginResponse struct {
-	Token     string `json:"token"`
-	ExpiresAt int64  `json:"expires_at"`
+	Token            string `json:"token"`
+	ExpiresAt        int64  `json:"expires_at"`
+	RefreshToken     string `json:"refresh_token"`
+	RefreshExpiresAt int64  `json:"refresh_expires_at"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// OAuth2TokenRequest redeems the one-time code an OAuth2 sign-in handed to
+// the app. CodeVerifier is the PKCE verifier of the challenge the app sent
+// when it started the sign-in.
+type OAuth2TokenRequest struct {
+	Code         string `json:"code"`
+	CodeVerifier string `json:"code_verifier"`
+}
+
+// SessionInfo describes one logged-in device of the user.
+type SessionInfo struct {
+	ID          string    `json:"id"`
+	Device      string    `json:"device,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	RefreshedAt time.Time `json:"refreshed_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	Current     bool      `json:"current"`
 }
 
 type ErrorResponse struct {
@@ -31,6 +86,126 @@ type ErrorResponse struct {
 }
 
 type UploadResponse struct {
-	Message  string `json:"message"`
-	Filename string `json:"filename"`
+	Message          string `json:"message"`
+	Filename         string `json:"filename"`
+	OriginalFilename string `json:"original_filename,omitempty"`
+}
+
+type SyncChange struct {
+	Op          string    `json:"op"`
+	Name        string    `json:"name"`
+	Size        int64     `json:"size,omitempty"`
+	ModTime     time.Time `json:"mod_time,omitzero"`
+	ContentType string    `json:"content_type,omitempty"`
+}
+
+type SyncResponse struct {
+	Cursor  string       `json:"cursor"`
+	Reset   bool         `json:"reset"`
+	HasMore bool         `json:"has_more"`
+	Changes []SyncChange `json:"changes"`
+}
+
+type HashCheckRequest struct {
+	SHA256 []string `json:"sha256"`
+}
+
+type HashCheckResponse struct {
+	Existing map[string]string `json:"existing"`
+	Missing  []string          `json:"missing"`
+}
+
+type TimelineBucket struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+}
+
+type TimelineResponse struct {
+	Group   string           `json:"group"`
+	Total   int              `json:"total"`
+	Buckets []TimelineBucket `json:"buckets"`
+}
+
+type Album struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Cover       string    `json:"cover,omitempty"`
+	Photos      []string  `json:"photos"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// AlbumRequest creates or updates an album. Fields left out of an update are
+// unchanged; Photos replaces the album's photos and their order.
+type AlbumRequest struct {
+	Name        *string  `json:"name"`
+	Description *string  `json:"description"`
+	Cover       *string  `json:"cover"`
+	Photos      []string `json:"photos"`
+}
+
+type AlbumPhotosRequest struct {
+	Photos   []string `json:"photos"`
+	Position *int     `json:"position"`
+}
+
+type AlbumResponse struct {
+	Album
+	Files []FileInfo `json:"files"`
+}
+
+// MoveRequest names the destination of a move or copy. An existing file at
+// To is only replaced with Overwrite.
+type MoveRequest struct {
+	To        string `json:"to"`
+	Overwrite bool   `json:"overwrite"`
+}
+
+// BatchRequest applies one operation to many photos. Items name photos by
+// path, or by trash ID for restore. To is an item's destination, required
+// for a move unless Folder is set and optional for a restore.
+type BatchRequest struct {
+	Op        string      `json:"op"`
+	Items     []BatchItem `json:"items"`
+	Folder    *string     `json:"folder"`
+	Overwrite bool        `json:"overwrite"`
+}
+
+type BatchItem struct {
+	ID string `json:"id"`
+	To string `json:"to,omitempty"`
+}
+
+// BatchResult is the outcome of one item, with the HTTP status the single
+// photo endpoint would have answered.
+type BatchResult struct {
+	ID     string    `json:"id"`
+	Status int       `json:"status"`
+	Error  string    `json:"error,omitempty"`
+	File   *FileInfo `json:"file,omitempty"`
+}
+
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+}
+
+// TrashItem is a deleted file. ID addresses it in the trash endpoints; Name
+// is the path it was deleted from.
+type TrashItem struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type,omitempty"`
+	DeletedAt   time.Time `json:"deleted_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+type FolderRequest struct {
+	Path string `json:"path"`
+}
+
+type FolderResponse struct {
+	Path    string     `json:"path"`
+	Entries []FileInfo `json:"entries"`
 }
@@ -0,0 +1,518 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var ErrNotFound = errors.New("session not found")
+
+var (
+	bucketSessions = []byte("sessions")
+	bucketRevoked  = []byte("revoked")
+)
+
+// Session is a logged-in device. The directory password is needed to open
+// storage connections on the user's behalf, so it is kept here, encrypted,
+// rather than in the bearer token.
+type Session struct {
+	ID           string    `json:"-"`
+	Username     string    `json:"username"`
+	Device       string    `json:"device,omitempty"`
+	Password     []byte    `json:"password"`
+	KeyID        string    `json:"key_id,omitempty"`
+	RefreshHash  []byte    `json:"refresh_hash"`
+	PreviousHash []byte    `json:"previous_hash,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	RefreshedAt  time.Time `json:"refreshed_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// Key is an AES-256 key passwords are sealed with, named by ID.
+type Key struct {
+	ID  string
+	Key []byte
+}
+
+// Store is the server-side credential vault: an embedded bbolt database of
+// sessions keyed by ID, with passwords sealed by AES-GCM under the current
+// encryption key. Passwords sealed under an older key are still opened, and
+// are sealed again under the current key at startup and at every cleanup,
+// after which the older key can be dropped. Sessions are wiped on logout and
+// once they expire.
+//
+// Each session has one valid refresh token at a time, stored as a SHA-256
+// hash and replaced on every refresh. Presenting the previous token again
+// means it was copied, so the session is revoked. Revoked session IDs are
+// kept on a revocation list, mirrored in memory, until the session would
+// have expired, so that access tokens already issued for them are refused.
+type Store struct {
+	db      *bolt.DB
+	keys    map[string]cipher.AEAD
+	current string
+	expiry  time.Duration
+
+	mu      sync.RWMutex
+	revoked map[string]time.Time
+
+	stop    sync.Once
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// Open opens the store at path, sealing passwords with the first of keys.
+// Sessions expire when they have not been refreshed for expiry.
+func Open(path string, keys []Key, expiry time.Duration) (*Store, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no encryption key")
+	}
+	aeads := make(map[string]cipher.AEAD)
+	for _, key := range keys {
+		block, err := aes.NewCipher(key.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key %s: %w", key.ID, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		aeads[key.ID] = aead
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open session store: %w", err)
+	}
+
+	revoked := make(map[string]time.Time)
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(bucketSessions); err != nil {
+			return err
+		}
+		bucket, err := tx.CreateBucketIfNotExists(bucketRevoked)
+		if err != nil {
+			return err
+		}
+		return bucket.ForEach(func(id, data []byte) error {
+			var until time.Time
+			if err := until.UnmarshalText(data); err != nil {
+				return err
+			}
+			revoked[string(id)] = until
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialise session store: %w", err)
+	}
+
+	store := &Store{db: db, keys: aeads, current: keys[0].ID, expiry: expiry, revoked: revoked, done: make(chan struct{}), stopped: make(chan struct{})}
+
+	go store.cleanup()
+
+	return store, nil
+}
+
+// Close stops the cleanup, waiting for a run in progress, and closes the
+// database.
+func (s *Store) Close() error {
+	s.stop.Do(func() { close(s.done) })
+	<-s.stopped
+	return s.db.Close()
+}
+
+// Create starts a session for username on device and returns it with its
+// first refresh token.
+func (s *Store) Create(username, password, device string) (*Session, string, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate session id: %w", err)
+	}
+
+	sealed, err := s.seal(id, password)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encrypt password: %w", err)
+	}
+
+	secret, err := newID()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	now := time.Now()
+	sess := &Session{
+		ID:          id,
+		Username:    username,
+		Device:      device,
+		Password:    sealed,
+		KeyID:       s.current,
+		RefreshHash: hashSecret(secret),
+		CreatedAt:   now,
+		RefreshedAt: now,
+		ExpiresAt:   now.Add(s.expiry),
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return putSession(tx, sess)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return sess, id + "." + secret, nil
+}
+
+// Refresh exchanges a refresh token for a new one and extends the session.
+// The presented token stops working; presenting it again revokes the
+// session.
+func (s *Store) Refresh(token string) (*Session, string, error) {
+	id, secret, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, "", ErrNotFound
+	}
+
+	next, err := newID()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	var sess *Session
+	reused := false
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		sess, err = getSession(tx, id)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		hash := hashSecret(secret)
+		switch {
+		case now.After(sess.ExpiresAt):
+			return ErrNotFound
+		case subtle.ConstantTimeCompare(hash, sess.RefreshHash) == 1:
+			sess.PreviousHash = sess.RefreshHash
+			sess.RefreshHash = hashSecret(next)
+			sess.RefreshedAt = now
+			sess.ExpiresAt = now.Add(s.expiry)
+			return putSession(tx, sess)
+		case subtle.ConstantTimeCompare(hash, sess.PreviousHash) == 1:
+			reused = true
+			return revoke(tx, sess)
+		default:
+			return ErrNotFound
+		}
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if reused {
+		log.Printf("Sessions: Refresh token of a session of user %s was reused, session revoked", sess.Username)
+		s.markRevoked(sess.ID, sess.ExpiresAt)
+		return nil, "", ErrNotFound
+	}
+	return sess, id + "." + next, nil
+}
+
+// Get returns the session id, or ErrNotFound once it has been revoked or has
+// expired.
+func (s *Store) Get(id string) (*Session, error) {
+	var sess *Session
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		sess, err = getSession(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(sess.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+	return sess, nil
+}
+
+// List returns the live sessions of username.
+func (s *Store) List(username string) ([]*Session, error) {
+	now := time.Now()
+	var sessions []*Session
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketSessions).ForEach(func(id, data []byte) error {
+			sess := &Session{ID: string(id)}
+			if err := json.Unmarshal(data, sess); err != nil {
+				return err
+			}
+			if sess.Username == username && now.Before(sess.ExpiresAt) {
+				sessions = append(sessions, sess)
+			}
+			return nil
+		})
+	})
+	return sessions, err
+}
+
+// Password returns the decrypted directory password of the session id.
+func (s *Store) Password(id string) (string, error) {
+	sess, err := s.Get(id)
+	if err != nil {
+		return "", err
+	}
+	return s.open(sess)
+}
+
+// Revoke ends the session id of username, wiping its credentials, and puts
+// it on the revocation list.
+func (s *Store) Revoke(username, id string) error {
+	var sess *Session
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		sess, err = getSession(tx, id)
+		if err != nil {
+			return err
+		}
+		if sess.Username != username {
+			return ErrNotFound
+		}
+		return revoke(tx, sess)
+	})
+	if err != nil {
+		return err
+	}
+	s.markRevoked(id, sess.ExpiresAt)
+	return nil
+}
+
+// Revoked reports whether the session id is on the revocation list.
+func (s *Store) Revoked(id string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.revoked[id]
+	return ok
+}
+
+// Delete removes a session that was never handed out.
+func (s *Store) Delete(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketSessions).Delete([]byte(id))
+	})
+}
+
+func (s *Store) markRevoked(id string, until time.Time) {
+	s.mu.Lock()
+	s.revoked[id] = until
+	s.mu.Unlock()
+}
+
+func getSession(tx *bolt.Tx, id string) (*Session, error) {
+	data := tx.Bucket(bucketSessions).Get([]byte(id))
+	if data == nil {
+		return nil, ErrNotFound
+	}
+
+	sess := &Session{ID: id}
+	if err := json.Unmarshal(data, sess); err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
+
+func putSession(tx *bolt.Tx, sess *Session) error {
+	data, err := json.Marshal(sess)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(bucketSessions).Put([]byte(sess.ID), data)
+}
+
+func revoke(tx *bolt.Tx, sess *Session) error {
+	until, err := sess.ExpiresAt.MarshalText()
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(bucketRevoked).Put([]byte(sess.ID), until); err != nil {
+		return err
+	}
+	return tx.Bucket(bucketSessions).Delete([]byte(sess.ID))
+}
+
+// seal encrypts password under the current key, binding it to the session
+// id so that a sealed password cannot be moved to another session.
+func (s *Store) seal(id, password string) ([]byte, error) {
+	aead := s.keys[s.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, []byte(password), []byte(id)), nil
+}
+
+func (s *Store) open(sess *Session) (string, error) {
+	keyID := sess.KeyID
+	if keyID == "" {
+		keyID = "default"
+	}
+	aead, ok := s.keys[keyID]
+	if !ok {
+		return "", fmt.Errorf("unknown encryption key %q", keyID)
+	}
+
+	nonceSize := aead.NonceSize()
+	if len(sess.Password) < nonceSize {
+		return "", fmt.Errorf("ciphertext too short")
+	}
+
+	plaintext, err := aead.Open(nil, sess.Password[:nonceSize], sess.Password[nonceSize:], []byte(sess.ID))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func (s *Store) cleanup() {
+	defer close(s.stopped)
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		removed, err := s.removeExpired()
+		if err != nil {
+			log.Printf("Sessions: Cleanup failed: %v", err)
+		}
+		if removed > 0 {
+			log.Printf("Sessions: Removed %d expired sessions", removed)
+		}
+
+		resealed, err := s.reseal()
+		if err != nil {
+			log.Printf("Sessions: Re-encryption failed: %v", err)
+		}
+		if resealed > 0 {
+			log.Printf("Sessions: Re-encrypted %d sessions with key %s", resealed, s.current)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// reseal seals passwords sealed under an older key again under the current
+// one.
+func (s *Store) reseal() (int, error) {
+	resealed := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var stale []*Session
+		err := tx.Bucket(bucketSessions).ForEach(func(id, data []byte) error {
+			sess := &Session{ID: string(id)}
+			if err := json.Unmarshal(data, sess); err != nil {
+				return nil
+			}
+			if sess.KeyID != s.current {
+				stale = append(stale, sess)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, sess := range stale {
+			password, err := s.open(sess)
+			if err != nil {
+				log.Printf("Sessions: Cannot re-encrypt a session of user %s: %v", sess.Username, err)
+				continue
+			}
+			if sess.Password, err = s.seal(sess.ID, password); err != nil {
+				return err
+			}
+			sess.KeyID = s.current
+			if err := putSession(tx, sess); err != nil {
+				return err
+			}
+			resealed++
+		}
+		return nil
+	})
+	return resealed, err
+}
+
+// removeExpired deletes expired sessions, and revocations of sessions that
+// would have expired by now.
+func (s *Store) removeExpired() (int, error) {
+	now := time.Now()
+	var expired, lapsed []string
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		sessions := tx.Bucket(bucketSessions)
+		err := sessions.ForEach(func(id, data []byte) error {
+			var sess Session
+			if err := json.Unmarshal(data, &sess); err != nil || !now.Before(sess.ExpiresAt) {
+				expired = append(expired, string(id))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range expired {
+			if err := sessions.Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+
+		revoked := tx.Bucket(bucketRevoked)
+		err = revoked.ForEach(func(id, data []byte) error {
+			var until time.Time
+			if err := until.UnmarshalText(data); err != nil || !now.Before(until) {
+				lapsed = append(lapsed, string(id))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range lapsed {
+			if err := revoked.Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	for _, id := range lapsed {
+		delete(s.revoked, id)
+	}
+	s.mu.Unlock()
+	return len(expired), nil
+}
+
+func hashSecret(secret string) []byte {
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
+
+func newID() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
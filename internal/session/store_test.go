@@ -0,0 +1,201 @@
+package session
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testKey(id string, b byte) Key {
+	return Key{ID: id, Key: bytes.Repeat([]byte{b}, 32)}
+}
+
+func openStore(t *testing.T, path string, expiry time.Duration, keys ...Key) *Store {
+	t.Helper()
+
+	s, err := Open(path, keys, expiry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestRefresh(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	s := openStore(t, path, time.Hour, testKey("a", 1))
+
+	sess, first, err := s.Create("alice", "secret", "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, second, err := s.Refresh(first)
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	_, third, err := s.Refresh(second)
+	if err != nil {
+		t.Fatalf("second Refresh: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "older token", token: first},
+		{name: "no separator", token: sess.ID},
+		{name: "wrong secret", token: sess.ID + ".nope"},
+		{name: "unknown session", token: "nope.secret"},
+	}
+	for _, tt := range tests {
+		if _, _, err := s.Refresh(tt.token); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Refresh with %s: error = %v, want ErrNotFound", tt.name, err)
+		}
+	}
+	if s.Revoked(sess.ID) {
+		t.Fatal("session revoked by a token that was never its previous one")
+	}
+
+	if _, _, err := s.Refresh(third); err != nil {
+		t.Fatalf("Refresh with the current token: %v", err)
+	}
+}
+
+func TestRefreshReuse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	s := openStore(t, path, time.Hour, testKey("a", 1))
+
+	sess, first, err := s.Create("alice", "secret", "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, second, err := s.Refresh(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The replaced token turning up again means it was copied.
+	if _, _, err := s.Refresh(first); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Refresh with the previous token: error = %v, want ErrNotFound", err)
+	}
+	if !s.Revoked(sess.ID) {
+		t.Error("session not revoked after reuse")
+	}
+	if _, _, err := s.Refresh(second); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Refresh with the current token after reuse: error = %v, want ErrNotFound", err)
+	}
+	if _, err := s.Password(sess.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Password after reuse: error = %v, want ErrNotFound", err)
+	}
+
+	s.Close()
+	s = openStore(t, path, time.Hour, testKey("a", 1))
+	if !s.Revoked(sess.ID) {
+		t.Error("revocation not kept across restarts")
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	s := openStore(t, filepath.Join(t.TempDir(), "sessions.db"), -time.Second, testKey("a", 1))
+
+	sess, token, err := s.Create("alice", "secret", "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Get(sess.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get: error = %v, want ErrNotFound", err)
+	}
+	if _, _, err := s.Refresh(token); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Refresh: error = %v, want ErrNotFound", err)
+	}
+	if sessions, err := s.List("alice"); err != nil || len(sessions) != 0 {
+		t.Errorf("List = %d sessions, %v, want none", len(sessions), err)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	s := openStore(t, filepath.Join(t.TempDir(), "sessions.db"), time.Hour, testKey("a", 1))
+
+	sess, token, err := s.Create("alice", "secret", "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := s.Create("alice", "secret", "laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Revoke("bob", sess.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Revoke by another user: error = %v, want ErrNotFound", err)
+	}
+	if err := s.Revoke("alice", sess.ID); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+
+	if !s.Revoked(sess.ID) || s.Revoked(other.ID) {
+		t.Errorf("Revoked = %v, %v, want true, false", s.Revoked(sess.ID), s.Revoked(other.ID))
+	}
+	if _, _, err := s.Refresh(token); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Refresh after Revoke: error = %v, want ErrNotFound", err)
+	}
+	sessions, err := s.List("alice")
+	if err != nil || len(sessions) != 1 || sessions[0].ID != other.ID {
+		t.Errorf("List = %v, %v, want only %s", sessions, err, other.ID)
+	}
+}
+
+func TestPassword(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	old, current := testKey("old", 1), testKey("new", 2)
+
+	s := openStore(t, path, time.Hour, old)
+	sess, _, err := s.Create("alice", "secret", "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	// Passwords sealed under the older key still open, and are sealed
+	// again under the current one.
+	s = openStore(t, path, time.Hour, current, old)
+	if got, err := s.Password(sess.ID); err != nil || got != "secret" {
+		t.Fatalf("Password with both keys = %q, %v", got, err)
+	}
+	if _, err := s.reseal(); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s = openStore(t, path, time.Hour, current)
+	if got, err := s.Password(sess.ID); err != nil || got != "secret" {
+		t.Fatalf("Password after resealing = %q, %v", got, err)
+	}
+	s.Close()
+
+	s = openStore(t, path, time.Hour, testKey("new", 3))
+	if _, err := s.Password(sess.ID); err == nil {
+		t.Error("Password opened with the wrong key")
+	}
+}
+
+func TestCloseStopsCleanup(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "sessions.db"), []Key{testKey("a", 1)}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-s.stopped:
+	default:
+		t.Fatal("cleanup still running after Close")
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("second Close = %v", err)
+	}
+}
@@ -1,19 +1,71 @@
 package storage
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"io"
 
 	"photosync-backend/internal/models"
 )
 
+var (
+	ErrNotFound = errors.New("file not found")
+	ErrNotEmpty = errors.New("directory not empty")
+)
+
+// uploadTempPrefix names the temporary files uploads are written to before
+// they are renamed into place. Listings skip them.
+const uploadTempPrefix = ".upload-"
+
 type Connection interface{}
 
+// StorageBackend stores each user's files below their own directory. File
+// and directory names are slash-separated paths relative to that directory;
+// List walks it recursively, ListDir returns a single level. Upload, Rename
+// and Copy replace an existing file at the destination and create missing
+// parent directories. Operations on a file that does not exist fail with
+// ErrNotFound. List fails when any directory cannot be read rather than
+// leave its files out: the index and the journal would take them for
+// deleted.
 type StorageBackend interface {
 	Connect(username, password string) (Connection, error)
 	Upload(conn Connection, username, filename string, data io.Reader) error
-	Download(conn Connection, username, filename string) ([]byte, error)
+	Download(conn Connection, username, filename string) (io.ReadCloser, *models.FileInfo, error)
+	DownloadRange(conn Connection, username, filename string, offset, length int64) (io.ReadCloser, error)
+	Stat(conn Connection, username, filename string) (*models.FileInfo, error)
 	List(conn Connection, username string) ([]models.FileInfo, error)
+	ListDir(conn Connection, username, dir string) ([]models.FileInfo, error)
+	Mkdir(conn Connection, username, dir string) error
+	Rmdir(conn Connection, username, dir string) error
+	Rename(conn Connection, username, from, to string) error
+	Copy(conn Connection, username, from, to string) error
 	Delete(conn Connection, username, filename string) error
 	Close(conn Connection) error
 	GetName() string
 }
+
+// UserLister is implemented by backends that reach every user's directory
+// with their own credentials rather than the user's, so that background jobs
+// can visit users who are not signed in.
+type UserLister interface {
+	Users() ([]string, error)
+}
+
+// tempPath returns a random, hidden path in dir for a temporary file.
+func tempPath(dir string) (string, error) {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return dir + "/" + uploadTempPrefix + hex.EncodeToString(suffix), nil
+}
+
+// joinPath joins a directory path, which may be empty for the user's
+// directory, and an entry name.
+func joinPath(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	return dir + "/" + name
+}
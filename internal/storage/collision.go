@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+)
+
+const (
+	CollisionReject    = "reject"
+	CollisionOverwrite = "overwrite"
+	CollisionRename    = "rename"
+)
+
+const maxRenameAttempts = 1000
+
+var ErrExists = errors.New("file already exists")
+
+// NameResolver applies the configured collision policy to upload names. Names
+// handed out but not yet released are treated as taken, so concurrent uploads
+// of IMG_0001.JPG end up as IMG_0001.JPG and IMG_0001 (1).JPG.
+type NameResolver struct {
+	backend  StorageBackend
+	policy   string
+	mu       sync.Mutex
+	reserved map[string]bool
+}
+
+func NewNameResolver(backend StorageBackend, policy string) *NameResolver {
+	if policy == "" {
+		policy = CollisionRename
+	}
+
+	return &NameResolver{
+		backend:  backend,
+		policy:   policy,
+		reserved: make(map[string]bool),
+	}
+}
+
+// Policy returns the collision policy in effect.
+func (r *NameResolver) Policy() string {
+	return r.policy
+}
+
+// Reserve returns the name an upload of filename should be stored under.
+// The caller must call release once the upload has finished or failed.
+// Folders count as taken under every policy: no upload may replace one.
+func (r *NameResolver) Reserve(conn Connection, username, filename string) (string, func(), error) {
+	if r.policy == CollisionOverwrite {
+		taken, folder, err := r.taken(conn, username, filename)
+		if err != nil {
+			return "", nil, err
+		}
+		if taken && folder {
+			return "", nil, fmt.Errorf("%s is a folder: %w", filename, ErrExists)
+		}
+		return filename, func() {}, nil
+	}
+
+	dir, name := path.Split(filename)
+	ext := path.Ext(name)
+	base := dir + strings.TrimSuffix(name, ext)
+
+	for i := 0; i <= maxRenameAttempts; i++ {
+		candidate := filename
+		if i > 0 {
+			candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+		}
+
+		taken, _, err := r.taken(conn, username, candidate)
+		if err != nil {
+			return "", nil, err
+		}
+		if taken {
+			if r.policy == CollisionReject {
+				return "", nil, fmt.Errorf("%s: %w", filename, ErrExists)
+			}
+			continue
+		}
+
+		if release, ok := r.reserve(username, candidate); ok {
+			return candidate, release, nil
+		}
+		if r.policy == CollisionReject {
+			return "", nil, fmt.Errorf("%s: %w", filename, ErrExists)
+		}
+	}
+
+	return "", nil, fmt.Errorf("no free name for %s after %d attempts: %w", filename, maxRenameAttempts, ErrExists)
+}
+
+// Claim reserves filename as the destination of a rename or copy, which
+// keeps its name rather than being renamed. Unless overwrite is set, an
+// existing file is reported as ErrExists whatever the policy.
+func (r *NameResolver) Claim(conn Connection, username, filename string, overwrite bool) (func(), error) {
+	taken, folder, err := r.taken(conn, username, filename)
+	switch {
+	case err != nil:
+		return nil, err
+	case taken && folder:
+		return nil, fmt.Errorf("%s is a folder: %w", filename, ErrExists)
+	case taken && !overwrite:
+		return nil, fmt.Errorf("%s: %w", filename, ErrExists)
+	}
+
+	release, ok := r.reserve(username, filename)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", filename, ErrExists)
+	}
+	return release, nil
+}
+
+// taken reports whether filename is taken on the backend, and whether by a
+// folder. Stat does not report folders, so they are looked for with ListDir.
+func (r *NameResolver) taken(conn Connection, username, filename string) (bool, bool, error) {
+	_, err := r.backend.Stat(conn, username, filename)
+	if err == nil {
+		return true, false, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return false, false, err
+	}
+	if _, err := r.backend.ListDir(conn, username, filename); err == nil {
+		return true, true, nil
+	}
+	return false, false, nil
+}
+
+func (r *NameResolver) reserve(username, filename string) (func(), bool) {
+	key := username + "/" + filename
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.reserved[key] {
+		return nil, false
+	}
+	r.reserved[key] = true
+
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.reserved, key)
+	}, true
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNameResolverReserve(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   string
+		existing []string
+		reserved []string
+		filename string
+		want     string
+		wantErr  error
+	}{
+		{name: "free name", policy: CollisionRename, filename: "a.jpg", want: "a.jpg"},
+		{name: "default policy renames", existing: []string{"a.jpg"}, filename: "a.jpg", want: "a (1).jpg"},
+		{name: "rename skips taken", policy: CollisionRename, existing: []string{"a.jpg", "a (1).jpg"}, filename: "a.jpg", want: "a (2).jpg"},
+		{name: "rename skips reserved", policy: CollisionRename, reserved: []string{"a.jpg"}, filename: "a.jpg", want: "a (1).jpg"},
+		{name: "rename keeps folder", policy: CollisionRename, existing: []string{"2024/a.jpg"}, filename: "2024/a.jpg", want: "2024/a (1).jpg"},
+		{name: "rename without extension", policy: CollisionRename, existing: []string{"notes"}, filename: "notes", want: "notes (1)"},
+		{name: "rename only last extension", policy: CollisionRename, existing: []string{"a.tar.gz"}, filename: "a.tar.gz", want: "a.tar (1).gz"},
+		{name: "reject existing", policy: CollisionReject, existing: []string{"a.jpg"}, filename: "a.jpg", wantErr: ErrExists},
+		{name: "reject reserved", policy: CollisionReject, reserved: []string{"a.jpg"}, filename: "a.jpg", wantErr: ErrExists},
+		{name: "reject free name", policy: CollisionReject, filename: "a.jpg", want: "a.jpg"},
+		{name: "overwrite existing", policy: CollisionOverwrite, existing: []string{"a.jpg"}, filename: "a.jpg", want: "a.jpg"},
+		{name: "rename skips folder", policy: CollisionRename, existing: []string{"a.jpg/b.jpg"}, filename: "a.jpg", want: "a (1).jpg"},
+		{name: "reject folder", policy: CollisionReject, existing: []string{"a.jpg/b.jpg"}, filename: "a.jpg", wantErr: ErrExists},
+		{name: "overwrite folder", policy: CollisionOverwrite, existing: []string{"a.jpg/b.jpg"}, filename: "a.jpg", wantErr: ErrExists},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend, conn := newTestBackend(t, tt.existing...)
+			r := NewNameResolver(backend, tt.policy)
+
+			for _, name := range tt.reserved {
+				if _, ok := r.reserve("alice", name); !ok {
+					t.Fatalf("reserve(%q) failed", name)
+				}
+			}
+
+			got, release, err := r.Reserve(conn, "alice", tt.filename)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Reserve(%q) error = %v, want %v", tt.filename, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Reserve(%q) error = %v", tt.filename, err)
+			}
+			defer release()
+
+			if got != tt.want {
+				t.Errorf("Reserve(%q) = %q, want %q", tt.filename, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNameResolverRelease(t *testing.T) {
+	backend, conn := newTestBackend(t)
+	r := NewNameResolver(backend, CollisionRename)
+
+	first, release, err := r.Reserve(conn, "alice", "a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, releaseSecond, err := r.Reserve(conn, "alice", "a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseSecond()
+	if first != "a.jpg" || second != "a (1).jpg" {
+		t.Fatalf("concurrent reservations = %q, %q", first, second)
+	}
+
+	release()
+	again, releaseAgain, err := r.Reserve(conn, "alice", "a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseAgain()
+	if again != "a.jpg" {
+		t.Errorf("Reserve after release = %q, want a.jpg", again)
+	}
+}
+
+func TestNameResolverClaim(t *testing.T) {
+	tests := []struct {
+		name      string
+		existing  []string
+		filename  string
+		overwrite bool
+		wantErr   error
+	}{
+		{name: "free name", filename: "b.jpg"},
+		{name: "existing", existing: []string{"b.jpg"}, filename: "b.jpg", wantErr: ErrExists},
+		{name: "existing with overwrite", existing: []string{"b.jpg"}, filename: "b.jpg", overwrite: true},
+		{name: "folder", existing: []string{"b/c.jpg"}, filename: "b", wantErr: ErrExists},
+		{name: "folder with overwrite", existing: []string{"b/c.jpg"}, filename: "b", overwrite: true, wantErr: ErrExists},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend, conn := newTestBackend(t, tt.existing...)
+			r := NewNameResolver(backend, CollisionRename)
+
+			release, err := r.Claim(conn, "alice", tt.filename, tt.overwrite)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Claim(%q) error = %v, want %v", tt.filename, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Claim(%q) error = %v", tt.filename, err)
+			}
+			defer release()
+
+			if _, err := r.Claim(conn, "alice", tt.filename, true); !errors.Is(err, ErrExists) {
+				t.Errorf("second Claim(%q) error = %v, want ErrExists", tt.filename, err)
+			}
+		})
+	}
+}
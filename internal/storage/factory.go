@@ -6,20 +6,25 @@ import (
 	"photosync-backend/internal/config"
 )
 
-func NewStorageBackend(cfg *config.Config) (StorageBackend, error) {
+func NewStorageBackend(cfg *config.Config) (*SafeBackend, error) {
 	storageType := cfg.Storage.Type
 	if storageType == "" {
 		storageType = "smb"
 	}
 
+	var backend StorageBackend
 	switch storageType {
 	case "smb":
-		return NewSMBBackend(&cfg.SMB), nil
+		backend = NewSMBBackend(&cfg.SMB)
 	case "s3":
-		return NewS3Backend(&cfg.S3), nil
+		backend = NewS3Backend(&cfg.S3)
 	case "nfs":
-		return NewNFSBackend(&cfg.NFS), nil
+		backend = NewNFSBackend(&cfg.NFS)
+	case "local":
+		backend = NewLocalBackend(&cfg.Local)
 	default:
 		return nil, fmt.Errorf("unknown storage type: %s", storageType)
 	}
+
+	return NewSafeBackend(backend), nil
 }
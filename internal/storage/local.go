@@ -0,0 +1,343 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"photosync-backend/internal/config"
+	"photosync-backend/internal/models"
+)
+
+type LocalBackend struct {
+	config *config.LocalConfig
+}
+
+type LocalConnection struct {
+	username string
+}
+
+func NewLocalBackend(cfg *config.LocalConfig) *LocalBackend {
+	return &LocalBackend{config: cfg}
+}
+
+func (b *LocalBackend) GetName() string {
+	return "local"
+}
+
+func (b *LocalBackend) Connect(username, password string) (Connection, error) {
+	if err := os.MkdirAll(b.config.BasePath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to access base path: %w", err)
+	}
+	return &LocalConnection{username: username}, nil
+}
+
+func (b *LocalBackend) Upload(conn Connection, username, filename string, data io.Reader) error {
+	target := b.getFilePath(username, filename)
+	dir := filepath.Dir(target)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, uploadTempPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+
+	if _, err := io.Copy(tmp, data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to set file permissions: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, target); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to move file into place: %w", err)
+	}
+
+	return nil
+}
+
+func (b *LocalBackend) Download(conn Connection, username, filename string) (io.ReadCloser, *models.FileInfo, error) {
+	file, err := os.Open(b.getFilePath(username, filename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, fmt.Errorf("%s: %w", filename, ErrNotFound)
+		}
+		return nil, nil, fmt.Errorf("failed to open file: %w", err)
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	if stat.IsDir() {
+		file.Close()
+		return nil, nil, fmt.Errorf("%s is a directory: %w", filename, ErrNotFound)
+	}
+
+	info := &models.FileInfo{
+		Name:    filename,
+		Size:    stat.Size(),
+		ModTime: stat.ModTime(),
+	}
+
+	return file, info, nil
+}
+
+func (b *LocalBackend) DownloadRange(conn Connection, username, filename string, offset, length int64) (io.ReadCloser, error) {
+	file, err := os.Open(b.getFilePath(username, filename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s: %w", filename, ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+
+	return sectionReadCloser(file, offset, length)
+}
+
+func (b *LocalBackend) Stat(conn Connection, username, filename string) (*models.FileInfo, error) {
+	stat, err := os.Stat(b.getFilePath(username, filename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s: %w", filename, ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	if stat.IsDir() {
+		return nil, fmt.Errorf("%s is a directory: %w", filename, ErrNotFound)
+	}
+
+	return &models.FileInfo{
+		Name:    filename,
+		Size:    stat.Size(),
+		ModTime: stat.ModTime(),
+	}, nil
+}
+
+func (b *LocalBackend) List(conn Connection, username string) ([]models.FileInfo, error) {
+	userDir := b.getUserPath(username)
+
+	files := []models.FileInfo{}
+	err := filepath.WalkDir(userDir, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if p == userDir && os.IsNotExist(err) {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), uploadTempPrefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			// Only a file removed since the directory was read may be left
+			// out; anything else would be taken for a deletion.
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		rel, err := filepath.Rel(userDir, p)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, models.FileInfo{
+			Name:    filepath.ToSlash(rel),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	return files, nil
+}
+
+func (b *LocalBackend) ListDir(conn Connection, username, dir string) ([]models.FileInfo, error) {
+	entries, err := os.ReadDir(b.getFilePath(username, dir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			if dir == "" {
+				return []models.FileInfo{}, nil
+			}
+			return nil, fmt.Errorf("%s: %w", dir, ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	files := []models.FileInfo{}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), uploadTempPrefix) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			log.Printf("Local: Could not stat %s: %v", entry.Name(), err)
+			continue
+		}
+
+		file := models.FileInfo{
+			Name:    joinPath(dir, entry.Name()),
+			ModTime: info.ModTime(),
+			IsDir:   entry.IsDir(),
+		}
+		if !entry.IsDir() {
+			file.Size = info.Size()
+		}
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+func (b *LocalBackend) Mkdir(conn Connection, username, dir string) error {
+	if err := os.MkdirAll(b.getFilePath(username, dir), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	return nil
+}
+
+func (b *LocalBackend) Rmdir(conn Connection, username, dir string) error {
+	path := b.getFilePath(username, dir)
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		if os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR) {
+			return fmt.Errorf("%s: %w", dir, ErrNotFound)
+		}
+		return fmt.Errorf("failed to read directory: %w", err)
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("%s: %w", dir, ErrNotEmpty)
+	}
+
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove directory: %w", err)
+	}
+	return nil
+}
+
+func (b *LocalBackend) Rename(conn Connection, username, from, to string) error {
+	source := b.getFilePath(username, from)
+	if _, err := os.Lstat(source); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s: %w", from, ErrNotFound)
+		}
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	target := b.getFilePath(username, to)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	if err := os.Rename(source, target); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s: %w", from, ErrNotFound)
+		}
+		return fmt.Errorf("failed to rename file: %w", err)
+	}
+	return nil
+}
+
+// Copy goes through Upload, so the copy only appears once it is complete.
+func (b *LocalBackend) Copy(conn Connection, username, from, to string) error {
+	src, _, err := b.Download(conn, username, from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	return b.Upload(conn, username, to, src)
+}
+
+// Delete refuses directories, which os.Remove would take when empty.
+func (b *LocalBackend) Delete(conn Connection, username, filename string) error {
+	path := b.getFilePath(username, filename)
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s: %w", filename, ErrNotFound)
+		}
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	if stat.IsDir() {
+		return fmt.Errorf("%s is a directory: %w", filename, ErrNotFound)
+	}
+
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s: %w", filename, ErrNotFound)
+		}
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+
+	return nil
+}
+
+func (b *LocalBackend) Close(conn Connection) error {
+	return nil
+}
+
+// Users returns the directories in the base path.
+func (b *LocalBackend) Users() ([]string, error) {
+	entries, err := os.ReadDir(b.config.BasePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read base path: %w", err)
+	}
+
+	var users []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			users = append(users, entry.Name())
+		}
+	}
+	return users, nil
+}
+
+func (b *LocalBackend) getUserPath(username string) string {
+	return filepath.Join(b.config.BasePath, username)
+}
+
+func (b *LocalBackend) getFilePath(username, name string) string {
+	return filepath.Join(b.getUserPath(username), filepath.FromSlash(name))
+}
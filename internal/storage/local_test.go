@@ -0,0 +1,270 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"photosync-backend/internal/config"
+	"photosync-backend/internal/models"
+)
+
+func newTestBackend(t *testing.T, files ...string) (*LocalBackend, Connection) {
+	t.Helper()
+
+	base := t.TempDir()
+	for _, name := range files {
+		target := filepath.Join(base, "alice", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(target, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backend := NewLocalBackend(&config.LocalConfig{BasePath: base})
+	conn, err := backend.Connect("alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend, conn
+}
+
+// failingReader returns data and then fails, like a client that went away
+// halfway through an upload.
+type failingReader struct {
+	data string
+}
+
+var errClientGone = errors.New("client gone")
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, errClientGone
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func readLocal(t *testing.T, b *LocalBackend, name string) (string, bool) {
+	t.Helper()
+
+	data, err := os.ReadFile(b.getFilePath("alice", name))
+	if os.IsNotExist(err) {
+		return "", false
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data), true
+}
+
+func fileNames(files []models.FileInfo) []string {
+	var names []string
+	for _, file := range files {
+		if file.IsDir {
+			names = append(names, file.Name+"/")
+		} else {
+			names = append(names, file.Name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestLocalUpload(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		data     io.Reader
+		wantErr  bool
+		want     string
+		wantOK   bool
+	}{
+		{name: "new file", filename: "b.jpg", data: strings.NewReader("new"), want: "new", wantOK: true},
+		{name: "new folders", filename: "2024/06/b.jpg", data: strings.NewReader("new"), want: "new", wantOK: true},
+		{name: "replace", filename: "a.jpg", data: strings.NewReader("new"), want: "new", wantOK: true},
+		{name: "failed", filename: "b.jpg", data: &failingReader{data: "partial"}, wantErr: true},
+		{name: "failed replace", filename: "a.jpg", data: &failingReader{data: "partial"}, wantErr: true, want: "a.jpg", wantOK: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, conn := newTestBackend(t, "a.jpg")
+
+			err := b.Upload(conn, "alice", tt.filename, tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Upload error = %v, want error %v", err, tt.wantErr)
+			}
+
+			got, ok := readLocal(t, b, tt.filename)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("%s = %q (exists %v), want %q (exists %v)", tt.filename, got, ok, tt.want, tt.wantOK)
+			}
+
+			// No temp file is left behind, failed or not.
+			files, err := b.List(conn, "alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries, err := os.ReadDir(filepath.Dir(b.getFilePath("alice", tt.filename)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, entry := range entries {
+				if strings.HasPrefix(entry.Name(), uploadTempPrefix) {
+					t.Errorf("temp file %s left behind; listing %v", entry.Name(), fileNames(files))
+				}
+			}
+		})
+	}
+}
+
+func TestLocalListings(t *testing.T) {
+	b, conn := newTestBackend(t, "a.jpg", "2024/b.jpg", "2024/06/c.jpg", "2024/"+uploadTempPrefix+"x")
+	if err := os.Mkdir(b.getFilePath("alice", "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := b.List(conn, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fileNames(files), []string{"2024/06/c.jpg", "2024/b.jpg", "a.jpg"}; !slices.Equal(got, want) {
+		t.Errorf("List = %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		dir     string
+		want    []string
+		wantErr error
+	}{
+		{dir: "", want: []string{"2024/", "a.jpg", "empty/"}},
+		{dir: "2024", want: []string{"2024/06/", "2024/b.jpg"}},
+		{dir: "empty"},
+		{dir: "missing", wantErr: ErrNotFound},
+	}
+	for _, tt := range tests {
+		files, err := b.ListDir(conn, "alice", tt.dir)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("ListDir(%q) error = %v, want %v", tt.dir, err, tt.wantErr)
+			continue
+		}
+		if got := fileNames(files); !slices.Equal(got, tt.want) {
+			t.Errorf("ListDir(%q) = %v, want %v", tt.dir, got, tt.want)
+		}
+	}
+
+	// A user without a directory yet has no files.
+	if files, err := b.List(conn, "bob"); err != nil || len(files) != 0 {
+		t.Errorf("List of a new user = %v, %v", files, err)
+	}
+	if files, err := b.ListDir(conn, "bob", ""); err != nil || len(files) != 0 {
+		t.Errorf("ListDir of a new user = %v, %v", files, err)
+	}
+}
+
+func TestLocalRename(t *testing.T) {
+	b, conn := newTestBackend(t, "a.jpg", "b.jpg")
+
+	if err := b.Rename(conn, "alice", "a.jpg", "2024/06/a.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := readLocal(t, b, "2024/06/a.jpg"); !ok || got != "a.jpg" {
+		t.Errorf("moved file = %q (exists %v)", got, ok)
+	}
+	if _, ok := readLocal(t, b, "a.jpg"); ok {
+		t.Error("a.jpg still exists after the move")
+	}
+
+	if err := b.Rename(conn, "alice", "2024/06/a.jpg", "b.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := readLocal(t, b, "b.jpg"); got != "a.jpg" {
+		t.Errorf("b.jpg = %q, want it replaced", got)
+	}
+
+	if err := b.Rename(conn, "alice", "missing.jpg", "2025/c.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Rename of a missing file = %v, want ErrNotFound", err)
+	}
+	if _, err := os.Stat(b.getFilePath("alice", "2025")); !os.IsNotExist(err) {
+		t.Errorf("Rename of a missing file created its folder: %v", err)
+	}
+}
+
+func TestLocalRmdir(t *testing.T) {
+	b, conn := newTestBackend(t, "a.jpg", "2024/b.jpg")
+	if err := os.Mkdir(b.getFilePath("alice", "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		dir     string
+		wantErr error
+	}{
+		{dir: "2024", wantErr: ErrNotEmpty},
+		{dir: "a.jpg", wantErr: ErrNotFound},
+		{dir: "missing", wantErr: ErrNotFound},
+		{dir: "empty"},
+	}
+	for _, tt := range tests {
+		if err := b.Rmdir(conn, "alice", tt.dir); !errors.Is(err, tt.wantErr) {
+			t.Errorf("Rmdir(%q) = %v, want %v", tt.dir, err, tt.wantErr)
+		}
+	}
+
+	if _, ok := readLocal(t, b, "2024/b.jpg"); !ok {
+		t.Error("Rmdir removed a file of a non-empty directory")
+	}
+	if _, err := os.Stat(b.getFilePath("alice", "empty")); !os.IsNotExist(err) {
+		t.Errorf("empty directory still exists: %v", err)
+	}
+}
+
+func TestLocalNotFound(t *testing.T) {
+	b, conn := newTestBackend(t, "2024/b.jpg")
+	if err := os.Mkdir(b.getFilePath("alice", "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ops := map[string]func(name string) error{
+		"Download": func(name string) error {
+			body, _, err := b.Download(conn, "alice", name)
+			if err == nil {
+				body.Close()
+			}
+			return err
+		},
+		"Stat": func(name string) error {
+			_, err := b.Stat(conn, "alice", name)
+			return err
+		},
+		"Copy": func(name string) error {
+			return b.Copy(conn, "alice", name, "copy.jpg")
+		},
+		"Delete": func(name string) error {
+			return b.Delete(conn, "alice", name)
+		},
+	}
+
+	for op, fn := range ops {
+		for _, name := range []string{"missing.jpg", "2024", "empty"} {
+			if err := fn(name); !errors.Is(err, ErrNotFound) {
+				t.Errorf("%s(%q) = %v, want ErrNotFound", op, name, err)
+			}
+		}
+	}
+
+	if _, err := os.Stat(b.getFilePath("alice", "empty")); err != nil {
+		t.Errorf("Delete removed a directory: %v", err)
+	}
+	if err := b.Delete(conn, "alice", "2024/b.jpg"); err != nil {
+		t.Errorf("Delete of a file = %v", err)
+	}
+}
@@ -3,22 +3,68 @@ package storage
 import (
 	"fmt"
 	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
 
 	"photosync-backend/internal/config"
 	"photosync-backend/internal/models"
 	nfs "github.com/vmware/go-nfs-client/nfs"
 	"github.com/vmware/go-nfs-client/nfs/rpc"
+	"github.com/vmware/go-nfs-client/nfs/xdr"
 )
 
+const nfsChunkSize = 1 << 20
+
 type NFSBackend struct {
 	config *config.NFSConfig
 }
 
+// NFSConnection is one mount. go-nfs-client's RPC client cannot have more
+// than one call in flight: it takes the next reply on the socket as the
+// reply to its call, so concurrent calls fail with "xid did not match". mu
+// serializes every call on the mount, including those of open files.
 type NFSConnection struct {
+	mu       sync.Mutex
 	mount    *nfs.Target
+	auth     rpc.Auth
 	username string
 }
 
+// nfsFile is an open file whose calls take the lock of its connection.
+type nfsFile struct {
+	conn *NFSConnection
+	file *nfs.File
+}
+
+func (f *nfsFile) Read(p []byte) (int, error) {
+	f.conn.mu.Lock()
+	defer f.conn.mu.Unlock()
+	return f.file.Read(p)
+}
+
+func (f *nfsFile) Write(p []byte) (int, error) {
+	f.conn.mu.Lock()
+	defer f.conn.mu.Unlock()
+	return f.file.Write(p)
+}
+
+func (f *nfsFile) Seek(offset int64, whence int) (int64, error) {
+	f.conn.mu.Lock()
+	defer f.conn.mu.Unlock()
+	return f.file.Seek(offset, whence)
+}
+
+func (f *nfsFile) Close() error {
+	f.conn.mu.Lock()
+	defer f.conn.mu.Unlock()
+	return f.file.Close()
+}
+
+// nfsProcRename is NFSv3 RENAME, which go-nfs-client does not wrap.
+const nfsProcRename = 14
+
 func NewNFSBackend(cfg *config.NFSConfig) *NFSBackend {
 	return &NFSBackend{config: cfg}
 }
@@ -42,100 +88,391 @@ func (b *NFSBackend) Connect(username, password string) (Connection, error) {
 
 	return &NFSConnection{
 		mount:    target,
+		auth:     auth.Auth(),
 		username: username,
 	}, nil
 }
 
+// Upload writes to a temporary file next to the target and renames it into
+// place, so an existing file is only replaced once the new one is complete.
+// It only holds the connection lock for each chunk it writes, so a slow
+// client does not stall the user's other requests.
 func (b *NFSBackend) Upload(conn Connection, username, filename string, data io.Reader) error {
 	nfsConn := conn.(*NFSConnection)
+	tmpPath, file, err := b.createTemp(nfsConn, username, filename)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.CopyBuffer(file, data, make([]byte, nfsChunkSize))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	nfsConn.mu.Lock()
+	defer nfsConn.mu.Unlock()
+
+	if err != nil {
+		nfsConn.mount.Remove(tmpPath)
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+
+	if err := b.rename(nfsConn, tmpPath, b.getUserPath(username)+"/"+filename); err != nil {
+		nfsConn.mount.Remove(tmpPath)
+		return fmt.Errorf("failed to move file into place: %w", err)
+	}
+
+	return nil
+}
+
+// createTemp creates a hidden temporary file in the directory of filename,
+// creating missing directories, and returns its path.
+func (b *NFSBackend) createTemp(nfsConn *NFSConnection, username, filename string) (string, *nfsFile, error) {
+	nfsConn.mu.Lock()
+	defer nfsConn.mu.Unlock()
 
 	userDir := b.getUserPath(username)
 
 	if err := b.ensureDirectory(nfsConn.mount, userDir); err != nil {
-		return fmt.Errorf("failed to create user directory: %w", err)
+		return "", nil, fmt.Errorf("failed to create user directory: %w", err)
 	}
 
-	fullPath := userDir + "/" + filename
+	if dir := path.Dir(filename); dir != "." {
+		if err := b.mkdirAll(nfsConn.mount, userDir, dir); err != nil {
+			return "", nil, err
+		}
+	}
 
-	body, err := io.ReadAll(data)
+	tmpPath, err := tempPath(path.Dir(userDir + "/" + filename))
 	if err != nil {
-		return fmt.Errorf("failed to read data: %w", err)
+		return "", nil, err
 	}
-
-	_, err = nfsConn.mount.OpenFile(fullPath, 0644)
-	if err == nil {
-		return fmt.Errorf("file already exists")
+	file, err := nfsConn.mount.OpenFile(tmpPath, 0644)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temp file: %w", err)
 	}
 
-	file, err := nfsConn.mount.OpenFile(fullPath, 0644)
+	return tmpPath, &nfsFile{conn: nfsConn, file: file}, nil
+}
+
+func (b *NFSBackend) Download(conn Connection, username, filename string) (io.ReadCloser, *models.FileInfo, error) {
+	nfsConn := conn.(*NFSConnection)
+	nfsConn.mu.Lock()
+	defer nfsConn.mu.Unlock()
+
+	fullPath := b.getUserPath(username) + "/" + filename
+
+	attr, _, err := nfsConn.mount.Lookup(fullPath)
 	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
+		if os.IsNotExist(err) {
+			return nil, nil, fmt.Errorf("%s: %w", filename, ErrNotFound)
+		}
+		return nil, nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	if attr.IsDir() {
+		return nil, nil, fmt.Errorf("%s is a directory: %w", filename, ErrNotFound)
 	}
-	defer file.Close()
 
-	_, err = file.Write(body)
+	file, err := nfsConn.mount.Open(fullPath)
 	if err != nil {
-		return fmt.Errorf("failed to write file: %w", err)
+		return nil, nil, fmt.Errorf("failed to open file: %w", err)
 	}
 
-	return nil
+	info := &models.FileInfo{
+		Name:    filename,
+		Size:    attr.Size(),
+		ModTime: attr.ModTime(),
+	}
+
+	return &nfsFile{conn: nfsConn, file: file}, info, nil
 }
 
-func (b *NFSBackend) Download(conn Connection, username, filename string) ([]byte, error) {
+func (b *NFSBackend) DownloadRange(conn Connection, username, filename string, offset, length int64) (io.ReadCloser, error) {
 	nfsConn := conn.(*NFSConnection)
 
 	fullPath := b.getUserPath(username) + "/" + filename
 
+	nfsConn.mu.Lock()
 	file, err := nfsConn.mount.Open(fullPath)
+	nfsConn.mu.Unlock()
 	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s: %w", filename, ErrNotFound)
+		}
 		return nil, fmt.Errorf("failed to open file: %w", err)
 	}
-	defer file.Close()
 
-	data, err := io.ReadAll(file)
+	return sectionReadCloser(&nfsFile{conn: nfsConn, file: file}, offset, length)
+}
+
+func (b *NFSBackend) Stat(conn Connection, username, filename string) (*models.FileInfo, error) {
+	nfsConn := conn.(*NFSConnection)
+	nfsConn.mu.Lock()
+	defer nfsConn.mu.Unlock()
+
+	fullPath := b.getUserPath(username) + "/" + filename
+
+	attr, _, err := nfsConn.mount.Lookup(fullPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read file: %w", err)
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s: %w", filename, ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	if attr.IsDir() {
+		return nil, fmt.Errorf("%s is a directory: %w", filename, ErrNotFound)
 	}
 
-	return data, nil
+	return &models.FileInfo{
+		Name:    filename,
+		Size:    attr.Size(),
+		ModTime: attr.ModTime(),
+	}, nil
 }
 
 func (b *NFSBackend) List(conn Connection, username string) ([]models.FileInfo, error) {
 	nfsConn := conn.(*NFSConnection)
+	nfsConn.mu.Lock()
+	defer nfsConn.mu.Unlock()
 
 	userDir := b.getUserPath(username)
 
 	if err := b.ensureDirectory(nfsConn.mount, userDir); err != nil {
-		return []models.FileInfo{}, nil
+		return nil, err
 	}
 
 	entries, err := nfsConn.mount.ReadDirPlus(userDir)
 	if err != nil {
-		return []models.FileInfo{}, nil
+		return nil, fmt.Errorf("failed to read directory %s: %w", userDir, err)
+	}
+
+	files := []models.FileInfo{}
+	if err := b.walk(nfsConn.mount, userDir, "", entries, &files); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// walk works like SMBBackend.walk on READDIRPLUS entries, which include
+// "." and "..".
+func (b *NFSBackend) walk(mount *nfs.Target, userDir, rel string, entries []*nfs.EntryPlus, files *[]models.FileInfo) error {
+	for _, entry := range entries {
+		if entry.Name() == "." || entry.Name() == ".." {
+			continue
+		}
+
+		if strings.HasPrefix(entry.Name(), uploadTempPrefix) {
+			continue
+		}
+
+		name := joinPath(rel, entry.Name())
+		if !entry.IsDir() {
+			*files = append(*files, models.FileInfo{
+				Name:    name,
+				Size:    int64(entry.Size()),
+				ModTime: entry.ModTime(),
+			})
+			continue
+		}
+
+		children, err := mount.ReadDirPlus(userDir + "/" + name)
+		if err != nil {
+			return fmt.Errorf("failed to read directory %s: %w", name, err)
+		}
+		if err := b.walk(mount, userDir, name, children, files); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *NFSBackend) ListDir(conn Connection, username, dir string) ([]models.FileInfo, error) {
+	nfsConn := conn.(*NFSConnection)
+	nfsConn.mu.Lock()
+	defer nfsConn.mu.Unlock()
+
+	entries, err := nfsConn.mount.ReadDirPlus(joinPath(b.getUserPath(username), dir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			if dir == "" {
+				return []models.FileInfo{}, nil
+			}
+			return nil, fmt.Errorf("%s: %w", dir, ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to read directory: %w", err)
 	}
 
 	files := []models.FileInfo{}
 	for _, entry := range entries {
-		if entry.IsDir() {
+		if entry.Name() == "." || entry.Name() == ".." || strings.HasPrefix(entry.Name(), uploadTempPrefix) {
 			continue
 		}
-		files = append(files, models.FileInfo{
-			Name:    entry.Name(),
-			Size:    int64(entry.Size()),
+
+		file := models.FileInfo{
+			Name:    joinPath(dir, entry.Name()),
 			ModTime: entry.ModTime(),
-		})
+			IsDir:   entry.IsDir(),
+		}
+		if !entry.IsDir() {
+			file.Size = int64(entry.Size())
+		}
+		files = append(files, file)
 	}
 
 	return files, nil
 }
 
+func (b *NFSBackend) Mkdir(conn Connection, username, dir string) error {
+	nfsConn := conn.(*NFSConnection)
+	nfsConn.mu.Lock()
+	defer nfsConn.mu.Unlock()
+
+	userDir := b.getUserPath(username)
+	if err := b.ensureDirectory(nfsConn.mount, userDir); err != nil {
+		return fmt.Errorf("failed to create user directory: %w", err)
+	}
+	return b.mkdirAll(nfsConn.mount, userDir, dir)
+}
+
+func (b *NFSBackend) Rmdir(conn Connection, username, dir string) error {
+	nfsConn := conn.(*NFSConnection)
+	nfsConn.mu.Lock()
+	defer nfsConn.mu.Unlock()
+	fullPath := b.getUserPath(username) + "/" + dir
+
+	attr, _, err := nfsConn.mount.Lookup(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s: %w", dir, ErrNotFound)
+		}
+		return fmt.Errorf("failed to stat directory: %w", err)
+	}
+	if !attr.IsDir() {
+		return fmt.Errorf("%s is not a directory: %w", dir, ErrNotFound)
+	}
+
+	entries, err := nfsConn.mount.ReadDirPlus(fullPath)
+	if err != nil {
+		return fmt.Errorf("failed to read directory: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != "." && entry.Name() != ".." {
+			return fmt.Errorf("%s: %w", dir, ErrNotEmpty)
+		}
+	}
+
+	if err := nfsConn.mount.RmDir(fullPath); err != nil {
+		return fmt.Errorf("failed to remove directory: %w", err)
+	}
+	return nil
+}
+
+// Rename uses the RENAME procedure, which replaces an existing target
+// atomically.
+func (b *NFSBackend) Rename(conn Connection, username, from, to string) error {
+	nfsConn := conn.(*NFSConnection)
+	nfsConn.mu.Lock()
+	defer nfsConn.mu.Unlock()
+	userDir := b.getUserPath(username)
+
+	// The source is looked up first so that moving a missing file leaves
+	// no folders behind.
+	if _, _, err := nfsConn.mount.Lookup(userDir + "/" + from); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s: %w", from, ErrNotFound)
+		}
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	if dir := path.Dir(to); dir != "." {
+		if err := b.mkdirAll(nfsConn.mount, userDir, dir); err != nil {
+			return err
+		}
+	}
+
+	if err := b.rename(nfsConn, userDir+"/"+from, userDir+"/"+to); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s: %w", from, ErrNotFound)
+		}
+		return fmt.Errorf("failed to rename file: %w", err)
+	}
+	return nil
+}
+
+// rename moves the file at the full path from to to. The caller holds the
+// connection lock.
+func (b *NFSBackend) rename(nfsConn *NFSConnection, from, to string) error {
+	_, fromDir, err := nfsConn.mount.Lookup(path.Dir(from))
+	if err != nil {
+		return err
+	}
+	_, toDir, err := nfsConn.mount.Lookup(path.Dir(to))
+	if err != nil {
+		return err
+	}
+
+	type renameArgs struct {
+		rpc.Header
+		From nfs.Diropargs3
+		To   nfs.Diropargs3
+	}
+
+	res, err := nfsConn.mount.Call(&renameArgs{
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    nfs.Nfs3Prog,
+			Vers:    nfs.Nfs3Vers,
+			Proc:    nfsProcRename,
+			Cred:    nfsConn.auth,
+			Verf:    rpc.AuthNull,
+		},
+		From: nfs.Diropargs3{FH: fromDir, Filename: path.Base(from)},
+		To:   nfs.Diropargs3{FH: toDir, Filename: path.Base(to)},
+	})
+	if err != nil {
+		return err
+	}
+
+	status, err := xdr.ReadUint32(res)
+	if err != nil {
+		return err
+	}
+	return nfs.NFS3Error(status)
+}
+
+// Copy streams the file through the server; NFSv3 has no server-side copy.
+func (b *NFSBackend) Copy(conn Connection, username, from, to string) error {
+	nfsConn := conn.(*NFSConnection)
+
+	nfsConn.mu.Lock()
+	file, err := nfsConn.mount.Open(b.getUserPath(username) + "/" + from)
+	nfsConn.mu.Unlock()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s: %w", from, ErrNotFound)
+		}
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	src := &nfsFile{conn: nfsConn, file: file}
+	defer src.Close()
+
+	return b.Upload(conn, username, to, src)
+}
+
 func (b *NFSBackend) Delete(conn Connection, username, filename string) error {
 	nfsConn := conn.(*NFSConnection)
+	nfsConn.mu.Lock()
+	defer nfsConn.mu.Unlock()
 
 	fullPath := b.getUserPath(username) + "/" + filename
 
 	err := nfsConn.mount.Remove(fullPath)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s: %w", filename, ErrNotFound)
+		}
 		return fmt.Errorf("failed to delete file: %w", err)
 	}
 
@@ -147,6 +484,8 @@ func (b *NFSBackend) Close(conn Connection) error {
 		return nil
 	}
 	nfsConn := conn.(*NFSConnection)
+	nfsConn.mu.Lock()
+	defer nfsConn.mu.Unlock()
 	nfsConn.mount.Close()
 	return nil
 }
@@ -158,6 +497,21 @@ func (b *NFSBackend) getUserPath(username string) string {
 	return username
 }
 
+// mkdirAll creates dir and any missing parents below userDir.
+func (b *NFSBackend) mkdirAll(mount *nfs.Target, userDir, dir string) error {
+	current := userDir
+	for _, segment := range strings.Split(dir, "/") {
+		current += "/" + segment
+		if _, _, err := mount.Lookup(current); err == nil {
+			continue
+		}
+		if _, err := mount.Mkdir(current, 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", current, err)
+		}
+	}
+	return nil
+}
+
 func (b *NFSBackend) ensureDirectory(mount *nfs.Target, path string) error {
 	_, err := mount.Mkdir(path, 0755)
 	if err != nil {
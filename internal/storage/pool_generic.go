@@ -10,12 +10,16 @@ type GenericConnectionPool struct {
 	mu          sync.RWMutex
 	ttl         time.Duration
 	backend     StorageBackend
+	connected   []func(username string)
 }
 
 type PooledGenericConnection struct {
 	connection Connection
 	lastUsed   time.Time
 	username   string
+	// leases counts requests and background jobs using the connection;
+	// cleanup leaves leased connections open.
+	leases int
 }
 
 func NewGenericConnectionPool(backend StorageBackend, ttl time.Duration) *GenericConnectionPool {
@@ -30,22 +34,17 @@ func NewGenericConnectionPool(backend StorageBackend, ttl time.Duration) *Generi
 	return pool
 }
 
-func (p *GenericConnectionPool) GetConnection(username, password string) (Connection, error) {
-	p.mu.RLock()
-	conn, exists := p.connections[username]
-	p.mu.RUnlock()
-
-	if exists && time.Since(conn.lastUsed) < p.ttl {
-		conn.lastUsed = time.Now()
-		return conn.connection, nil
-	}
-
+// GetConnection returns the user's connection, opening one if needed, and
+// keeps it open until the returned release is called, however long the
+// request using it takes.
+func (p *GenericConnectionPool) GetConnection(username, password string) (Connection, func(), error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	if conn, exists := p.connections[username]; exists && time.Since(conn.lastUsed) < p.ttl {
+	conn, exists := p.connections[username]
+	if exists && (conn.leases > 0 || time.Since(conn.lastUsed) < p.ttl) {
 		conn.lastUsed = time.Now()
-		return conn.connection, nil
+		return conn.connection, p.lease(conn, true), nil
 	}
 
 	if exists {
@@ -54,16 +53,77 @@ func (p *GenericConnectionPool) GetConnection(username, password string) (Connec
 
 	connection, err := p.backend.Connect(username, password)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	p.connections[username] = &PooledGenericConnection{
+	conn = &PooledGenericConnection{
 		connection: connection,
 		lastUsed:   time.Now(),
 		username:   username,
 	}
+	p.connections[username] = conn
+	for _, fn := range p.connected {
+		go fn(username)
+	}
 
-	return connection, nil
+	return connection, p.lease(conn, true), nil
+}
+
+// OnConnect registers fn to be called, in a goroutine of its own, whenever a
+// connection is opened for a user. fn can Lease the new connection.
+func (p *GenericConnectionPool) OnConnect(fn func(username string)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.connected = append(p.connected, fn)
+}
+
+// Active returns the users with a live connection, for background work that
+// should only touch users who are currently signed in.
+func (p *GenericConnectionPool) Active() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var active []string
+	for username, conn := range p.connections {
+		if time.Since(conn.lastUsed) < p.ttl {
+			active = append(active, username)
+		}
+	}
+	return active
+}
+
+// Lease returns the user's live connection for a background job and keeps
+// it open, whatever its idle time, until the returned release is called.
+// Leasing does not count as use, so it does not keep a user signed in.
+func (p *GenericConnectionPool) Lease(username string) (Connection, func(), bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	conn, exists := p.connections[username]
+	if !exists || time.Since(conn.lastUsed) >= p.ttl {
+		return nil, nil, false
+	}
+
+	return conn.connection, p.lease(conn, false), true
+}
+
+// lease takes a lease on conn and returns its release. Releases after use
+// count as use, so the idle time starts when a request ends. p.mu must be
+// held.
+func (p *GenericConnectionPool) lease(conn *PooledGenericConnection, use bool) func() {
+	conn.leases++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.mu.Lock()
+			conn.leases--
+			if use {
+				conn.lastUsed = time.Now()
+			}
+			p.mu.Unlock()
+		})
+	}
 }
 
 func (p *GenericConnectionPool) cleanup() {
@@ -75,7 +135,7 @@ func (p *GenericConnectionPool) cleanup() {
 
 		now := time.Now()
 		for username, conn := range p.connections {
-			if now.Sub(conn.lastUsed) > p.ttl {
+			if conn.leases == 0 && now.Sub(conn.lastUsed) > p.ttl {
 				p.backend.Close(conn.connection)
 				delete(p.connections, username)
 			}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+// countingBackend counts connections opened and closed.
+type countingBackend struct {
+	StorageBackend
+	opened, closed int
+}
+
+func (b *countingBackend) Connect(username, password string) (Connection, error) {
+	b.opened++
+	return b.StorageBackend.Connect(username, password)
+}
+
+func (b *countingBackend) Close(conn Connection) error {
+	b.closed++
+	return nil
+}
+
+func TestGetConnectionLease(t *testing.T) {
+	local, _ := newTestBackend(t)
+	backend := &countingBackend{StorageBackend: local}
+	const ttl = 20 * time.Millisecond
+	pool := NewGenericConnectionPool(backend, ttl)
+	defer pool.Close()
+
+	first, done, err := pool.GetConnection("alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A transfer outlasting the TTL keeps its connection.
+	time.Sleep(2 * ttl)
+	second, doneSecond, err := pool.GetConnection("alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second != first || backend.opened != 1 || backend.closed != 0 {
+		t.Fatalf("leased connection replaced: opened %d, closed %d", backend.opened, backend.closed)
+	}
+	doneSecond()
+	done()
+	done()
+
+	// Releasing counts as use, so the idle time starts when the request ends.
+	if active := pool.Active(); len(active) != 1 {
+		t.Fatalf("Active = %v after release, want alice", active)
+	}
+
+	time.Sleep(2 * ttl)
+	if _, done, err = pool.GetConnection("alice", ""); err != nil {
+		t.Fatal(err)
+	}
+	done()
+	if backend.opened != 2 || backend.closed != 1 {
+		t.Errorf("idle connection not replaced: opened %d, closed %d", backend.opened, backend.closed)
+	}
+}
@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"photosync-backend/internal/models"
+)
+
+// RangeReader presents a stored file as an io.ReadSeeker. Seeking is free;
+// the backend is only asked for bytes (via DownloadRange) starting at the
+// current offset once Read is called, and an open download is kept while
+// reads continue where it left off.
+type RangeReader struct {
+	backend  StorageBackend
+	conn     Connection
+	username string
+	filename string
+	size     int64
+	offset   int64
+
+	body       io.ReadCloser
+	bodyOffset int64
+
+	// peeked holds bytes read ahead by Peek, starting at peekedOffset.
+	peeked       []byte
+	peekedOffset int64
+}
+
+func NewRangeReader(backend StorageBackend, conn Connection, username string, info *models.FileInfo) *RangeReader {
+	return &RangeReader{
+		backend:  backend,
+		conn:     conn,
+		username: username,
+		filename: info.Name,
+		size:     info.Size,
+	}
+}
+
+func (r *RangeReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	if start := r.offset - r.peekedOffset; start >= 0 && start < int64(len(r.peeked)) {
+		n := copy(p, r.peeked[start:])
+		r.offset += int64(n)
+		return n, nil
+	}
+
+	// A read from the start is kept like a peek, so that sniffing the start
+	// and seeking back, as http.ServeContent does, takes one download.
+	if r.offset == 0 {
+		peeked, err := r.Peek(len(p))
+		n := copy(p, peeked)
+		r.offset += int64(n)
+		if n > 0 {
+			return n, nil
+		}
+		return 0, err
+	}
+
+	if err := r.open(); err != nil {
+		return 0, err
+	}
+
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	r.bodyOffset += int64(n)
+	if err == io.EOF && r.offset < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
+
+// Peek returns up to n bytes at the current offset without advancing it.
+// The bytes are kept for the next Read, so a caller can sniff the start of
+// a file and then read all of it with a single download.
+func (r *RangeReader) Peek(n int) ([]byte, error) {
+	n = int(min(int64(n), r.size-r.offset))
+	if n <= 0 {
+		return nil, nil
+	}
+	if start := r.offset - r.peekedOffset; start >= 0 && start+int64(n) <= int64(len(r.peeked)) {
+		return r.peeked[start : start+int64(n)], nil
+	}
+
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, n)
+	read, err := io.ReadFull(r.body, buf)
+	r.bodyOffset += int64(read)
+	r.peeked = buf[:read]
+	r.peekedOffset = r.offset
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return r.peeked, err
+}
+
+// open makes sure the download continues from the current offset.
+func (r *RangeReader) open() error {
+	if r.body != nil && r.bodyOffset == r.offset {
+		return nil
+	}
+	r.closeBody()
+
+	body, err := r.backend.DownloadRange(r.conn, r.username, r.filename, r.offset, r.size-r.offset)
+	if err != nil {
+		return err
+	}
+	r.body = body
+	r.bodyOffset = r.offset
+	return nil
+}
+
+func (r *RangeReader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.offset + offset
+	case io.SeekEnd:
+		abs = r.size + offset
+	default:
+		return r.offset, errors.New("invalid whence")
+	}
+
+	if abs < 0 {
+		return r.offset, fmt.Errorf("negative offset %d", abs)
+	}
+
+	r.offset = abs
+	return abs, nil
+}
+
+func (r *RangeReader) Close() error {
+	return r.closeBody()
+}
+
+func (r *RangeReader) closeBody() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// sectionReadCloser positions a seekable file at offset and limits reads to
+// length bytes (or to EOF when length is negative).
+func sectionReadCloser(file io.ReadSeekCloser, offset, length int64) (io.ReadCloser, error) {
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to seek: %w", err)
+	}
+
+	if length < 0 {
+		return file, nil
+	}
+
+	return &limitedReadCloser{Reader: io.LimitReader(file, length), Closer: file}, nil
+}
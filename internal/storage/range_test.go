@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"testing"
+)
+
+// downloadsBackend records the offset of every download.
+type downloadsBackend struct {
+	StorageBackend
+	offsets []int64
+}
+
+func (b *downloadsBackend) DownloadRange(conn Connection, username, filename string, offset, length int64) (io.ReadCloser, error) {
+	b.offsets = append(b.offsets, offset)
+	return b.StorageBackend.DownloadRange(conn, username, filename, offset, length)
+}
+
+// errAny stands for any error in wantErr.
+var errAny = errors.New("any error")
+
+func TestRangeReader(t *testing.T) {
+	local, conn := newTestBackend(t, "0123456789")
+	backend := &downloadsBackend{StorageBackend: local}
+	info, err := local.Stat(conn, "alice", "0123456789")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRangeReader(backend, conn, "alice", info)
+	defer r.Close()
+
+	const (
+		peek = iota
+		read
+		seek
+	)
+	steps := []struct {
+		name          string
+		op            int
+		n             int64
+		whence        int
+		want          string
+		wantErr       error
+		wantOffset    int64
+		wantDownloads []int64
+	}{
+		{name: "peek at the start", op: peek, n: 4, want: "0123", wantOffset: 0, wantDownloads: []int64{0}},
+		{name: "peek again", op: peek, n: 2, want: "01", wantOffset: 0, wantDownloads: []int64{0}},
+		{name: "read the peeked bytes", op: read, n: 2, want: "01", wantOffset: 2, wantDownloads: []int64{0}},
+		{name: "read stops at the end of the peeked bytes", op: read, n: 4, want: "23", wantOffset: 4, wantDownloads: []int64{0}},
+		{name: "read on from the same download", op: read, n: 3, want: "456", wantOffset: 7, wantDownloads: []int64{0}},
+		{name: "seek back into the peeked bytes", op: seek, n: 2, whence: io.SeekStart, wantOffset: 2, wantDownloads: []int64{0}},
+		{name: "read them again", op: read, n: 2, want: "23", wantOffset: 4, wantDownloads: []int64{0}},
+		{name: "seek ahead", op: seek, n: 4, whence: io.SeekCurrent, wantOffset: 8, wantDownloads: []int64{0}},
+		{name: "read reopens at the offset", op: read, n: 5, want: "89", wantOffset: 10, wantDownloads: []int64{0, 8}},
+		{name: "read at the end", op: read, n: 1, wantErr: io.EOF, wantOffset: 10, wantDownloads: []int64{0, 8}},
+		{name: "peek at the end", op: peek, n: 1, wantOffset: 10, wantDownloads: []int64{0, 8}},
+		{name: "seek from the end", op: seek, n: -3, whence: io.SeekEnd, wantOffset: 7, wantDownloads: []int64{0, 8}},
+		{name: "peek past the end", op: peek, n: 5, want: "789", wantOffset: 7, wantDownloads: []int64{0, 8, 7}},
+		{name: "read the peeked tail", op: read, n: 5, want: "789", wantOffset: 10, wantDownloads: []int64{0, 8, 7}},
+		{name: "seek before the start", op: seek, n: -1, whence: io.SeekStart, wantErr: errAny, wantOffset: 10, wantDownloads: []int64{0, 8, 7}},
+		{name: "invalid whence", op: seek, n: 0, whence: 3, wantErr: errAny, wantOffset: 10, wantDownloads: []int64{0, 8, 7}},
+	}
+
+	for _, tt := range steps {
+		var got []byte
+		var err error
+		switch tt.op {
+		case peek:
+			got, err = r.Peek(int(tt.n))
+		case read:
+			buf := make([]byte, tt.n)
+			var n int
+			n, err = r.Read(buf)
+			got = buf[:n]
+		case seek:
+			var offset int64
+			offset, err = r.Seek(tt.n, tt.whence)
+			if err == nil && offset != tt.wantOffset {
+				t.Errorf("%s: Seek = %d, want %d", tt.name, offset, tt.wantOffset)
+			}
+		}
+
+		switch {
+		case tt.wantErr == errAny:
+			if err == nil {
+				t.Errorf("%s: no error", tt.name)
+			}
+		case err != tt.wantErr:
+			t.Fatalf("%s: error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+		if r.offset != tt.wantOffset {
+			t.Errorf("%s: offset = %d, want %d", tt.name, r.offset, tt.wantOffset)
+		}
+		if !slices.Equal(backend.offsets, tt.wantDownloads) {
+			t.Errorf("%s: downloads at %v, want %v", tt.name, backend.offsets, tt.wantDownloads)
+		}
+	}
+}
+
+func TestRangeReaderTruncated(t *testing.T) {
+	local, conn := newTestBackend(t, "0123456789")
+	info, err := local.Stat(conn, "alice", "0123456789")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The file shrank after it was listed.
+	info.Size = 12
+
+	r := NewRangeReader(local, conn, "alice", info)
+	defer r.Close()
+	if got, err := r.Peek(12); err != io.ErrUnexpectedEOF || string(got) != "0123456789" {
+		t.Errorf("Peek = %q, %v, want the 10 bytes and io.ErrUnexpectedEOF", got, err)
+	}
+
+	r = NewRangeReader(local, conn, "alice", info)
+	defer r.Close()
+	if got, err := io.ReadAll(r); err != io.ErrUnexpectedEOF || string(got) != "0123456789" {
+		t.Errorf("ReadAll = %q, %v, want the 10 bytes and io.ErrUnexpectedEOF", got, err)
+	}
+}
+
+func TestRangeReaderSniff(t *testing.T) {
+	local, conn := newTestBackend(t, "0123456789")
+	backend := &downloadsBackend{StorageBackend: local}
+	info, err := local.Stat(conn, "alice", "0123456789")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRangeReader(backend, conn, "alice", info)
+	defer r.Close()
+
+	// Like http.ServeContent: sniff the start, seek back, copy the file.
+	buf := make([]byte, 4)
+	if n, err := io.ReadFull(r, buf); err != nil || string(buf[:n]) != "0123" {
+		t.Fatalf("sniff = %q, %v", buf[:n], err)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(r); err != nil || string(got) != "0123456789" {
+		t.Errorf("ReadAll = %q, %v", got, err)
+	}
+	if !slices.Equal(backend.offsets, []int64{0}) {
+		t.Errorf("downloads at %v, want one at 0", backend.offsets)
+	}
+}
@@ -3,16 +3,41 @@ package storage
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"photosync-backend/internal/config"
 	"photosync-backend/internal/models"
 )
 
+// Objects larger than one part are sent with multipart upload, so at most
+// s3PartSize bytes of an upload are held in memory at a time.
+const s3PartSize = 16 << 20
+
+// s3Parts holds part buffers for reuse, so that uploads do not each
+// allocate one.
+var s3Parts = sync.Pool{
+	New: func() any {
+		buf := make([]byte, s3PartSize)
+		return &buf
+	},
+}
+
+// CopyObject handles objects up to 5 GiB; larger ones are copied in parts.
+const (
+	s3MaxCopySize  = 5 << 30
+	s3CopyPartSize = 512 << 20
+)
+
 type S3Backend struct {
 	config *config.S3Config
 	client *s3.Client
@@ -53,73 +78,380 @@ func (b *S3Backend) Connect(username, password string) (Connection, error) {
 func (b *S3Backend) Upload(conn Connection, username, filename string, data io.Reader) error {
 	key := b.getObjectKey(username, filename)
 
-	body, err := io.ReadAll(data)
+	part := s3Parts.Get().(*[]byte)
+	defer s3Parts.Put(part)
+
+	buf := *part
+	n, err := io.ReadFull(data, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		_, err = b.client.PutObject(context.Background(), &s3.PutObjectInput{
+			Bucket: aws.String(b.config.Bucket),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader(buf[:n]),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to upload to S3: %w", err)
+		}
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("failed to read data: %w", err)
 	}
 
-	_, err = b.client.PutObject(context.Background(), &s3.PutObjectInput{
+	return b.uploadMultipart(key, data, buf)
+}
+
+func (b *S3Backend) uploadMultipart(key string, data io.Reader, firstPart []byte) error {
+	ctx := context.Background()
+
+	created, err := b.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
 		Bucket: aws.String(b.config.Bucket),
 		Key:    aws.String(key),
-		Body:   bytes.NewReader(body),
 	})
+	if err != nil {
+		return fmt.Errorf("failed to start multipart upload: %w", err)
+	}
+
+	abort := func(cause error) error {
+		_, abortErr := b.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(b.config.Bucket),
+			Key:      aws.String(key),
+			UploadId: created.UploadId,
+		})
+		if abortErr != nil {
+			log.Printf("S3: Failed to abort multipart upload for %s: %v", key, abortErr)
+		}
+		return cause
+	}
+
+	buf := firstPart
+	n := len(buf)
+	parts := []types.CompletedPart{}
+
+	for partNumber := int32(1); n > 0; partNumber++ {
+		result, err := b.client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:     aws.String(b.config.Bucket),
+			Key:        aws.String(key),
+			UploadId:   created.UploadId,
+			PartNumber: aws.Int32(partNumber),
+			Body:       bytes.NewReader(buf[:n]),
+		})
+		if err != nil {
+			return abort(fmt.Errorf("failed to upload part %d: %w", partNumber, err))
+		}
+
+		parts = append(parts, types.CompletedPart{
+			ETag:       result.ETag,
+			PartNumber: aws.Int32(partNumber),
+		})
+
+		n, err = io.ReadFull(data, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return abort(fmt.Errorf("failed to read data: %w", err))
+		}
+	}
 
+	_, err = b.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(b.config.Bucket),
+		Key:             aws.String(key),
+		UploadId:        created.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
 	if err != nil {
-		return fmt.Errorf("failed to upload to S3: %w", err)
+		return abort(fmt.Errorf("failed to complete multipart upload: %w", err))
 	}
 
 	return nil
 }
 
-func (b *S3Backend) Download(conn Connection, username, filename string) ([]byte, error) {
+func (b *S3Backend) Download(conn Connection, username, filename string) (io.ReadCloser, *models.FileInfo, error) {
+	key := b.getObjectKey(username, filename)
+
+	result, err := b.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(b.config.Bucket),
+		Key:    aws.String(key),
+	})
+
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, nil, fmt.Errorf("%s: %w", filename, ErrNotFound)
+		}
+		return nil, nil, fmt.Errorf("failed to download from S3: %w", err)
+	}
+
+	info := &models.FileInfo{
+		Name:    filename,
+		Size:    aws.ToInt64(result.ContentLength),
+		ModTime: aws.ToTime(result.LastModified),
+	}
+
+	return result.Body, info, nil
+}
+
+func (b *S3Backend) DownloadRange(conn Connection, username, filename string, offset, length int64) (io.ReadCloser, error) {
 	key := b.getObjectKey(username, filename)
 
+	byteRange := fmt.Sprintf("bytes=%d-", offset)
+	if length >= 0 {
+		byteRange = fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	}
+
 	result, err := b.client.GetObject(context.Background(), &s3.GetObjectInput{
 		Bucket: aws.String(b.config.Bucket),
 		Key:    aws.String(key),
+		Range:  aws.String(byteRange),
 	})
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to download from S3: %w", err)
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, fmt.Errorf("%s: %w", filename, ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to download range from S3: %w", err)
 	}
-	defer result.Body.Close()
 
-	data, err := io.ReadAll(result.Body)
+	return result.Body, nil
+}
+
+func (b *S3Backend) Stat(conn Connection, username, filename string) (*models.FileInfo, error) {
+	key := b.getObjectKey(username, filename)
+
+	result, err := b.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(b.config.Bucket),
+		Key:    aws.String(key),
+	})
+
 	if err != nil {
-		return nil, fmt.Errorf("failed to read S3 object: %w", err)
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return nil, fmt.Errorf("%s: %w", filename, ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to stat S3 object: %w", err)
 	}
 
-	return data, nil
+	return &models.FileInfo{
+		Name:    filename,
+		Size:    aws.ToInt64(result.ContentLength),
+		ModTime: aws.ToTime(result.LastModified),
+	}, nil
 }
 
 func (b *S3Backend) List(conn Connection, username string) ([]models.FileInfo, error) {
 	prefix := b.getUserPrefix(username)
 
-	result, err := b.client.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{
+	paginator := s3.NewListObjectsV2Paginator(b.client, &s3.ListObjectsV2Input{
 		Bucket: aws.String(b.config.Bucket),
 		Prefix: aws.String(prefix),
 	})
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to list S3 objects: %w", err)
+	files := []models.FileInfo{}
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("failed to list S3 objects: %w", err)
+		}
+
+		for _, obj := range page.Contents {
+			filename := (*obj.Key)[len(prefix):]
+			if filename != "" && !strings.HasSuffix(filename, "/") {
+				files = append(files, models.FileInfo{
+					Name:    filename,
+					Size:    *obj.Size,
+					ModTime: *obj.LastModified,
+				})
+			}
+		}
 	}
 
+	return files, nil
+}
+
+// ListDir lists one level below dir, reporting common prefixes as
+// directories. Directories have no objects of their own in S3 apart from the
+// empty "dir/" markers written by Mkdir.
+func (b *S3Backend) ListDir(conn Connection, username, dir string) ([]models.FileInfo, error) {
+	prefix := b.getDirPrefix(username, dir)
+
+	paginator := s3.NewListObjectsV2Paginator(b.client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(b.config.Bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
+
 	files := []models.FileInfo{}
-	for _, obj := range result.Contents {
-		filename := (*obj.Key)[len(prefix):]
-		if filename != "" {
+	exists := dir == ""
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("failed to list S3 objects: %w", err)
+		}
+
+		if len(page.CommonPrefixes) > 0 || len(page.Contents) > 0 {
+			exists = true
+		}
+
+		for _, common := range page.CommonPrefixes {
+			name := strings.TrimSuffix((*common.Prefix)[len(prefix):], "/")
+			files = append(files, models.FileInfo{Name: joinPath(dir, name), IsDir: true})
+		}
+		for _, obj := range page.Contents {
+			name := (*obj.Key)[len(prefix):]
+			if name == "" {
+				continue
+			}
 			files = append(files, models.FileInfo{
-				Name:    filename,
+				Name:    joinPath(dir, name),
 				Size:    *obj.Size,
 				ModTime: *obj.LastModified,
 			})
 		}
 	}
 
+	if !exists {
+		return nil, fmt.Errorf("%s: %w", dir, ErrNotFound)
+	}
 	return files, nil
 }
 
+func (b *S3Backend) Mkdir(conn Connection, username, dir string) error {
+	_, err := b.client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(b.config.Bucket),
+		Key:    aws.String(b.getDirPrefix(username, dir)),
+		Body:   bytes.NewReader(nil),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create directory marker: %w", err)
+	}
+	return nil
+}
+
+func (b *S3Backend) Rmdir(conn Connection, username, dir string) error {
+	prefix := b.getDirPrefix(username, dir)
+
+	result, err := b.client.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{
+		Bucket:  aws.String(b.config.Bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int32(2),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list S3 objects: %w", err)
+	}
+
+	if len(result.Contents) == 0 {
+		return fmt.Errorf("%s: %w", dir, ErrNotFound)
+	}
+	for _, obj := range result.Contents {
+		if *obj.Key != prefix {
+			return fmt.Errorf("%s: %w", dir, ErrNotEmpty)
+		}
+	}
+
+	_, err = b.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(b.config.Bucket),
+		Key:    aws.String(prefix),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete directory marker: %w", err)
+	}
+	return nil
+}
+
+func (b *S3Backend) Rename(conn Connection, username, from, to string) error {
+	if err := b.Copy(conn, username, from, to); err != nil {
+		return err
+	}
+	return b.Delete(conn, username, from)
+}
+
+// Copy copies the object within the bucket. Objects above the CopyObject
+// limit are copied in parts with UploadPartCopy.
+func (b *S3Backend) Copy(conn Connection, username, from, to string) error {
+	info, err := b.Stat(conn, username, from)
+	if err != nil {
+		return err
+	}
+
+	source := b.copySource(b.getObjectKey(username, from))
+	key := b.getObjectKey(username, to)
+
+	if info.Size <= s3MaxCopySize {
+		_, err := b.client.CopyObject(context.Background(), &s3.CopyObjectInput{
+			Bucket:     aws.String(b.config.Bucket),
+			Key:        aws.String(key),
+			CopySource: aws.String(source),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to copy S3 object: %w", err)
+		}
+		return nil
+	}
+
+	return b.copyMultipart(source, key, info.Size)
+}
+
+func (b *S3Backend) copyMultipart(source, key string, size int64) error {
+	ctx := context.Background()
+
+	created, err := b.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(b.config.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start multipart copy: %w", err)
+	}
+
+	parts := []types.CompletedPart{}
+	partNumber := int32(1)
+	for offset := int64(0); offset < size; offset += s3CopyPartSize {
+		end := min(offset+s3CopyPartSize, size) - 1
+
+		result, err := b.client.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+			Bucket:          aws.String(b.config.Bucket),
+			Key:             aws.String(key),
+			UploadId:        created.UploadId,
+			PartNumber:      aws.Int32(partNumber),
+			CopySource:      aws.String(source),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", offset, end)),
+		})
+		if err != nil {
+			_, abortErr := b.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(b.config.Bucket),
+				Key:      aws.String(key),
+				UploadId: created.UploadId,
+			})
+			if abortErr != nil {
+				log.Printf("S3: Failed to abort multipart copy for %s: %v", key, abortErr)
+			}
+			return fmt.Errorf("failed to copy part %d: %w", partNumber, err)
+		}
+
+		parts = append(parts, types.CompletedPart{
+			ETag:       result.CopyPartResult.ETag,
+			PartNumber: aws.Int32(partNumber),
+		})
+		partNumber++
+	}
+
+	_, err = b.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(b.config.Bucket),
+		Key:             aws.String(key),
+		UploadId:        created.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart copy: %w", err)
+	}
+	return nil
+}
+
+// Delete checks that the object exists first: DeleteObject succeeds for
+// missing keys, and a missing file must be reported as ErrNotFound.
 func (b *S3Backend) Delete(conn Connection, username, filename string) error {
+	if _, err := b.Stat(conn, username, filename); err != nil {
+		return err
+	}
+
 	key := b.getObjectKey(username, filename)
 
 	_, err := b.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
@@ -138,6 +470,34 @@ func (b *S3Backend) Close(conn Connection) error {
 	return nil
 }
 
+// Users returns the common prefixes below the path prefix.
+func (b *S3Backend) Users() ([]string, error) {
+	prefix := ""
+	if b.config.PathPrefix != "" {
+		prefix = b.config.PathPrefix + "/"
+	}
+
+	paginator := s3.NewListObjectsV2Paginator(b.client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(b.config.Bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
+
+	var users []string
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("failed to list S3 users: %w", err)
+		}
+		for _, common := range page.CommonPrefixes {
+			if name := strings.TrimSuffix(strings.TrimPrefix(aws.ToString(common.Prefix), prefix), "/"); name != "" {
+				users = append(users, name)
+			}
+		}
+	}
+	return users, nil
+}
+
 func (b *S3Backend) getUserPrefix(username string) string {
 	if b.config.PathPrefix != "" {
 		return b.config.PathPrefix + "/" + username + "/"
@@ -148,3 +508,19 @@ func (b *S3Backend) getUserPrefix(username string) string {
 func (b *S3Backend) getObjectKey(username, filename string) string {
 	return b.getUserPrefix(username) + filename
 }
+
+// copySource is the URL-encoded bucket/key form CopySource expects.
+func (b *S3Backend) copySource(key string) string {
+	segments := strings.Split(b.config.Bucket+"/"+key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func (b *S3Backend) getDirPrefix(username, dir string) string {
+	if dir == "" {
+		return b.getUserPrefix(username)
+	}
+	return b.getUserPrefix(username) + dir + "/"
+}
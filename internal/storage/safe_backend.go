@@ -0,0 +1,396 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"sync"
+
+	"golang.org/x/text/unicode/norm"
+	"photosync-backend/internal/models"
+)
+
+// trashDir holds each user's deleted files. Like sidecars it is hidden, so
+// only the trash operations below can reach it.
+const trashDir = ".trash"
+
+// SafeBackend validates and NFC-normalizes every username and file path
+// before it reaches the wrapped backend, so no backend ever builds a path
+// from unchecked client input.
+type SafeBackend struct {
+	backend StorageBackend
+
+	// unreachable remembers the stored names that were skipped because
+	// CleanPath rejects them, so each is only logged once.
+	unreachable sync.Map
+}
+
+func NewSafeBackend(backend StorageBackend) *SafeBackend {
+	return &SafeBackend{backend: backend}
+}
+
+func (b *SafeBackend) GetName() string {
+	return b.backend.GetName()
+}
+
+func (b *SafeBackend) Connect(username, password string) (Connection, error) {
+	username, err := CleanUsername(username)
+	if err != nil {
+		return nil, err
+	}
+	return b.backend.Connect(username, password)
+}
+
+func (b *SafeBackend) Upload(conn Connection, username, filename string, data io.Reader) error {
+	username, filename, err := cleanNames(username, filename)
+	if err != nil {
+		return err
+	}
+	return b.backend.Upload(conn, username, filename, data)
+}
+
+func (b *SafeBackend) Download(conn Connection, username, filename string) (io.ReadCloser, *models.FileInfo, error) {
+	username, filename, err := cleanNames(username, filename)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	body, info, err := b.backend.Download(conn, username, b.locate(conn, username, filename))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info.Name = filename
+	return body, info, nil
+}
+
+func (b *SafeBackend) DownloadRange(conn Connection, username, filename string, offset, length int64) (io.ReadCloser, error) {
+	username, filename, err := cleanNames(username, filename)
+	if err != nil {
+		return nil, err
+	}
+	return b.backend.DownloadRange(conn, username, b.locate(conn, username, filename), offset, length)
+}
+
+func (b *SafeBackend) Stat(conn Connection, username, filename string) (*models.FileInfo, error) {
+	username, filename, err := cleanNames(username, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := b.backend.Stat(conn, username, filename)
+	if errors.Is(err, ErrNotFound) {
+		if decomposed := norm.NFD.String(filename); decomposed != filename {
+			info, err = b.backend.Stat(conn, username, decomposed)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info.Name = filename
+	return info, nil
+}
+
+func (b *SafeBackend) List(conn Connection, username string) ([]models.FileInfo, error) {
+	username, err := CleanUsername(username)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := b.backend.List(conn, username)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.visible(username, entries), nil
+}
+
+// ListDir lists a single directory; an empty dir is the user's directory.
+func (b *SafeBackend) ListDir(conn Connection, username, dir string) ([]models.FileInfo, error) {
+	username, err := CleanUsername(username)
+	if err != nil {
+		return nil, err
+	}
+	if dir != "" {
+		if dir, err = CleanPath(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	entries, err := b.backend.ListDir(conn, username, dir)
+	if err != nil {
+		return nil, err
+	}
+	return b.visible(username, entries), nil
+}
+
+func (b *SafeBackend) Mkdir(conn Connection, username, dir string) error {
+	username, dir, err := cleanNames(username, dir)
+	if err != nil {
+		return err
+	}
+	return b.backend.Mkdir(conn, username, dir)
+}
+
+func (b *SafeBackend) Rmdir(conn Connection, username, dir string) error {
+	username, dir, err := cleanNames(username, dir)
+	if err != nil {
+		return err
+	}
+	return b.backend.Rmdir(conn, username, dir)
+}
+
+func (b *SafeBackend) Rename(conn Connection, username, from, to string) error {
+	username, from, to, err := cleanPair(username, from, to)
+	if err != nil {
+		return err
+	}
+	return b.backend.Rename(conn, username, b.locate(conn, username, from), to)
+}
+
+func (b *SafeBackend) Copy(conn Connection, username, from, to string) error {
+	username, from, to, err := cleanPair(username, from, to)
+	if err != nil {
+		return err
+	}
+	return b.backend.Copy(conn, username, b.locate(conn, username, from), to)
+}
+
+func (b *SafeBackend) Delete(conn Connection, username, filename string) error {
+	username, filename, err := cleanNames(username, filename)
+	if err != nil {
+		return err
+	}
+	return b.backend.Delete(conn, username, b.locate(conn, username, filename))
+}
+
+func (b *SafeBackend) Close(conn Connection) error {
+	return b.backend.Close(conn)
+}
+
+// Users returns the users with a directory on the backend, or
+// errors.ErrUnsupported when the backend can only be reached with a user's
+// password. Directories that are not valid usernames are left out.
+func (b *SafeBackend) Users() ([]string, error) {
+	lister, ok := b.backend.(UserLister)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	names, err := lister.Users()
+	if err != nil {
+		return nil, err
+	}
+
+	var users []string
+	for _, name := range names {
+		if username, err := CleanUsername(name); err == nil && username == name {
+			users = append(users, username)
+		}
+	}
+	return users, nil
+}
+
+// ReadSidecar returns the contents of a server-owned file in the user's
+// directory, such as the album manifest. Sidecar names start with a dot, so
+// they are hidden from listings and cannot be reached through the file
+// operations above.
+func (b *SafeBackend) ReadSidecar(conn Connection, username, name string) ([]byte, error) {
+	username, err := cleanSidecarNames(username, name)
+	if err != nil {
+		return nil, err
+	}
+
+	body, _, err := b.backend.Download(conn, username, name)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return io.ReadAll(body)
+}
+
+func (b *SafeBackend) WriteSidecar(conn Connection, username, name string, data []byte) error {
+	username, err := cleanSidecarNames(username, name)
+	if err != nil {
+		return err
+	}
+	return b.backend.Upload(conn, username, name, bytes.NewReader(data))
+}
+
+// Trash moves a file into the user's trash area, .trash/<entry>/<path>.
+// entry is chosen by the caller to tell deletions apart.
+func (b *SafeBackend) Trash(conn Connection, username, filename, entry string) error {
+	username, filename, err := cleanNames(username, filename)
+	if err != nil {
+		return err
+	}
+	if err := checkSegment(entry); err != nil {
+		return err
+	}
+	return b.backend.Rename(conn, username, b.locate(conn, username, filename), trashDir+"/"+entry+"/"+filename)
+}
+
+// ListTrash lists the files in the user's trash, named <entry>/<path>.
+func (b *SafeBackend) ListTrash(conn Connection, username string) ([]models.FileInfo, error) {
+	username, err := CleanUsername(username)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []models.FileInfo{}
+	if err := b.walkTrash(conn, username, trashDir, &files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func (b *SafeBackend) walkTrash(conn Connection, username, dir string, files *[]models.FileInfo) error {
+	entries, err := b.backend.ListDir(conn, username, dir)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir {
+			if err := b.walkTrash(conn, username, entry.Name, files); err != nil {
+				return err
+			}
+			continue
+		}
+		entry.Name = norm.NFC.String(strings.TrimPrefix(entry.Name, trashDir+"/"))
+		*files = append(*files, entry)
+	}
+	return nil
+}
+
+// RestoreTrash moves the trashed file name, as returned by ListTrash, back
+// to to.
+func (b *SafeBackend) RestoreTrash(conn Connection, username, name, to string) error {
+	username, name, to, err := cleanPair(username, name, to)
+	if err != nil {
+		return err
+	}
+
+	if err := b.backend.Rename(conn, username, trashDir+"/"+name, to); err != nil {
+		return err
+	}
+	b.pruneTrash(conn, username, name)
+	return nil
+}
+
+// DeleteTrash permanently deletes the trashed file name.
+func (b *SafeBackend) DeleteTrash(conn Connection, username, name string) error {
+	username, name, err := cleanNames(username, name)
+	if err != nil {
+		return err
+	}
+
+	if err := b.backend.Delete(conn, username, trashDir+"/"+name); err != nil {
+		return err
+	}
+	b.pruneTrash(conn, username, name)
+	return nil
+}
+
+// pruneTrash removes the directories left empty once name has left the
+// trash. It stops at the first directory that is not empty.
+func (b *SafeBackend) pruneTrash(conn Connection, username, name string) {
+	for dir := path.Dir(trashDir + "/" + name); dir != trashDir; dir = path.Dir(dir) {
+		if err := b.backend.Rmdir(conn, username, dir); err != nil {
+			return
+		}
+	}
+}
+
+// locate returns the name under which a file is actually stored. Files
+// written by macOS clients directly to a share may use decomposed (NFD)
+// names; those are found when the NFC name does not exist.
+func (b *SafeBackend) locate(conn Connection, username, filename string) string {
+	decomposed := norm.NFD.String(filename)
+	if decomposed == filename {
+		return filename
+	}
+
+	if _, err := b.backend.Stat(conn, username, filename); !errors.Is(err, ErrNotFound) {
+		return filename
+	}
+
+	if _, err := b.backend.Stat(conn, username, decomposed); err == nil {
+		return decomposed
+	}
+
+	return filename
+}
+
+// visible drops hidden entries, and entries inside hidden directories, and
+// normalizes the remaining names to NFC. Entries CleanPath rejects, such as
+// names with a reserved character or a trailing dot created on the share
+// directly, are dropped too: none of the other operations could reach them.
+func (b *SafeBackend) visible(username string, entries []models.FileInfo) []models.FileInfo {
+	files := make([]models.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		if hiddenPath(entry.Name) {
+			continue
+		}
+		name, err := CleanPath(entry.Name)
+		if err != nil {
+			if _, logged := b.unreachable.LoadOrStore(username+"/"+entry.Name, true); !logged {
+				log.Printf("Storage: Skipping %q of user %s: %v", entry.Name, username, err)
+			}
+			continue
+		}
+		entry.Name = name
+		files = append(files, entry)
+	}
+	return files
+}
+
+func cleanSidecarNames(username, name string) (string, error) {
+	if !isHidden(name) {
+		return "", fmt.Errorf("%w: sidecar names must start with a dot", ErrInvalidPath)
+	}
+	if err := checkSegment(name[1:]); err != nil {
+		return "", err
+	}
+	return CleanUsername(username)
+}
+
+func cleanPair(username, from, to string) (string, string, string, error) {
+	username, from, err := cleanNames(username, from)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	to, err = CleanPath(to)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	if from == to {
+		return "", "", "", fmt.Errorf("%w: source and destination are the same", ErrInvalidPath)
+	}
+
+	return username, from, to, nil
+}
+
+func cleanNames(username, filename string) (string, string, error) {
+	username, err := CleanUsername(username)
+	if err != nil {
+		return "", "", err
+	}
+
+	filename, err = CleanPath(filename)
+	if err != nil {
+		return "", "", err
+	}
+
+	return username, filename, nil
+}
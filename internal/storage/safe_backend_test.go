@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"slices"
+	"testing"
+
+	"photosync-backend/internal/models"
+)
+
+func TestSafeBackendListings(t *testing.T) {
+	local, conn := newTestBackend(t,
+		"a.jpg",
+		"café.jpg",
+		"2024/b.jpg",
+		".albums/x.json",
+		"a:b.jpg",
+		"c.jpg ",
+		"d.",
+		"CON.jpg",
+		"dir./e.jpg",
+	)
+	b := NewSafeBackend(local)
+
+	names := func(files []models.FileInfo) []string {
+		var names []string
+		for _, file := range files {
+			names = append(names, file.Name)
+		}
+		slices.Sort(names)
+		return names
+	}
+
+	tests := []struct {
+		name string
+		list func() ([]models.FileInfo, error)
+		want []string
+	}{
+		{name: "List", list: func() ([]models.FileInfo, error) { return b.List(conn, "alice") }, want: []string{"2024/b.jpg", "a.jpg", "caf\u00e9.jpg"}},
+		{name: "ListDir", list: func() ([]models.FileInfo, error) { return b.ListDir(conn, "alice", "") }, want: []string{"2024", "a.jpg", "caf\u00e9.jpg"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := tt.list()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := names(files)
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("names = %q, want %q", got, tt.want)
+			}
+			// Every listed name can be passed back to the other operations.
+			for _, file := range files {
+				if file.IsDir {
+					_, err = b.ListDir(conn, "alice", file.Name)
+				} else {
+					_, err = b.Stat(conn, "alice", file.Name)
+				}
+				if err != nil {
+					t.Errorf("%s: %v", file.Name, err)
+				}
+			}
+		})
+	}
+}
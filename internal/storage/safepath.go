@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var ErrInvalidPath = errors.New("invalid file name")
+
+const (
+	maxNameLength = 255
+	maxPathLength = 1024
+)
+
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// CleanFilename validates a client-supplied file name and returns its NFC
+// form. Names that could escape the user's directory, that some backends
+// cannot store (SMB rejects Windows-reserved names and characters), or that
+// are hidden/internal (leading dot) are rejected with ErrInvalidPath.
+func CleanFilename(name string) (string, error) {
+	if !utf8.ValidString(name) {
+		return "", fmt.Errorf("%w: not valid UTF-8", ErrInvalidPath)
+	}
+
+	name = norm.NFC.String(name)
+
+	if err := checkSegment(name); err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// CleanPath validates a slash-separated path within the user's directory,
+// applying the CleanFilename rules to every segment. Empty segments, and so
+// leading, trailing and doubled slashes, are rejected.
+func CleanPath(p string) (string, error) {
+	if !utf8.ValidString(p) {
+		return "", fmt.Errorf("%w: not valid UTF-8", ErrInvalidPath)
+	}
+
+	p = norm.NFC.String(p)
+
+	if len(p) > maxPathLength {
+		return "", fmt.Errorf("%w: path longer than %d bytes", ErrInvalidPath, maxPathLength)
+	}
+
+	for _, segment := range strings.Split(p, "/") {
+		if err := checkSegment(segment); err != nil {
+			return "", err
+		}
+	}
+
+	return p, nil
+}
+
+// CleanUsername applies the same rules to the per-user directory name.
+func CleanUsername(username string) (string, error) {
+	cleaned, err := CleanFilename(username)
+	if err != nil {
+		return "", fmt.Errorf("invalid username: %w", err)
+	}
+	return cleaned, nil
+}
+
+func checkSegment(name string) error {
+	if name == "" {
+		return fmt.Errorf("%w: empty name", ErrInvalidPath)
+	}
+
+	if len(name) > maxNameLength {
+		return fmt.Errorf("%w: name longer than %d bytes", ErrInvalidPath, maxNameLength)
+	}
+
+	if strings.HasPrefix(name, ".") {
+		return fmt.Errorf("%w: names may not start with a dot", ErrInvalidPath)
+	}
+
+	if strings.HasSuffix(name, " ") {
+		return fmt.Errorf("%w: names may not end with a space", ErrInvalidPath)
+	}
+
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("%w: control characters are not allowed", ErrInvalidPath)
+		}
+		if strings.ContainsRune(`/\<>:"|?*`, r) {
+			return fmt.Errorf("%w: character %q is not allowed", ErrInvalidPath, r)
+		}
+	}
+
+	base, _, _ := strings.Cut(name, ".")
+	if reservedNames[strings.ToUpper(strings.TrimRight(base, " "))] {
+		return fmt.Errorf("%w: %s is a reserved name", ErrInvalidPath, name)
+	}
+
+	if strings.HasSuffix(name, ".") {
+		return fmt.Errorf("%w: names may not end with a dot", ErrInvalidPath)
+	}
+
+	return nil
+}
+
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// hiddenPath reports whether any segment of p is hidden.
+func hiddenPath(p string) bool {
+	for _, segment := range strings.Split(p, "/") {
+		if isHidden(segment) {
+			return true
+		}
+	}
+	return false
+}
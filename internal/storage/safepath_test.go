@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCleanFilename(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{name: "plain", input: "IMG_0001.JPG", want: "IMG_0001.JPG"},
+		{name: "spaces inside", input: "my photo (1).jpg", want: "my photo (1).jpg"},
+		{name: "NFD to NFC", input: "cafe\u0301.jpg", want: "caf\u00e9.jpg"},
+		{name: "unicode", input: "写真.heic", want: "写真.heic"},
+		{name: "empty", input: "", wantErr: true},
+		{name: "dot", input: ".", wantErr: true},
+		{name: "dot dot", input: "..", wantErr: true},
+		{name: "hidden", input: ".albums", wantErr: true},
+		{name: "slash", input: "a/b.jpg", wantErr: true},
+		{name: "backslash", input: `a\b.jpg`, wantErr: true},
+		{name: "colon", input: "a:b.jpg", wantErr: true},
+		{name: "wildcard", input: "a*.jpg", wantErr: true},
+		{name: "control character", input: "a\x00b.jpg", wantErr: true},
+		{name: "newline", input: "a\nb.jpg", wantErr: true},
+		{name: "trailing space", input: "a.jpg ", wantErr: true},
+		{name: "trailing dot", input: "a.", wantErr: true},
+		{name: "reserved", input: "CON", wantErr: true},
+		{name: "reserved with extension", input: "nul.txt", wantErr: true},
+		{name: "reserved prefix only", input: "CONSOLE.jpg", want: "CONSOLE.jpg"},
+		{name: "invalid UTF-8", input: "a\xffb.jpg", wantErr: true},
+		{name: "longest", input: strings.Repeat("a", maxNameLength), want: strings.Repeat("a", maxNameLength)},
+		{name: "too long", input: strings.Repeat("a", maxNameLength+1), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CleanFilename(tt.input)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidPath) {
+					t.Fatalf("CleanFilename(%q) error = %v, want ErrInvalidPath", tt.input, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CleanFilename(%q) error = %v", tt.input, err)
+			}
+			if got != tt.want {
+				t.Errorf("CleanFilename(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{name: "file", input: "IMG_0001.JPG", want: "IMG_0001.JPG"},
+		{name: "nested", input: "2024/Holiday/IMG_0001.JPG", want: "2024/Holiday/IMG_0001.JPG"},
+		{name: "NFD segment", input: "Cafe\u0301/a.jpg", want: "Caf\u00e9/a.jpg"},
+		{name: "leading slash", input: "/a.jpg", wantErr: true},
+		{name: "trailing slash", input: "2024/", wantErr: true},
+		{name: "double slash", input: "2024//a.jpg", wantErr: true},
+		{name: "parent", input: "../bob/a.jpg", wantErr: true},
+		{name: "parent inside", input: "2024/../../bob/a.jpg", wantErr: true},
+		{name: "current", input: "./a.jpg", wantErr: true},
+		{name: "hidden folder", input: ".trash/a.jpg", wantErr: true},
+		{name: "reserved folder", input: "aux/a.jpg", wantErr: true},
+		{name: "backslash", input: `2024\a.jpg`, wantErr: true},
+		{name: "too long", input: strings.Repeat("abcdefgh/", maxPathLength/9+1) + "a.jpg", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CleanPath(tt.input)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidPath) {
+					t.Fatalf("CleanPath(%q) error = %v, want ErrInvalidPath", tt.input, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CleanPath(%q) error = %v", tt.input, err)
+			}
+			if got != tt.want {
+				t.Errorf("CleanPath(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"io"
 	"log"
 	"net"
+	"os"
+	"path"
+	"strings"
 
 	"github.com/hirochachacha/go-smb2"
 	"photosync-backend/internal/config"
@@ -81,23 +84,44 @@ func (b *SMBBackend) Upload(conn Connection, username, filename string, data io.
 		return fmt.Errorf("failed to create user directory: %w", err)
 	}
 
+	if dir := path.Dir(filename); dir != "." {
+		if err := smbConn.share.MkdirAll(userDir+"/"+dir, 0755); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+	}
+
 	fullPath := userDir + "/" + filename
 
-	file, err := smbConn.share.Create(fullPath)
+	// The data goes to a temporary file first, so an aborted stream neither
+	// leaves a truncated file under the real name nor destroys the file it
+	// would have replaced.
+	tmpPath, err := tempPath(path.Dir(fullPath))
+	if err != nil {
+		return err
+	}
+	file, err := smbConn.share.Create(tmpPath)
 	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
+		return fmt.Errorf("failed to create temp file: %w", err)
 	}
-	defer file.Close()
 
 	_, err = io.Copy(file, data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
+		smbConn.share.Remove(tmpPath)
 		return fmt.Errorf("failed to write file: %w", err)
 	}
 
+	if err := b.replace(smbConn.share, tmpPath, fullPath); err != nil {
+		smbConn.share.Remove(tmpPath)
+		return fmt.Errorf("failed to move file into place: %w", err)
+	}
+
 	return nil
 }
 
-func (b *SMBBackend) Download(conn Connection, username, filename string) ([]byte, error) {
+func (b *SMBBackend) Download(conn Connection, username, filename string) (io.ReadCloser, *models.FileInfo, error) {
 	smbConn := conn.(*SMBConnection)
 
 	userDir := username
@@ -108,16 +132,66 @@ func (b *SMBBackend) Download(conn Connection, username, filename string) ([]byt
 
 	file, err := smbConn.share.Open(fullPath)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, fmt.Errorf("%s: %w", filename, ErrNotFound)
+		}
+		return nil, nil, fmt.Errorf("failed to open file: %w", err)
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	if stat.IsDir() {
+		file.Close()
+		return nil, nil, fmt.Errorf("%s is a directory: %w", filename, ErrNotFound)
+	}
+
+	info := &models.FileInfo{
+		Name:    filename,
+		Size:    stat.Size(),
+		ModTime: stat.ModTime(),
+	}
+
+	return file, info, nil
+}
+
+func (b *SMBBackend) DownloadRange(conn Connection, username, filename string, offset, length int64) (io.ReadCloser, error) {
+	smbConn := conn.(*SMBConnection)
+
+	file, err := smbConn.share.Open(b.getFilePath(username, filename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s: %w", filename, ErrNotFound)
+		}
 		return nil, fmt.Errorf("failed to open file: %w", err)
 	}
-	defer file.Close()
 
-	data, err := io.ReadAll(file)
+	return sectionReadCloser(file, offset, length)
+}
+
+func (b *SMBBackend) Stat(conn Connection, username, filename string) (*models.FileInfo, error) {
+	smbConn := conn.(*SMBConnection)
+
+	stat, err := smbConn.share.Stat(b.getFilePath(username, filename))
 	if err != nil {
-		return nil, fmt.Errorf("failed to read file: %w", err)
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s: %w", filename, ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to stat file: %w", err)
 	}
 
-	return data, nil
+	if stat.IsDir() {
+		return nil, fmt.Errorf("%s is a directory: %w", filename, ErrNotFound)
+	}
+
+	return &models.FileInfo{
+		Name:    filename,
+		Size:    stat.Size(),
+		ModTime: stat.ModTime(),
+	}, nil
 }
 
 func (b *SMBBackend) List(conn Connection, username string) ([]models.FileInfo, error) {
@@ -131,30 +205,207 @@ func (b *SMBBackend) List(conn Connection, username string) ([]models.FileInfo,
 	log.Printf("SMB: Listing files for user %s in directory: %s", username, userDir)
 
 	if err := b.ensureDirectory(smbConn.share, userDir); err != nil {
-		log.Printf("SMB: Could not ensure directory %s exists: %v - will try to list anyway", userDir, err)
+		return nil, err
 	}
 
 	entries, err := smbConn.share.ReadDir(userDir)
 	if err != nil {
-		log.Printf("SMB: ReadDir failed for %s: %v - returning empty array", userDir, err)
-		return []models.FileInfo{}, nil
+		return nil, fmt.Errorf("failed to read directory %s: %w", userDir, err)
 	}
 
 	files := []models.FileInfo{}
+	if err := b.walk(smbConn.share, userDir, "", entries, &files); err != nil {
+		return nil, err
+	}
+
+	log.Printf("SMB: Found %d files in %s", len(files), userDir)
+	return files, nil
+}
+
+// walk appends the files among entries of the directory rel, and of its
+// subdirectories, to files.
+func (b *SMBBackend) walk(share *smb2.Share, userDir, rel string, entries []os.FileInfo, files *[]models.FileInfo) error {
 	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), uploadTempPrefix) {
+			continue
+		}
+
+		name := joinPath(rel, entry.Name())
 		if !entry.IsDir() {
-			files = append(files, models.FileInfo{
-				Name:    entry.Name(),
+			*files = append(*files, models.FileInfo{
+				Name:    name,
 				Size:    entry.Size(),
 				ModTime: entry.ModTime(),
 			})
+			continue
+		}
+
+		children, err := share.ReadDir(userDir + "/" + name)
+		if err != nil {
+			return fmt.Errorf("failed to read directory %s: %w", name, err)
+		}
+		if err := b.walk(share, userDir, name, children, files); err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+func (b *SMBBackend) ListDir(conn Connection, username, dir string) ([]models.FileInfo, error) {
+	smbConn := conn.(*SMBConnection)
+
+	entries, err := smbConn.share.ReadDir(b.getFilePath(username, dir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			if dir == "" {
+				return []models.FileInfo{}, nil
+			}
+			return nil, fmt.Errorf("%s: %w", dir, ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	files := []models.FileInfo{}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), uploadTempPrefix) {
+			continue
+		}
+
+		file := models.FileInfo{
+			Name:    joinPath(dir, entry.Name()),
+			ModTime: entry.ModTime(),
+			IsDir:   entry.IsDir(),
+		}
+		if !entry.IsDir() {
+			file.Size = entry.Size()
+		}
+		files = append(files, file)
+	}
 
-	log.Printf("SMB: Found %d files in %s", len(files), userDir)
 	return files, nil
 }
 
+func (b *SMBBackend) Mkdir(conn Connection, username, dir string) error {
+	smbConn := conn.(*SMBConnection)
+
+	if err := smbConn.share.MkdirAll(b.getFilePath(username, dir), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	return nil
+}
+
+func (b *SMBBackend) Rmdir(conn Connection, username, dir string) error {
+	smbConn := conn.(*SMBConnection)
+	fullPath := b.getFilePath(username, dir)
+
+	stat, err := smbConn.share.Stat(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s: %w", dir, ErrNotFound)
+		}
+		return fmt.Errorf("failed to stat directory: %w", err)
+	}
+	if !stat.IsDir() {
+		return fmt.Errorf("%s is not a directory: %w", dir, ErrNotFound)
+	}
+
+	entries, err := smbConn.share.ReadDir(fullPath)
+	if err != nil {
+		return fmt.Errorf("failed to read directory: %w", err)
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("%s: %w", dir, ErrNotEmpty)
+	}
+
+	if err := smbConn.share.Remove(fullPath); err != nil {
+		return fmt.Errorf("failed to remove directory: %w", err)
+	}
+	return nil
+}
+
+func (b *SMBBackend) Rename(conn Connection, username, from, to string) error {
+	smbConn := conn.(*SMBConnection)
+
+	source := b.getFilePath(username, from)
+	target := b.getFilePath(username, to)
+
+	if _, err := smbConn.share.Stat(source); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s: %w", from, ErrNotFound)
+		}
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	if dir := path.Dir(to); dir != "." {
+		if err := smbConn.share.MkdirAll(b.getFilePath(username, dir), 0755); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+	}
+
+	if err := b.replace(smbConn.share, source, target); err != nil {
+		return fmt.Errorf("failed to rename file: %w", err)
+	}
+	return nil
+}
+
+// smbRenamer is the part of a share that replace needs.
+type smbRenamer interface {
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+}
+
+// replace renames source to target. SMB refuses to rename over an existing
+// file, so an existing target is moved aside first, and put back if the
+// rename fails. Shares are usually case insensitive, so a change of case
+// only has no target to move.
+func (b *SMBBackend) replace(share smbRenamer, source, target string) error {
+	var aside string
+	if !strings.EqualFold(source, target) {
+		tmp, err := tempPath(path.Dir(target))
+		if err != nil {
+			return err
+		}
+		if err := share.Rename(target, tmp); err == nil {
+			aside = tmp
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("failed to move existing file aside: %w", err)
+		}
+	}
+
+	if err := share.Rename(source, target); err != nil {
+		if aside != "" {
+			if restoreErr := share.Rename(aside, target); restoreErr != nil {
+				log.Printf("SMB: Failed to restore %s from %s: %v", target, aside, restoreErr)
+			}
+		}
+		return err
+	}
+
+	if aside != "" {
+		if err := share.Remove(aside); err != nil {
+			log.Printf("SMB: Failed to remove replaced file %s: %v", aside, err)
+		}
+	}
+	return nil
+}
+
+// Copy streams the file through the server; go-smb2 offers no server-side
+// copy.
+func (b *SMBBackend) Copy(conn Connection, username, from, to string) error {
+	smbConn := conn.(*SMBConnection)
+
+	src, err := smbConn.share.Open(b.getFilePath(username, from))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s: %w", from, ErrNotFound)
+		}
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer src.Close()
+
+	return b.Upload(conn, username, to, src)
+}
+
 func (b *SMBBackend) Delete(conn Connection, username, filename string) error {
 	smbConn := conn.(*SMBConnection)
 
@@ -166,6 +417,9 @@ func (b *SMBBackend) Delete(conn Connection, username, filename string) error {
 
 	err := smbConn.share.Remove(fullPath)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s: %w", filename, ErrNotFound)
+		}
 		return fmt.Errorf("failed to delete file: %w", err)
 	}
 
@@ -180,6 +434,14 @@ func (b *SMBBackend) Close(conn Connection) error {
 	return smbConn.session.Logoff()
 }
 
+func (b *SMBBackend) getFilePath(username, filename string) string {
+	userDir := username
+	if b.config.Path != "" {
+		userDir = b.config.Path + "/" + username
+	}
+	return joinPath(userDir, filename)
+}
+
 func (b *SMBBackend) ensureDirectory(share *smb2.Share, path string) error {
 	log.Printf("SMB: Checking if directory exists: %s", path)
 
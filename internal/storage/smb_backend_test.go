@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"errors"
+	"maps"
+	"os"
+	"strings"
+	"testing"
+)
+
+// fakeShare keeps files by name and renames like SMB: names are case
+// insensitive and a rename never replaces an existing file.
+type fakeShare struct {
+	files map[string]string
+	fail  func(oldpath, newpath string) error
+}
+
+func (s *fakeShare) find(name string) (string, bool) {
+	for existing := range s.files {
+		if strings.EqualFold(existing, name) {
+			return existing, true
+		}
+	}
+	return "", false
+}
+
+func (s *fakeShare) Rename(oldpath, newpath string) error {
+	if s.fail != nil {
+		if err := s.fail(oldpath, newpath); err != nil {
+			return err
+		}
+	}
+	source, ok := s.find(oldpath)
+	if !ok {
+		return &os.PathError{Op: "rename", Path: oldpath, Err: os.ErrNotExist}
+	}
+	if target, ok := s.find(newpath); ok && target != source {
+		return &os.PathError{Op: "rename", Path: newpath, Err: os.ErrExist}
+	}
+	data := s.files[source]
+	delete(s.files, source)
+	s.files[newpath] = data
+	return nil
+}
+
+func (s *fakeShare) Remove(name string) error {
+	existing, ok := s.find(name)
+	if !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	delete(s.files, existing)
+	return nil
+}
+
+func TestSMBReplace(t *testing.T) {
+	errDenied := errors.New("access denied")
+
+	tests := []struct {
+		name    string
+		files   map[string]string
+		source  string
+		target  string
+		fail    func(oldpath, newpath string) error
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:   "new name",
+			files:  map[string]string{"alice/a.jpg": "a"},
+			source: "alice/a.jpg", target: "alice/2024/b.jpg",
+			want: map[string]string{"alice/2024/b.jpg": "a"},
+		},
+		{
+			name:   "existing target",
+			files:  map[string]string{"alice/a.jpg": "a", "alice/b.jpg": "b"},
+			source: "alice/a.jpg", target: "alice/b.jpg",
+			want: map[string]string{"alice/b.jpg": "a"},
+		},
+		{
+			name:   "case change",
+			files:  map[string]string{"alice/a.jpg": "a"},
+			source: "alice/a.jpg", target: "alice/A.jpg",
+			want: map[string]string{"alice/A.jpg": "a"},
+		},
+		{
+			name:   "rename fails",
+			files:  map[string]string{"alice/a.jpg": "a", "alice/b.jpg": "b"},
+			source: "alice/a.jpg", target: "alice/b.jpg",
+			fail: func(oldpath, newpath string) error {
+				if oldpath == "alice/a.jpg" {
+					return errDenied
+				}
+				return nil
+			},
+			want:    map[string]string{"alice/a.jpg": "a", "alice/b.jpg": "b"},
+			wantErr: true,
+		},
+		{
+			name:   "target cannot be moved aside",
+			files:  map[string]string{"alice/a.jpg": "a", "alice/b.jpg": "b"},
+			source: "alice/a.jpg", target: "alice/b.jpg",
+			fail: func(oldpath, newpath string) error {
+				if oldpath == "alice/b.jpg" {
+					return errDenied
+				}
+				return nil
+			},
+			want:    map[string]string{"alice/a.jpg": "a", "alice/b.jpg": "b"},
+			wantErr: true,
+		},
+		{
+			name:   "missing source",
+			files:  map[string]string{"alice/b.jpg": "b"},
+			source: "alice/a.jpg", target: "alice/b.jpg",
+			want:    map[string]string{"alice/b.jpg": "b"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			share := &fakeShare{files: tt.files, fail: tt.fail}
+
+			err := (&SMBBackend{}).replace(share, tt.source, tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("replace error = %v, want error %v", err, tt.wantErr)
+			}
+			// Nothing is left aside, whatever the outcome.
+			if !maps.Equal(share.files, tt.want) {
+				t.Errorf("files = %v, want %v", share.files, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package thumbnail
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+
+	_ "image/gif"
+	_ "image/png"
+
+	_ "golang.org/x/image/webp"
+	"golang.org/x/sync/singleflight"
+
+	"photosync-backend/internal/media"
+	"photosync-backend/internal/models"
+)
+
+const (
+	maxSourceBytes  = 100 << 20
+	maxSourcePixels = 100_000_000
+)
+
+var ErrUnsupported = errors.New("unsupported image format")
+
+// decodable holds the content types of the registered image decoders.
+var decodable = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// Supported reports whether thumbnails can be rendered from files of
+// contentType, so HEIC photos and videos can be turned away before their
+// source is downloaded.
+func Supported(contentType string) bool {
+	return decodable[contentType]
+}
+
+type Generator struct {
+	cacheDir string
+	quality  int
+	maxAge   time.Duration
+
+	// renders limits how many thumbnails are generated at once, as each
+	// holds a decoded source image in memory. Requests for the same
+	// thumbnail share one generation.
+	renders chan struct{}
+	flight  singleflight.Group
+}
+
+func NewGenerator(cacheDir string, quality int, maxAge time.Duration) (*Generator, error) {
+	if err := os.MkdirAll(cacheDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create thumbnail cache directory: %w", err)
+	}
+
+	g := &Generator{
+		cacheDir: cacheDir,
+		quality:  quality,
+		maxAge:   maxAge,
+		renders:  make(chan struct{}, runtime.NumCPU()),
+	}
+
+	go g.cleanup()
+
+	return g, nil
+}
+
+// Key identifies a thumbnail of a specific version of a file, so edits to the
+// original (new size or modification time) never serve a stale thumbnail.
+func Key(username string, info *models.FileInfo, size int) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%d\x00%d\x00%d", username, info.Name, info.ModTime.UnixNano(), info.Size, size)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Get returns the path of the cached thumbnail for key, generating it from
+// the source returned by open when it is not cached yet.
+func (g *Generator) Get(key string, size int, open func() (io.ReadCloser, error)) (string, error) {
+	path := g.path(key)
+
+	if _, err := os.Stat(path); err == nil {
+		now := time.Now()
+		os.Chtimes(path, now, now)
+		return path, nil
+	}
+
+	_, err, _ := g.flight.Do(key+"/"+strconv.Itoa(size), func() (interface{}, error) {
+		// A generation that just finished may have stored it.
+		if _, err := os.Stat(path); err == nil {
+			return nil, nil
+		}
+
+		g.renders <- struct{}{}
+		defer func() { <-g.renders }()
+		return nil, g.generate(path, size, open)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// generate renders the thumbnail of the source returned by open and stores
+// it at path.
+func (g *Generator) generate(path string, size int, open func() (io.ReadCloser, error)) error {
+	src, err := open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	data, err := io.ReadAll(io.LimitReader(src, maxSourceBytes+1))
+	if err != nil {
+		return fmt.Errorf("failed to read source image: %w", err)
+	}
+	if len(data) > maxSourceBytes {
+		return fmt.Errorf("source image too large: %w", ErrUnsupported)
+	}
+
+	thumb, err := render(data, size)
+	if err != nil {
+		return err
+	}
+
+	return g.store(path, thumb)
+}
+
+func render(data []byte, size int) (image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+	if config.Width*config.Height > maxSourcePixels {
+		return nil, fmt.Errorf("source image has too many pixels: %w", ErrUnsupported)
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	orientation := 1
+	if format == "jpeg" {
+		orientation = media.Orientation(bytes.NewReader(data))
+	}
+
+	return orient(scale(img, size), orientation), nil
+}
+
+func (g *Generator) store(path string, img image.Image) error {
+	tmp, err := os.CreateTemp(g.cacheDir, ".thumb-*")
+	if err != nil {
+		return fmt.Errorf("failed to create thumbnail file: %w", err)
+	}
+	tmpPath := tmp.Name()
+
+	if err := jpeg.Encode(tmp, img, &jpeg.Options{Quality: g.quality}); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write thumbnail: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to store thumbnail: %w", err)
+	}
+
+	return nil
+}
+
+func (g *Generator) path(key string) string {
+	return filepath.Join(g.cacheDir, key+".jpg")
+}
+
+func (g *Generator) cleanup() {
+	ticker := time.NewTicker(1 * time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		entries, err := os.ReadDir(g.cacheDir)
+		if err != nil {
+			log.Printf("Thumbnails: Could not read cache directory: %v", err)
+			continue
+		}
+
+		cutoff := time.Now().Add(-g.maxAge)
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil || info.ModTime().After(cutoff) {
+				continue
+			}
+			os.Remove(filepath.Join(g.cacheDir, entry.Name()))
+		}
+	}
+}
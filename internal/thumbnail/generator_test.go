@@ -0,0 +1,106 @@
+package thumbnail
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestGenerator(t *testing.T, renders int) *Generator {
+	t.Helper()
+
+	g, err := NewGenerator(t.TempDir(), 80, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.renders = make(chan struct{}, renders)
+	return g
+}
+
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 64, 48))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGetShared(t *testing.T) {
+	g := newTestGenerator(t, 4)
+	data := testPNG(t)
+
+	var opens atomic.Int32
+	release := make(chan struct{})
+	open := func() (io.ReadCloser, error) {
+		opens.Add(1)
+		<-release
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	var wg sync.WaitGroup
+	paths := make([]string, 8)
+	errs := make([]error, len(paths))
+	for i := range paths {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			paths[i], errs[i] = g.Get("key", 32, open)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for i := range paths {
+		if errs[i] != nil || paths[i] != g.path("key") {
+			t.Errorf("Get = %q, %v", paths[i], errs[i])
+		}
+	}
+	if n := opens.Load(); n != 1 {
+		t.Errorf("source opened %d times, want once", n)
+	}
+}
+
+func TestGetLimit(t *testing.T) {
+	const limit = 2
+	g := newTestGenerator(t, limit)
+	data := testPNG(t)
+
+	var active, peak atomic.Int32
+	open := func() (io.ReadCloser, error) {
+		n := active.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		active.Add(-1)
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := g.Get("key"+strconv.Itoa(i), 32, open); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := peak.Load(); n > limit {
+		t.Errorf("%d thumbnails generated at once, want at most %d", n, limit)
+	}
+}
@@ -0,0 +1,91 @@
+package thumbnail
+
+import (
+	"image"
+	"image/color"
+	"slices"
+	"testing"
+)
+
+// grid builds an image whose pixels are the given gray levels, row by row,
+// with an origin away from 0,0.
+func grid(rows [][]uint8) image.Image {
+	img := image.NewGray(image.Rect(10, 20, 10+len(rows[0]), 20+len(rows)))
+	for y, row := range rows {
+		for x, v := range row {
+			img.SetGray(10+x, 20+y, color.Gray{Y: v})
+		}
+	}
+	return img
+}
+
+func levels(img image.Image) [][]uint8 {
+	bounds := img.Bounds()
+	rows := make([][]uint8, bounds.Dy())
+	for y := range rows {
+		rows[y] = make([]uint8, bounds.Dx())
+		for x := range rows[y] {
+			rows[y][x] = color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y
+		}
+	}
+	return rows
+}
+
+func TestOrient(t *testing.T) {
+	const (
+		a, b, c = 10, 20, 30
+		d, e, f = 40, 50, 60
+	)
+	src := [][]uint8{
+		{a, b, c},
+		{d, e, f},
+	}
+
+	tests := []struct {
+		orientation int
+		want        [][]uint8
+	}{
+		{0, src},
+		{1, src},
+		{2, [][]uint8{{c, b, a}, {f, e, d}}},
+		{3, [][]uint8{{f, e, d}, {c, b, a}}},
+		{4, [][]uint8{{d, e, f}, {a, b, c}}},
+		{5, [][]uint8{{a, d}, {b, e}, {c, f}}},
+		{6, [][]uint8{{d, a}, {e, b}, {f, c}}},
+		{7, [][]uint8{{f, c}, {e, b}, {d, a}}},
+		{8, [][]uint8{{c, f}, {b, e}, {a, d}}},
+		{9, src},
+	}
+
+	for _, tt := range tests {
+		got := levels(orient(grid(src), tt.orientation))
+		if !slices.EqualFunc(got, tt.want, slices.Equal) {
+			t.Errorf("orient(%d) = %v, want %v", tt.orientation, got, tt.want)
+		}
+	}
+}
+
+func TestScale(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		size          int
+		wantW, wantH  int
+	}{
+		{name: "small enough", width: 100, height: 50, size: 256, wantW: 100, wantH: 50},
+		{name: "landscape", width: 1000, height: 500, size: 256, wantW: 256, wantH: 128},
+		{name: "portrait", width: 500, height: 1000, size: 256, wantW: 128, wantH: 256},
+		{name: "square", width: 300, height: 300, size: 256, wantW: 256, wantH: 256},
+		{name: "panorama", width: 4000, height: 10, size: 256, wantW: 256, wantH: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := image.NewRGBA(image.Rect(0, 0, tt.width, tt.height))
+			bounds := scale(img, tt.size).Bounds()
+			if bounds.Dx() != tt.wantW || bounds.Dy() != tt.wantH {
+				t.Errorf("scale(%dx%d, %d) = %dx%d, want %dx%d", tt.width, tt.height, tt.size, bounds.Dx(), bounds.Dy(), tt.wantW, tt.wantH)
+			}
+		})
+	}
+}
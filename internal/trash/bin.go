@@ -0,0 +1,195 @@
+package trash
+
+import (
+	"cmp"
+	"errors"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"photosync-backend/internal/media"
+	"photosync-backend/internal/models"
+	"photosync-backend/internal/storage"
+)
+
+// Bin keeps deleted files in each user's trash on the storage backend until
+// they are restored, removed or older than the retention. A trashed file is
+// stored under an entry named after the time of deletion, and its ID is
+// "<entry>/<path>".
+type Bin struct {
+	backend   *storage.SafeBackend
+	retention time.Duration
+}
+
+// NewBin returns a bin keeping files for retention; with 0 the trash is
+// disabled.
+func NewBin(backend *storage.SafeBackend, retention time.Duration) *Bin {
+	return &Bin{backend: backend, retention: retention}
+}
+
+func (b *Bin) Enabled() bool {
+	return b.retention > 0
+}
+
+// Delete moves filename to the trash and returns its ID.
+func (b *Bin) Delete(conn storage.Connection, username, filename string) (string, error) {
+	entry := strconv.FormatInt(time.Now().UnixNano(), 10)
+	if err := b.backend.Trash(conn, username, filename, entry); err != nil {
+		return "", err
+	}
+	return entry + "/" + filename, nil
+}
+
+// List returns the user's trashed files, most recently deleted first.
+func (b *Bin) List(conn storage.Connection, username string) ([]models.TrashItem, error) {
+	files, err := b.backend.ListTrash(conn, username)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]models.TrashItem, 0, len(files))
+	for _, file := range files {
+		name, deleted, ok := ParseID(file.Name)
+		if !ok {
+			continue
+		}
+		items = append(items, models.TrashItem{
+			ID:          file.Name,
+			Name:        name,
+			Size:        file.Size,
+			ContentType: media.ContentTypeByExtension(name),
+			DeletedAt:   deleted,
+			ExpiresAt:   deleted.Add(b.retention),
+		})
+	}
+
+	slices.SortFunc(items, func(a, b models.TrashItem) int {
+		if c := b.DeletedAt.Compare(a.DeletedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return items, nil
+}
+
+// Restore moves a trashed file back into the library as to.
+func (b *Bin) Restore(conn storage.Connection, username, id, to string) error {
+	return b.backend.RestoreTrash(conn, username, id, to)
+}
+
+// Remove permanently deletes a trashed file.
+func (b *Bin) Remove(conn storage.Connection, username, id string) error {
+	return b.backend.DeleteTrash(conn, username, id)
+}
+
+// Empty permanently deletes every trashed file of the user and returns how
+// many were deleted.
+func (b *Bin) Empty(conn storage.Connection, username string) (int, error) {
+	return b.removeWhere(conn, username, func(models.TrashItem) bool { return true })
+}
+
+// Purge permanently deletes the user's trashed files older than the
+// retention.
+func (b *Bin) Purge(conn storage.Connection, username string) (int, error) {
+	now := time.Now()
+	return b.removeWhere(conn, username, func(item models.TrashItem) bool {
+		return item.ExpiresAt.Before(now)
+	})
+}
+
+func (b *Bin) removeWhere(conn storage.Connection, username string, match func(models.TrashItem) bool) (int, error) {
+	items, err := b.List(conn, username)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, item := range items {
+		if !match(item) {
+			continue
+		}
+		if err := b.Remove(conn, username, item.ID); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Watch purges expired files each interval: of every user when the backend
+// can list them, or else of every user with a pooled connection. Users of
+// backends that need their password are also purged when they connect,
+// however long they were away.
+func (b *Bin) Watch(pool *storage.GenericConnectionPool, interval time.Duration) {
+	pool.OnConnect(func(username string) {
+		b.purgeLeased(pool, username)
+	})
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		b.purgeAll(pool)
+	}
+}
+
+func (b *Bin) purgeAll(pool *storage.GenericConnectionPool) {
+	users, err := b.backend.Users()
+	if err == nil {
+		for _, username := range users {
+			conn, err := b.backend.Connect(username, "")
+			if err != nil {
+				log.Printf("Trash: Failed to connect for user %s: %v", username, err)
+				continue
+			}
+			b.purge(conn, username)
+			b.backend.Close(conn)
+		}
+		return
+	}
+	if !errors.Is(err, errors.ErrUnsupported) {
+		log.Printf("Trash: Failed to list users: %v", err)
+	}
+
+	for _, username := range pool.Active() {
+		b.purgeLeased(pool, username)
+	}
+}
+
+// purgeLeased purges the user's expired files over their pooled connection,
+// if they have one.
+func (b *Bin) purgeLeased(pool *storage.GenericConnectionPool, username string) {
+	conn, release, ok := pool.Lease(username)
+	if !ok {
+		return
+	}
+	defer release()
+	b.purge(conn, username)
+}
+
+// purge runs Purge for a background job, logging the outcome.
+func (b *Bin) purge(conn storage.Connection, username string) {
+	removed, err := b.Purge(conn, username)
+	if err != nil {
+		log.Printf("Trash: Purge for user %s failed: %v", username, err)
+	}
+	if removed > 0 {
+		log.Printf("Trash: Purged %d files for user %s", removed, username)
+	}
+}
+
+// ParseID returns the original path and deletion time of a trashed file.
+func ParseID(id string) (name string, deletedAt time.Time, ok bool) {
+	entry, name, ok := strings.Cut(id, "/")
+	if !ok || name == "" {
+		return "", time.Time{}, false
+	}
+
+	nanos, err := strconv.ParseInt(entry, 10, 64)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return name, time.Unix(0, nanos).UTC(), true
+}
@@ -0,0 +1,211 @@
+package trash
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"photosync-backend/internal/config"
+	"photosync-backend/internal/storage"
+)
+
+type testBin struct {
+	*Bin
+	conn storage.Connection
+	base string
+	dir  string
+}
+
+func newTestBin(t *testing.T, retention time.Duration) *testBin {
+	t.Helper()
+
+	base := t.TempDir()
+	backend := storage.NewSafeBackend(storage.NewLocalBackend(&config.LocalConfig{BasePath: base}))
+	conn, err := backend.Connect("alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testBin{Bin: NewBin(backend, retention), conn: conn, base: base, dir: filepath.Join(base, "alice")}
+}
+
+func (b *testBin) write(t *testing.T, name string) {
+	t.Helper()
+
+	path := filepath.Join(b.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// trashedAt puts name in the trash as if it had been deleted at deleted.
+func (b *testBin) trashedAt(t *testing.T, name string, deleted time.Time) string {
+	t.Helper()
+
+	id := strconv.FormatInt(deleted.UnixNano(), 10) + "/" + name
+	b.write(t, ".trash/"+id)
+	return id
+}
+
+func (b *testBin) ids(t *testing.T) []string {
+	t.Helper()
+
+	items, err := b.List(b.conn, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+	return ids
+}
+
+func TestDeleteRestore(t *testing.T) {
+	b := newTestBin(t, time.Hour)
+	b.write(t, "2024/a.jpg")
+
+	first, err := b.Delete(b.conn, "alice", "2024/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.write(t, "2024/a.jpg")
+	second, err := b.Delete(b.conn, "alice", "2024/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both versions are kept, the latest first.
+	if got := b.ids(t); !slices.Equal(got, []string{second, first}) {
+		t.Fatalf("trash = %v, want %v", got, []string{second, first})
+	}
+	name, _, ok := ParseID(first)
+	if !ok || name != "2024/a.jpg" {
+		t.Fatalf("ParseID(%q) = %q, %v", first, name, ok)
+	}
+
+	if err := b.Restore(b.conn, "alice", first, "2024/a (restored).jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(b.dir, "2024", "a (restored).jpg")); err != nil {
+		t.Errorf("restored file: %v", err)
+	}
+	if got := b.ids(t); !slices.Equal(got, []string{second}) {
+		t.Errorf("trash after restore = %v, want %v", got, []string{second})
+	}
+	if _, err := os.Stat(filepath.Join(b.dir, ".trash", filepath.Dir(filepath.FromSlash(first)))); !os.IsNotExist(err) {
+		t.Errorf("emptied trash entry left behind: %v", err)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	b := newTestBin(t, 24*time.Hour)
+	now := time.Now()
+
+	expired := b.trashedAt(t, "old.jpg", now.Add(-25*time.Hour))
+	expiredNested := b.trashedAt(t, "2023/old.jpg", now.Add(-48*time.Hour))
+	kept := b.trashedAt(t, "recent.jpg", now.Add(-23*time.Hour))
+	fresh := b.trashedAt(t, "2024/new.jpg", now)
+
+	removed, err := b.Purge(b.conn, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 {
+		t.Errorf("Purge removed %d files, want 2", removed)
+	}
+	if got, want := b.ids(t), []string{fresh, kept}; !slices.Equal(got, want) {
+		t.Errorf("trash = %v, want %v", got, want)
+	}
+	for _, id := range []string{expired, expiredNested} {
+		entry, _, _ := ParseID(id)
+		if _, err := os.Stat(filepath.Join(b.dir, ".trash", entry)); !os.IsNotExist(err) {
+			t.Errorf("entry of %s left behind: %v", id, err)
+		}
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	b := newTestBin(t, time.Hour)
+	for _, name := range []string{"a.jpg", "2024/b.jpg", "2024/Trip/c.jpg"} {
+		b.write(t, name)
+		if _, err := b.Delete(b.conn, "alice", name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := b.Empty(b.conn, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 3 {
+		t.Errorf("Empty removed %d files, want 3", removed)
+	}
+	if got := b.ids(t); len(got) != 0 {
+		t.Errorf("trash after Empty = %v", got)
+	}
+
+	if removed, err := b.Empty(b.conn, "alice"); err != nil || removed != 0 {
+		t.Errorf("Empty of an empty trash = %d, %v", removed, err)
+	}
+}
+
+func TestPurgeAll(t *testing.T) {
+	b := newTestBin(t, time.Hour)
+	now := time.Now()
+	b.trashedAt(t, "old.jpg", now.Add(-2*time.Hour))
+	kept := b.trashedAt(t, "new.jpg", now)
+
+	// Bob is not signed in, and his backend is reached without him.
+	bob := filepath.Join(b.base, "bob", ".trash", strconv.FormatInt(now.Add(-2*time.Hour).UnixNano(), 10))
+	if err := os.MkdirAll(bob, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(bob, "old.jpg"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pool := storage.NewGenericConnectionPool(b.backend, time.Hour)
+	defer pool.Close()
+	b.purgeAll(pool)
+
+	if got := b.ids(t); !slices.Equal(got, []string{kept}) {
+		t.Errorf("alice's trash = %v, want %v", got, []string{kept})
+	}
+	if _, err := os.Stat(bob); !os.IsNotExist(err) {
+		t.Errorf("bob's expired entry left behind: %v", err)
+	}
+}
+
+func TestPurgeOnConnect(t *testing.T) {
+	b := newTestBin(t, time.Hour)
+	b.trashedAt(t, "old.jpg", time.Now().Add(-2*time.Hour))
+
+	pool := storage.NewGenericConnectionPool(b.backend, time.Hour)
+	defer pool.Close()
+	purged := make(chan struct{})
+	pool.OnConnect(func(username string) {
+		b.purgeLeased(pool, username)
+		close(purged)
+	})
+
+	_, done, err := pool.GetConnection("alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	select {
+	case <-purged:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no purge after connecting")
+	}
+	if got := b.ids(t); len(got) != 0 {
+		t.Errorf("trash = %v after connecting, want it purged", got)
+	}
+}
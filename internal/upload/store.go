@@ -0,0 +1,290 @@
+package upload
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound       = errors.New("upload not found")
+	ErrOffsetMismatch = errors.New("upload offset mismatch")
+	ErrLocked         = errors.New("upload is locked by another request")
+)
+
+type Info struct {
+	ID        string            `json:"id"`
+	Username  string            `json:"username"`
+	Length    int64             `json:"length"`
+	Offset    int64             `json:"offset"`
+	Metadata  map[string]string `json:"metadata"`
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+func (i *Info) Complete() bool {
+	return i.Offset >= i.Length
+}
+
+// Store stages resumable uploads on local disk until all bytes have arrived.
+// Each upload is a <id>.bin data file plus a <id>.info JSON sidecar.
+type Store struct {
+	dir    string
+	expiry time.Duration
+	mu     sync.Mutex
+	locks  map[string]bool
+}
+
+func NewStore(dir string, expiry time.Duration) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+
+	store := &Store{
+		dir:    dir,
+		expiry: expiry,
+		locks:  make(map[string]bool),
+	}
+
+	go store.cleanup()
+
+	return store, nil
+}
+
+func (s *Store) Create(username string, length int64, metadata map[string]string) (*Info, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate upload id: %w", err)
+	}
+
+	now := time.Now()
+	info := &Info{
+		ID:        id,
+		Username:  username,
+		Length:    length,
+		Metadata:  metadata,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.expiry),
+	}
+
+	file, err := os.OpenFile(s.dataPath(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging file: %w", err)
+	}
+	file.Close()
+
+	if err := s.saveInfo(info); err != nil {
+		os.Remove(s.dataPath(id))
+		return nil, err
+	}
+
+	return info, nil
+}
+
+func (s *Store) Get(id string) (*Info, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+
+	data, err := os.ReadFile(s.infoPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to read upload info: %w", err)
+	}
+
+	var info Info
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse upload info: %w", err)
+	}
+
+	if time.Now().After(info.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+
+	return &info, nil
+}
+
+// Lock reserves the upload for one request, failing with ErrLocked while
+// another holds it. Write and Remove must be called with the upload locked,
+// and a request that commits a complete upload keeps the lock until the
+// staged files are removed, so that a retried PATCH or a termination cannot
+// overlap the commit.
+func (s *Store) Lock(id string) (func(), error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	if err := s.lock(id); err != nil {
+		return nil, err
+	}
+	return func() { s.unlock(id) }, nil
+}
+
+// Write appends data at offset, which must equal the current upload offset.
+// Bytes that arrive before the reader fails are kept so the client can resume
+// from the new offset.
+func (s *Store) Write(id string, offset int64, data io.Reader) (*Info, error) {
+	info, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset != info.Offset {
+		return info, ErrOffsetMismatch
+	}
+
+	file, err := os.OpenFile(s.dataPath(id), os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open staging file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek staging file: %w", err)
+	}
+
+	n, copyErr := io.Copy(file, io.LimitReader(data, info.Length-offset))
+
+	info.Offset += n
+	info.ExpiresAt = time.Now().Add(s.expiry)
+	if err := s.saveInfo(info); err != nil {
+		return nil, err
+	}
+
+	if copyErr != nil {
+		return info, fmt.Errorf("upload interrupted at offset %d: %w", info.Offset, copyErr)
+	}
+
+	return info, nil
+}
+
+func (s *Store) Open(id string) (*os.File, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	return os.Open(s.dataPath(id))
+}
+
+func (s *Store) Remove(id string) error {
+	if !validID(id) {
+		return ErrNotFound
+	}
+
+	s.removeFiles(id)
+	return nil
+}
+
+func (s *Store) lock(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.locks[id] {
+		return ErrLocked
+	}
+	s.locks[id] = true
+	return nil
+}
+
+func (s *Store) unlock(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.locks, id)
+}
+
+func (s *Store) saveInfo(info *Info) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("failed to encode upload info: %w", err)
+	}
+
+	tmp := s.infoPath(info.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write upload info: %w", err)
+	}
+
+	if err := os.Rename(tmp, s.infoPath(info.ID)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write upload info: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) removeFiles(id string) {
+	os.Remove(s.dataPath(id))
+	os.Remove(s.infoPath(id))
+}
+
+func (s *Store) cleanup() {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.removeExpired()
+	}
+}
+
+func (s *Store) removeExpired() {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		log.Printf("Uploads: Could not read staging directory: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".info")
+		if !ok || !validID(id) {
+			continue
+		}
+
+		data, err := os.ReadFile(s.infoPath(id))
+		if err != nil {
+			continue
+		}
+
+		var info Info
+		if err := json.Unmarshal(data, &info); err != nil || now.After(info.ExpiresAt) {
+			if s.lock(id) != nil {
+				continue
+			}
+			log.Printf("Uploads: Removing expired upload %s for user %s", id, info.Username)
+			s.removeFiles(id)
+			s.unlock(id)
+		}
+	}
+}
+
+func (s *Store) dataPath(id string) string {
+	return filepath.Join(s.dir, id+".bin")
+}
+
+func (s *Store) infoPath(id string) string {
+	return filepath.Join(s.dir, id+".info")
+}
+
+func newID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func validID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
@@ -0,0 +1,242 @@
+package upload
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T, expiry time.Duration) *Store {
+	t.Helper()
+
+	store, err := NewStore(t.TempDir(), expiry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+type chunk struct {
+	offset  int64
+	data    string
+	want    int64
+	wantErr error
+}
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		name   string
+		length int64
+		chunks []chunk
+		stored string
+	}{
+		{
+			name:   "single chunk",
+			length: 5,
+			chunks: []chunk{{offset: 0, data: "hello", want: 5}},
+			stored: "hello",
+		},
+		{
+			name:   "resumed",
+			length: 11,
+			chunks: []chunk{{offset: 0, data: "hello", want: 5}, {offset: 5, data: " world", want: 11}},
+			stored: "hello world",
+		},
+		{
+			name:   "excess bytes are dropped",
+			length: 4,
+			chunks: []chunk{{offset: 0, data: "hello", want: 4}},
+			stored: "hell",
+		},
+		{
+			name:   "offset behind",
+			length: 10,
+			chunks: []chunk{{offset: 0, data: "hello", want: 5}, {offset: 0, data: "hello", want: 5, wantErr: ErrOffsetMismatch}},
+			stored: "hello",
+		},
+		{
+			name:   "offset ahead",
+			length: 10,
+			chunks: []chunk{{offset: 3, data: "lo", want: 0, wantErr: ErrOffsetMismatch}},
+			stored: "",
+		},
+		{
+			name:   "empty chunk",
+			length: 3,
+			chunks: []chunk{{offset: 0, data: "", want: 0}, {offset: 0, data: "abc", want: 3}},
+			stored: "abc",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t, time.Hour)
+			info, err := store.Create("alice", tt.length, map[string]string{"filename": "a.jpg"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, c := range tt.chunks {
+				got, err := store.Write(info.ID, c.offset, strings.NewReader(c.data))
+				if !errors.Is(err, c.wantErr) {
+					t.Fatalf("chunk %d: Write error = %v, want %v", i, err, c.wantErr)
+				}
+				if got.Offset != c.want {
+					t.Fatalf("chunk %d: offset = %d, want %d", i, got.Offset, c.want)
+				}
+			}
+
+			info, err = store.Get(info.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Complete() != (int64(len(tt.stored)) == tt.length) {
+				t.Errorf("Complete = %v at offset %d of %d", info.Complete(), info.Offset, info.Length)
+			}
+			if got := readStaged(t, store, info.ID); got != tt.stored {
+				t.Errorf("staged data = %q, want %q", got, tt.stored)
+			}
+		})
+	}
+}
+
+// failingReader returns data and then fails, like a dropped connection.
+type failingReader struct {
+	data string
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestWriteInterrupted(t *testing.T) {
+	store := newTestStore(t, time.Hour)
+	info, err := store.Create("alice", 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.Write(info.ID, 0, &failingReader{data: "hello"})
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("Write error = %v, want the reader's error", err)
+	}
+	if got.Offset != 5 {
+		t.Fatalf("offset after interruption = %d, want 5", got.Offset)
+	}
+
+	info, err = store.Get(info.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Offset != 5 {
+		t.Fatalf("stored offset = %d, want 5", info.Offset)
+	}
+
+	if _, err := store.Write(info.ID, 5, strings.NewReader("world")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readStaged(t, store, info.ID); got != "helloworld" {
+		t.Errorf("staged data = %q, want helloworld", got)
+	}
+}
+
+func TestLock(t *testing.T) {
+	store := newTestStore(t, time.Hour)
+	info, err := store.Create("alice", 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, err := store.Lock(info.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Lock(info.ID); !errors.Is(err, ErrLocked) {
+		t.Fatalf("second Lock error = %v, want ErrLocked", err)
+	}
+
+	// Expired uploads are not removed while a request holds them.
+	store.expiry = -time.Hour
+	if _, err := store.Write(info.ID, 0, strings.NewReader("x")); err != nil {
+		t.Fatal(err)
+	}
+	store.removeExpired()
+	if _, err := os.Stat(store.dataPath(info.ID)); err != nil {
+		t.Errorf("locked upload was removed: %v", err)
+	}
+
+	unlock()
+	again, err := store.Lock(info.ID)
+	if err != nil {
+		t.Fatalf("Lock after unlock error = %v", err)
+	}
+	again()
+
+	store.removeExpired()
+	if _, err := os.Stat(store.dataPath(info.ID)); !os.IsNotExist(err) {
+		t.Errorf("expired upload was kept: %v", err)
+	}
+}
+
+func TestGet(t *testing.T) {
+	store := newTestStore(t, time.Hour)
+	info, err := store.Create("alice", 1, map[string]string{"filename": "a.jpg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired := newTestStore(t, -time.Minute)
+	old, err := expired.Create("alice", 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		store   *Store
+		id      string
+		wantErr error
+	}{
+		{name: "existing", store: store, id: info.ID},
+		{name: "unknown", store: store, id: strings.Repeat("0", 32), wantErr: ErrNotFound},
+		{name: "short", store: store, id: "abc", wantErr: ErrNotFound},
+		{name: "path", store: store, id: "../../../../etc/passwd0000000000", wantErr: ErrNotFound},
+		{name: "expired", store: expired, id: old.ID, wantErr: ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.store.Get(tt.id)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Get(%q) error = %v, want %v", tt.id, err, tt.wantErr)
+			}
+			if err == nil && (got.Username != "alice" || got.Metadata["filename"] != "a.jpg") {
+				t.Errorf("Get(%q) = %+v", tt.id, got)
+			}
+		})
+	}
+}
+
+func readStaged(t *testing.T, store *Store, id string) string {
+	t.Helper()
+
+	file, err := store.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -148,6 +148,11 @@ generate_jwt_keys() {
         return
     fi
 
+    if grep -qE "^\s*(signing_keys|encryption_keys):" "$CONFIG_FILE"; then
+        echo "JWT key lists configured in $CONFIG_FILE, skipping JWT key generation"
+        return
+    fi
+
     echo "Checking JWT keys in $CONFIG_FILE..."
 
     # Check if JWT keys need generation